@@ -16,13 +16,17 @@ import (
 	"github.com/globocom/tsuru/errors"
 	"github.com/globocom/tsuru/log"
 	"github.com/globocom/tsuru/repository"
+	"github.com/globocom/tsuru/webhook"
 	"io"
 	"io/ioutil"
 	"labix.org/v2/mgo/bson"
 	"net/http"
+	"os/exec"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -49,6 +53,61 @@ func getAppOrError(name string, u *auth.User) (app.App, error) {
 	return app, nil
 }
 
+// deployLockWait is how long a deploy waits for the lock of the app, when
+// another operation holds it.
+var deployLockWait = 10 * time.Minute
+
+// lockApp acquires the lock of the app for the given operation, and reloads
+// the app, so the operation sees the changes made while the app was locked. If
+// the app is locked by another operation, lockApp waits up to wait for the
+// lock to be released, and then fails with 409.
+func lockApp(a *app.App, owner, operation string, wait time.Duration) (*app.Lock, error) {
+	lock, err := app.AcquireLock(a.Name, owner, operation, wait)
+	if e, ok := err.(*app.LockedError); ok {
+		return nil, &errors.Http{Code: http.StatusConflict, Message: e.Error()}
+	} else if err != nil {
+		return nil, err
+	}
+	if err = a.Get(); err != nil {
+		lock.Release()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// lockForDeploy acquires the lock of the app for a deploy. Deploys wait in line
+// for the lock, telling the user about it.
+func lockForDeploy(a *app.App, w io.Writer, owner, operation string) (*app.Lock, error) {
+	lock, err := lockApp(a, owner, operation, 0)
+	if e, ok := err.(*errors.Http); ok && e.Code == http.StatusConflict {
+		write(w, []byte(fmt.Sprintf("\n ---> %s Waiting for it to finish...\n", e.Message)))
+		lock, err = lockApp(a, owner, operation, deployLockWait)
+	}
+	return lock, err
+}
+
+// checkMaintenance returns an error if the app is in maintenance mode and the
+// user is not an admin. A nil user is never an admin.
+func checkMaintenance(a *app.App, u *auth.User) error {
+	if !a.Maintenance.Enabled || (u != nil && u.IsAdmin()) {
+		return nil
+	}
+	msg := fmt.Sprintf("The app %s is in maintenance mode since %s, set by %s. Only admin users can change it until the maintenance mode is turned off.",
+		a.Name, a.Maintenance.Since.Format("2006-01-02 15:04:05"), a.Maintenance.User)
+	return &errors.Http{Code: http.StatusForbidden, Message: msg}
+}
+
+// CloneRepositoryHandler deploys the app, updating its code in all units,
+// installing dependencies and restarting it.
+//
+// The deploy is saved in the deploy history of the app, and the webhooks of
+// the app are notified when it finishes. The git hook that calls this handler
+// may inform the user that pushed the code in the "user" query string
+// parameter, and the ref to deploy in the "ref" parameter.
+//
+// The "user" parameter is not authenticated: it's only recorded in the deploy
+// when it's a member of one of the teams of the app, and never makes the push
+// bypass the maintenance mode.
 func CloneRepositoryHandler(w http.ResponseWriter, r *http.Request) error {
 	w.Header().Set("Content-Type", "text")
 	instance := app.App{Name: r.URL.Query().Get(":name")}
@@ -57,47 +116,266 @@ func CloneRepositoryHandler(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return &errors.Http{Code: http.StatusNotFound, Message: fmt.Sprintf("App %s not found.", instance.Name)}
 	}
-	err = write(&logWriter, []byte("\n ---> Tsuru receiving push\n"))
+	ref := r.URL.Query().Get("ref")
+	if ref != "" {
+		if err = repository.ValidateRef(ref); err != nil {
+			return &errors.Http{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+	}
+	user := "git"
+	if email := r.URL.Query().Get("user"); email != "" && auth.CheckUserAccess(instance.Teams, &auth.User{Email: email}) {
+		user = email
+	}
+	lock, err := lockForDeploy(&instance, &logWriter, user, "deploy")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	if err = checkMaintenance(&instance, nil); err != nil {
+		return err
+	}
+	d := app.NewDeploy(&instance, user)
+	d.Ref = ref
+	err = deploy(&instance, &logWriter, d)
+	if ferr := d.Finish(err); ferr != nil {
+		log.Printf("Failed to save deploy of the app %q: %s", instance.Name, ferr)
+	}
+	webhook.Notify(webhook.Deploy, instance.Name, instance.Teams, err, map[string]string{
+		"ref":    d.Ref,
+		"commit": d.Commit,
+		"user":   d.User,
+	})
+	return err
+}
+
+func deploy(instance *app.App, w io.Writer, d *app.Deploy) error {
+	err := write(w, []byte("\n ---> Tsuru receiving push\n"))
+	if err != nil {
+		return err
+	}
+	err = write(w, []byte("\n ---> Cloning your code in your machines\n"))
+	if err != nil {
+		return err
+	}
+	out, err := repository.CloneOrPull(instance, d.Ref) // should iterate over the machines
+	if err != nil {
+		return &errors.Http{Code: http.StatusInternalServerError, Message: string(out)}
+	}
+	err = write(w, out)
+	if err != nil {
+		return err
+	}
+	if d.Ref == "" {
+		d.Ref = "master"
+	}
+	if err = instance.SetRef(d.Ref); err != nil {
+		log.Printf("Failed to save the ref of the app %q: %s", instance.Name, err)
+	}
+	d.Commit, err = repository.GetCommit(instance)
+	if err != nil {
+		instance.Log(err.Error(), "tsuru")
+	}
+	err = write(w, []byte("\n ---> Installing dependencies\n"))
+	if err != nil {
+		return err
+	}
+	err = instance.InstallDeps(w)
+	if err != nil {
+		return err
+	}
+	err = instance.Restart(w)
+	if err != nil {
+		return err
+	}
+	err = instance.CheckHealth(w)
+	if err != nil {
+		if e, ok := err.(*app.HealthcheckError); ok && e.Rollback {
+			rollbackUnhealthy(instance, w, d)
+		}
+		return err
+	}
+	return write(w, []byte("\n ---> Deploy done!\n\n"))
+}
+
+// rollbackUnhealthy rolls the app back to the last successful deploy before
+// d, after d failed the healthcheck.
+func rollbackUnhealthy(instance *app.App, w io.Writer, d *app.Deploy) {
+	deploys, err := instance.Deploys()
+	if err != nil {
+		instance.Log(fmt.Sprintf("Failed to find the previous deploy: %s", err), "tsuru")
+		return
+	}
+	for _, previous := range deploys {
+		if previous.Success && previous.Commit != "" && previous.Commit != d.Commit {
+			if err := rollback(instance, w, previous.Commit); err != nil {
+				instance.Log(fmt.Sprintf("Failed to rollback to %s: %s", previous.Commit, err), "tsuru")
+			}
+			return
+		}
+	}
+	instance.Log("There's no previous deploy to rollback to.", "tsuru")
+}
+
+// DeployHandler deploys a git ref (branch, tag or commit) of the app. The ref
+// is read from the request body; if it's empty, master is deployed.
+func DeployHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	w.Header().Set("Content-Type", "text")
+	var ref string
+	if r.Body != nil {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		ref = strings.TrimSpace(string(b))
+	}
+	if ref != "" {
+		if err := repository.ValidateRef(ref); err != nil {
+			return &errors.Http{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+	}
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	logWriter := LogWriter{&instance, w}
+	lock, err := lockForDeploy(&instance, &logWriter, u.Email, "deploy")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	if err = checkMaintenance(&instance, u); err != nil {
+		return err
+	}
+	d := app.NewDeploy(&instance, u.Email)
+	d.Ref = ref
+	err = deploy(&instance, &logWriter, d)
+	if ferr := d.Finish(err); ferr != nil {
+		log.Printf("Failed to save deploy of the app %q: %s", instance.Name, ferr)
+	}
+	webhook.Notify(webhook.Deploy, instance.Name, instance.Teams, err, map[string]string{
+		"ref":    d.Ref,
+		"commit": d.Commit,
+		"user":   d.User,
+	})
+	return err
+}
+
+// DeployListHandler lists the deploy history of an app, most recent first.
+func DeployListHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	deploys, err := instance.Deploys()
+	if err != nil {
+		return err
+	}
+	if len(deploys) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(deploys)
+}
+
+// RollbackHandler checks out a previously deployed commit in all units of the
+// app and restarts it. The commit, which may be abbreviated, is read from the
+// request body.
+func RollbackHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	w.Header().Set("Content-Type", "text")
+	msg := "You must provide the commit to rollback to."
+	if r.Body == nil {
+		return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	commit := strings.TrimSpace(string(b))
+	if commit == "" {
+		return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
 	if err != nil {
 		return err
 	}
-	err = write(&logWriter, []byte("\n ---> Cloning your code in your machines\n"))
+	previous, err := instance.FindDeploy(commit)
+	if err != nil {
+		msg := fmt.Sprintf("Commit %s was never successfully deployed in the app %s.", commit, instance.Name)
+		return &errors.Http{Code: http.StatusNotFound, Message: msg}
+	}
+	logWriter := LogWriter{&instance, w}
+	lock, err := lockForDeploy(&instance, &logWriter, u.Email, "rollback")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	if err = checkMaintenance(&instance, u); err != nil {
+		return err
+	}
+	d := app.NewDeploy(&instance, u.Email)
+	d.Ref = previous.Commit
+	d.Commit = previous.Commit
+	err = rollback(&instance, &logWriter, d.Commit)
+	if ferr := d.Finish(err); ferr != nil {
+		log.Printf("Failed to save deploy of the app %q: %s", instance.Name, ferr)
+	}
+	webhook.Notify(webhook.Deploy, instance.Name, instance.Teams, err, map[string]string{
+		"ref":    d.Ref,
+		"commit": d.Commit,
+		"user":   d.User,
+	})
+	return err
+}
+
+func rollback(instance *app.App, w io.Writer, commit string) error {
+	err := write(w, []byte(fmt.Sprintf("\n ---> Rolling back to %s\n", commit)))
 	if err != nil {
 		return err
 	}
-	out, err := repository.CloneOrPull(&instance) // should iterate over the machines
+	out, err := repository.Checkout(instance, commit)
 	if err != nil {
 		return &errors.Http{Code: http.StatusInternalServerError, Message: string(out)}
 	}
-	err = write(&logWriter, out)
+	err = write(w, out)
 	if err != nil {
 		return err
 	}
-	err = write(&logWriter, []byte("\n ---> Installing dependencies\n"))
+	// the app may hold the hooks loaded from the app.conf of the commit that
+	// is being rolled back, so it's loaded again.
+	reloaded := app.App{Name: instance.Name}
+	if err = reloaded.Get(); err != nil {
+		return err
+	}
+	*instance = reloaded
+	if err = instance.SetRef(commit); err != nil {
+		log.Printf("Failed to save the ref of the app %q: %s", instance.Name, err)
+	}
+	err = write(w, []byte("\n ---> Installing dependencies\n"))
 	if err != nil {
 		return err
 	}
-	err = instance.InstallDeps(&logWriter)
+	err = instance.InstallDeps(w)
 	if err != nil {
 		return err
 	}
-	err = instance.Restart(&logWriter)
+	err = instance.Restart(w)
 	if err != nil {
 		return err
 	}
-	return write(&logWriter, []byte("\n ---> Deploy done!\n\n"))
+	return write(w, []byte("\n ---> Rollback done!\n\n"))
 }
 
-// AppIsAvaliableHandler verify if the app.Unit().State() is
-// started. If is started it returns 200 else returns 500 for
-// status code.
+// AppIsAvaliableHandler verify if the app is available, i.e. if it has
+// started units (see app.App.Available). If it's available it returns 200
+// else returns 500 for status code.
 func AppIsAvaliableHandler(w http.ResponseWriter, r *http.Request) error {
 	app := app.App{Name: r.URL.Query().Get(":name")}
 	err := app.Get()
 	if err != nil {
 		return err
 	}
-	if app.State != "started" {
+	if !app.Available() {
 		return fmt.Errorf("App must be started to receive pushs, but it is %q.", app.State)
 	}
 	w.WriteHeader(http.StatusOK)
@@ -109,6 +387,11 @@ func AppDelete(w http.ResponseWriter, r *http.Request, u *auth.User) error {
 	if err != nil {
 		return err
 	}
+	lock, err := lockApp(&app, u.Email, "destroy", 0)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
 	gUrl := repository.GitServerUri()
 	if err := (&gandalf.Client{Endpoint: gUrl}).RemoveRepository(app.Name); err != nil {
 		log.Printf("Got error while removing repository from gandalf: %s", err.Error())
@@ -226,16 +509,134 @@ func AddUnitsHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error
 		}
 	}
 	appName := r.URL.Query().Get(":name")
-	app, err := getAppOrError(appName, u)
+	instance, err := getAppOrError(appName, u)
+	if err != nil {
+		return err
+	}
+	lock, err := lockApp(&instance, u.Email, "add-units", 0)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	if err = checkMaintenance(&instance, u); err != nil {
+		return err
+	}
+	err = instance.AddUnits(uint(n), r.URL.Query().Get("process"))
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
+
+// RemoveUnitsHandler removes units from an app. The request body contains
+// either the number of units to remove or the name of the unit to remove.
+func RemoveUnitsHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	missingMsg := "You must provide the number of units or the name of the unit to remove."
+	if r.Body == nil {
+		return &errors.Http{Code: http.StatusBadRequest, Message: missingMsg}
+	}
+	defer r.Body.Close()
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	value := strings.TrimSpace(string(b))
+	if value == "" {
+		return &errors.Http{Code: http.StatusBadRequest, Message: missingMsg}
+	}
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	lock, err := lockApp(&instance, u.Email, "remove-units", 0)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	if err = checkMaintenance(&instance, u); err != nil {
+		return err
+	}
+	if n, perr := strconv.ParseUint(value, 10, 32); perr == nil {
+		err = instance.RemoveUnits(uint(n), r.URL.Query().Get("process"))
+	} else {
+		err = instance.RemoveUnit(value)
+	}
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
+
+func readDrain(r *http.Request) (string, error) {
+	msg := "You must provide the URL of the drain."
+	if r.Body == nil {
+		return "", &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	defer r.Body.Close()
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	drain := strings.TrimSpace(string(b))
+	if drain == "" {
+		return "", &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	return drain, nil
+}
+
+// AddDrainHandler registers a log drain in the app. The URL of the drain is
+// read from the request body.
+func AddDrainHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	drain, err := readDrain(r)
+	if err != nil {
+		return err
+	}
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	err = instance.AddDrain(drain)
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
+
+// RemoveDrainHandler removes a log drain from the app. The URL of the drain
+// is read from the request body.
+func RemoveDrainHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	drain, err := readDrain(r)
+	if err != nil {
+		return err
+	}
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
 	if err != nil {
 		return err
 	}
-	return app.AddUnits(uint(n))
+	err = instance.RemoveDrain(drain)
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusNotFound, Message: e.Message}
+	}
+	return err
+}
+
+// ListDrainsHandler lists the URLs of the log drains of the app.
+func ListDrainsHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	if len(instance.Drains) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(instance.Drains)
 }
 
 func grantAccessToTeam(appName, teamName string, u *auth.User) error {
 	t := new(auth.Team)
-	app, err := getAppOrError(appName, u)
+	instance, err := getAppOrError(appName, u)
 	if err != nil {
 		return err
 	}
@@ -243,16 +644,14 @@ func grantAccessToTeam(appName, teamName string, u *auth.User) error {
 	if err != nil {
 		return &errors.Http{Code: http.StatusNotFound, Message: "Team not found"}
 	}
-	err = app.Grant(t)
-	if err != nil {
-		return &errors.Http{Code: http.StatusConflict, Message: err.Error()}
-	}
-	err = db.Session.Apps().Update(bson.M{"name": app.Name}, app)
-	if err != nil {
+	err = instance.GrantAccess(t)
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusConflict, Message: e.Message}
+	} else if err != nil {
 		return err
 	}
 	gUrl := repository.GitServerUri()
-	return (&gandalf.Client{Endpoint: gUrl}).GrantAccess([]string{app.Name}, t.Users)
+	return (&gandalf.Client{Endpoint: gUrl}).GrantAccess([]string{instance.Name}, t.Users)
 }
 
 func GrantAccessToTeamHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
@@ -263,7 +662,7 @@ func GrantAccessToTeamHandler(w http.ResponseWriter, r *http.Request, u *auth.Us
 
 func revokeAccessFromTeam(appName, teamName string, u *auth.User) error {
 	t := new(auth.Team)
-	app, err := getAppOrError(appName, u)
+	instance, err := getAppOrError(appName, u)
 	if err != nil {
 		return err
 	}
@@ -271,20 +670,18 @@ func revokeAccessFromTeam(appName, teamName string, u *auth.User) error {
 	if err != nil {
 		return &errors.Http{Code: http.StatusNotFound, Message: "Team not found"}
 	}
-	if len(app.Teams) == 1 {
+	if len(instance.Teams) == 1 {
 		msg := "You can not revoke the access from this team, because it is the unique team with access to the app, and an app can not be orphaned"
 		return &errors.Http{Code: http.StatusForbidden, Message: msg}
 	}
-	err = app.Revoke(t)
-	if err != nil {
-		return &errors.Http{Code: http.StatusNotFound, Message: err.Error()}
-	}
-	err = db.Session.Apps().Update(bson.M{"name": app.Name}, app)
-	if err != nil {
+	err = instance.RevokeAccess(t)
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusNotFound, Message: e.Message}
+	} else if err != nil {
 		return err
 	}
 	gUrl := repository.GitServerUri()
-	if err := (&gandalf.Client{Endpoint: gUrl}).RevokeAccess([]string{app.Name}, t.Users); err != nil {
+	if err := (&gandalf.Client{Endpoint: gUrl}).RevokeAccess([]string{instance.Name}, t.Users); err != nil {
 		return &errors.Http{Code: http.StatusInternalServerError, Message: err.Error()}
 	}
 	return nil
@@ -296,6 +693,9 @@ func RevokeAccessFromTeamHandler(w http.ResponseWriter, r *http.Request, u *auth
 	return revokeAccessFromTeam(appName, teamName, u)
 }
 
+// RunCommand runs the command in the request body in the units of the app,
+// streaming the output. The unit parameter restricts it to a single unit, once
+// to any started unit, and timeout kills the command after the given duration.
 func RunCommand(w http.ResponseWriter, r *http.Request, u *auth.User) error {
 	w.Header().Set("Content-Type", "text")
 	msg := "You must provide the command to run"
@@ -309,14 +709,57 @@ func RunCommand(w http.ResponseWriter, r *http.Request, u *auth.User) error {
 	if len(c) < 1 {
 		return &errors.Http{Code: http.StatusBadRequest, Message: msg}
 	}
+	var timeout time.Duration
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		timeout, err = time.ParseDuration(t)
+		if err != nil || timeout <= 0 {
+			msg := fmt.Sprintf("Invalid timeout %q, it must be a positive duration, like 30s or 5m.", t)
+			return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+		}
+	}
 	appName := r.URL.Query().Get(":name")
-	app, err := getAppOrError(appName, u)
+	a, err := getAppOrError(appName, u)
 	if err != nil {
 		return err
 	}
-	return app.Run(string(c), w)
+	if err = checkMaintenance(&a, u); err != nil {
+		return err
+	}
+	unit := r.URL.Query().Get("unit")
+	if unit != "" || r.URL.Query().Get("once") == "true" {
+		err = a.RunInUnit(string(c), unit, w, timeout)
+	} else {
+		err = a.RunWithTimeout(string(c), w, timeout)
+	}
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	if status, ok := exitStatus(err); ok {
+		fmt.Fprintf(w, exitStatusTrailer, status)
+		return nil
+	}
+	return err
 }
 
+// exitStatusTrailer is written at the end of the output of commands that exit
+// with a non-zero status, so clients can exit with the same status.
+const exitStatusTrailer = "\n ---> Exit status: %d\n"
+
+// exitStatus returns the exit status of the command that failed with err, and
+// whether err carries one.
+func exitStatus(err error) (int, bool) {
+	if e, ok := err.(*exec.ExitError); ok {
+		if status, ok := e.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus(), true
+		}
+	}
+	return 0, false
+}
+
+// GetEnv writes the environment variables of the app, one NAME=value per
+// line. If the request accepts application/json, the variables are written as
+// a JSON list of name/value/public entries, sorted by name. In both formats,
+// values of private variables are masked.
 func GetEnv(w http.ResponseWriter, r *http.Request, u *auth.User) (err error) {
 	var variable []byte
 	if r.Body != nil {
@@ -330,30 +773,55 @@ func GetEnv(w http.ResponseWriter, r *http.Request, u *auth.User) (err error) {
 	if err != nil {
 		return err
 	}
-	var write = func(v bind.EnvVar) error {
-		_, err := fmt.Fprintf(w, "%s\n", &v)
-		return err
-	}
+	var envs []bind.EnvVar
 	if variables := strings.Fields(string(variable)); len(variables) > 0 {
 		for _, variable := range variables {
 			if v, ok := app.Env[variable]; ok {
-				err = write(v)
-				if err != nil {
-					return
-				}
+				envs = append(envs, v)
 			}
 		}
 	} else {
 		for _, v := range app.Env {
-			err = write(v)
-			if err != nil {
-				return
-			}
+			envs = append(envs, v)
+		}
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		return writeEnvsJSON(w, envs)
+	}
+	for _, v := range envs {
+		if _, err = fmt.Fprintf(w, "%s\n", &v); err != nil {
+			return
 		}
 	}
 	return nil
 }
 
+type envsByName []bind.EnvVar
+
+func (l envsByName) Len() int           { return len(l) }
+func (l envsByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l envsByName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+
+// writeEnvsJSON writes the given environment variables as JSON. Variables set
+// by bound service instances include the name of the instance, so clients can
+// tell them from the variables set by users.
+func writeEnvsJSON(w http.ResponseWriter, envs []bind.EnvVar) error {
+	sort.Sort(envsByName(envs))
+	result := make([]map[string]interface{}, len(envs))
+	for i, env := range envs {
+		value := env.Value
+		if !env.Public {
+			value = "***"
+		}
+		result[i] = map[string]interface{}{"name": env.Name, "value": value, "public": env.Public}
+		if env.InstanceName != "" {
+			result[i]["instanceName"] = env.InstanceName
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(result)
+}
+
 func SetEnv(w http.ResponseWriter, r *http.Request, u *auth.User) error {
 	msg := "You must provide the environment variables"
 	if r.Body == nil {
@@ -371,17 +839,63 @@ func SetEnv(w http.ResponseWriter, r *http.Request, u *auth.User) error {
 	if err != nil {
 		return err
 	}
-	regex, err := regexp.Compile(`(\w+=[^=]+)(\s|$)`)
+	lock, err := lockApp(&app, u.Email, "env-set", 0)
 	if err != nil {
 		return err
 	}
-	variables := regex.FindAllStringSubmatch(string(body), -1)
-	envs := make([]bind.EnvVar, len(variables))
-	for i, v := range variables {
-		parts := strings.Split(v[1], "=")
-		envs[i] = bind.EnvVar{Name: parts[0], Value: parts[1], Public: true}
+	defer lock.Release()
+	if err = checkMaintenance(&app, u); err != nil {
+		return err
+	}
+	var envs []bind.EnvVar
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		envs, err = decodeEnvs(body)
+		if err != nil {
+			return err
+		}
+	} else {
+		regex, err := regexp.Compile(`(\w+=[^=]+)(\s|$)`)
+		if err != nil {
+			return err
+		}
+		variables := regex.FindAllStringSubmatch(string(body), -1)
+		envs = make([]bind.EnvVar, len(variables))
+		for i, v := range variables {
+			parts := strings.Split(v[1], "=")
+			envs[i] = bind.EnvVar{Name: parts[0], Value: parts[1], Public: true}
+		}
+	}
+	return app.SetEnvsToApp(envs, true, false, u.Email)
+}
+
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// decodeEnvs decodes a list of environment variables in the JSON format:
+//
+//	[{"name": "DATABASE_HOST", "value": "localhost", "public": true}]
+//
+// Values are taken verbatim. Variables are public unless "public" is false.
+func decodeEnvs(body []byte) ([]bind.EnvVar, error) {
+	var entries []struct {
+		Name   string
+		Value  string
+		Public *bool
+	}
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return nil, &errors.Http{Code: http.StatusBadRequest, Message: "Invalid JSON: " + err.Error()}
+	}
+	if len(entries) == 0 {
+		return nil, &errors.Http{Code: http.StatusBadRequest, Message: "You must provide the environment variables"}
+	}
+	envs := make([]bind.EnvVar, len(entries))
+	for i, e := range entries {
+		if !envNameRegexp.MatchString(e.Name) {
+			msg := fmt.Sprintf("Invalid environment variable name: %q.", e.Name)
+			return nil, &errors.Http{Code: http.StatusBadRequest, Message: msg}
+		}
+		envs[i] = bind.EnvVar{Name: e.Name, Value: e.Value, Public: e.Public == nil || *e.Public}
 	}
-	return app.SetEnvsToApp(envs, true, false)
+	return envs, nil
 }
 
 func UnsetEnv(w http.ResponseWriter, r *http.Request, u *auth.User) error {
@@ -401,53 +915,324 @@ func UnsetEnv(w http.ResponseWriter, r *http.Request, u *auth.User) error {
 	if err != nil {
 		return err
 	}
-	return app.UnsetEnvsFromApp(strings.Fields(string(body)), true, false)
+	lock, err := lockApp(&app, u.Email, "env-unset", 0)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	if err = checkMaintenance(&app, u); err != nil {
+		return err
+	}
+	return app.UnsetEnvsFromApp(strings.Fields(string(body)), true, false, u.Email)
+}
+
+// EnvHistoryHandler writes the versions of the environment variables of the
+// app, most recent first, as a JSON array.
+func EnvHistoryHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	versions, err := instance.EnvHistory()
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(versions)
+}
+
+// EnvRevertHandler restores the environment variables of the app to a
+// previous version. The version is read from the request body.
+func EnvRevertHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	msg := "You must provide the version to revert to."
+	if r.Body == nil {
+		return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	value := strings.TrimSpace(string(b))
+	if value == "" {
+		return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return &errors.Http{
+			Code:    http.StatusBadRequest,
+			Message: "Invalid version: the version must be a integer greater than 0.",
+		}
+	}
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	lock, err := lockApp(&instance, u.Email, "env-revert", 0)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	if err = checkMaintenance(&instance, u); err != nil {
+		return err
+	}
+	err = instance.RevertEnv(version, u.Email)
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusNotFound, Message: e.Message}
+	}
+	return err
+}
+
+// RotateEnvKeyHandler re-encrypts the private environment variables of all
+// apps with the current encryption key. Only admin users can rotate the key.
+func RotateEnvKeyHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	if !u.IsAdmin() {
+		return &errors.Http{Code: http.StatusForbidden, Message: "Only admin users can rotate the encryption key."}
+	}
+	count, err := app.RotateEnvKey()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]int{"apps": count})
+}
+
+// MaintenanceHandler turns the maintenance mode of an app on or off. The
+// request body is either "on" or "off". Only admin users can change the
+// maintenance mode of apps.
+func MaintenanceHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	if !u.IsAdmin() {
+		return &errors.Http{Code: http.StatusForbidden, Message: "Only admin users can change the maintenance mode of apps."}
+	}
+	msg := `You must provide the maintenance mode: "on" or "off".`
+	if r.Body == nil {
+		return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	mode := strings.TrimSpace(string(b))
+	if mode != "on" && mode != "off" {
+		return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	instance := app.App{Name: r.URL.Query().Get(":name")}
+	if err = instance.Get(); err != nil {
+		return &errors.Http{Code: http.StatusNotFound, Message: fmt.Sprintf("App %s not found.", instance.Name)}
+	}
+	err = instance.SetMaintenance(mode == "on", u.Email)
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
+
+// HealingHandler changes the self-healing policy of an app. The body is a JSON
+// object with the keys enabled and after, the latter being a duration like
+// "10m". An empty after means the default stuck timeout.
+func HealingHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var params struct {
+		Enabled bool
+		After   string
+	}
+	if err = json.Unmarshal(body, &params); err != nil {
+		return &errors.Http{Code: http.StatusBadRequest, Message: "Invalid JSON: " + err.Error()}
+	}
+	policy := app.HealingPolicy{Enabled: params.Enabled}
+	if params.After != "" {
+		policy.After, err = time.ParseDuration(params.After)
+		if err != nil || policy.After <= 0 {
+			msg := fmt.Sprintf("Invalid timeout %q, it must be a positive duration, like 10m.", params.After)
+			return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+		}
+	}
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	err = instance.SetHealingPolicy(policy, u.Email)
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
+
+// AutoscaleHandler changes the autoscale policy of an app. The body is a JSON
+// object with the keys enabled, process, min, max, metric, threshold and
+// cooldown, the latter being a duration like "5m". An empty cooldown means the
+// default cooldown.
+func AutoscaleHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var params struct {
+		Enabled   bool
+		Process   string
+		Min       uint
+		Max       uint
+		Metric    string
+		Threshold float64
+		Cooldown  string
+	}
+	if err = json.Unmarshal(body, &params); err != nil {
+		return &errors.Http{Code: http.StatusBadRequest, Message: "Invalid JSON: " + err.Error()}
+	}
+	policy := app.AutoscalePolicy{
+		Enabled:   params.Enabled,
+		Process:   params.Process,
+		MinUnits:  params.Min,
+		MaxUnits:  params.Max,
+		Metric:    params.Metric,
+		Threshold: params.Threshold,
+	}
+	if params.Cooldown != "" {
+		policy.Cooldown, err = time.ParseDuration(params.Cooldown)
+		if err != nil || policy.Cooldown <= 0 {
+			msg := fmt.Sprintf("Invalid cooldown %q, it must be a positive duration, like 5m.", params.Cooldown)
+			return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+		}
+	}
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	err = instance.SetAutoscalePolicy(policy, u.Email)
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
+
+// MetricsHandler lists the samples of the resources used by the units of an
+// app, sorted by unit and date. The since parameter is a duration, like "1h",
+// that limits how old the samples are, defaulting to one hour.
+func MetricsHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	since := time.Hour
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		since, err = time.ParseDuration(s)
+		if err != nil || since <= 0 {
+			msg := fmt.Sprintf("Invalid duration %q, it must be a positive duration, like 1h.", s)
+			return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+		}
+	}
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	metrics, err := instance.Metrics(time.Now().Add(-since))
+	if err != nil {
+		return err
+	}
+	if len(metrics) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(metrics)
+}
+
+// ForceUnlockHandler removes the lock of an app, whoever holds it, and writes
+// the removed lock. Only admin users can force the unlock of apps.
+func ForceUnlockHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	if !u.IsAdmin() {
+		return &errors.Http{Code: http.StatusForbidden, Message: "Only admin users can unlock apps."}
+	}
+	lock, err := app.ForceUnlock(r.URL.Query().Get(":name"))
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusNotFound, Message: e.Message}
+	} else if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(lock)
+}
+
+func parseLogDate(r *http.Request, param string) (time.Time, error) {
+	var t time.Time
+	value := r.URL.Query().Get(param)
+	if value == "" {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		msg := fmt.Sprintf("Invalid value for %q: the date must be in RFC 3339 format.", param)
+		return t, &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	return t, nil
 }
 
+func logFilter(r *http.Request) (app.LogFilter, error) {
+	var err error
+	filter := app.LogFilter{
+		Source: r.URL.Query().Get("source"),
+		Unit:   r.URL.Query().Get("unit"),
+	}
+	if filter.Since, err = parseLogDate(r, "since"); err != nil {
+		return filter, err
+	}
+	filter.Until, err = parseLogDate(r, "until")
+	return filter, err
+}
+
+// AppLog writes the last log entries of the app, as a JSON array.
+//
+// When the follow parameter is "true", the connection is kept open and new
+// entries are written, each of them as a JSON array, as they are added to the
+// app log.
 func AppLog(w http.ResponseWriter, r *http.Request, u *auth.User) error {
 	w.Header().Set("Content-Type", "application/json")
 	appName := r.URL.Query().Get(":name")
-	pipe := []bson.M{}
-	pipe = append(pipe, bson.M{"$unwind": "$logs"})
-	match := bson.M{}
-	match["name"] = appName
-	if source := r.URL.Query().Get("source"); source != "" {
-		match["logs.source"] = source
-	}
-	_, err := getAppOrError(appName, u)
+	instance, err := getAppOrError(appName, u)
 	if err != nil {
 		return err
 	}
-	pipe = append(pipe, bson.M{"$match": match})
-	pipe = append(pipe, bson.M{"$project": bson.M{"_id": 0, "logs": 1}})
-	pipe = append(pipe, bson.M{"$sort": bson.M{"logs.date": -1}})
+	var lines int
 	if l := r.URL.Query().Get("lines"); l != "" {
-		lines, err := strconv.Atoi(l)
+		lines, err = strconv.Atoi(l)
 		if err != nil {
 			return err
 		}
-		pipe = append(pipe, bson.M{"$limit": lines})
 	}
-	var result []map[string]map[string]interface{}
-	err = db.Session.Apps().Pipe(pipe).All(&result)
+	filter, err := logFilter(r)
 	if err != nil {
 		return err
 	}
-	n := len(result)
-	logs := make([]app.Applog, n)
-	for i, row := range result {
-		log := app.Applog{
-			Message: row["logs"]["message"].(string),
-			Source:  row["logs"]["source"].(string),
-			Date:    row["logs"]["date"].(time.Time),
-		}
-		logs[n-i-1] = log
+	logs, err := instance.LastLogs(lines, filter)
+	if err != nil {
+		return err
+	}
+	if logs == nil {
+		logs = []app.Applog{}
 	}
 	b, err := json.Marshal(logs)
 	if err != nil {
 		return err
 	}
-	return write(w, b)
+	if err = write(w, b); err != nil || r.URL.Query().Get("follow") != "true" {
+		return err
+	}
+	return instance.FollowLogs(filter, func(logs []app.Applog) error {
+		if len(logs) == 0 {
+			// keeps the connection alive, and detects closed ones.
+			return write(w, []byte("\n"))
+		}
+		b, err := json.Marshal(logs)
+		if err != nil {
+			return err
+		}
+		return write(w, b)
+	})
 }
 
 func serviceInstanceAndAppOrError(instanceName, appName string, u *auth.User) (instance service.ServiceInstance, a app.App, err error) {
@@ -478,6 +1263,11 @@ func BindHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
 	if err != nil {
 		return err
 	}
+	lock, err := lockApp(&a, u.Email, "bind", 0)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
 	err = instance.Bind(&a)
 	if err != nil {
 		return err
@@ -497,16 +1287,68 @@ func UnbindHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
 	if err != nil {
 		return err
 	}
+	lock, err := lockApp(&a, u.Email, "unbind", 0)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
 	return instance.Unbind(&a)
 }
 
+// RestartHandler restarts the app. If the "rolling" query string parameter is
+// "true", the units are restarted in batches, whose size is given by the
+// "batch-size" parameter (default: 1).
 func RestartHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
 	w.Header().Set("Content-Type", "text")
 	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
 	if err != nil {
 		return err
 	}
-	return instance.Restart(w)
+	lock, err := lockApp(&instance, u.Email, "restart", 0)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	if r.URL.Query().Get("rolling") != "true" {
+		return instance.Restart(w)
+	}
+	batchSize := 1
+	if size := r.URL.Query().Get("batch-size"); size != "" {
+		batchSize, err = strconv.Atoi(size)
+		if err != nil || batchSize < 1 {
+			msg := "The batch size must be a positive integer."
+			return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+		}
+	}
+	return instance.RollingRestart(w, batchSize)
+}
+
+func StopHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	w.Header().Set("Content-Type", "text")
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	lock, err := lockApp(&instance, u.Email, "stop", 0)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	return instance.Stop(w)
+}
+
+func StartHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	w.Header().Set("Content-Type", "text")
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	lock, err := lockApp(&instance, u.Email, "start", 0)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	return instance.Start(w)
 }
 
 func AddLogHandler(w http.ResponseWriter, r *http.Request) error {
@@ -523,7 +1365,7 @@ func AddLogHandler(w http.ResponseWriter, r *http.Request) error {
 	var logs []string
 	err = json.Unmarshal(body, &logs)
 	for _, log := range logs {
-		err := app.Log(log, "app")
+		err := app.UnitLog(log, "app", r.URL.Query().Get("unit"))
 		if err != nil {
 			return err
 		}
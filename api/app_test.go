@@ -26,6 +26,7 @@ import (
 	stdlog "log"
 	"net/http"
 	"net/http/httptest"
+	"os/exec"
 	"sort"
 	"strconv"
 	"strings"
@@ -75,6 +76,29 @@ func (s *S) TestAppIsAvaliableHandlerShouldReturnErrorWhenAppStatusIsnotStarted(
 	c.Assert(err, NotNil)
 }
 
+func (s *S) TestAppIsAvaliableHandlerShouldReturn200WhenTheAppIsDegraded(c *C) {
+	a := app.App{
+		Name:      "someapp",
+		Framework: "python",
+		Teams:     []string{s.team.Name},
+		Units: []app.Unit{
+			{Name: "someapp/0", Type: "django", State: string(provision.StatusStarted)},
+			{Name: "someapp/1", Type: "django", State: string(provision.StatusError)},
+		},
+		State: app.StateDegraded,
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/avaliable?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AppIsAvaliableHandler(recorder, request)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusOK)
+}
+
 func (s *S) TestAppIsAvaliableHandlerShouldReturn200WhenAppUnitStatusIsStarted(c *C) {
 	a := app.App{
 		Name:      "someapp",
@@ -102,13 +126,14 @@ pos-restart:
   - pos.sh
 `
 	s.provisioner.PrepareOutput(nil)            // clone
+	s.provisioner.PrepareOutput(nil)            // rev-parse
 	s.provisioner.PrepareOutput(nil)            // install
 	s.provisioner.PrepareOutput([]byte(output)) // loadHooks
 	s.provisioner.PrepareOutput(nil)            // pre-restart
 	s.provisioner.PrepareOutput(nil)            // restart
 	s.provisioner.PrepareOutput(nil)            // pos-restart
 	a := app.App{
-		Name:      "someapp",
+		Name:      "loggedapp",
 		Framework: "django",
 		Teams:     []string{s.team.Name},
 		State:     string(provision.StatusStarted),
@@ -130,7 +155,7 @@ pos-restart:
 		" ---> Deploy done!",
 	}
 	for _, msg := range messages {
-		length, err := db.Session.Apps().Find(bson.M{"logs.message": msg}).Count()
+		length, err := db.Session.Logs().Find(bson.M{"appname": a.Name, "message": msg}).Count()
 		c.Check(err, IsNil)
 		c.Check(length, Equals, 1)
 	}
@@ -143,6 +168,7 @@ pos-restart:
   - pos.sh
 `
 	s.provisioner.PrepareOutput(nil)            // clone
+	s.provisioner.PrepareOutput(nil)            // rev-parse
 	s.provisioner.PrepareOutput(nil)            // install
 	s.provisioner.PrepareOutput([]byte(output)) // loadHooks
 	s.provisioner.PrepareOutput(nil)            // pre-restart
@@ -186,6 +212,7 @@ pos-restart:
   - pos.sh
 `
 	s.provisioner.PrepareOutput(nil)            // clone
+	s.provisioner.PrepareOutput(nil)            // rev-parse
 	s.provisioner.PrepareOutput(nil)            // install
 	s.provisioner.PrepareOutput([]byte(output)) // loadHooks
 	s.provisioner.PrepareOutput(nil)            // pre-restart
@@ -223,6 +250,437 @@ func (s *S) TestCloneRepositoryShouldReturnNotFoundWhenAppDoesNotExist(c *C) {
 	c.Assert(e, ErrorMatches, "^App abc not found.$")
 }
 
+func (s *S) TestCloneRepositoryHandlerSavesTheDeploy(c *C) {
+	commit := "6c7a8e93c1ab2c7b3b5d8bc4e3c2f3a8b3d1a2e4"
+	s.provisioner.PrepareOutput(nil)               // clone
+	s.provisioner.PrepareOutput([]byte(commit))    // rev-parse
+	s.provisioner.PrepareOutput(nil)               // install
+	s.provisioner.PrepareOutput(nil)               // loadHooks
+	s.provisioner.PrepareOutput([]byte("nothing")) // restart
+	a := app.App{
+		Name:      "someapp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:name=%s&user=%s", a.Name, a.Name, s.user.Email)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = CloneRepositoryHandler(recorder, request)
+	c.Assert(err, IsNil)
+	deploys, err := a.Deploys()
+	c.Assert(err, IsNil)
+	c.Assert(deploys, HasLen, 1)
+	c.Assert(deploys[0].Commit, Equals, commit)
+	c.Assert(deploys[0].User, Equals, s.user.Email)
+	c.Assert(deploys[0].Success, Equals, true)
+	c.Assert(deploys[0].Error, Equals, "")
+}
+
+func (s *S) TestCloneRepositoryHandlerIgnoresUsersWithoutAccessToTheApp(c *C) {
+	s.provisioner.PrepareOutput(nil)               // clone
+	s.provisioner.PrepareOutput(nil)               // rev-parse
+	s.provisioner.PrepareOutput(nil)               // install
+	s.provisioner.PrepareOutput(nil)               // loadHooks
+	s.provisioner.PrepareOutput([]byte("nothing")) // restart
+	a := app.App{
+		Name:      "someapp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:name=%s&user=someone@tsuru.io", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = CloneRepositoryHandler(recorder, request)
+	c.Assert(err, IsNil)
+	deploys, err := a.Deploys()
+	c.Assert(err, IsNil)
+	c.Assert(deploys, HasLen, 1)
+	c.Assert(deploys[0].User, Equals, "git")
+}
+
+func (s *S) TestCloneRepositoryHandlerSavesFailedDeploys(c *C) {
+	s.provisioner.PrepareFailure("ExecuteCommand", &errors.Http{Code: 500, Message: "clone failed"})
+	s.provisioner.PrepareOutput([]byte("fatal: could not clone")) // clone
+	s.provisioner.PrepareFailure("ExecuteCommand", &errors.Http{Code: 500, Message: "pull failed"})
+	s.provisioner.PrepareOutput([]byte("fatal: could not pull")) // pull
+	a := app.App{
+		Name:      "someapp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = CloneRepositoryHandler(recorder, request)
+	c.Assert(err, NotNil)
+	deploys, err := a.Deploys()
+	c.Assert(err, IsNil)
+	c.Assert(deploys, HasLen, 1)
+	c.Assert(deploys[0].Success, Equals, false)
+	c.Assert(deploys[0].Error, Equals, "fatal: could not pull")
+}
+
+func (s *S) TestCloneRepositoryHandlerDeploysTheGivenRef(c *C) {
+	s.provisioner.PrepareOutput(nil)               // clone
+	s.provisioner.PrepareOutput(nil)               // rev-parse
+	s.provisioner.PrepareOutput(nil)               // install
+	s.provisioner.PrepareOutput(nil)               // loadHooks
+	s.provisioner.PrepareOutput([]byte("nothing")) // restart
+	a := app.App{
+		Name:      "someapp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+		Units:     []app.Unit{{Name: "someapp/0"}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:name=%s&ref=v1.0", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = CloneRepositoryHandler(recorder, request)
+	c.Assert(err, IsNil)
+	clone := fmt.Sprintf("git clone %s /home/application/current && cd /home/application/current && git checkout -q 'v1.0'", repository.GetReadOnlyUrl(a.Name))
+	c.Assert(s.provisioner.GetCmds(clone, &a), HasLen, 1)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units[0].Ref, Equals, "v1.0")
+	deploys, err := a.Deploys()
+	c.Assert(err, IsNil)
+	c.Assert(deploys, HasLen, 1)
+	c.Assert(deploys[0].Ref, Equals, "v1.0")
+}
+
+func (s *S) TestCloneRepositoryHandlerInvalidRef(c *C) {
+	a := app.App{Name: "someapp", Framework: "django", Teams: []string{s.team.Name}, Units: []app.Unit{{Name: "someapp/0"}}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:name=%s&ref=%s", a.Name, a.Name, "master%3Breboot")
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = CloneRepositoryHandler(recorder, request)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, `Invalid ref "master;reboot".`)
+	c.Assert(s.provisioner.GetCmds("", &a), HasLen, 0)
+	n, err := db.Session.Deploys().Find(bson.M{"app": a.Name}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *S) TestDeployHandler(c *C) {
+	s.provisioner.PrepareOutput(nil)               // clone
+	s.provisioner.PrepareOutput(nil)               // rev-parse
+	s.provisioner.PrepareOutput(nil)               // install
+	s.provisioner.PrepareOutput(nil)               // loadHooks
+	s.provisioner.PrepareOutput([]byte("nothing")) // restart
+	a := app.App{
+		Name:      "someapp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+		Units:     []app.Unit{{Name: "someapp/0"}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/deploy?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("hotfix"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = DeployHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "text")
+	c.Assert(recorder.Body.String(), Matches, "(?s).* ---> Deploy done!.*")
+	clone := fmt.Sprintf("git clone %s /home/application/current && cd /home/application/current && git checkout -q 'hotfix'", repository.GetReadOnlyUrl(a.Name))
+	c.Assert(s.provisioner.GetCmds(clone, &a), HasLen, 1)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units[0].Ref, Equals, "hotfix")
+	deploys, err := a.Deploys()
+	c.Assert(err, IsNil)
+	c.Assert(deploys, HasLen, 1)
+	c.Assert(deploys[0].Ref, Equals, "hotfix")
+	c.Assert(deploys[0].User, Equals, s.user.Email)
+}
+
+func (s *S) TestDeployHandlerWithoutRefDeploysMaster(c *C) {
+	s.provisioner.PrepareOutput(nil)               // clone
+	s.provisioner.PrepareOutput(nil)               // rev-parse
+	s.provisioner.PrepareOutput(nil)               // install
+	s.provisioner.PrepareOutput(nil)               // loadHooks
+	s.provisioner.PrepareOutput([]byte("nothing")) // restart
+	a := app.App{
+		Name:      "someapp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+		Units:     []app.Unit{{Name: "someapp/0"}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/deploy?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader(""))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = DeployHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	clone := fmt.Sprintf("git clone %s /home/application/current", repository.GetReadOnlyUrl(a.Name))
+	c.Assert(s.provisioner.GetCmds(clone, &a), HasLen, 1)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units[0].Ref, Equals, "master")
+}
+
+func (s *S) TestDeployHandlerInvalidRef(c *C) {
+	a := app.App{Name: "someapp", Framework: "django", Teams: []string{s.team.Name}, Units: []app.Unit{{Name: "someapp/0"}}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/deploy?:name=%s", a.Name, a.Name)
+	for _, ref := range []string{"--upload-pack=touch /tmp/pwned", "v1.0 && reboot", "$(id)"} {
+		request, err := http.NewRequest("POST", url, strings.NewReader(ref))
+		c.Assert(err, IsNil)
+		recorder := httptest.NewRecorder()
+		err = DeployHandler(recorder, request, s.user)
+		c.Assert(err, NotNil)
+		e, ok := err.(*errors.Http)
+		c.Assert(ok, Equals, true)
+		c.Assert(e.Code, Equals, http.StatusBadRequest)
+	}
+	c.Assert(s.provisioner.GetCmds("", &a), HasLen, 0)
+}
+
+func (s *S) TestDeployHandlerReturns404IfTheAppDoesNotExist(c *C) {
+	request, err := http.NewRequest("POST", "/apps/unknown/deploy?:name=unknown", strings.NewReader("v1.0"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = DeployHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+}
+
+func (s *S) TestDeployListHandler(c *C) {
+	a := app.App{
+		Name:  "someapp",
+		Teams: []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	now := time.Now()
+	deploys := []app.Deploy{
+		{App: a.Name, Commit: "abc123", User: "someone@tsuru.io", Timestamp: now.Add(-time.Hour), Success: true},
+		{App: a.Name, Commit: "def456", User: "someone@tsuru.io", Timestamp: now, Success: false, Error: "failed"},
+	}
+	for _, d := range deploys {
+		err = db.Session.Deploys().Insert(d)
+		c.Assert(err, IsNil)
+	}
+	url := fmt.Sprintf("/apps/%s/deploys?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = DeployListHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/json")
+	var result []app.Deploy
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 2)
+	c.Assert(result[0].Commit, Equals, "def456")
+	c.Assert(result[1].Commit, Equals, "abc123")
+}
+
+func (s *S) TestDeployListHandlerWithoutDeploys(c *C) {
+	a := app.App{
+		Name:  "someapp",
+		Teams: []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/deploys?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = DeployListHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusNoContent)
+}
+
+func (s *S) TestDeployListHandlerReturns404IfTheAppDoesNotExist(c *C) {
+	request, err := http.NewRequest("GET", "/apps/unknown/deploys?:name=unknown", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = DeployListHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+}
+
+func (s *S) TestRollbackHandler(c *C) {
+	commit := "6c7a8e93c1ab2c7b3b5d8bc4e3c2f3a8b3d1a2e4"
+	s.provisioner.PrepareOutput(nil)               // checkout
+	s.provisioner.PrepareOutput(nil)               // install
+	s.provisioner.PrepareOutput(nil)               // loadHooks
+	s.provisioner.PrepareOutput([]byte("nothing")) // restart
+	a := app.App{
+		Name:      "someapp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	d := app.Deploy{App: a.Name, Commit: commit, Timestamp: time.Now().Add(-time.Hour), Success: true}
+	err = db.Session.Deploys().Insert(d)
+	c.Assert(err, IsNil)
+	url := fmt.Sprintf("/apps/%s/rollback?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("6c7a8e9"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RollbackHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	regexp := `^# ---> Rolling back to 6c7a8e93c1ab2c7b3b5d8bc4e3c2f3a8b3d1a2e4#.*
+# ---> Installing dependencies#.*
+# ---> Restarting your app#.*
+# ---> Rollback done!##$
+`
+	c.Assert(strings.Replace(recorder.Body.String(), "\n", "#", -1), Matches, strings.Replace(regexp, "\n", "", -1))
+	checkout := "cd /home/application/current && ([ ! -f .git/shallow ] || git fetch -q --unshallow origin) && git fetch origin && git fetch --tags origin && (git checkout -q 'origin/" + commit + "' 2>/dev/null || git checkout -q '" + commit + "')"
+	c.Assert(s.provisioner.GetCmds(checkout, &a), HasLen, 1)
+	deploys, err := a.Deploys()
+	c.Assert(err, IsNil)
+	c.Assert(deploys, HasLen, 2)
+	c.Assert(deploys[0].Commit, Equals, commit)
+	c.Assert(deploys[0].User, Equals, s.user.Email)
+	c.Assert(deploys[0].Success, Equals, true)
+}
+
+func (s *S) TestDeployRollsBackWithTheHooksOfThePreviousCommit(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	good := "6c7a8e93c1ab2c7b3b5d8bc4e3c2f3a8b3d1a2e4"
+	bad := "b4d0000000000000000000000000000000000b4d"
+	badConf := "pos-restart:\n  - bad.sh\nhealthcheck:\n  path: /\n  timeout: 1\n  rollback: true\n"
+	goodConf := "pos-restart:\n  - good.sh\n"
+	outputs := []string{
+		"",       // clone
+		bad,      // rev-parse
+		"",       // install
+		badConf,  // loadHooks
+		"",       // restart
+		"",       // bad.sh
+		"",       // checkout
+		"",       // install
+		goodConf, // loadHooks
+		"",       // restart
+		"",       // good.sh
+	}
+	go func() {
+		for _, out := range outputs {
+			s.provisioner.PrepareOutput([]byte(out))
+		}
+	}()
+	a := app.App{
+		Name:      "someapp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+		Units:     []app.Unit{{Name: "someapp/0", Ip: server.URL[len("http://"):]}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	previous := app.Deploy{App: a.Name, Commit: good, Timestamp: time.Now().Add(-time.Hour), Success: true}
+	err = db.Session.Deploys().Insert(previous)
+	c.Assert(err, IsNil)
+	var w bytes.Buffer
+	err = deploy(&a, &w, app.NewDeploy(&a, s.user.Email))
+	c.Assert(err, FitsTypeOf, &app.HealthcheckError{})
+	c.Assert(w.String(), Matches, "(?s).* ---> Rolling back to "+good+".* ---> Rollback done!.*")
+	var badHooks, goodHooks int
+	for _, cmd := range s.provisioner.GetCmds("", &a) {
+		if strings.Contains(cmd.Cmd, "bad.sh") {
+			badHooks++
+		} else if strings.Contains(cmd.Cmd, "good.sh") {
+			goodHooks++
+		}
+	}
+	c.Assert(badHooks, Equals, 1)
+	c.Assert(goodHooks, Equals, 1)
+}
+
+func (s *S) TestRollbackHandlerUnknownCommit(c *C) {
+	a := app.App{
+		Name:  "someapp",
+		Teams: []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/rollback?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("6c7a8e9"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RollbackHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+	c.Assert(e.Message, Equals, "Commit 6c7a8e9 was never successfully deployed in the app someapp.")
+}
+
+func (s *S) TestRollbackHandlerWithoutCommit(c *C) {
+	request, err := http.NewRequest("POST", "/apps/someapp/rollback?:name=someapp", strings.NewReader(""))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RollbackHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+}
+
 func (s *S) TestAppList(c *C) {
 	app1 := app.App{
 		Name:  "app1",
@@ -610,6 +1068,67 @@ func (s *S) TestAddUnits(c *C) {
 	c.Assert(a.Units, HasLen, 3)
 }
 
+func (s *S) TestAddUnitsWithProcessType(c *C) {
+	server := testing.FakeQueueServer{}
+	err := server.Start("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err == nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	a := app.App{
+		Name:      "armorandsword",
+		Framework: "python",
+		State:     string(provision.StatusStarted),
+		Teams:     []string{s.team.Name},
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, IsNil)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.PrepareOutput([]byte("web: python app.py\nworker: python worker.py\n"))
+	body := strings.NewReader("2")
+	request, err := http.NewRequest("PUT", "/apps/armorandsword/units?:name=armorandsword&process=worker", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddUnitsHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 2)
+	c.Assert(a.Units[0].ProcessType, Equals, "worker")
+}
+
+func (s *S) TestAddUnitsReturns400IfTheProcessTypeIsNotDeclared(c *C) {
+	a := app.App{
+		Name:      "armorandsword",
+		Framework: "python",
+		State:     string(provision.StatusStarted),
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, IsNil)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.PrepareOutput([]byte("web: python app.py\n"))
+	body := strings.NewReader("2")
+	request, err := http.NewRequest("PUT", "/apps/armorandsword/units?:name=armorandsword&process=clock", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddUnitsHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, `Process type "clock" is not declared in the Procfile of the app armorandsword.`)
+}
+
 func (s *S) TestAddUnitsReturns404IfAppDoesNotExist(c *C) {
 	body := strings.NewReader("1")
 	request, err := http.NewRequest("PUT", "/apps/armorandsword/units?:name=armorandsword", body)
@@ -674,6 +1193,152 @@ func (s *S) TestAddUnitsReturns400IfNumberIsInvalid(c *C) {
 	}
 }
 
+func (s *S) TestRemoveUnits(c *C) {
+	server := testing.FakeQueueServer{}
+	err := server.Start("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err == nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	a := app.App{
+		Name:      "velha",
+		Framework: "python",
+		Teams:     []string{s.team.Name},
+	}
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, IsNil)
+	defer s.provisioner.Destroy(&a)
+	units, err := s.provisioner.AddUnits(&a, 3, "web")
+	c.Assert(err, IsNil)
+	for _, u := range units {
+		a.AddUnit(&app.Unit{Name: u.Name, Machine: u.Machine})
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader("2")
+	request, err := http.NewRequest("DELETE", "/apps/velha/units?:name=velha", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RemoveUnitsHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 1)
+	c.Assert(a.Units[0].Name, Equals, "velha/2")
+	c.Assert(s.provisioner.GetUnits(&a), HasLen, 1)
+}
+
+func (s *S) TestRemoveUnitsByName(c *C) {
+	server := testing.FakeQueueServer{}
+	err := server.Start("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err == nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	a := app.App{
+		Name:      "velha",
+		Framework: "python",
+		Teams:     []string{s.team.Name},
+	}
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, IsNil)
+	defer s.provisioner.Destroy(&a)
+	units, err := s.provisioner.AddUnits(&a, 3, "web")
+	c.Assert(err, IsNil)
+	for _, u := range units {
+		a.AddUnit(&app.Unit{Name: u.Name, Machine: u.Machine})
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader("velha/1")
+	request, err := http.NewRequest("DELETE", "/apps/velha/units?:name=velha", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RemoveUnitsHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 2)
+	c.Assert(a.Units[0].Name, Equals, "velha/0")
+	c.Assert(a.Units[1].Name, Equals, "velha/2")
+}
+
+func (s *S) TestRemoveUnitsReturns404IfAppDoesNotExist(c *C) {
+	body := strings.NewReader("1")
+	request, err := http.NewRequest("DELETE", "/apps/velha/units?:name=velha", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RemoveUnitsHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+	c.Assert(e.Message, Equals, "App velha not found.")
+}
+
+func (s *S) TestRemoveUnitsReturns403IfTheUserDoesNotHaveAccessToTheApp(c *C) {
+	a := app.App{
+		Name:      "velha",
+		Framework: "python",
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader("1")
+	request, err := http.NewRequest("DELETE", "/apps/velha/units?:name=velha", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RemoveUnitsHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+}
+
+func (s *S) TestRemoveUnitsReturns400IfTheBodyIsMissing(c *C) {
+	bodies := []io.Reader{nil, strings.NewReader("")}
+	for _, body := range bodies {
+		request, err := http.NewRequest("DELETE", "/apps/velha/units?:name=velha", body)
+		c.Assert(err, IsNil)
+		recorder := httptest.NewRecorder()
+		err = RemoveUnitsHandler(recorder, request, s.user)
+		c.Assert(err, NotNil)
+		e, ok := err.(*errors.Http)
+		c.Assert(ok, Equals, true)
+		c.Assert(e.Code, Equals, http.StatusBadRequest)
+		c.Assert(e.Message, Equals, "You must provide the number of units or the name of the unit to remove.")
+	}
+}
+
+func (s *S) TestRemoveUnitsReturns400IfTheAppWouldBeLeftWithoutUnits(c *C) {
+	a := app.App{
+		Name:  "velha",
+		Teams: []string{s.team.Name},
+		Units: []app.Unit{{Name: "velha/0"}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader("1")
+	request, err := http.NewRequest("DELETE", "/apps/velha/units?:name=velha", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RemoveUnitsHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, "You can't remove all units from an app.")
+}
+
 func (s *S) TestAddTeamToTheApp(c *C) {
 	h := testHandler{}
 	ts := s.t.StartGandalfTestServer(&h)
@@ -1005,6 +1670,90 @@ func (s *S) TestRunHandlerReturnsTheOutputOfTheCommandEvenIfItFails(c *C) {
 	c.Assert(recorder.Body.String(), Equals, "failure output")
 }
 
+func (s *S) TestRunHandlerInASingleUnit(c *C) {
+	s.provisioner.PrepareOutput([]byte("migrated"))
+	a := app.App{
+		Name:  "secrets",
+		Teams: []string{s.team.Name},
+		Units: []app.Unit{
+			{Name: "secrets/0", State: string(provision.StatusStarted)},
+			{Name: "secrets/1", State: string(provision.StatusStarted)},
+		},
+		State: string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/run/?:name=%s&once=true&timeout=1m", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("./migrate"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RunCommand(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Body.String(), Equals, "migrated")
+	cmds := s.provisioner.GetCmds("", &a)
+	c.Assert(cmds, HasLen, 1)
+	c.Assert(cmds[0].Cmd, Matches, `^timeout 60 /bin/bash -c .*\./migrate'$`)
+}
+
+func (s *S) TestRunHandlerReturnsBadRequestIfTheUnitIsNotStarted(c *C) {
+	a := app.App{
+		Name:  "secrets",
+		Teams: []string{s.team.Name},
+		Units: []app.Unit{{Name: "secrets/0", State: string(provision.StatusPending)}},
+		State: string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/run/?:name=%s&unit=secrets/0", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("./migrate"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RunCommand(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(s.provisioner.GetCmds("", &a), HasLen, 0)
+}
+
+func (s *S) TestRunHandlerReturnsBadRequestIfTheTimeoutIsInvalid(c *C) {
+	for _, timeout := range []string{"soon", "-1s", "0"} {
+		url := "/apps/secrets/run/?:name=secrets&timeout=" + timeout
+		request, err := http.NewRequest("POST", url, strings.NewReader("ls"))
+		c.Assert(err, IsNil)
+		recorder := httptest.NewRecorder()
+		err = RunCommand(recorder, request, s.user)
+		c.Assert(err, NotNil)
+		e, ok := err.(*errors.Http)
+		c.Assert(ok, Equals, true)
+		c.Assert(e.Code, Equals, http.StatusBadRequest)
+	}
+}
+
+func (s *S) TestRunHandlerWritesTheExitStatusOfTheCommand(c *C) {
+	exitErr := exec.Command("/bin/sh", "-c", "exit 3").Run()
+	s.provisioner.PrepareFailure("ExecuteCommand", exitErr)
+	s.provisioner.PrepareOutput([]byte("no migrations to apply"))
+	a := app.App{
+		Name:  "secrets",
+		Teams: []string{s.team.Name},
+		Units: []app.Unit{{Name: "secrets/0", State: string(provision.StatusStarted)}},
+		State: string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/run/?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("./migrate"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RunCommand(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Body.String(), Equals, "no migrations to apply\n ---> Exit status: 3\n")
+}
+
 func (s *S) TestRunHandlerReturnsBadRequestIfTheCommandIsMissing(c *C) {
 	bodies := []io.Reader{nil, strings.NewReader("")}
 	for _, body := range bodies {
@@ -1134,6 +1883,40 @@ func (s *S) TestGetEnvHandlerReturnsAllVariablesIfEnvironmentVariablesAreMissing
 	}
 }
 
+func (s *S) TestGetEnvHandlerInJSON(c *C) {
+	a := app.App{
+		Name:      "time",
+		Framework: "pink-floyd",
+		Teams:     []string{s.team.Name},
+		Env: map[string]bind.EnvVar{
+			"DATABASE_HOST":     {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+			"DATABASE_USER":     {Name: "DATABASE_USER", Value: "root", Public: true},
+			"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Value: "secret", Public: false},
+			"MYSQL_HOST":        {Name: "MYSQL_HOST", Value: "10.0.0.1", Public: true, InstanceName: "mysql-time"},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("GET", "/apps/time/env/?:name=time", nil)
+	c.Assert(err, IsNil)
+	request.Header.Set("Accept", "application/json")
+	recorder := httptest.NewRecorder()
+	err = GetEnv(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/json")
+	var got []map[string]interface{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &got)
+	c.Assert(err, IsNil)
+	expected := []map[string]interface{}{
+		{"name": "DATABASE_HOST", "value": "localhost", "public": true},
+		{"name": "DATABASE_PASSWORD", "value": "***", "public": false},
+		{"name": "DATABASE_USER", "value": "root", "public": true},
+		{"name": "MYSQL_HOST", "value": "10.0.0.1", "public": true, "instanceName": "mysql-time"},
+	}
+	c.Assert(got, DeepEquals, expected)
+}
+
 func (s *S) TestGetEnvHandlerReturnsInternalErrorIfReadAllFails(c *C) {
 	b := s.getTestData("bodyToBeClosed.txt")
 	request, err := http.NewRequest("GET", "/apps/unkown/env/?:name=unknown", b)
@@ -1311,12 +2094,13 @@ func (s *S) TestSetEnvHandlerShouldSupportLowerCasedVariableName(c *C) {
 	c.Assert(app.Env["http_proxy"], DeepEquals, expected)
 }
 
-func (s *S) TestSetEnvHandlerShouldNotChangeValueOfPrivateVariables(c *C) {
+func (s *S) TestSetEnvHandlerShouldNotChangeValueOfServiceVariables(c *C) {
 	original := map[string]bind.EnvVar{
 		"DATABASE_HOST": {
-			Name:   "DATABASE_HOST",
-			Value:  "privatehost.com",
-			Public: false,
+			Name:         "DATABASE_HOST",
+			Value:        "privatehost.com",
+			Public:       false,
+			InstanceName: "mysql",
 		},
 	}
 	a := app.App{
@@ -1340,26 +2124,107 @@ func (s *S) TestSetEnvHandlerShouldNotChangeValueOfPrivateVariables(c *C) {
 	c.Assert(app.Env, DeepEquals, original)
 }
 
-func (s *S) TestSetEnvHandlerReturnsInternalErrorIfReadAllFails(c *C) {
-	b := s.getTestData("bodyToBeClosed.txt")
-	request, err := http.NewRequest("POST", "/apps/unkown/env/?:name=unknown", b)
+func (s *S) TestSetEnvHandlerUpdatesPrivateVariablesSetByTheUser(c *C) {
+	a := app.App{
+		Name:  "losers",
+		Teams: []string{s.team.Name},
+		Units: []app.Unit{{Machine: 1}},
+	}
+	err := db.Session.Apps().Insert(a)
 	c.Assert(err, IsNil)
-	request.Body.Close()
-	recorder := httptest.NewRecorder()
-	err = SetEnv(recorder, request, s.user)
-	c.Assert(err, NotNil)
-}
-
-func (s *S) TestSetEnvHandlerReturnsBadRequestIfVariablesAreMissing(c *C) {
-	bodies := []io.Reader{nil, strings.NewReader("")}
-	for _, body := range bodies {
-		request, err := http.NewRequest("POST", "/apps/unknown/env/?:name=unkown", body)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/env?:name=%s", a.Name, a.Name)
+	for _, value := range []string{"abc", "xyz"} {
+		body := fmt.Sprintf(`[{"name": "SECRET_TOKEN", "value": %q, "public": false}]`, value)
+		request, err := http.NewRequest("POST", url, strings.NewReader(body))
 		c.Assert(err, IsNil)
+		request.Header.Set("Content-Type", "application/json")
 		recorder := httptest.NewRecorder()
 		err = SetEnv(recorder, request, s.user)
-		c.Assert(err, NotNil)
-		e, ok := err.(*errors.Http)
-		c.Assert(ok, Equals, true)
+		c.Assert(err, IsNil)
+	}
+	err = a.Get()
+	c.Assert(err, IsNil)
+	expected := bind.EnvVar{Name: "SECRET_TOKEN", Value: "xyz", Public: false}
+	c.Assert(a.Env["SECRET_TOKEN"], DeepEquals, expected)
+}
+
+func (s *S) TestSetEnvHandlerWithJSONBody(c *C) {
+	a := app.App{
+		Name:  "the-ocean",
+		Teams: []string{s.team.Name},
+		Units: []app.Unit{{Machine: 1}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := `[{"name": "DATABASE_URL", "value": "mysql://root@localhost/db?opt=1&b=2"},
+{"name": "GREETING", "value": "hello  world", "public": true},
+{"name": "SECRET", "value": "$ec'r\"et` + "`" + `", "public": false}]`
+	url := fmt.Sprintf("/apps/%s/env?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader(body))
+	c.Assert(err, IsNil)
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	err = SetEnv(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	instance := &app.App{Name: a.Name}
+	err = instance.Get()
+	c.Assert(err, IsNil)
+	expectedURL := bind.EnvVar{Name: "DATABASE_URL", Value: "mysql://root@localhost/db?opt=1&b=2", Public: true}
+	expectedGreeting := bind.EnvVar{Name: "GREETING", Value: "hello  world", Public: true}
+	c.Assert(instance.Env["DATABASE_URL"], DeepEquals, expectedURL)
+	c.Assert(instance.Env["GREETING"], DeepEquals, expectedGreeting)
+	secret := instance.Env["SECRET"]
+	c.Assert(secret.Public, Equals, false)
+	c.Assert(secret.Value, Not(Equals), "$ec'r\"et`")
+}
+
+func (s *S) TestSetEnvHandlerWithJSONBodyReturns400IfTheBodyIsInvalid(c *C) {
+	a := app.App{Name: "the-ocean", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	bodies := map[string]string{
+		`{"name": "DATABASE_HOST"}`: "^Invalid JSON: .*",
+		`[]`:                        "^You must provide the environment variables$",
+		`[{"name": "DATABASE HOST", "value": "a"}]`: `^Invalid environment variable name: "DATABASE HOST".$`,
+	}
+	url := fmt.Sprintf("/apps/%s/env?:name=%s", a.Name, a.Name)
+	for body, msg := range bodies {
+		request, err := http.NewRequest("POST", url, strings.NewReader(body))
+		c.Assert(err, IsNil)
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		err = SetEnv(recorder, request, s.user)
+		c.Assert(err, NotNil)
+		e, ok := err.(*errors.Http)
+		c.Assert(ok, Equals, true)
+		c.Assert(e.Code, Equals, http.StatusBadRequest)
+		c.Assert(e, ErrorMatches, msg)
+	}
+}
+
+func (s *S) TestSetEnvHandlerReturnsInternalErrorIfReadAllFails(c *C) {
+	b := s.getTestData("bodyToBeClosed.txt")
+	request, err := http.NewRequest("POST", "/apps/unkown/env/?:name=unknown", b)
+	c.Assert(err, IsNil)
+	request.Body.Close()
+	recorder := httptest.NewRecorder()
+	err = SetEnv(recorder, request, s.user)
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestSetEnvHandlerReturnsBadRequestIfVariablesAreMissing(c *C) {
+	bodies := []io.Reader{nil, strings.NewReader("")}
+	for _, body := range bodies {
+		request, err := http.NewRequest("POST", "/apps/unknown/env/?:name=unkown", body)
+		c.Assert(err, IsNil)
+		recorder := httptest.NewRecorder()
+		err = SetEnv(recorder, request, s.user)
+		c.Assert(err, NotNil)
+		e, ok := err.(*errors.Http)
+		c.Assert(ok, Equals, true)
 		c.Assert(e.Code, Equals, http.StatusBadRequest)
 		c.Assert(e, ErrorMatches, "^You must provide the environment variables$")
 	}
@@ -1453,14 +2318,14 @@ func (s *S) TestUnsetEnvHandlerRemovesAllGivenEnvironmentVariables(c *C) {
 	c.Assert(app.Env, DeepEquals, expected)
 }
 
-func (s *S) TestUnsetHandlerDoesNotRemovePrivateVariables(c *C) {
+func (s *S) TestUnsetHandlerDoesNotRemoveServiceVariables(c *C) {
 	a := app.App{
 		Name:  "letitbe",
 		Teams: []string{s.team.Name},
 		Env: map[string]bind.EnvVar{
 			"DATABASE_HOST":     {Name: "DATABASE_HOST", Value: "localhost", Public: true},
 			"DATABASE_USER":     {Name: "DATABASE_USER", Value: "root", Public: true},
-			"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Value: "secret", Public: false},
+			"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Value: "secret", Public: false, InstanceName: "mysql"},
 		},
 	}
 	err := db.Session.Apps().Insert(a)
@@ -1476,15 +2341,44 @@ func (s *S) TestUnsetHandlerDoesNotRemovePrivateVariables(c *C) {
 	err = app.Get()
 	expected := map[string]bind.EnvVar{
 		"DATABASE_PASSWORD": {
-			Name:   "DATABASE_PASSWORD",
-			Value:  "secret",
-			Public: false,
+			Name:         "DATABASE_PASSWORD",
+			Value:        "secret",
+			Public:       false,
+			InstanceName: "mysql",
 		},
 	}
 	c.Assert(err, IsNil)
 	c.Assert(app.Env, DeepEquals, expected)
 }
 
+func (s *S) TestUnsetEnvHandlerRemovesPrivateVariablesSetByTheUser(c *C) {
+	a := app.App{
+		Name:  "letitbe",
+		Teams: []string{s.team.Name},
+		Units: []app.Unit{{Machine: 1}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/env?:name=%s", a.Name, a.Name)
+	body := `[{"name": "SECRET_TOKEN", "value": "abc", "public": false}]`
+	request, err := http.NewRequest("POST", url, strings.NewReader(body))
+	c.Assert(err, IsNil)
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	err = SetEnv(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	request, err = http.NewRequest("DELETE", url, strings.NewReader("SECRET_TOKEN"))
+	c.Assert(err, IsNil)
+	recorder = httptest.NewRecorder()
+	err = UnsetEnv(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	_, ok := a.Env["SECRET_TOKEN"]
+	c.Assert(ok, Equals, false)
+}
+
 func (s *S) TestUnsetEnvHandlerReturnsInternalErrorIfReadAllFails(c *C) {
 	b := s.getTestData("bodyToBeClosed.txt")
 	request, err := http.NewRequest("POST", "/apps/unkown/env/?:name=unknown", b)
@@ -1539,157 +2433,823 @@ func (s *S) TestUnsetEnvHandlerReturnsForbiddenIfTheGivenUserDoesNotHaveAccessTo
 	c.Assert(e.Code, Equals, http.StatusForbidden)
 }
 
-func (s *S) TestLogShouldReturnNotFoundWhenAppDoesNotExist(c *C) {
-	request, err := http.NewRequest("GET", "/apps/unknown/log/?:name=unknown", nil)
+func (s *S) TestEnvHistoryHandler(c *C) {
+	a := app.App{Name: "mountain-mama", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvVersions().RemoveAll(bson.M{"app": a.Name})
+	envs := []bind.EnvVar{
+		{Name: "DATABASE_HOST", Value: "localhost", Public: true},
+		{Name: "DATABASE_PASSWORD", Value: "secret", Public: false},
+	}
+	err = a.SetEnvsToApp(envs, false, false, s.user.Email)
+	c.Assert(err, IsNil)
+	url := fmt.Sprintf("/apps/%s/env/history?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
 	c.Assert(err, IsNil)
 	recorder := httptest.NewRecorder()
-	err = AppLog(recorder, request, s.user)
-	c.Assert(err, NotNil)
-	e, ok := err.(*errors.Http)
-	c.Assert(ok, Equals, true)
-	c.Assert(e.Code, Equals, http.StatusNotFound)
-	c.Assert(e, ErrorMatches, "^App unknown not found.$")
+	err = EnvHistoryHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/json")
+	c.Assert(strings.Contains(recorder.Body.String(), "secret"), Equals, false)
+	var versions []app.EnvVersion
+	err = json.Unmarshal(recorder.Body.Bytes(), &versions)
+	c.Assert(err, IsNil)
+	c.Assert(versions, HasLen, 1)
+	c.Assert(versions[0].Version, Equals, 1)
+	c.Assert(versions[0].User, Equals, s.user.Email)
+	c.Assert(versions[0].Added, DeepEquals, []string{"DATABASE_HOST=localhost", "DATABASE_PASSWORD=***"})
+	c.Assert(versions[0].Env, IsNil)
 }
 
-func (s *S) TestLogReturnsForbiddenIfTheGivenUserDoesNotHaveAccessToTheApp(c *C) {
-	a := app.App{
-		Name:      "lost",
-		Framework: "vougan",
-	}
+func (s *S) TestEnvHistoryHandlerWithoutVersions(c *C) {
+	a := app.App{Name: "mountain-mama", Teams: []string{s.team.Name}}
 	err := db.Session.Apps().Insert(a)
 	c.Assert(err, IsNil)
 	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
-	url := fmt.Sprintf("/apps/%s/log/?:name=%s", a.Name, a.Name)
+	url := fmt.Sprintf("/apps/%s/env/history?:name=%s", a.Name, a.Name)
 	request, err := http.NewRequest("GET", url, nil)
 	c.Assert(err, IsNil)
 	recorder := httptest.NewRecorder()
-	err = AppLog(recorder, request, s.user)
+	err = EnvHistoryHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusNoContent)
+}
+
+func (s *S) TestEnvRevertHandler(c *C) {
+	server := testing.FakeQueueServer{}
+	err := server.Start("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err == nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	a := app.App{Name: "mountain-mama", Teams: []string{s.team.Name}}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvVersions().RemoveAll(bson.M{"app": a.Name})
+	err = a.SetEnvsToApp([]bind.EnvVar{{Name: "DEBUG", Value: "0", Public: true}}, false, true, s.user.Email)
+	c.Assert(err, IsNil)
+	err = a.SetEnvsToApp([]bind.EnvVar{{Name: "DEBUG", Value: "1", Public: true}}, false, true, s.user.Email)
+	c.Assert(err, IsNil)
+	url := fmt.Sprintf("/apps/%s/env/revert?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("1"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = EnvRevertHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Env["DEBUG"].Value, Equals, "0")
+}
+
+func (s *S) TestEnvRevertHandlerReturns400IfTheVersionIsInvalid(c *C) {
+	for _, body := range []string{"", "zero", "-1", "0"} {
+		request, err := http.NewRequest("POST", "/apps/mountain-mama/env/revert?:name=mountain-mama", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		recorder := httptest.NewRecorder()
+		err = EnvRevertHandler(recorder, request, s.user)
+		c.Assert(err, NotNil)
+		e, ok := err.(*errors.Http)
+		c.Assert(ok, Equals, true)
+		c.Assert(e.Code, Equals, http.StatusBadRequest)
+	}
+}
+
+func (s *S) TestEnvRevertHandlerReturns404IfTheVersionDoesNotExist(c *C) {
+	a := app.App{Name: "mountain-mama", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/env/revert?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("3"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = EnvRevertHandler(recorder, request, s.user)
 	c.Assert(err, NotNil)
 	e, ok := err.(*errors.Http)
 	c.Assert(ok, Equals, true)
-	c.Assert(e.Code, Equals, http.StatusForbidden)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+	c.Assert(e.Message, Equals, "Version 3 not found in the env history of the app mountain-mama.")
 }
 
-func (s *S) TestAppLogShouldHaveContentType(c *C) {
+func (s *S) TestRotateEnvKeyHandler(c *C) {
+	adminTeamName, err := config.GetString("admin-team")
+	c.Assert(err, IsNil)
+	adminTeam := auth.Team{Name: adminTeamName, Users: []string{s.user.Email}}
+	err = db.Session.Teams().Insert(&adminTeam)
+	c.Assert(err, IsNil)
+	defer db.Session.Teams().RemoveId(adminTeam.Name)
 	a := app.App{
-		Name:      "lost",
-		Framework: "vougan",
-		Teams:     []string{s.team.Name},
-		Logs: []app.Applog{
-			{
-				Date:    time.Now(),
-				Message: "Something new",
-			},
+		Name: "mountain-mama",
+		Env: map[string]bind.EnvVar{
+			"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Value: "secret", Public: false},
 		},
 	}
-	err := db.Session.Apps().Insert(a)
+	err = db.Session.Apps().Insert(a)
 	c.Assert(err, IsNil)
 	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
-	url := fmt.Sprintf("/apps/%s/log/?:name=%s", a.Name, a.Name)
-	request, err := http.NewRequest("GET", url, nil)
+	request, err := http.NewRequest("POST", "/env/rotate-key", nil)
 	c.Assert(err, IsNil)
 	recorder := httptest.NewRecorder()
-	request.Header.Set("Content-Type", "application/json")
-	err = AppLog(recorder, request, s.user)
+	err = RotateEnvKeyHandler(recorder, request, s.user)
 	c.Assert(err, IsNil)
 	c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/json")
+	var result map[string]int
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, map[string]int{"apps": 1})
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Env["DATABASE_PASSWORD"].Value, Not(Equals), "secret")
+	c.Assert(a.InstanceEnv("")["DATABASE_PASSWORD"].Value, Equals, "secret")
 }
 
-func (s *S) TestAppLogSelectByLines(c *C) {
-	a := app.App{
-		Name:      "lost",
-		Framework: "vougan",
-		Teams:     []string{s.team.Name},
-	}
-	err := db.Session.Apps().Insert(a)
+func (s *S) TestForceUnlockHandler(c *C) {
+	adminTeamName, err := config.GetString("admin-team")
 	c.Assert(err, IsNil)
-	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
-	for i := 0; i < 15; i++ {
-		a.Log(strconv.Itoa(i), "source")
-	}
-	url := fmt.Sprintf("/apps/%s/log/?:name=%s&lines=10", a.Name, a.Name)
-	request, err := http.NewRequest("GET", url, nil)
+	adminTeam := auth.Team{Name: adminTeamName, Users: []string{s.user.Email}}
+	err = db.Session.Teams().Insert(&adminTeam)
+	c.Assert(err, IsNil)
+	defer db.Session.Teams().RemoveId(adminTeam.Name)
+	_, err = app.AcquireLock("mountain-mama", "other@tsuru.io", "deploy", 0)
+	c.Assert(err, IsNil)
+	request, err := http.NewRequest("DELETE", "/apps/mountain-mama/lock?:name=mountain-mama", nil)
 	c.Assert(err, IsNil)
 	recorder := httptest.NewRecorder()
-	request.Header.Set("Content-Type", "application/json")
-	err = AppLog(recorder, request, s.user)
+	err = ForceUnlockHandler(recorder, request, s.user)
 	c.Assert(err, IsNil)
-	c.Assert(recorder.Code, Equals, http.StatusOK)
-	body, err := ioutil.ReadAll(recorder.Body)
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/json")
+	var lock app.Lock
+	err = json.Unmarshal(recorder.Body.Bytes(), &lock)
 	c.Assert(err, IsNil)
-	logs := []app.Applog{}
-	err = json.Unmarshal(body, &logs)
+	c.Assert(lock.Owner, Equals, "other@tsuru.io")
+	c.Assert(lock.Operation, Equals, "deploy")
+	n, err := db.Session.AppLocks().FindId("mountain-mama").Count()
 	c.Assert(err, IsNil)
-	c.Assert(logs, HasLen, 10)
+	c.Assert(n, Equals, 0)
 }
 
-func (s *S) TestAppLogSelectBySource(c *C) {
-	a := app.App{
-		Name:      "lost",
-		Framework: "vougan",
-		Teams:     []string{s.team.Name},
-	}
-	err := db.Session.Apps().Insert(a)
+func (s *S) TestForceUnlockHandlerReturnsNotFoundIfTheAppIsNotLocked(c *C) {
+	adminTeamName, err := config.GetString("admin-team")
+	c.Assert(err, IsNil)
+	adminTeam := auth.Team{Name: adminTeamName, Users: []string{s.user.Email}}
+	err = db.Session.Teams().Insert(&adminTeam)
+	c.Assert(err, IsNil)
+	defer db.Session.Teams().RemoveId(adminTeam.Name)
+	request, err := http.NewRequest("DELETE", "/apps/mountain-mama/lock?:name=mountain-mama", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = ForceUnlockHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+	c.Assert(e.Message, Equals, "The app mountain-mama is not locked.")
+}
+
+func (s *S) TestForceUnlockHandlerReturnsForbiddenIfTheUserIsNotAnAdmin(c *C) {
+	request, err := http.NewRequest("DELETE", "/apps/mountain-mama/lock?:name=mountain-mama", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = ForceUnlockHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+}
+
+func (s *S) TestMaintenanceHandler(c *C) {
+	adminTeamName, err := config.GetString("admin-team")
+	c.Assert(err, IsNil)
+	adminTeam := auth.Team{Name: adminTeamName, Users: []string{s.user.Email}}
+	err = db.Session.Teams().Insert(&adminTeam)
+	c.Assert(err, IsNil)
+	defer db.Session.Teams().RemoveId(adminTeam.Name)
+	a := app.App{Name: "frozen", Framework: "python", Teams: []string{s.team.Name}}
+	err = db.Session.Apps().Insert(a)
 	c.Assert(err, IsNil)
 	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
-	a.Log("mars log", "mars")
-	a.Log("earth log", "earth")
-	url := fmt.Sprintf("/apps/%s/log/?:name=%s&source=mars", a.Name, a.Name)
-	request, err := http.NewRequest("GET", url, nil)
+	request, err := http.NewRequest("POST", "/apps/frozen/maintenance?:name=frozen", strings.NewReader("on"))
 	c.Assert(err, IsNil)
 	recorder := httptest.NewRecorder()
-	request.Header.Set("Content-Type", "application/json")
-	err = AppLog(recorder, request, s.user)
+	err = MaintenanceHandler(recorder, request, s.user)
 	c.Assert(err, IsNil)
-	c.Assert(recorder.Code, Equals, http.StatusOK)
-	body, err := ioutil.ReadAll(recorder.Body)
+	err = a.Get()
 	c.Assert(err, IsNil)
-	logs := []app.Applog{}
-	err = json.Unmarshal(body, &logs)
+	c.Assert(a.Maintenance.Enabled, Equals, true)
+	c.Assert(a.Maintenance.User, Equals, s.user.Email)
+}
+
+func (s *S) TestMaintenanceHandlerReturnsBadRequestIfTheModeIsInvalid(c *C) {
+	adminTeamName, err := config.GetString("admin-team")
 	c.Assert(err, IsNil)
-	c.Assert(logs, HasLen, 1)
-	c.Assert(logs[0].Message, Equals, "mars log")
-	c.Assert(logs[0].Source, Equals, "mars")
+	adminTeam := auth.Team{Name: adminTeamName, Users: []string{s.user.Email}}
+	err = db.Session.Teams().Insert(&adminTeam)
+	c.Assert(err, IsNil)
+	defer db.Session.Teams().RemoveId(adminTeam.Name)
+	request, err := http.NewRequest("POST", "/apps/frozen/maintenance?:name=frozen", strings.NewReader("maybe"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = MaintenanceHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, `You must provide the maintenance mode: "on" or "off".`)
 }
 
-func (s *S) TestAppLogSelectByLinesShouldReturnTheLastestEntries(c *C) {
-	a := app.App{
-		Name:      "lost",
-		Framework: "vougan",
-		Teams:     []string{s.team.Name},
-	}
+func (s *S) TestMaintenanceHandlerReturnsForbiddenIfTheUserIsNotAnAdmin(c *C) {
+	request, err := http.NewRequest("POST", "/apps/frozen/maintenance?:name=frozen", strings.NewReader("on"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = MaintenanceHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+}
+
+func (s *S) TestHealingHandler(c *C) {
+	a := app.App{Name: "healthy", Framework: "python", Teams: []string{s.team.Name}}
 	err := db.Session.Apps().Insert(a)
 	c.Assert(err, IsNil)
 	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
-	logs := make([]app.Applog, 15)
-	now := time.Now()
-	for i := 0; i < 15; i++ {
-		logs[i] = app.Applog{
-			Date:    now.Add(time.Duration(i) * time.Hour),
-			Message: strconv.Itoa(i),
-			Source:  "source",
-		}
-	}
-	a.Logs = logs
-	err = db.Session.Apps().Update(bson.M{"name": a.Name}, a)
+	body := strings.NewReader(`{"enabled":true,"after":"15m"}`)
+	request, err := http.NewRequest("POST", "/apps/healthy/healing?:name=healthy", body)
 	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = HealingHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Healing, DeepEquals, app.HealingPolicy{Enabled: true, After: 15 * time.Minute})
+}
+
+func (s *S) TestHealingHandlerReturnsBadRequestIfTheTimeoutIsInvalid(c *C) {
+	body := strings.NewReader(`{"enabled":true,"after":"soon"}`)
+	request, err := http.NewRequest("POST", "/apps/healthy/healing?:name=healthy", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = HealingHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, `Invalid timeout "soon", it must be a positive duration, like 10m.`)
+}
+
+func (s *S) TestHealingHandlerChecksAccess(c *C) {
+	a := app.App{Name: "healthy", Framework: "python", Teams: []string{"otherteam"}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("POST", "/apps/healthy/healing?:name=healthy", strings.NewReader(`{"enabled":true}`))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = HealingHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+}
+
+func (s *S) TestAutoscaleHandler(c *C) {
+	a := app.App{Name: "elastic", Framework: "python", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"enabled":true,"min":2,"max":6,"metric":"cpu","threshold":70,"cooldown":"10m"}`)
+	request, err := http.NewRequest("POST", "/apps/elastic/autoscale?:name=elastic", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AutoscaleHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	expected := app.AutoscalePolicy{
+		Enabled:   true,
+		MinUnits:  2,
+		MaxUnits:  6,
+		Metric:    "cpu",
+		Threshold: 70,
+		Cooldown:  10 * time.Minute,
+	}
+	c.Assert(a.Autoscale, DeepEquals, expected)
+}
+
+func (s *S) TestAutoscaleHandlerReturnsBadRequestIfThePolicyIsInvalid(c *C) {
+	a := app.App{Name: "elastic", Framework: "python", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"enabled":true,"min":2,"max":6,"metric":"disk","threshold":70}`)
+	request, err := http.NewRequest("POST", "/apps/elastic/autoscale?:name=elastic", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AutoscaleHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, `Invalid metric "disk", it must be cpu, memory or network.`)
+}
+
+func (s *S) TestAutoscaleHandlerReturnsBadRequestIfTheCooldownIsInvalid(c *C) {
+	body := strings.NewReader(`{"enabled":true,"min":1,"max":2,"metric":"cpu","threshold":70,"cooldown":"soon"}`)
+	request, err := http.NewRequest("POST", "/apps/elastic/autoscale?:name=elastic", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AutoscaleHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, `Invalid cooldown "soon", it must be a positive duration, like 5m.`)
+}
+
+func (s *S) TestAutoscaleHandlerChecksAccess(c *C) {
+	a := app.App{Name: "elastic", Framework: "python", Teams: []string{"otherteam"}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("POST", "/apps/elastic/autoscale?:name=elastic", strings.NewReader(`{"enabled":false}`))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AutoscaleHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+}
+
+func (s *S) TestMetricsHandler(c *C) {
+	a := app.App{Name: "measured", Framework: "python", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Metrics().RemoveAll(bson.M{"app": a.Name})
+	now := time.Now()
+	err = db.Session.Metrics().Insert(
+		app.UnitMetrics{App: a.Name, Unit: "measured/0", Date: now.Add(-time.Minute), CPU: 12.5},
+		app.UnitMetrics{App: a.Name, Unit: "measured/0", Date: now.Add(-3 * time.Hour), CPU: 50},
+	)
+	c.Assert(err, IsNil)
+	request, err := http.NewRequest("GET", "/apps/measured/metrics?:name=measured&since=2h", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = MetricsHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/json")
+	var metrics []app.UnitMetrics
+	err = json.Unmarshal(recorder.Body.Bytes(), &metrics)
+	c.Assert(err, IsNil)
+	c.Assert(metrics, HasLen, 1)
+	c.Assert(metrics[0].CPU, Equals, 12.5)
+}
+
+func (s *S) TestMetricsHandlerWithoutSamples(c *C) {
+	a := app.App{Name: "measured", Framework: "python", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("GET", "/apps/measured/metrics?:name=measured", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = MetricsHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusNoContent)
+}
+
+func (s *S) TestMetricsHandlerReturnsBadRequestIfTheDurationIsInvalid(c *C) {
+	request, err := http.NewRequest("GET", "/apps/measured/metrics?:name=measured&since=yesterday", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = MetricsHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, `Invalid duration "yesterday", it must be a positive duration, like 1h.`)
+}
+
+func (s *S) TestMetricsHandlerChecksAccess(c *C) {
+	a := app.App{Name: "measured", Framework: "python", Teams: []string{"otherteam"}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("GET", "/apps/measured/metrics?:name=measured", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = MetricsHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+}
+
+func (s *S) TestRunHandlerReturnsForbiddenIfTheAppIsInMaintenance(c *C) {
+	a := app.App{
+		Name:        "frozen",
+		Framework:   "python",
+		Teams:       []string{s.team.Name},
+		State:       string(provision.StatusStarted),
+		Maintenance: app.Maintenance{Enabled: true, User: "admin@tsuru.io", Since: time.Now()},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("POST", "/apps/frozen/run/?:name=frozen", strings.NewReader("ls"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RunCommand(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+	c.Assert(e.Message, Matches, "The app frozen is in maintenance mode since .*, set by admin@tsuru.io. Only admin users can change it until the maintenance mode is turned off.")
+}
+
+func (s *S) TestRunHandlerAllowsAdminsIfTheAppIsInMaintenance(c *C) {
+	adminTeamName, err := config.GetString("admin-team")
+	c.Assert(err, IsNil)
+	adminTeam := auth.Team{Name: adminTeamName, Users: []string{s.user.Email}}
+	err = db.Session.Teams().Insert(&adminTeam)
+	c.Assert(err, IsNil)
+	defer db.Session.Teams().RemoveId(adminTeam.Name)
+	s.provisioner.PrepareOutput([]byte("lots of files"))
+	a := app.App{
+		Name:        "frozen",
+		Framework:   "python",
+		Teams:       []string{s.team.Name},
+		State:       string(provision.StatusStarted),
+		Maintenance: app.Maintenance{Enabled: true, User: "admin@tsuru.io", Since: time.Now()},
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("POST", "/apps/frozen/run/?:name=frozen", strings.NewReader("ls"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RunCommand(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Body.String(), Equals, "lots of files")
+}
+
+func (s *S) TestSetEnvHandlerReturnsForbiddenIfTheAppIsInMaintenance(c *C) {
+	a := app.App{
+		Name:        "frozen",
+		Framework:   "python",
+		Teams:       []string{s.team.Name},
+		Maintenance: app.Maintenance{Enabled: true, User: "admin@tsuru.io", Since: time.Now()},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("POST", "/apps/frozen/env/?:name=frozen", strings.NewReader("DATABASE_HOST=localhost"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = SetEnv(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Env, HasLen, 0)
+}
+
+func (s *S) TestAddUnitsReturnsForbiddenIfTheAppIsInMaintenance(c *C) {
+	a := app.App{
+		Name:        "frozen",
+		Framework:   "python",
+		Teams:       []string{s.team.Name},
+		Units:       []app.Unit{{Name: "frozen/0"}},
+		Maintenance: app.Maintenance{Enabled: true, User: "admin@tsuru.io", Since: time.Now()},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("PUT", "/apps/frozen/units?:name=frozen", strings.NewReader("3"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddUnitsHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 1)
+}
+
+func (s *S) TestCloneRepositoryHandlerReturnsForbiddenIfTheAppIsInMaintenance(c *C) {
+	a := app.App{
+		Name:        "frozen",
+		Framework:   "python",
+		Teams:       []string{s.team.Name},
+		Maintenance: app.Maintenance{Enabled: true, User: "admin@tsuru.io", Since: time.Now()},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:name=%s&user=%s", a.Name, a.Name, s.user.Email)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = CloneRepositoryHandler(recorder, request)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+	c.Assert(s.provisioner.GetCmds("", &a), HasLen, 0)
+}
+
+func (s *S) TestCloneRepositoryHandlerDoesNotTrustTheUserParameterInMaintenance(c *C) {
+	adminTeamName, err := config.GetString("admin-team")
+	c.Assert(err, IsNil)
+	adminTeam := auth.Team{Name: adminTeamName, Users: []string{s.user.Email}}
+	err = db.Session.Teams().Insert(&adminTeam)
+	c.Assert(err, IsNil)
+	defer db.Session.Teams().RemoveId(adminTeam.Name)
+	a := app.App{
+		Name:        "frozen",
+		Framework:   "python",
+		Teams:       []string{s.team.Name},
+		Maintenance: app.Maintenance{Enabled: true, User: "admin@tsuru.io", Since: time.Now()},
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:name=%s&user=%s", a.Name, a.Name, s.user.Email)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = CloneRepositoryHandler(recorder, request)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+	c.Assert(s.provisioner.GetCmds("", &a), HasLen, 0)
+}
+
+func (s *S) TestRotateEnvKeyHandlerReturnsForbiddenIfTheUserIsNotAnAdmin(c *C) {
+	request, err := http.NewRequest("POST", "/env/rotate-key", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RotateEnvKeyHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+	c.Assert(e.Message, Equals, "Only admin users can rotate the encryption key.")
+}
+
+func (s *S) TestLogShouldReturnNotFoundWhenAppDoesNotExist(c *C) {
+	request, err := http.NewRequest("GET", "/apps/unknown/log/?:name=unknown", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AppLog(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+	c.Assert(e, ErrorMatches, "^App unknown not found.$")
+}
+
+func (s *S) TestLogReturnsForbiddenIfTheGivenUserDoesNotHaveAccessToTheApp(c *C) {
+	a := app.App{
+		Name:      "lost",
+		Framework: "vougan",
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/log/?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AppLog(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+}
+
+func (s *S) TestAppLogShouldHaveContentType(c *C) {
+	a := app.App{
+		Name:      "lost",
+		Framework: "vougan",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	a.Log("Something new", "tsuru")
+	url := fmt.Sprintf("/apps/%s/log/?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	request.Header.Set("Content-Type", "application/json")
+	err = AppLog(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/json")
+}
+
+func (s *S) TestAppLogSelectByLines(c *C) {
+	a := app.App{
+		Name:      "lost",
+		Framework: "vougan",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	for i := 0; i < 15; i++ {
+		a.Log(strconv.Itoa(i), "source")
+	}
+	url := fmt.Sprintf("/apps/%s/log/?:name=%s&lines=10", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	request.Header.Set("Content-Type", "application/json")
+	err = AppLog(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusOK)
+	body, err := ioutil.ReadAll(recorder.Body)
+	c.Assert(err, IsNil)
+	logs := []app.Applog{}
+	err = json.Unmarshal(body, &logs)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 10)
+}
+
+func (s *S) TestAppLogSelectBySource(c *C) {
+	a := app.App{
+		Name:      "lost",
+		Framework: "vougan",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	a.Log("mars log", "mars")
+	a.Log("earth log", "earth")
+	url := fmt.Sprintf("/apps/%s/log/?:name=%s&source=mars", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	request.Header.Set("Content-Type", "application/json")
+	err = AppLog(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusOK)
+	body, err := ioutil.ReadAll(recorder.Body)
+	c.Assert(err, IsNil)
+	logs := []app.Applog{}
+	err = json.Unmarshal(body, &logs)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Message, Equals, "mars log")
+	c.Assert(logs[0].Source, Equals, "mars")
+}
+
+func (s *S) TestAppLogSelectByLinesShouldReturnTheLastestEntries(c *C) {
+	a := app.App{
+		Name:      "lost",
+		Framework: "vougan",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	for i := 0; i < 15; i++ {
+		a.Log(strconv.Itoa(i), "source")
+	}
 	url := fmt.Sprintf("/apps/%s/log/?:name=%s&lines=3", a.Name, a.Name)
 	request, err := http.NewRequest("GET", url, nil)
 	c.Assert(err, IsNil)
 	recorder := httptest.NewRecorder()
-	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Content-Type", "application/json")
+	err = AppLog(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusOK)
+	body, err := ioutil.ReadAll(recorder.Body)
+	c.Assert(err, IsNil)
+	logs := []app.Applog{}
+	err = json.Unmarshal(body, &logs)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 3)
+	c.Assert(logs[0].Message, Equals, "12")
+	c.Assert(logs[1].Message, Equals, "13")
+	c.Assert(logs[2].Message, Equals, "14")
+}
+
+func (s *S) TestAppLogSelectByUnit(c *C) {
+	a := app.App{
+		Name:      "unitlost",
+		Framework: "vougan",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	a.UnitLog("from unit 0", "app", "unitlost/0")
+	a.UnitLog("from unit 1", "app", "unitlost/1")
+	url := fmt.Sprintf("/apps/%s/log/?:name=%s&unit=unitlost/1", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AppLog(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusOK)
+	logs := []app.Applog{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &logs)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Message, Equals, "from unit 1")
+	c.Assert(logs[0].Unit, Equals, "unitlost/1")
+}
+
+func (s *S) TestAppLogSelectByTimeRange(c *C) {
+	a := app.App{
+		Name:      "rangelost",
+		Framework: "vougan",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	now := time.Now()
+	err = db.Session.Logs().Insert(
+		app.Applog{AppName: a.Name, Message: "old", Date: now.Add(-2 * time.Hour)},
+		app.Applog{AppName: a.Name, Message: "recent", Date: now.Add(-time.Minute)},
+	)
+	c.Assert(err, IsNil)
+	since := now.Add(-time.Hour).UTC().Format(time.RFC3339)
+	url := fmt.Sprintf("/apps/%s/log/?:name=%s&since=%s", a.Name, a.Name, since)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AppLog(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	logs := []app.Applog{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &logs)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Message, Equals, "recent")
+}
+
+func (s *S) TestAppLogWithInvalidDate(c *C) {
+	a := app.App{
+		Name:      "invalidlost",
+		Framework: "vougan",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/log/?:name=%s&until=yesterday", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AppLog(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, `Invalid value for "until": the date must be in RFC 3339 format.`)
+}
+
+func (s *S) TestAppLogFollowStopsAtTheEndOfTheTimeRange(c *C) {
+	a := app.App{
+		Name:      "followlost",
+		Framework: "vougan",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	now := time.Now()
+	err = db.Session.Logs().Insert(app.Applog{AppName: a.Name, Message: "some message", Date: now.Add(-2 * time.Minute)})
+	c.Assert(err, IsNil)
+	until := now.Add(-time.Minute).UTC().Format(time.RFC3339)
+	url := fmt.Sprintf("/apps/%s/log/?:name=%s&follow=true&until=%s", a.Name, a.Name, until)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
 	err = AppLog(recorder, request, s.user)
 	c.Assert(err, IsNil)
 	c.Assert(recorder.Code, Equals, http.StatusOK)
-	body, err := ioutil.ReadAll(recorder.Body)
-	c.Assert(err, IsNil)
-	logs = []app.Applog{}
-	err = json.Unmarshal(body, &logs)
+	logs := []app.Applog{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &logs)
 	c.Assert(err, IsNil)
-	c.Assert(logs, HasLen, 3)
-	c.Assert(logs[0].Message, Equals, "12")
-	c.Assert(logs[1].Message, Equals, "13")
-	c.Assert(logs[2].Message, Equals, "14")
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Message, Equals, "some message")
 }
 
 func (s *S) TestAppLogShouldReturnLogByApp(c *C) {
@@ -1803,8 +3363,10 @@ func (s *S) TestBindHandler(c *C) {
 	c.Assert(err, IsNil)
 	expectedUser := bind.EnvVar{Name: "DATABASE_USER", Value: "root", Public: false, InstanceName: instance.Name}
 	expectedPassword := bind.EnvVar{Name: "DATABASE_PASSWORD", Value: "s3cr3t", Public: false, InstanceName: instance.Name}
-	c.Assert(a.Env["DATABASE_USER"], DeepEquals, expectedUser)
-	c.Assert(a.Env["DATABASE_PASSWORD"], DeepEquals, expectedPassword)
+	c.Assert(a.Env["DATABASE_PASSWORD"].Value, Not(Equals), "s3cr3t")
+	instanceEnv := a.InstanceEnv(instance.Name)
+	c.Assert(instanceEnv["DATABASE_USER"], DeepEquals, expectedUser)
+	c.Assert(instanceEnv["DATABASE_PASSWORD"], DeepEquals, expectedPassword)
 	var envs []string
 	err = json.Unmarshal(recorder.Body.Bytes(), &envs)
 	c.Assert(err, IsNil)
@@ -2084,6 +3646,103 @@ func (s *S) TestRestartHandler(c *C) {
 	c.Assert(recorder.Header().Get("Content-Type"), Equals, "text")
 }
 
+func (s *S) TestRestartHandlerReturnsConflictIfTheAppIsLocked(c *C) {
+	a := app.App{
+		Name:  "stress",
+		Teams: []string{s.team.Name},
+		State: string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	lock, err := app.AcquireLock(a.Name, "other@tsuru.io", "deploy", 0)
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	url := fmt.Sprintf("/apps/%s/restart?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RestartHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusConflict)
+	c.Assert(e.Message, Matches, "The app stress is locked: other@tsuru.io is running the deploy operation since .*")
+}
+
+func (s *S) TestRestartHandlerReleasesTheLock(c *C) {
+	s.provisioner.PrepareOutput(nil) // loadHooks
+	s.provisioner.PrepareOutput([]byte("restarted"))
+	a := app.App{
+		Name:  "stress",
+		Teams: []string{s.team.Name},
+		State: string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/restart?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RestartHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	n, err := db.Session.AppLocks().FindId(a.Name).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *S) TestRestartHandlerRolling(c *C) {
+	s.provisioner.PrepareOutput(nil) // loadHooks
+	s.provisioner.PrepareOutput([]byte("restarted"))
+	s.provisioner.PrepareOutput([]byte("restarted"))
+	a := app.App{
+		Name:  "stress",
+		Teams: []string{s.team.Name},
+		State: string(provision.StatusStarted),
+	}
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	units, err := s.provisioner.AddUnits(&a, 2, "web")
+	c.Assert(err, IsNil)
+	for _, u := range units {
+		a.AddUnit(&app.Unit{Name: u.Name, Machine: u.Machine, State: string(u.Status)})
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/restart?:name=%s&rolling=true&batch-size=1", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RestartHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	result := strings.Replace(recorder.Body.String(), "\n", "#", -1)
+	c.Assert(result, Matches, ".*# ---> Restarting batch 1 of 2 \\(stress/0\\)#.*")
+	c.Assert(result, Matches, ".*# ---> Restarting batch 2 of 2 \\(stress/1\\)#.*")
+	c.Assert(s.provisioner.GetCmds("/var/lib/tsuru/hooks/restart", &a), HasLen, 2)
+}
+
+func (s *S) TestRestartHandlerRollingInvalidBatchSize(c *C) {
+	a := app.App{
+		Name:  "stress",
+		Teams: []string{s.team.Name},
+		State: string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/restart?:name=%s&rolling=true&batch-size=zero", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RestartHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+}
+
 func (s *S) TestRestartHandlerReturns404IfTheAppDoesNotExist(c *C) {
 	request, err := http.NewRequest("GET", "/apps/unknown/restart?:name=unknown", nil)
 	c.Assert(err, IsNil)
@@ -2111,6 +3770,94 @@ func (s *S) TestRestartHandlerReturns403IfTheUserDoesNotHaveAccessToTheApp(c *C)
 	c.Assert(e.Code, Equals, http.StatusForbidden)
 }
 
+func (s *S) TestStopHandler(c *C) {
+	a := app.App{
+		Name:  "stress",
+		Teams: []string{s.team.Name},
+		State: string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	url := fmt.Sprintf("/apps/%s/stop?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = StopHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	result := strings.Replace(recorder.Body.String(), "\n", "#", -1)
+	c.Assert(result, Matches, ".*# ---> Stopping your app#.*")
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "text")
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.State, Equals, string(provision.StatusDown))
+}
+
+func (s *S) TestStopHandlerReturns404IfTheAppDoesNotExist(c *C) {
+	request, err := http.NewRequest("POST", "/apps/unknown/stop?:name=unknown", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = StopHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+}
+
+func (s *S) TestStopHandlerReturns403IfTheUserDoesNotHaveAccessToTheApp(c *C) {
+	a := app.App{Name: "nightmist"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/stop?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = StopHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+}
+
+func (s *S) TestStartHandler(c *C) {
+	a := app.App{
+		Name:  "stress",
+		Teams: []string{s.team.Name},
+		State: string(provision.StatusDown),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	url := fmt.Sprintf("/apps/%s/start?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = StartHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	result := strings.Replace(recorder.Body.String(), "\n", "#", -1)
+	c.Assert(result, Matches, ".*# ---> Starting your app#.*")
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "text")
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.State, Equals, string(provision.StatusStarted))
+}
+
+func (s *S) TestStartHandlerReturns404IfTheAppDoesNotExist(c *C) {
+	request, err := http.NewRequest("POST", "/apps/unknown/start?:name=unknown", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = StartHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+}
+
 func (s *S) TestAddLogHandler(c *C) {
 	a := app.App{
 		Name:      "myapp",
@@ -2132,8 +3879,160 @@ func (s *S) TestAddLogHandler(c *C) {
 		"message 3",
 	}
 	for _, msg := range messages {
-		length, err := db.Session.Apps().Find(bson.M{"name": a.Name, "logs.message": msg}).Count()
+		length, err := db.Session.Logs().Find(bson.M{"appname": a.Name, "message": msg}).Count()
 		c.Check(err, IsNil)
 		c.Check(length, Equals, 1)
 	}
 }
+
+func (s *S) TestAddLogHandlerWithUnit(c *C) {
+	a := app.App{
+		Name:      "myunitapp",
+		Framework: "python",
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	b := strings.NewReader(`["message 1"]`)
+	request, err := http.NewRequest("POST", "/apps/myunitapp/log/?:name=myunitapp&unit=myunitapp/0", b)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddLogHandler(recorder, request)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusOK)
+	length, err := db.Session.Logs().Find(bson.M{"appname": a.Name, "unit": "myunitapp/0"}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(length, Equals, 1)
+}
+
+func (s *S) TestAddDrainHandler(c *C) {
+	a := app.App{
+		Name:      "drained",
+		Framework: "python",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	b := strings.NewReader("syslog://logs.example.com:514")
+	request, err := http.NewRequest("POST", "/apps/drained/drains?:name=drained", b)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddDrainHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Drains, DeepEquals, []string{"syslog://logs.example.com:514"})
+}
+
+func (s *S) TestAddDrainHandlerInvalidDrain(c *C) {
+	a := app.App{
+		Name:      "drained",
+		Framework: "python",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	b := strings.NewReader("ftp://logs.example.com")
+	request, err := http.NewRequest("POST", "/apps/drained/drains?:name=drained", b)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddDrainHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestAddDrainHandlerWithoutDrain(c *C) {
+	request, err := http.NewRequest("POST", "/apps/drained/drains?:name=drained", strings.NewReader(""))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddDrainHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, "You must provide the URL of the drain.")
+}
+
+func (s *S) TestRemoveDrainHandler(c *C) {
+	a := app.App{
+		Name:      "drained",
+		Framework: "python",
+		Teams:     []string{s.team.Name},
+		Drains:    []string{"syslog://logs.example.com:514"},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	b := strings.NewReader("syslog://logs.example.com:514")
+	request, err := http.NewRequest("DELETE", "/apps/drained/drains?:name=drained", b)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RemoveDrainHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Drains, HasLen, 0)
+}
+
+func (s *S) TestRemoveDrainHandlerDrainNotFound(c *C) {
+	a := app.App{
+		Name:      "drained",
+		Framework: "python",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	b := strings.NewReader("syslog://logs.example.com:514")
+	request, err := http.NewRequest("DELETE", "/apps/drained/drains?:name=drained", b)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RemoveDrainHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+}
+
+func (s *S) TestListDrainsHandler(c *C) {
+	a := app.App{
+		Name:      "drained",
+		Framework: "python",
+		Teams:     []string{s.team.Name},
+		Drains:    []string{"syslog://logs.example.com:514", "http://logs.example.com"},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("GET", "/apps/drained/drains?:name=drained", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = ListDrainsHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/json")
+	var drains []string
+	err = json.Unmarshal(recorder.Body.Bytes(), &drains)
+	c.Assert(err, IsNil)
+	c.Assert(drains, DeepEquals, a.Drains)
+}
+
+func (s *S) TestListDrainsHandlerWithoutDrains(c *C) {
+	a := app.App{
+		Name:      "drained",
+		Framework: "python",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("GET", "/apps/drained/drains?:name=drained", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = ListDrainsHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusNoContent)
+}
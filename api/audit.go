@@ -0,0 +1,75 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/globocom/tsuru/api/auth"
+	"github.com/globocom/tsuru/audit"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"strconv"
+)
+
+const defaultAuditLimit = 100
+
+// AuditHandler lists the audit trail, most recent entries first. The entries
+// may be filtered by user, app, team and date, using the parameters user,
+// app, team, since and until. The team filter matches the entries targeting
+// the team and the entries targeting apps of the team. The parameter limit sets the maximum number of
+// entries, 100 by default. Only admin users can list the audit trail.
+func AuditHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	if !u.IsAdmin() {
+		return &errors.Http{Code: http.StatusForbidden, Message: "Only admin users can list the audit trail."}
+	}
+	query := bson.M{}
+	for _, field := range []string{"user", "app"} {
+		if value := r.URL.Query().Get(field); value != "" {
+			query[field] = value
+		}
+	}
+	if team := r.URL.Query().Get("team"); team != "" {
+		query["$or"] = []bson.M{{"team": team}, {"teams": team}}
+	}
+	since, err := parseLogDate(r, "since")
+	if err != nil {
+		return err
+	}
+	until, err := parseLogDate(r, "until")
+	if err != nil {
+		return err
+	}
+	if !since.IsZero() || !until.IsZero() {
+		timestamp := bson.M{}
+		if !since.IsZero() {
+			timestamp["$gte"] = since
+		}
+		if !until.IsZero() {
+			timestamp["$lte"] = until
+		}
+		query["timestamp"] = timestamp
+	}
+	limit := defaultAuditLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			msg := "Invalid limit: the limit must be a integer greater than 0."
+			return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+		}
+	}
+	var entries []audit.Entry
+	err = db.Session.Audit().Find(query).Sort("-timestamp").Limit(limit).All(&entries)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(entries)
+}
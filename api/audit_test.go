@@ -0,0 +1,112 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/api/auth"
+	"github.com/globocom/tsuru/audit"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+func (s *S) TestAuditHandler(c *C) {
+	adminTeamName, err := config.GetString("admin-team")
+	c.Assert(err, IsNil)
+	adminTeam := auth.Team{Name: adminTeamName, Users: []string{s.user.Email}}
+	err = db.Session.Teams().Insert(&adminTeam)
+	c.Assert(err, IsNil)
+	defer db.Session.Teams().RemoveId(adminTeam.Name)
+	now := time.Now()
+	entries := []audit.Entry{
+		{User: "a@tsuru.io", Action: "POST /apps", App: "app1", Status: 200, Timestamp: now.Add(-3 * time.Hour)},
+		{User: "b@tsuru.io", Action: "DELETE /apps/:name", App: "app1", Status: 200, Timestamp: now.Add(-2 * time.Hour)},
+		{User: "a@tsuru.io", Action: "PUT /apps/:app/:team", App: "app2", Team: "ops", Status: 200, Timestamp: now.Add(-time.Hour)},
+		{User: "c@tsuru.io", Action: "POST /apps/:name/env", App: "app3", Teams: []string{"dev", "ops"}, Status: 200, Timestamp: now.Add(-4 * time.Hour)},
+	}
+	for _, e := range entries {
+		err = db.Session.Audit().Insert(e)
+		c.Assert(err, IsNil)
+	}
+	defer db.Session.Audit().RemoveAll(nil)
+	var tests = []struct {
+		query   string
+		actions []string
+	}{
+		{"", []string{"PUT /apps/:app/:team", "DELETE /apps/:name", "POST /apps", "POST /apps/:name/env"}},
+		{"?user=a@tsuru.io", []string{"PUT /apps/:app/:team", "POST /apps"}},
+		{"?app=app1", []string{"DELETE /apps/:name", "POST /apps"}},
+		{"?team=ops", []string{"PUT /apps/:app/:team", "POST /apps/:name/env"}},
+		{"?team=dev", []string{"POST /apps/:name/env"}},
+		{"?since=" + now.Add(-150*time.Minute).Format(time.RFC3339), []string{"PUT /apps/:app/:team", "DELETE /apps/:name"}},
+		{"?until=" + now.Add(-150*time.Minute).Format(time.RFC3339), []string{"POST /apps", "POST /apps/:name/env"}},
+		{"?limit=1", []string{"PUT /apps/:app/:team"}},
+	}
+	for _, t := range tests {
+		request, err := http.NewRequest("GET", "/audit"+t.query, nil)
+		c.Assert(err, IsNil)
+		recorder := httptest.NewRecorder()
+		err = AuditHandler(recorder, request, s.user)
+		c.Assert(err, IsNil)
+		c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/json")
+		var got []audit.Entry
+		err = json.Unmarshal(recorder.Body.Bytes(), &got)
+		c.Assert(err, IsNil)
+		var actions []string
+		for _, e := range got {
+			actions = append(actions, e.Action)
+		}
+		c.Check(actions, DeepEquals, t.actions)
+	}
+}
+
+func (s *S) TestAuditHandlerWithoutEntries(c *C) {
+	adminTeamName, err := config.GetString("admin-team")
+	c.Assert(err, IsNil)
+	adminTeam := auth.Team{Name: adminTeamName, Users: []string{s.user.Email}}
+	err = db.Session.Teams().Insert(&adminTeam)
+	c.Assert(err, IsNil)
+	defer db.Session.Teams().RemoveId(adminTeam.Name)
+	request, err := http.NewRequest("GET", "/audit?user=nobody@tsuru.io", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AuditHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusNoContent)
+}
+
+func (s *S) TestAuditHandlerReturnsBadRequestIfTheLimitIsInvalid(c *C) {
+	adminTeamName, err := config.GetString("admin-team")
+	c.Assert(err, IsNil)
+	adminTeam := auth.Team{Name: adminTeamName, Users: []string{s.user.Email}}
+	err = db.Session.Teams().Insert(&adminTeam)
+	c.Assert(err, IsNil)
+	defer db.Session.Teams().RemoveId(adminTeam.Name)
+	request, err := http.NewRequest("GET", "/audit?limit=0", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AuditHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestAuditHandlerReturnsForbiddenIfTheUserIsNotAnAdmin(c *C) {
+	request, err := http.NewRequest("GET", "/audit", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AuditHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+	c.Assert(e.Message, Equals, "Only admin users can list the audit trail.")
+}
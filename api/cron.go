@@ -0,0 +1,74 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/globocom/tsuru/api/auth"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/errors"
+	"io/ioutil"
+	"net/http"
+)
+
+// AddCronHandler adds a scheduled job to an app. The body is a JSON object
+// with the keys schedule and command, and the response contains the job.
+func AddCronHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var params struct {
+		Schedule string
+		Command  string
+	}
+	if err = json.Unmarshal(body, &params); err != nil {
+		return &errors.Http{Code: http.StatusBadRequest, Message: "Invalid JSON: " + err.Error()}
+	}
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	cron, err := instance.AddCron(params.Schedule, params.Command)
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusBadRequest, Message: e.Message}
+	} else if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(cron)
+}
+
+// ListCronsHandler lists the scheduled jobs of an app, sorted by their next
+// run.
+func ListCronsHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	crons, err := instance.Crons()
+	if err != nil {
+		return err
+	}
+	if len(crons) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(crons)
+}
+
+func RemoveCronHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	err = instance.RemoveCron(r.URL.Query().Get(":id"))
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
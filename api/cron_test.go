@@ -0,0 +1,140 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+func (s *S) TestAddCronHandler(c *C) {
+	a := app.App{Name: "mountain-mama", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Crons().RemoveAll(bson.M{"app": a.Name})
+	body := strings.NewReader(`{"schedule":"*/5 * * * *","command":"./poll.sh"}`)
+	request, err := http.NewRequest("POST", "/apps/mountain-mama/crons?:name=mountain-mama", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddCronHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/json")
+	var cron app.Cron
+	err = json.Unmarshal(recorder.Body.Bytes(), &cron)
+	c.Assert(err, IsNil)
+	c.Assert(cron.Schedule, Equals, "*/5 * * * *")
+	c.Assert(cron.Command, Equals, "./poll.sh")
+	n, err := db.Session.Crons().FindId(cron.Id).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+}
+
+func (s *S) TestAddCronHandlerInvalidSchedule(c *C) {
+	a := app.App{Name: "mountain-mama", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"schedule":"every minute","command":"./poll.sh"}`)
+	request, err := http.NewRequest("POST", "/apps/mountain-mama/crons?:name=mountain-mama", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddCronHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestAddCronHandlerChecksAccess(c *C) {
+	a := app.App{Name: "mountain-mama", Teams: []string{"otherteam"}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"schedule":"@daily","command":"./backup.sh"}`)
+	request, err := http.NewRequest("POST", "/apps/mountain-mama/crons?:name=mountain-mama", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddCronHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+}
+
+func (s *S) TestListCronsHandler(c *C) {
+	a := app.App{Name: "mountain-mama", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Crons().RemoveAll(bson.M{"app": a.Name})
+	_, err = a.AddCron("@daily", "./backup.sh")
+	c.Assert(err, IsNil)
+	request, err := http.NewRequest("GET", "/apps/mountain-mama/crons?:name=mountain-mama", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = ListCronsHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	var crons []app.Cron
+	err = json.Unmarshal(recorder.Body.Bytes(), &crons)
+	c.Assert(err, IsNil)
+	c.Assert(crons, HasLen, 1)
+	c.Assert(crons[0].Command, Equals, "./backup.sh")
+}
+
+func (s *S) TestListCronsHandlerWithoutCrons(c *C) {
+	a := app.App{Name: "mountain-mama", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("GET", "/apps/mountain-mama/crons?:name=mountain-mama", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = ListCronsHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusNoContent)
+}
+
+func (s *S) TestRemoveCronHandler(c *C) {
+	a := app.App{Name: "mountain-mama", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Crons().RemoveAll(bson.M{"app": a.Name})
+	cron, err := a.AddCron("@daily", "./backup.sh")
+	c.Assert(err, IsNil)
+	url := "/apps/mountain-mama/crons/" + cron.Id + "?:name=mountain-mama&:id=" + cron.Id
+	request, err := http.NewRequest("DELETE", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RemoveCronHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	n, err := db.Session.Crons().FindId(cron.Id).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *S) TestRemoveCronHandlerNotFound(c *C) {
+	a := app.App{Name: "mountain-mama", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("DELETE", "/apps/mountain-mama/crons/abc?:name=mountain-mama&:id=abc", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RemoveCronHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, "Cron job abc not found in the app mountain-mama.")
+}
@@ -5,6 +5,7 @@
 package service_test
 
 import (
+	"github.com/globocom/config"
 	"github.com/globocom/tsuru/api/auth"
 	"github.com/globocom/tsuru/api/bind"
 	"github.com/globocom/tsuru/api/service"
@@ -37,6 +38,7 @@ func (s *S) SetUpSuite(c *C) {
 	c.Assert(err, IsNil)
 	db.Session, err = db.Open("127.0.0.1:27017", "tsuru_service_bind_test")
 	c.Assert(err, IsNil)
+	config.Set("env:encryption-key", "tsuru-env-encryption-key")
 	s.user = auth.User{Email: "sad-but-true@metallica.com"}
 	s.user.Create()
 	s.team = auth.Team{Name: "metallica", Users: []string{s.user.Email}}
@@ -162,7 +164,8 @@ func (s *S) TestBindCallTheServiceAPIAndSetsEnvironmentVariableReturnedFromTheCa
 			InstanceName: instance.Name,
 		},
 	}
-	c.Assert(a.Env, DeepEquals, expectedEnv)
+	c.Assert(a.Env["DATABASE_PASSWORD"].Value, Not(Equals), "s3cr3t")
+	c.Assert(a.InstanceEnv(instance.Name), DeepEquals, expectedEnv)
 }
 
 func (s *S) TestBindReturnConflictIfTheAppIsAlreadyBinded(c *C) {
@@ -10,6 +10,7 @@ import (
 	"github.com/globocom/tsuru/api/bind"
 	"github.com/globocom/tsuru/db"
 	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/webhook"
 	"labix.org/v2/mgo/bson"
 	"net/http"
 )
@@ -92,15 +93,19 @@ func (si *ServiceInstance) Bind(app bind.App) error {
 	}
 	env, err := cli.Bind(si, app)
 	if err != nil {
+		si.notify(webhook.Bind, app, err)
 		return err
 	}
 	setEnv(env)
 	err = si.update()
 	if err != nil {
 		cli.Unbind(si, app)
+		si.notify(webhook.Bind, app, err)
 		return err
 	}
-	return app.SetEnvs(envVars, false)
+	err = app.SetEnvs(envVars, false)
+	si.notify(webhook.Bind, app, err)
+	return err
 }
 
 func (si *ServiceInstance) Unbind(app bind.App) error {
@@ -119,7 +124,16 @@ func (si *ServiceInstance) Unbind(app bind.App) error {
 	for k := range app.InstanceEnv(si.Name) {
 		envVars = append(envVars, k)
 	}
-	return app.UnsetEnvs(envVars, false)
+	err = app.UnsetEnvs(envVars, false)
+	si.notify(webhook.Unbind, app, err)
+	return err
+}
+
+// notify notifies the webhooks of the app about the binding or unbinding of
+// the instance.
+func (si *ServiceInstance) notify(event string, app bind.App, err error) {
+	data := map[string]string{"instance": si.Name, "service": si.ServiceName}
+	webhook.Notify(event, app.GetName(), nil, err, data)
 }
 
 func genericServiceInstancesFilter(services interface{}, teams []string) (q, f bson.M) {
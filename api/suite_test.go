@@ -27,6 +27,7 @@ type S struct {
 	rfs         *fsTesting.RecordingFs
 	t           *tsuruTesting.T
 	provisioner *tsuruTesting.FakeProvisioner
+	queueServer tsuruTesting.FakeQueueServer
 }
 
 var _ = Suite(&S{})
@@ -82,19 +83,25 @@ func (s *S) SetUpSuite(c *C) {
 	s.t.SetGitConfs(c)
 	s.provisioner = tsuruTesting.NewFakeProvisioner()
 	app.Provisioner = s.provisioner
+	err = s.queueServer.Start("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	config.Set("queue-server", s.queueServer.Addr())
 }
 
 func (s *S) TearDownSuite(c *C) {
 	defer s.t.S3Server.Quit()
 	defer s.t.IamServer.Quit()
 	defer db.Session.Close()
+	defer s.queueServer.Stop()
 	db.Session.Apps().Database.DropDatabase()
 	fsystem = nil
 }
 
 func (s *S) TearDownTest(c *C) {
 	s.t.RollbackGitConfs(c)
+	config.Set("queue-server", s.queueServer.Addr())
 	s.provisioner.Reset()
+	tsuruTesting.ClearLogs(c)
 }
 
 func (s *S) getTestData(p ...string) io.ReadCloser {
@@ -0,0 +1,156 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/api/auth"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/webhook"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+)
+
+// deliveriesLimit is the maximum number of deliveries listed by
+// WebhookDeliveriesHandler.
+const deliveriesLimit = 50
+
+// checkTeamAccess returns an error if the team doesn't exist or if the user is
+// not a member of it.
+func checkTeamAccess(teamName string, u *auth.User) error {
+	n, err := db.Session.Teams().Find(bson.M{"_id": teamName}).Count()
+	if err != nil || n == 0 {
+		return &errors.Http{Code: http.StatusNotFound, Message: fmt.Sprintf("Team %s not found.", teamName)}
+	}
+	if !auth.CheckUserAccess([]string{teamName}, u) {
+		return &errors.Http{Code: http.StatusForbidden, Message: "User is not a member of this team"}
+	}
+	return nil
+}
+
+func getWebhookOrError(id string, u *auth.User) (webhook.Hook, error) {
+	var h webhook.Hook
+	err := db.Session.Webhooks().FindId(id).One(&h)
+	if err != nil {
+		return h, &errors.Http{Code: http.StatusNotFound, Message: fmt.Sprintf("Webhook %s not found.", id)}
+	}
+	if h.App != "" {
+		_, err = getAppOrError(h.App, u)
+	} else {
+		err = checkTeamAccess(h.Team, u)
+	}
+	return h, err
+}
+
+// AddWebhookHandler registers a webhook for an app or for a team. The body is
+// a JSON object with the keys app or team, url and events.
+//
+// The response contains the webhook, including the secret used to sign the
+// payloads. The secret is not displayed anywhere else.
+func AddWebhookHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var h webhook.Hook
+	if err = json.Unmarshal(body, &h); err != nil {
+		return &errors.Http{Code: http.StatusBadRequest, Message: "Invalid JSON: " + err.Error()}
+	}
+	if h.App != "" {
+		if _, err = getAppOrError(h.App, u); err != nil {
+			return err
+		}
+	} else if h.Team != "" {
+		if err = checkTeamAccess(h.Team, u); err != nil {
+			return err
+		}
+	}
+	if err = h.Create(); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(h)
+}
+
+// ListWebhooksHandler lists the webhooks of the app or the team given in the
+// app and team parameters. Without parameters, it lists the webhooks of all
+// apps and teams the user has access to. Secrets are not listed.
+func ListWebhooksHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	var query bson.M
+	if appName := r.URL.Query().Get("app"); appName != "" {
+		if _, err := getAppOrError(appName, u); err != nil {
+			return err
+		}
+		query = bson.M{"app": appName}
+	} else if teamName := r.URL.Query().Get("team"); teamName != "" {
+		if err := checkTeamAccess(teamName, u); err != nil {
+			return err
+		}
+		query = bson.M{"team": teamName}
+	} else {
+		apps, err := app.List(u)
+		if err != nil {
+			return err
+		}
+		appNames := make([]string, len(apps))
+		for i, a := range apps {
+			appNames[i] = a.Name
+		}
+		teams, err := u.Teams()
+		if err != nil {
+			return err
+		}
+		query = bson.M{"$or": []bson.M{
+			{"app": bson.M{"$in": appNames}},
+			{"team": bson.M{"$in": auth.GetTeamsNames(teams)}},
+		}}
+	}
+	var hooks []webhook.Hook
+	if err := db.Session.Webhooks().Find(query).All(&hooks); err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(hooks)
+}
+
+func RemoveWebhookHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	h, err := getWebhookOrError(r.URL.Query().Get(":id"), u)
+	if err != nil {
+		return err
+	}
+	return db.Session.Webhooks().RemoveId(h.Id)
+}
+
+// WebhookDeliveriesHandler lists the latest deliveries of a webhook, most
+// recent first.
+func WebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	h, err := getWebhookOrError(r.URL.Query().Get(":id"), u)
+	if err != nil {
+		return err
+	}
+	var deliveries []webhook.Delivery
+	err = db.Session.WebhookDeliveries().Find(bson.M{"hook": h.Id}).Sort("-timestamp").Limit(deliveriesLimit).All(&deliveries)
+	if err != nil {
+		return err
+	}
+	if len(deliveries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(deliveries)
+}
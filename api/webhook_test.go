@@ -0,0 +1,206 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/webhook"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+func (s *S) TestAddWebhookHandlerForAnApp(c *C) {
+	a := app.App{Name: "mountain-mama", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Webhooks().RemoveAll(bson.M{"app": a.Name})
+	body := strings.NewReader(`{"app":"mountain-mama","url":"http://ci.tsuru.io/hook","events":["deploy"]}`)
+	request, err := http.NewRequest("POST", "/webhooks", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddWebhookHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/json")
+	var h webhook.Hook
+	err = json.Unmarshal(recorder.Body.Bytes(), &h)
+	c.Assert(err, IsNil)
+	c.Assert(h.Secret, Not(Equals), "")
+	var stored webhook.Hook
+	err = db.Session.Webhooks().FindId(h.Id).One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored, DeepEquals, h)
+	c.Assert(stored.URL, Equals, "http://ci.tsuru.io/hook")
+	c.Assert(stored.Events, DeepEquals, []string{"deploy"})
+}
+
+func (s *S) TestAddWebhookHandlerForATeam(c *C) {
+	defer db.Session.Webhooks().RemoveAll(bson.M{"team": s.team.Name})
+	body := strings.NewReader(`{"team":"tsuruteam","url":"http://chat.tsuru.io/hook"}`)
+	request, err := http.NewRequest("POST", "/webhooks", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddWebhookHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	n, err := db.Session.Webhooks().Find(bson.M{"team": s.team.Name}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+}
+
+func (s *S) TestAddWebhookHandlerChecksAccess(c *C) {
+	a := app.App{Name: "mountain-mama", Teams: []string{"otherteam"}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	var tests = []struct {
+		body string
+		code int
+	}{
+		{`{"app":"mountain-mama","url":"http://ci.tsuru.io"}`, http.StatusForbidden},
+		{`{"app":"unknown","url":"http://ci.tsuru.io"}`, http.StatusNotFound},
+		{`{"team":"unknown","url":"http://ci.tsuru.io"}`, http.StatusNotFound},
+		{`{"url":"http://ci.tsuru.io"}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+	}
+	for _, t := range tests {
+		request, err := http.NewRequest("POST", "/webhooks", strings.NewReader(t.body))
+		c.Assert(err, IsNil)
+		recorder := httptest.NewRecorder()
+		err = AddWebhookHandler(recorder, request, s.user)
+		c.Assert(err, NotNil)
+		e, ok := err.(*errors.Http)
+		c.Assert(ok, Equals, true)
+		c.Check(e.Code, Equals, t.code)
+	}
+}
+
+func (s *S) TestListWebhooksHandler(c *C) {
+	a := app.App{Name: "mountain-mama", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	hooks := []webhook.Hook{
+		{Id: "1", App: a.Name, URL: "http://ci.tsuru.io", Secret: "s1"},
+		{Id: "2", Team: s.team.Name, URL: "http://chat.tsuru.io", Secret: "s2"},
+		{Id: "3", App: "otherapp", URL: "http://other.tsuru.io", Secret: "s3"},
+	}
+	for _, h := range hooks {
+		err = db.Session.Webhooks().Insert(h)
+		c.Assert(err, IsNil)
+	}
+	defer db.Session.Webhooks().RemoveAll(nil)
+	var tests = []struct {
+		query string
+		ids   []string
+	}{
+		{"", []string{"1", "2"}},
+		{"?app=mountain-mama", []string{"1"}},
+		{"?team=tsuruteam", []string{"2"}},
+	}
+	for _, t := range tests {
+		request, err := http.NewRequest("GET", "/webhooks"+t.query, nil)
+		c.Assert(err, IsNil)
+		recorder := httptest.NewRecorder()
+		err = ListWebhooksHandler(recorder, request, s.user)
+		c.Assert(err, IsNil)
+		c.Assert(strings.Contains(recorder.Body.String(), "Secret"), Equals, false)
+		var got []webhook.Hook
+		err = json.Unmarshal(recorder.Body.Bytes(), &got)
+		c.Assert(err, IsNil)
+		var ids []string
+		for _, h := range got {
+			ids = append(ids, h.Id)
+		}
+		c.Check(ids, DeepEquals, t.ids)
+	}
+}
+
+func (s *S) TestListWebhooksHandlerWithoutWebhooks(c *C) {
+	request, err := http.NewRequest("GET", "/webhooks", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = ListWebhooksHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusNoContent)
+}
+
+func (s *S) TestRemoveWebhookHandler(c *C) {
+	err := db.Session.Webhooks().Insert(webhook.Hook{Id: "1", Team: s.team.Name, URL: "http://ci.tsuru.io"})
+	c.Assert(err, IsNil)
+	defer db.Session.Webhooks().RemoveAll(nil)
+	request, err := http.NewRequest("DELETE", "/webhooks/1?:id=1", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RemoveWebhookHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	n, err := db.Session.Webhooks().FindId("1").Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *S) TestRemoveWebhookHandlerReturnsForbiddenIfTheUserIsNotAMemberOfTheTeam(c *C) {
+	err := db.Session.Teams().Insert(bson.M{"_id": "otherteam"})
+	c.Assert(err, IsNil)
+	defer db.Session.Teams().RemoveId("otherteam")
+	err = db.Session.Webhooks().Insert(webhook.Hook{Id: "1", Team: "otherteam", URL: "http://ci.tsuru.io"})
+	c.Assert(err, IsNil)
+	defer db.Session.Webhooks().RemoveAll(nil)
+	request, err := http.NewRequest("DELETE", "/webhooks/1?:id=1", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RemoveWebhookHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+}
+
+func (s *S) TestRemoveWebhookHandlerReturnsNotFoundIfTheWebhookDoesNotExist(c *C) {
+	request, err := http.NewRequest("DELETE", "/webhooks/unknown?:id=unknown", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RemoveWebhookHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+	c.Assert(e.Message, Equals, "Webhook unknown not found.")
+}
+
+func (s *S) TestWebhookDeliveriesHandler(c *C) {
+	err := db.Session.Webhooks().Insert(webhook.Hook{Id: "1", Team: s.team.Name, URL: "http://ci.tsuru.io"})
+	c.Assert(err, IsNil)
+	defer db.Session.Webhooks().RemoveAll(nil)
+	now := time.Now()
+	deliveries := []webhook.Delivery{
+		{Id: "d1", Hook: "1", Event: webhook.Deploy, Attempts: 1, Status: 200, Success: true, Timestamp: now.Add(-time.Hour)},
+		{Id: "d2", Hook: "1", Event: webhook.Restart, Attempts: 3, Status: 500, Timestamp: now},
+		{Id: "d3", Hook: "2", Event: webhook.Restart, Attempts: 1, Status: 200, Success: true, Timestamp: now},
+	}
+	for _, d := range deliveries {
+		err = db.Session.WebhookDeliveries().Insert(d)
+		c.Assert(err, IsNil)
+	}
+	defer db.Session.WebhookDeliveries().RemoveAll(nil)
+	request, err := http.NewRequest("GET", "/webhooks/1/deliveries?:id=1", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = WebhookDeliveriesHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/json")
+	var got []webhook.Delivery
+	err = json.Unmarshal(recorder.Body.Bytes(), &got)
+	c.Assert(err, IsNil)
+	c.Assert(got, HasLen, 2)
+	c.Assert(got[0].Id, Equals, "d2")
+	c.Assert(got[1].Id, Equals, "d1")
+}
@@ -7,6 +7,7 @@ package main
 import (
 	"fmt"
 	"github.com/globocom/tsuru/api/auth"
+	"github.com/globocom/tsuru/audit"
 	"github.com/globocom/tsuru/errors"
 	"github.com/globocom/tsuru/log"
 	"net/http"
@@ -51,21 +52,33 @@ func (fn AuthorizationRequiredHandler) ServeHTTP(w http.ResponseWriter, r *http.
 			r.Body.Close()
 		}
 	}()
-	fw := FlushingWriter{w, false}
+	sw := &statusWriter{ResponseWriter: w}
+	fw := FlushingWriter{sw, false}
 	token := r.Header.Get("Authorization")
 	if token == "" {
 		http.Error(&fw, "You must provide the Authorization header", http.StatusUnauthorized)
 	} else if user, err := auth.CheckToken(token); err != nil {
 		http.Error(&fw, "Invalid token", http.StatusUnauthorized)
-	} else if err = fn(&fw, r, user); err != nil {
-		code := http.StatusInternalServerError
-		if e, ok := err.(*errors.Http); ok {
-			code = e.Code
+	} else {
+		var entry *audit.Entry
+		if audit.IsMutating(r) {
+			entry = audit.NewEntry(r, user.Email)
 		}
-		if fw.wrote {
-			fmt.Fprintln(&fw, err)
-		} else {
-			http.Error(&fw, err.Error(), code)
+		if err = fn(&fw, r, user); err != nil {
+			code := http.StatusInternalServerError
+			if e, ok := err.(*errors.Http); ok {
+				code = e.Code
+			}
+			if fw.wrote {
+				fmt.Fprintln(&fw, err)
+			} else {
+				http.Error(&fw, err.Error(), code)
+			}
+		}
+		if entry != nil {
+			if rerr := entry.Record(sw.Status(), err); rerr != nil {
+				log.Printf("Failed to record the audit entry of %s: %s", entry.Action, rerr)
+			}
 		}
 	}
 }
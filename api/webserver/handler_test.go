@@ -8,11 +8,14 @@ import (
 	stderrors "errors"
 	"fmt"
 	"github.com/globocom/tsuru/api/auth"
+	"github.com/globocom/tsuru/audit"
 	"github.com/globocom/tsuru/db"
 	"github.com/globocom/tsuru/errors"
+	"labix.org/v2/mgo/bson"
 	. "launchpad.net/gocheck"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -224,3 +227,32 @@ func (s *S) TestSetVersionHeaders(c *C) {
 	c.Assert(recorder.Header().Get("Supported-Tsuru"), Equals, tsuruMin)
 	c.Assert(recorder.Header().Get("Supported-Crane"), Equals, craneMin)
 }
+
+func (s *S) TestAuthorizationRequiredHandlerRecordsMutatingRequests(c *C) {
+	recorder := httptest.NewRecorder()
+	body := strings.NewReader("DEBUG=1 DATABASE_PASSWORD=123")
+	request, err := http.NewRequest("POST", "/apps/myapp/env?:name=myapp", body)
+	c.Assert(err, IsNil)
+	request.Header.Set("Authorization", s.t.Token)
+	AuthorizationRequiredHandler(authorizedBadRequestHandler).ServeHTTP(recorder, request)
+	defer db.Session.Audit().RemoveAll(nil)
+	var entry audit.Entry
+	err = db.Session.Audit().Find(bson.M{"app": "myapp"}).One(&entry)
+	c.Assert(err, IsNil)
+	c.Assert(entry.User, Equals, s.u.Email)
+	c.Assert(entry.Action, Equals, "POST /apps/:name/env")
+	c.Assert(entry.Body, Equals, "DEBUG=1 DATABASE_PASSWORD=***")
+	c.Assert(entry.Status, Equals, http.StatusBadRequest)
+	c.Assert(entry.Error, Equals, "some error")
+}
+
+func (s *S) TestAuthorizationRequiredHandlerDoesNotRecordReadOnlyRequests(c *C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/apps/myapp/env?:name=myapp", nil)
+	c.Assert(err, IsNil)
+	request.Header.Set("Authorization", s.t.Token)
+	AuthorizationRequiredHandler(authorizedSimpleHandler).ServeHTTP(recorder, request)
+	n, err := db.Session.Audit().Find(nil).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
@@ -57,7 +57,39 @@ func main() {
 	defer db.Session.Close()
 	fmt.Printf("Connected to MongoDB server at %s.\n", connString)
 	fmt.Printf("Using the database %q.\n\n", dbName)
+	migrated, err := app.MigrateLogs()
+	if err != nil {
+		fatal(err)
+	}
+	if migrated > 0 {
+		fmt.Printf("Migrated %d log entries to the logs collection.\n\n", migrated)
+	}
+
+	m := newRouter()
+
+	if !*dry {
+		provisioner, err := config.GetString("provisioner")
+		if err != nil {
+			fmt.Printf("Warning: %q didn't declare a provisioner, using default provisioner.\n", configFile)
+			provisioner = "juju"
+		}
+		app.Provisioner, err = provision.Get(provisioner)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Using %q provisioner.\n\n", provisioner)
+
+		listen, err := config.GetString("listen")
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("tsuru HTTP server listening at %s...\n", listen)
+		fatal(http.ListenAndServe(listen, m))
+	}
+}
 
+// newRouter returns the router with the routes of the tsuru API.
+func newRouter() *pat.PatternServeMux {
 	m := pat.New()
 
 	m.Get("/services/instances", AuthorizationRequiredHandler(consumption.ServicesInstancesHandler))
@@ -84,16 +116,43 @@ func main() {
 	m.Get("/apps/:name", AuthorizationRequiredHandler(api.AppInfo))
 	m.Post("/apps/:name/run", AuthorizationRequiredHandler(api.RunCommand))
 	m.Get("/apps/:name/restart", AuthorizationRequiredHandler(api.RestartHandler))
+	m.Post("/apps/:name/stop", AuthorizationRequiredHandler(api.StopHandler))
+	m.Post("/apps/:name/start", AuthorizationRequiredHandler(api.StartHandler))
 	m.Get("/apps/:name/env", AuthorizationRequiredHandler(api.GetEnv))
 	m.Post("/apps/:name/env", AuthorizationRequiredHandler(api.SetEnv))
 	m.Del("/apps/:name/env", AuthorizationRequiredHandler(api.UnsetEnv))
+	m.Get("/apps/:name/env/history", AuthorizationRequiredHandler(api.EnvHistoryHandler))
+	m.Post("/apps/:name/env/revert", AuthorizationRequiredHandler(api.EnvRevertHandler))
+	m.Post("/env/rotate-key", AuthorizationRequiredHandler(api.RotateEnvKeyHandler))
+	m.Get("/audit", AuthorizationRequiredHandler(api.AuditHandler))
+	m.Get("/webhooks", AuthorizationRequiredHandler(api.ListWebhooksHandler))
+	m.Post("/webhooks", AuthorizationRequiredHandler(api.AddWebhookHandler))
+	m.Del("/webhooks/:id", AuthorizationRequiredHandler(api.RemoveWebhookHandler))
+	m.Get("/webhooks/:id/deliveries", AuthorizationRequiredHandler(api.WebhookDeliveriesHandler))
 	m.Get("/apps", AuthorizationRequiredHandler(api.AppList))
 	m.Post("/apps", AuthorizationRequiredHandler(api.CreateAppHandler))
 	m.Put("/apps/:name/units", AuthorizationRequiredHandler(api.AddUnitsHandler))
-	m.Put("/apps/:app/:team", AuthorizationRequiredHandler(api.GrantAccessToTeamHandler))
-	m.Del("/apps/:app/:team", AuthorizationRequiredHandler(api.RevokeAccessFromTeamHandler))
+	m.Del("/apps/:name/units", AuthorizationRequiredHandler(api.RemoveUnitsHandler))
+	m.Get("/apps/:name/drains", AuthorizationRequiredHandler(api.ListDrainsHandler))
+	m.Post("/apps/:name/drains", AuthorizationRequiredHandler(api.AddDrainHandler))
+	m.Del("/apps/:name/drains", AuthorizationRequiredHandler(api.RemoveDrainHandler))
 	m.Get("/apps/:name/log", AuthorizationRequiredHandler(api.AppLog))
+	m.Post("/apps/:name/deploy", AuthorizationRequiredHandler(api.DeployHandler))
+	m.Get("/apps/:name/deploys", AuthorizationRequiredHandler(api.DeployListHandler))
+	m.Post("/apps/:name/rollback", AuthorizationRequiredHandler(api.RollbackHandler))
+	m.Del("/apps/:name/lock", AuthorizationRequiredHandler(api.ForceUnlockHandler))
+	m.Post("/apps/:name/maintenance", AuthorizationRequiredHandler(api.MaintenanceHandler))
+	m.Post("/apps/:name/healing", AuthorizationRequiredHandler(api.HealingHandler))
+	m.Post("/apps/:name/autoscale", AuthorizationRequiredHandler(api.AutoscaleHandler))
+	m.Get("/apps/:name/metrics", AuthorizationRequiredHandler(api.MetricsHandler))
+	m.Post("/apps/:name/crons", AuthorizationRequiredHandler(api.AddCronHandler))
+	m.Get("/apps/:name/crons", AuthorizationRequiredHandler(api.ListCronsHandler))
+	m.Del("/apps/:name/crons/:id", AuthorizationRequiredHandler(api.RemoveCronHandler))
 	m.Post("/apps/:name/log", Handler(api.AddLogHandler))
+	// The routes of teams go after all the /apps/:name/<resource> routes,
+	// otherwise pat would match :team against the resource.
+	m.Put("/apps/:app/:team", AuthorizationRequiredHandler(api.GrantAccessToTeamHandler))
+	m.Del("/apps/:app/:team", AuthorizationRequiredHandler(api.RevokeAccessFromTeamHandler))
 
 	m.Post("/users", Handler(auth.CreateUser))
 	m.Post("/users/:email/tokens", Handler(auth.Login))
@@ -107,24 +166,5 @@ func main() {
 	m.Del("/teams/:name", AuthorizationRequiredHandler(auth.RemoveTeam))
 	m.Put("/teams/:team/:user", AuthorizationRequiredHandler(auth.AddUserToTeam))
 	m.Del("/teams/:team/:user", AuthorizationRequiredHandler(auth.RemoveUserFromTeam))
-
-	if !*dry {
-		provisioner, err := config.GetString("provisioner")
-		if err != nil {
-			fmt.Printf("Warning: %q didn't declare a provisioner, using default provisioner.\n", configFile)
-			provisioner = "juju"
-		}
-		app.Provisioner, err = provision.Get(provisioner)
-		if err != nil {
-			fatal(err)
-		}
-		fmt.Printf("Using %q provisioner.\n\n", provisioner)
-
-		listen, err := config.GetString("listen")
-		if err != nil {
-			fatal(err)
-		}
-		fmt.Printf("tsuru HTTP server listening at %s...\n", listen)
-		fatal(http.ListenAndServe(listen, m))
-	}
+	return m
 }
@@ -0,0 +1,31 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+)
+
+func (s *S) TestRouterRoutesTheLockOfTheAppToForceUnlock(c *C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("DELETE", "/apps/someapp/lock", nil)
+	c.Assert(err, IsNil)
+	request.Header.Set("Authorization", s.t.Token)
+	newRouter().ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, Equals, http.StatusForbidden)
+	c.Assert(recorder.Body.String(), Equals, "Only admin users can unlock apps.\n")
+}
+
+func (s *S) TestRouterRoutesTeamsOfTheAppToRevokeAccess(c *C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("DELETE", "/apps/someapp/someteam", nil)
+	c.Assert(err, IsNil)
+	request.Header.Set("Authorization", s.t.Token)
+	newRouter().ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, Equals, http.StatusNotFound)
+	c.Assert(recorder.Body.String(), Equals, "App someapp not found.\n")
+}
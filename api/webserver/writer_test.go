@@ -6,6 +6,7 @@ package main
 
 import (
 	. "launchpad.net/gocheck"
+	"net/http"
 	"net/http/httptest"
 )
 
@@ -43,3 +44,29 @@ func (s *S) TestFlushingWriterWriteHeader(c *C) {
 	c.Assert(recorder.Code, Equals, expectedCode)
 	c.Assert(writer.wrote, Equals, true)
 }
+
+func (s *S) TestStatusWriter(c *C) {
+	recorder := httptest.NewRecorder()
+	writer := statusWriter{ResponseWriter: recorder}
+	c.Assert(writer.Status(), Equals, http.StatusOK)
+	writer.WriteHeader(http.StatusNotFound)
+	writer.Write([]byte("not found"))
+	c.Assert(writer.Status(), Equals, http.StatusNotFound)
+	c.Assert(recorder.Code, Equals, http.StatusNotFound)
+	c.Assert(recorder.Body.String(), Equals, "not found")
+}
+
+func (s *S) TestStatusWriterWrite(c *C) {
+	recorder := httptest.NewRecorder()
+	writer := statusWriter{ResponseWriter: recorder}
+	writer.Write([]byte("ok"))
+	writer.WriteHeader(http.StatusInternalServerError)
+	c.Assert(writer.Status(), Equals, http.StatusOK)
+}
+
+func (s *S) TestStatusWriterFlush(c *C) {
+	recorder := httptest.NewRecorder()
+	writer := statusWriter{ResponseWriter: recorder}
+	writer.Flush()
+	c.Assert(recorder.Flushed, Equals, true)
+}
@@ -23,10 +23,11 @@ func (s *S) TestLogWriter(c *C) {
 	_, err = writer.Write(data)
 	c.Assert(err, IsNil)
 	c.Assert(b.Bytes(), DeepEquals, data)
-	instance := app.App{}
-	err = db.Session.Apps().Find(bson.M{"name": a.Name}).One(&instance)
-	logLen := len(instance.Logs)
-	c.Assert(instance.Logs[logLen-1].Message, Equals, string(data))
+	var logs []app.Applog
+	err = db.Session.Logs().Find(bson.M{"appname": a.Name}).All(&logs)
+	c.Assert(err, IsNil)
+	logLen := len(logs)
+	c.Assert(logs[logLen-1].Message, Equals, string(data))
 }
 
 func (s *S) TestLogWriterShouldReturnsTheDataSize(c *C) {
@@ -62,8 +62,7 @@ func (a *createBucketIam) forward(app *App) error {
 			InstanceName: s3InstanceName,
 		})
 	}
-	app.SetEnvsToApp(envVars, false, true)
-	return nil
+	return app.SetEnvsToApp(envVars, false, true, "tsuru")
 }
 
 // createBucketIam backward destroys the app bucket.
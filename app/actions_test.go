@@ -112,16 +112,41 @@ func (s *S) TestCreateBucketForward(c *C) {
 	c.Assert(server.Messages(), DeepEquals, []queue.Message{expectedMessage})
 }
 
+func (s *S) TestCreateBucketForwardFailsIfTheEnvsCantBeSaved(c *C) {
+	patchRandomReader()
+	defer unpatchRandomReader()
+	a := App{
+		Name:      "unsaved",
+		Framework: "django",
+		Units:     []Unit{{Machine: 3}},
+	}
+	action := new(createBucketIam)
+	err := action.forward(&a)
+	defer action.backward(&a)
+	c.Assert(err, NotNil)
+}
+
 func (s *S) TestCreateBucketBackward(c *C) {
 	source := patchRandomReader()
 	defer unpatchRandomReader()
+	server := testing.FakeQueueServer{}
+	server.Start("127.0.0.1:0")
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err == nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
 	a := App{
 		Name:      "theirapp",
 		Framework: "ruby",
 		Units:     []Unit{{Machine: 1}},
 	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
 	action := new(createBucketIam)
-	err := action.forward(&a)
+	err = action.forward(&a)
 	c.Assert(err, IsNil)
 	action.backward(&a)
 	iam := getIAMEndpoint()
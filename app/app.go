@@ -18,6 +18,7 @@ import (
 	"github.com/globocom/tsuru/provision"
 	"github.com/globocom/tsuru/queue"
 	"github.com/globocom/tsuru/repository"
+	"github.com/globocom/tsuru/webhook"
 	"io"
 	"labix.org/v2/mgo/bson"
 	"launchpad.net/goyaml"
@@ -32,10 +33,21 @@ import (
 const (
 	RegenerateApprc = "regenerate-apprc"
 	StartApp        = "start-app"
+	RunCron         = "run-cron"
 )
 
 var Provisioner provision.Provisioner
 
+var (
+	// rollingTimeout is how long a rolling restart waits for the units of a
+	// batch to be started again.
+	rollingTimeout = 5 * time.Minute
+
+	// rollingInterval is the interval between checks of the units status in
+	// a rolling restart.
+	rollingInterval = 5 * time.Second
+)
+
 func write(w io.Writer, content []byte) error {
 	n, err := w.Write(content)
 	if err != nil {
@@ -48,14 +60,20 @@ func write(w io.Writer, content []byte) error {
 }
 
 type App struct {
-	Env       map[string]bind.EnvVar
-	Framework string
-	Logs      []Applog
-	Name      string
-	State     string
-	Units     []Unit
-	Teams     []string
-	hooks     *conf
+	Env         map[string]bind.EnvVar
+	Framework   string
+	Name        string
+	State       string
+	Stopped     bool
+	Units       []Unit
+	Teams       []string
+	Drains      []string
+	Maintenance Maintenance
+	Healing     HealingPolicy
+	Autoscale   AutoscalePolicy
+	Version     int
+	hooks       *conf
+	batch       []Unit
 }
 
 func (a *App) MarshalJSON() ([]byte, error) {
@@ -66,18 +84,17 @@ func (a *App) MarshalJSON() ([]byte, error) {
 	result["Teams"] = a.Teams
 	result["Units"] = a.Units
 	result["Repository"] = repository.GetUrl(a.Name)
+	result["Maintenance"] = a.Maintenance
+	result["Healing"] = a.Healing
+	result["Autoscale"] = a.Autoscale
 	return json.Marshal(&result)
 }
 
-type Applog struct {
-	Date    time.Time
-	Message string
-	Source  string
-}
-
 type conf struct {
-	PreRestart []string `yaml:"pre-restart"`
-	PosRestart []string `yaml:"pos-restart"`
+	PreRestart  []string     `yaml:"pre-restart"`
+	PosRestart  []string     `yaml:"pos-restart"`
+	Healthcheck *healthcheck `yaml:"healthcheck"`
+	Cron        []confCron   `yaml:"cron"`
 }
 
 func (a *App) Get() error {
@@ -88,10 +105,10 @@ func (a *App) Get() error {
 //
 // Creating a new app is a process composed of four steps:
 //
-//       1. Save the app in the database
-//       2. Create S3 credentials and bucket for the app
-//       3. Create the git repository using gandalf
-//       4. Provision the unit within the provisioner
+//  1. Save the app in the database
+//  2. Create S3 credentials and bucket for the app
+//  3. Create the git repository using gandalf
+//  4. Provision the unit within the provisioner
 func CreateApp(a *App) error {
 	if !a.isValid() {
 		msg := "Invalid app name, your app should have at most 63 " +
@@ -137,10 +154,11 @@ func (a *App) unbind() error {
 //
 // Destroy an app is a process composed of x steps:
 //
-//       1. Destroy the bucket and S3 credentials
-//       2. Destroy the app unit using juju
-//       3. Execute the unbind for the app
-//       4. Remove the app from the database
+//  1. Destroy the bucket and S3 credentials
+//  2. Destroy the app unit using juju
+//  3. Execute the unbind for the app
+//  4. Remove the app, its deploy history, its webhooks and its cron jobs from
+//     the database
 func (a *App) Destroy() error {
 	err := destroyBucket(a)
 	if err != nil {
@@ -156,31 +174,72 @@ func (a *App) Destroy() error {
 			return err
 		}
 	}
-	return db.Session.Apps().Remove(bson.M{"name": a.Name})
+	for _, d := range a.Drains {
+		closeDrainer(a.Name, d)
+	}
+	db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	err = db.Session.Apps().Remove(bson.M{"name": a.Name})
+	a.notify(webhook.Destroy, err, nil)
+	if err == nil {
+		db.Session.Webhooks().RemoveAll(bson.M{"app": a.Name})
+		db.Session.Crons().RemoveAll(bson.M{"app": a.Name})
+	}
+	return err
 }
 
 // AddUnit adds a new unit to the app (or update an existing unit). It just updates
 // the internal list of units, it does not talk to the provisioner. For
 // provisioning a new unit for the app, one should use AddUnits method, which
 // receives the number of units that you want to provision.
+//
+// When updating an existing unit, the deployed ref and the process type are
+// kept unless the given unit carries them. StateSince and Stuck are kept while
+// the state of the unit doesn't change, and reset otherwise.
 func (a *App) AddUnit(u *Unit) {
 	for i, unt := range a.Units {
 		if unt.Name == u.Name {
+			if u.Ref == "" {
+				u.Ref = unt.Ref
+			}
+			if u.ProcessType == "" {
+				u.ProcessType = unt.ProcessType
+			}
+			if u.StateSince.IsZero() && u.State == unt.State {
+				u.StateSince = unt.StateSince
+				u.Stuck = unt.Stuck
+			}
+			if u.StateSince.IsZero() {
+				u.StateSince = time.Now()
+			}
 			a.Units[i] = *u
 			return
 		}
 	}
+	if u.StateSince.IsZero() {
+		u.StateSince = time.Now()
+	}
 	a.Units = append(a.Units, *u)
 }
 
 // AddUnits creates n new units within the provisioner, saves new units in the
-// database and enqueues the apprc serialization.
-func (a *App) AddUnits(n uint) error {
+// database and enqueues the apprc serialization. The webhooks of the app are
+// notified when it finishes.
+//
+// The new units run the given process type, which must be declared in the
+// Procfile of the app. An empty process type means the default one, "web".
+func (a *App) AddUnits(n uint, processType string) error {
 	if n == 0 {
 		return errors.New("Cannot add zero units.")
 	}
-	units, err := Provisioner.AddUnits(a, n)
+	if processType == "" {
+		processType = provision.DefaultProcessType
+	}
+	if err := a.validateProcessType(processType); err != nil {
+		return err
+	}
+	units, err := Provisioner.AddUnits(a, n, processType)
 	if err != nil {
+		a.notify(webhook.AddUnits, err, map[string]string{"process": processType})
 		return err
 	}
 	qArgs := make([]string, len(units)+1)
@@ -192,22 +251,31 @@ func (a *App) AddUnits(n uint) error {
 	mCount := 0
 	for i, unit := range units {
 		a.Units[i+length] = Unit{
-			Name:    unit.Name,
-			Type:    unit.Type,
-			Ip:      unit.Ip,
-			Machine: unit.Machine,
-			State:   provision.StatusPending.String(),
+			Name:        unit.Name,
+			Type:        unit.Type,
+			Ip:          unit.Ip,
+			Machine:     unit.Machine,
+			State:       provision.StatusPending.String(),
+			StateSince:  time.Now(),
+			ProcessType: processType,
 		}
 		qArgs[i+1] = unit.Name
 		messages[mCount] = queue.Message{Action: RegenerateApprc, Args: []string{a.Name, unit.Name}}
 		messages[mCount+1] = queue.Message{Action: StartApp, Args: []string{a.Name, unit.Name}}
 		mCount += 2
 	}
-	err = db.Session.Apps().Update(bson.M{"name": a.Name}, a)
-	if err != nil {
-		return err
+	err = a.initField("units", []Unit{})
+	if err == nil {
+		err = a.update(bson.M{"$push": bson.M{"units": bson.M{"$each": a.Units[length:]}}})
+	}
+	if err == nil {
+		err = a.enqueue(messages...)
 	}
-	return a.enqueue(messages...)
+	a.notify(webhook.AddUnits, err, map[string]string{
+		"process": processType,
+		"units":   strings.Join(qArgs[1:], ","),
+	})
+	return err
 }
 
 func (a *App) Find(team *auth.Team) (int, bool) {
@@ -241,6 +309,26 @@ func (a *App) Revoke(team *auth.Team) error {
 	return nil
 }
 
+// GrantAccess grants the team access to the app, saving the change.
+func (a *App) GrantAccess(team *auth.Team) error {
+	return a.modify(func() (bson.M, error) {
+		if err := a.Grant(team); err != nil {
+			return nil, &ValidationError{Message: err.Error()}
+		}
+		return bson.M{"$set": bson.M{"teams": a.Teams}}, nil
+	})
+}
+
+// RevokeAccess revokes the access of the team to the app, saving the change.
+func (a *App) RevokeAccess(team *auth.Team) error {
+	return a.modify(func() (bson.M, error) {
+		if err := a.Revoke(team); err != nil {
+			return nil, &ValidationError{Message: err.Error()}
+		}
+		return bson.M{"$set": bson.M{"teams": a.Teams}}, nil
+	})
+}
+
 func (a *App) teams() []auth.Team {
 	var teams []auth.Team
 	db.Session.Teams().Find(bson.M{"_id": bson.M{"$in": a.Teams}}).All(&teams)
@@ -255,12 +343,22 @@ func (a *App) SetTeams(teams []auth.Team) {
 	sort.Strings(a.Teams)
 }
 
-func (a *App) setEnv(env bind.EnvVar) {
+// setEnv sets the given environment variable in the app. Values of private
+// variables are encrypted before being stored, and are never logged.
+func (a *App) setEnv(env bind.EnvVar) error {
 	if a.Env == nil {
 		a.Env = make(map[string]bind.EnvVar)
 	}
+	a.Log(fmt.Sprintf("setting env %s", &env), "tsuru")
+	if !env.Public {
+		value, err := encryptValue(env.Value)
+		if err != nil {
+			return err
+		}
+		env.Value = value
+	}
 	a.Env[env.Name] = env
-	a.Log(fmt.Sprintf("setting env %s with value %s", env.Name, env.Value), "tsuru")
+	return nil
 }
 
 func (a *App) getEnv(name string) (bind.EnvVar, error) {
@@ -280,10 +378,19 @@ func (a *App) isValid() bool {
 	return regex.MatchString(a.Name)
 }
 
+// InstanceEnv returns the environment variables of the app that were exported
+// by the given service instance, with the values of private variables
+// decrypted.
 func (a *App) InstanceEnv(name string) map[string]bind.EnvVar {
 	envs := make(map[string]bind.EnvVar)
 	for k, env := range a.Env {
 		if env.InstanceName == name {
+			value, err := decryptValue(env.Value)
+			if err != nil {
+				a.Log(fmt.Sprintf("Failed to decrypt the env %s: %s", k, err), "tsuru")
+			} else {
+				env.Value = value
+			}
 			envs[k] = bind.EnvVar(env)
 		}
 	}
@@ -305,7 +412,7 @@ func deployHookAbsPath(p string) (string, error) {
 	return strings.Join(cmdArgs, " "), nil
 }
 
-// Loads restart hooks from app.conf.
+// Loads restart hooks, the healthcheck and the cron jobs from app.conf.
 func (a *App) loadHooks() error {
 	if a.hooks != nil {
 		return nil
@@ -328,6 +435,12 @@ func (a *App) loadHooks() error {
 		a.Log(fmt.Sprintf("Got error while parsing yaml: %s", err), "tsuru")
 		return err
 	}
+	if hc := a.hooks.Healthcheck; hc != nil && hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+		err = fmt.Errorf("Invalid healthcheck path %q, it must start with a slash.", hc.Path)
+		a.Log(err.Error(), "tsuru")
+		a.hooks = nil
+		return err
+	}
 	return nil
 }
 
@@ -379,15 +492,59 @@ func (a *App) posRestart(w io.Writer) error {
 // Run executes the command in app units, sourcing apprc before running the
 // command.
 func (a *App) Run(cmd string, w io.Writer) error {
+	return a.RunWithTimeout(cmd, w, 0)
+}
+
+// RunWithTimeout runs the command in all units of the app, like Run. If
+// timeout is not zero, the command is killed in the units where it runs for
+// longer than timeout, exiting with the status 124.
+func (a *App) RunWithTimeout(cmd string, w io.Writer, timeout time.Duration) error {
 	a.Log(fmt.Sprintf("running '%s'", cmd), "tsuru")
 	source := "[ -f /home/application/apprc ] && source /home/application/apprc"
 	cd := "[ -d /home/application/current ] && cd /home/application/current"
 	cmd = fmt.Sprintf("%s; %s; %s", source, cd, cmd)
+	if timeout > 0 {
+		seconds := (timeout + time.Second - 1) / time.Second
+		cmd = fmt.Sprintf("timeout %d /bin/bash -c %s", seconds, shellEscape(cmd))
+	}
 	return a.run(cmd, w)
 }
 
+// RunInUnit runs the command in a single unit of the app: the unit with the
+// given name or, if the name is empty, any started unit. The timeout works as
+// in RunWithTimeout.
+func (a *App) RunInUnit(cmd, unitName string, w io.Writer, timeout time.Duration) error {
+	unit, err := a.startedUnit(unitName)
+	if err != nil {
+		return err
+	}
+	a.batch = []Unit{*unit}
+	defer func() { a.batch = nil }()
+	return a.RunWithTimeout(cmd, w, timeout)
+}
+
+// startedUnit returns the unit with the given name, or the first started unit
+// if the name is empty. The unit must be started.
+func (a *App) startedUnit(name string) (*Unit, error) {
+	for i, u := range a.Units {
+		if name != "" && u.Name != name {
+			continue
+		}
+		if u.State == string(provision.StatusStarted) {
+			return &a.Units[i], nil
+		} else if name != "" {
+			msg := fmt.Sprintf("The unit %s is %q, it must be started to run commands.", name, u.State)
+			return nil, &ValidationError{Message: msg}
+		}
+	}
+	if name != "" {
+		return nil, &ValidationError{Message: fmt.Sprintf("Unit %s not found in the app %s.", name, a.Name)}
+	}
+	return nil, &ValidationError{Message: fmt.Sprintf("The app %s has no started units.", a.Name)}
+}
+
 func (a *App) run(cmd string, w io.Writer) error {
-	if a.State != string(provision.StatusStarted) {
+	if !a.Available() {
 		return fmt.Errorf("App must be started to run commands, but it is %q.", a.State)
 	}
 	return Provisioner.ExecuteCommand(w, w, a, cmd)
@@ -399,22 +556,344 @@ func (a *App) Command(stdout, stderr io.Writer, cmdArgs ...string) error {
 }
 
 // Restart runs the restart hook for the app
-// and returns your output.
+// and returns your output. The webhooks of the app are notified when it
+// finishes.
 func (a *App) Restart(w io.Writer) error {
+	err := a.restart(w)
+	a.notify(webhook.Restart, err, nil)
+	return err
+}
+
+func (a *App) restart(w io.Writer) error {
 	a.Log("executing hook to restart", "tsuru")
 	err := a.preRestart(w)
 	if err != nil {
 		return err
 	}
-	err = write(w, []byte("\n ---> Restarting your app\n"))
+	if err = a.restartUnits(w); err != nil {
+		return err
+	}
+	return a.restarted(w)
+}
+
+// restartUnits runs the restart hook in the units of the app, or in the units
+// of the current batch. Units that run other process types than the default
+// one restart the command of their process type instead (see startProcesses).
+func (a *App) restartUnits(w io.Writer) error {
+	units := a.Units
+	if a.batch != nil {
+		units = a.batch
+	}
+	web, others, types := splitUnits(units)
+	if len(types) > 0 {
+		batch := a.batch
+		defer func() { a.batch = batch }()
+		a.batch = web
+	}
+	if len(types) == 0 || len(web) > 0 {
+		err := write(w, []byte("\n ---> Restarting your app\n"))
+		if err != nil {
+			return err
+		}
+		if err = a.run("/var/lib/tsuru/hooks/restart", w); err != nil {
+			return err
+		}
+	}
+	return a.startProcesses(w, others, types)
+}
+
+// restarted runs the pos-restart hooks of the app and loads its cron jobs,
+// once all of its units are restarted.
+func (a *App) restarted(w io.Writer) error {
+	if err := a.posRestart(w); err != nil {
+		return err
+	}
+	if err := a.syncConfCrons(); err != nil {
+		a.Log(fmt.Sprintf("Failed to load the cron jobs from app.conf: %s", err), "tsuru")
+	}
+	return nil
+}
+
+// RemoveUnits removes n units from the app. The units are removed from the
+// provisioner and from the database, and the apprc regeneration of the
+// remaining units is enqueued.
+//
+// If processType is not empty, only units running that process type are
+// removed. The units are removed in the order they were added, and the app
+// must keep at least one unit.
+func (a *App) RemoveUnits(n uint, processType string) error {
+	if processType != "" {
+		return a.removeProcessUnits(n, processType)
+	}
+	length := uint(len(a.Units))
+	if n == 0 {
+		return &ValidationError{Message: "Cannot remove zero units."}
+	} else if n == length {
+		return &ValidationError{Message: "You can't remove all units from an app."}
+	} else if n > length {
+		msg := fmt.Sprintf("You can't remove %d units from this app because it has only %d units.", n, length)
+		return &ValidationError{Message: msg}
+	}
+	err := Provisioner.RemoveUnits(a, n)
+	if err != nil {
+		return err
+	}
+	names := make([]string, n)
+	for i, u := range a.Units[:n] {
+		names[i] = u.Name
+	}
+	a.Units = a.Units[n:]
+	return a.unitsRemoved(names)
+}
+
+func (a *App) removeProcessUnits(n uint, processType string) error {
+	units := a.processUnits(processType)
+	length := uint(len(units))
+	if n == 0 {
+		return &ValidationError{Message: "Cannot remove zero units."}
+	} else if n > length {
+		msg := fmt.Sprintf("You can't remove %d %s units from this app because it has only %d %s units.", n, processType, length, processType)
+		return &ValidationError{Message: msg}
+	} else if n == uint(len(a.Units)) {
+		return &ValidationError{Message: "You can't remove all units from an app."}
+	}
+	var names []string
+	for _, u := range units[:n] {
+		err := Provisioner.RemoveUnit(a, u.Name)
+		if err != nil {
+			if len(names) > 0 {
+				a.unitsRemoved(names)
+			}
+			return err
+		}
+		a.deleteUnit(u.Name)
+		names = append(names, u.Name)
+	}
+	return a.unitsRemoved(names)
+}
+
+// RemoveUnit removes the unit with the given name from the app. Like
+// RemoveUnits, it removes the unit from the provisioner and from the database,
+// and enqueues the apprc regeneration of the remaining units.
+func (a *App) RemoveUnit(name string) error {
+	index := -1
+	for i, u := range a.Units {
+		if u.Name == name {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return &ValidationError{Message: fmt.Sprintf("Unit %s not found in the app %s.", name, a.Name)}
+	}
+	if len(a.Units) == 1 {
+		return &ValidationError{Message: "You can't remove all units from an app."}
+	}
+	err := Provisioner.RemoveUnit(a, name)
+	if err != nil {
+		return err
+	}
+	copy(a.Units[index:], a.Units[index+1:])
+	a.Units = a.Units[:len(a.Units)-1]
+	return a.unitsRemoved([]string{name})
+}
+
+// deleteUnit removes the unit with the given name from the list of units of
+// the app. It doesn't touch the provisioner nor the database.
+func (a *App) deleteUnit(name string) {
+	for i, u := range a.Units {
+		if u.Name == name {
+			copy(a.Units[i:], a.Units[i+1:])
+			a.Units = a.Units[:len(a.Units)-1]
+			return
+		}
+	}
+}
+
+// unitsRemoved pulls the units with the given names from the app document and
+// enqueues the apprc regeneration of the remaining units.
+func (a *App) unitsRemoved(names []string) error {
+	err := a.update(bson.M{"$pull": bson.M{"units": bson.M{"name": bson.M{"$in": names}}}})
+	if err != nil {
+		return err
+	}
+	return a.enqueue(queue.Message{Action: RegenerateApprc, Args: []string{a.Name}})
+}
+
+// RollingRestart restarts the app batchSize units at a time, so the app is
+// never down as a whole.
+//
+// The pre-restart hooks run before the first batch, and the pos-restart hooks
+// after the last one. After restarting a batch, it waits for its units to come
+// back before moving to the next batch (see waitUnits). If a batch fails to
+// restart, or its units don't come back within rollingTimeout, the remaining
+// batches are not restarted. The webhooks of the app are notified when it
+// finishes.
+func (a *App) RollingRestart(w io.Writer, batchSize int) error {
+	if batchSize < 1 {
+		return errors.New("The batch size must be greater than zero.")
+	}
+	err := a.rollingRestart(w, batchSize)
+	a.notify(webhook.Restart, err, nil)
+	return err
+}
+
+func (a *App) rollingRestart(w io.Writer, batchSize int) error {
+	a.Log("executing hook to restart", "tsuru")
+	if err := a.preRestart(w); err != nil {
+		return err
+	}
+	defer func() { a.batch = nil }()
+	units := a.Units
+	total := (len(units) + batchSize - 1) / batchSize
+	for i, n := 0, 1; i < len(units); i, n = i+batchSize, n+1 {
+		end := i + batchSize
+		if end > len(units) {
+			end = len(units)
+		}
+		a.batch = units[i:end]
+		names := make([]string, len(a.batch))
+		for j, u := range a.batch {
+			names[j] = u.Name
+		}
+		msg := fmt.Sprintf("\n ---> Restarting batch %d of %d (%s)\n", n, total, strings.Join(names, ", "))
+		err := write(w, []byte(msg))
+		if err != nil {
+			return err
+		}
+		err = a.restartUnits(w)
+		if err == nil {
+			err = a.waitUnits(a.batch)
+		}
+		if err != nil {
+			msg := fmt.Sprintf("Rolling restart aborted in batch %d of %d: %s", n, total, err)
+			a.Log(msg, "tsuru")
+			return errors.New(msg)
+		}
+	}
+	a.batch = nil
+	return a.restarted(w)
+}
+
+// waitUnits waits until all the given units are back after a restart, giving
+// up after rollingTimeout.
+//
+// The provisioner keeps reporting a unit as started for a while after it is
+// restarted, so a unit is only back after the provisioner reports it in
+// another state and then as started again. If the app declares a healthcheck,
+// a started unit that passes the healthcheck is back too.
+func (a *App) waitUnits(units []Unit) error {
+	var hc *healthcheck
+	if a.hooks != nil && a.hooks.Healthcheck != nil && a.hooks.Healthcheck.Path != "" {
+		hc = a.hooks.Healthcheck
+	}
+	timeout := time.After(rollingTimeout)
+	restarting := make(map[string]bool, len(units))
+	for {
+		status, err := Provisioner.CollectStatus()
+		if err != nil {
+			return err
+		}
+		started := make(map[string]bool, len(status))
+		for _, u := range status {
+			started[u.Name] = u.Status == provision.StatusStarted
+		}
+		var pending []Unit
+		for _, u := range units {
+			switch {
+			case !started[u.Name]:
+				restarting[u.Name] = true
+				pending = append(pending, u)
+			case restarting[u.Name]:
+			case hc != nil && probeUnit(u.Ip, hc.Path, hc.expectedStatus(), rollingInterval) == "":
+			default:
+				pending = append(pending, u)
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		units = pending
+		select {
+		case <-timeout:
+			return fmt.Errorf("timed out waiting for units to be %q.", provision.StatusStarted)
+		case <-time.After(rollingInterval):
+		}
+	}
+}
+
+// SetRef records the git ref (branch, tag or commit) that is deployed in all
+// units of the app, saving the app in the database.
+func (a *App) SetRef(ref string) error {
+	return a.modify(func() (bson.M, error) {
+		for i := range a.Units {
+			a.Units[i].Ref = ref
+		}
+		return bson.M{"$set": bson.M{"units": a.Units}}, nil
+	})
+}
+
+// setState changes the state of the app and all its units, and whether the app
+// was stopped, saving the app in the database.
+func (a *App) setState(state provision.Status, stopped bool) error {
+	return a.modify(func() (bson.M, error) {
+		a.State = state.String()
+		a.Stopped = stopped
+		now := time.Now()
+		for i := range a.Units {
+			if a.Units[i].State != state.String() {
+				a.Units[i].State = state.String()
+				a.Units[i].StateSince = now
+			}
+		}
+		return bson.M{"$set": bson.M{"state": a.State, "stopped": a.Stopped, "units": a.Units}}, nil
+	})
+}
+
+// Stop takes the app offline, stopping it in all units within the
+// provisioner, without destroying them. The app stays down until it's started
+// again, whatever the state of its units.
+func (a *App) Stop(w io.Writer) error {
+	a.Log("stopping the app", "tsuru")
+	err := write(w, []byte("\n ---> Stopping your app\n"))
+	if err != nil {
+		return err
+	}
+	_, others, types := splitUnits(a.Units)
+	if err = a.stopProcesses(w, others, types); err != nil {
+		a.Log(fmt.Sprintf("Failed to stop the app: %s", err), "tsuru")
+		return err
+	}
+	err = Provisioner.Stop(a)
+	if err != nil {
+		a.Log(fmt.Sprintf("Failed to stop the app: %s", err), "tsuru")
+		return err
+	}
+	return a.setState(provision.StatusDown, true)
+}
+
+// Start brings a stopped app back online, starting it in all units within the
+// provisioner.
+func (a *App) Start(w io.Writer) error {
+	a.Log("starting the app", "tsuru")
+	err := write(w, []byte("\n ---> Starting your app\n"))
 	if err != nil {
 		return err
 	}
-	err = a.run("/var/lib/tsuru/hooks/restart", w)
+	err = Provisioner.Start(a)
 	if err != nil {
+		a.Log(fmt.Sprintf("Failed to start the app: %s", err), "tsuru")
+		return err
+	}
+	if err = a.setState(provision.StatusStarted, false); err != nil {
 		return err
 	}
-	return a.posRestart(w)
+	_, others, types := splitUnits(a.Units)
+	if err = a.startProcesses(w, others, types); err != nil {
+		a.Log(fmt.Sprintf("Failed to start the app: %s", err), "tsuru")
+		return err
+	}
+	return nil
 }
 
 // InstallDeps runs the dependencies hook for the app
@@ -450,8 +929,12 @@ func (a *App) GetFramework() string {
 }
 
 func (a *App) ProvisionUnits() []provision.AppUnit {
-	units := make([]provision.AppUnit, len(a.Units))
-	for i, u := range a.Units {
+	appUnits := a.Units
+	if a.batch != nil {
+		appUnits = a.batch
+	}
+	units := make([]provision.AppUnit, len(appUnits))
+	for i, u := range appUnits {
 		other := u
 		other.app = a
 		units[i] = &other
@@ -461,16 +944,22 @@ func (a *App) ProvisionUnits() []provision.AppUnit {
 
 // SerializeEnvVars serializes the environment variables of the app. The
 // environment variables will be written the the file /home/application/apprc
-// in all units of the app.
+// in all units of the app. Private variables are decrypted only here, right
+// before being written. Values are shell-escaped, so they reach the app
+// exactly as they were set.
 //
 // The wait parameter indicates whether it should wait or not for the write to
 // complete.
 func (a *App) SerializeEnvVars() error {
 	var buf bytes.Buffer
-	cmd := "cat > /home/application/apprc <<END\n"
+	cmd := "cat > /home/application/apprc <<'END'\n"
 	cmd += fmt.Sprintf("# generated by tsuru at %s\n", time.Now().Format(time.RFC822Z))
 	for k, v := range a.Env {
-		cmd += fmt.Sprintf(`export %s="%s"`+"\n", k, v.Value)
+		value, err := decryptValue(v.Value)
+		if err != nil {
+			return fmt.Errorf("Failed to decrypt the env %s: %s.", k, err)
+		}
+		cmd += fmt.Sprintf("export %s=%s\n", k, shellEscape(value))
 	}
 	cmd += "END\n"
 	err := a.run(cmd, &buf)
@@ -485,12 +974,24 @@ func (a *App) SerializeEnvVars() error {
 	return err
 }
 
-func (a *App) SetEnvs(envs []bind.EnvVar, publicOnly bool) error {
+// shellEscape quotes the given value so it is taken literally by the shell,
+// wrapping it in single quotes. Single quotes in the value are closed, escaped
+// and reopened.
+func shellEscape(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+func (a *App) SetEnvs(envs []bind.EnvVar, userOnly bool) error {
 	e := make([]bind.EnvVar, len(envs))
 	for i, env := range envs {
 		e[i] = bind.EnvVar(env)
 	}
-	return a.SetEnvsToApp(e, publicOnly, false)
+	return a.SetEnvsToApp(e, userOnly, false, "tsuru")
+}
+
+// notify notifies the webhooks of the app, and of its teams, about an event.
+func (a *App) notify(event string, err error, data map[string]string) {
+	webhook.Notify(event, a.Name, a.Teams, err, data)
 }
 
 func (a *App) enqueue(msgs ...queue.Message) error {
@@ -514,27 +1015,42 @@ func (a *App) enqueue(msgs ...queue.Message) error {
 // serialize them directly or using a queue.
 //
 // Besides the slice of environment variables, this method also takes two other
-// parameters: publicOnly indicates whether only public variables can be
-// overridden (if set to false, setEnvsToApp may override a private variable).
+// parameters: userOnly indicates whether only variables set by users can be
+// overridden (if set to false, setEnvsToApp may override a variable set by a
+// service instance).
 //
 // If useQueue is true, it will use a queue to write the environment variables
-// in the units of the app.
-func (app *App) SetEnvsToApp(envs []bind.EnvVar, publicOnly, useQueue bool) error {
+// in the units of the app. The change is recorded in the env history of the
+// app as made by the given user.
+func (app *App) SetEnvsToApp(envs []bind.EnvVar, userOnly, useQueue bool, user string) error {
 	if len(envs) > 0 {
+		old := app.copyEnv()
+		changes := bson.M{}
 		for _, env := range envs {
 			set := true
-			if publicOnly {
+			if userOnly {
 				e, err := app.getEnv(env.Name)
-				if err == nil && !e.Public {
+				if err == nil && e.InstanceName != "" {
 					set = false
 				}
 			}
 			if set {
-				app.setEnv(env)
+				if err := app.setEnv(env); err != nil {
+					return err
+				}
+				changes["env."+env.Name] = app.Env[env.Name]
 			}
 		}
-		if err := db.Session.Apps().Update(bson.M{"name": app.Name}, app); err != nil {
-			return err
+		if len(changes) > 0 {
+			if err := app.initField("env", bson.M{}); err != nil {
+				return err
+			}
+			if err := app.update(bson.M{"$set": changes}); err != nil {
+				return err
+			}
+		}
+		if err := app.recordEnvVersion(old, user, 0); err != nil {
+			log.Printf("Failed to record the env history of the app %q: %s", app.Name, err)
 		}
 		if useQueue {
 			return app.enqueue(queue.Message{Action: RegenerateApprc, Args: []string{app.Name}})
@@ -544,8 +1060,8 @@ func (app *App) SetEnvsToApp(envs []bind.EnvVar, publicOnly, useQueue bool) erro
 	return nil
 }
 
-func (a *App) UnsetEnvs(envs []string, publicOnly bool) error {
-	return a.UnsetEnvsFromApp(envs, publicOnly, false)
+func (a *App) UnsetEnvs(envs []string, userOnly bool) error {
+	return a.UnsetEnvsFromApp(envs, userOnly, false, "tsuru")
 }
 
 // UnsetEnvsFromApp removes environment variables from an app, serializing the
@@ -553,47 +1069,44 @@ func (a *App) UnsetEnvs(envs []string, publicOnly bool) error {
 // can serialize them directly or use a queue.
 //
 // Besides the slice with the name of the variables, this method also takes two
-// other parameters: publicOnly indicates whether only public variables can be
-// overridden (if set to false, setEnvsToApp may override a private variable).
+// other parameters: userOnly indicates whether only variables set by users can
+// be removed (if set to false, UnsetEnvsFromApp may remove a variable set by a
+// service instance).
 //
 // If useQueue is true, it will use a queue to write the environment variables
-// in the units of the app.
-func (app *App) UnsetEnvsFromApp(variableNames []string, publicOnly, useQueue bool) error {
+// in the units of the app. The change is recorded in the env history of the
+// app as made by the given user.
+func (app *App) UnsetEnvsFromApp(variableNames []string, userOnly, useQueue bool, user string) error {
 	if len(variableNames) > 0 {
+		old := app.copyEnv()
+		changes := bson.M{}
 		for _, name := range variableNames {
 			var unset bool
 			e, err := app.getEnv(name)
-			if !publicOnly || (err == nil && e.Public) {
+			if !userOnly || (err == nil && e.InstanceName == "") {
 				unset = true
 			}
 			if unset {
 				delete(app.Env, name)
+				changes["env."+name] = ""
 			}
 		}
-		if err := db.Session.Apps().Update(bson.M{"name": app.Name}, app); err != nil {
-			return err
+		if len(changes) > 0 {
+			if err := app.initField("env", bson.M{}); err != nil {
+				return err
+			}
+			if err := app.update(bson.M{"$unset": changes}); err != nil {
+				return err
+			}
+		}
+		if err := app.recordEnvVersion(old, user, 0); err != nil {
+			log.Printf("Failed to record the env history of the app %q: %s", app.Name, err)
 		}
 		app.SerializeEnvVars()
 	}
 	return nil
 }
 
-func (a *App) Log(message string, source string) error {
-	log.Printf(message)
-	messages := strings.Split(message, "\n")
-	for _, msg := range messages {
-		if msg != "" {
-			l := Applog{
-				Date:    time.Now(),
-				Message: msg,
-				Source:  source,
-			}
-			a.Logs = append(a.Logs, l)
-		}
-	}
-	return db.Session.Apps().Update(bson.M{"name": a.Name}, a)
-}
-
 type ValidationError struct {
 	Message string
 }
@@ -18,9 +18,13 @@ import (
 	"github.com/globocom/tsuru/queue"
 	"github.com/globocom/tsuru/repository"
 	"github.com/globocom/tsuru/testing"
+	"github.com/globocom/tsuru/webhook"
+	"io/ioutil"
 	"labix.org/v2/mgo/bson"
 	. "launchpad.net/gocheck"
 	stdlog "log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"strings"
@@ -33,7 +37,6 @@ func (s *S) TestGet(c *C) {
 	c.Assert(err, IsNil)
 	defer db.Session.Apps().Remove(bson.M{"name": newApp.Name})
 	newApp.Env = map[string]bind.EnvVar{}
-	newApp.Logs = []Applog{}
 	err = db.Session.Apps().Update(bson.M{"name": newApp.Name}, &newApp)
 	c.Assert(err, IsNil)
 	myApp := App{Name: "myApp"}
@@ -81,6 +84,22 @@ func (s *S) TestDestroyWithoutUnits(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *S) TestDestroyRemovesTheDeployHistory(c *C) {
+	h := testHandler{}
+	ts := s.t.StartGandalfTestServer(&h)
+	defer ts.Close()
+	a := App{Name: "x4"}
+	err := CreateApp(&a)
+	c.Assert(err, IsNil)
+	err = NewDeploy(&a, "someone@tsuru.io").Finish(nil)
+	c.Assert(err, IsNil)
+	err = a.Destroy()
+	c.Assert(err, IsNil)
+	n, err := db.Session.Deploys().Find(bson.M{"app": a.Name}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
 func (s *S) TestFailingDestroy(c *C) {
 	h := testHandler{}
 	ts := s.t.StartGandalfTestServer(&h)
@@ -246,6 +265,33 @@ func (s *S) TestAppendOrUpdate(c *C) {
 	c.Assert(a.Units[0], DeepEquals, u)
 }
 
+func (s *S) TestAddUnitKeepsTheDeployedRef(c *C) {
+	a := App{
+		Name:  "appName",
+		Units: []Unit{{Name: "i-00000zz8", Ref: "v1.0"}},
+	}
+	u := Unit{Name: "i-00000zz8", State: string(provision.StatusStarted)}
+	a.AddUnit(&u)
+	c.Assert(a.Units[0].Ref, Equals, "v1.0")
+	c.Assert(a.Units[0].State, Equals, string(provision.StatusStarted))
+}
+
+func (s *S) TestSetRef(c *C) {
+	a := App{
+		Name:  "appName",
+		Units: []Unit{{Name: "i-00000zz8"}, {Name: "i-00000zz9"}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.SetRef("hotfix")
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units[0].Ref, Equals, "hotfix")
+	c.Assert(a.Units[1].Ref, Equals, "hotfix")
+}
+
 func (s *S) TestAddUnits(c *C) {
 	server := testing.FakeQueueServer{}
 	server.Start("127.0.0.1:0")
@@ -261,11 +307,11 @@ func (s *S) TestAddUnits(c *C) {
 	defer db.Session.Apps().Remove(bson.M{"name": app.Name})
 	s.provisioner.Provision(&app)
 	defer s.provisioner.Destroy(&app)
-	err = app.AddUnits(5)
+	err = app.AddUnits(5, "")
 	c.Assert(err, IsNil)
 	units := s.provisioner.GetUnits(&app)
 	c.Assert(units, HasLen, 5)
-	err = app.AddUnits(2)
+	err = app.AddUnits(2, "")
 	c.Assert(err, IsNil)
 	units = s.provisioner.GetUnits(&app)
 	c.Assert(units, HasLen, 7)
@@ -293,18 +339,138 @@ func (s *S) TestAddUnits(c *C) {
 
 func (s *S) TestAddZeroUnits(c *C) {
 	app := App{Name: "warpaint", Framework: "ruby"}
-	err := app.AddUnits(0)
+	err := app.AddUnits(0, "")
 	c.Assert(err, NotNil)
 	c.Assert(err.Error(), Equals, "Cannot add zero units.")
 }
 
 func (s *S) TestAddUnitsFailureInProvisioner(c *C) {
 	app := App{Name: "scars", Framework: "golang"}
-	err := app.AddUnits(2)
+	err := app.AddUnits(2, "")
 	c.Assert(err, NotNil)
 	c.Assert(err.Error(), Equals, "App is not provisioned.")
 }
 
+func (s *S) TestRemoveUnits(c *C) {
+	server := testing.FakeQueueServer{}
+	server.Start("127.0.0.1:0")
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err != nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	app := App{Name: "chemistry", Framework: "python"}
+	s.provisioner.Provision(&app)
+	defer s.provisioner.Destroy(&app)
+	units, err := s.provisioner.AddUnits(&app, 4, "web")
+	c.Assert(err, IsNil)
+	for _, u := range units {
+		app.AddUnit(&Unit{Name: u.Name, Machine: u.Machine})
+	}
+	err = db.Session.Apps().Insert(app)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": app.Name})
+	err = app.RemoveUnits(2, "")
+	c.Assert(err, IsNil)
+	units = s.provisioner.GetUnits(&app)
+	c.Assert(units, HasLen, 2)
+	err = app.Get()
+	c.Assert(err, IsNil)
+	c.Assert(app.Units, HasLen, 2)
+	c.Assert(app.Units[0].Name, Equals, "chemistry/2")
+	c.Assert(app.Units[1].Name, Equals, "chemistry/3")
+	time.Sleep(1e6)
+	expected := []queue.Message{{Action: RegenerateApprc, Args: []string{app.Name}}}
+	c.Assert(server.Messages(), DeepEquals, expected)
+}
+
+func (s *S) TestRemoveUnitsInvalidValues(c *C) {
+	app := App{
+		Name:  "chemistry",
+		Units: []Unit{{Name: "chemistry/0"}, {Name: "chemistry/1"}},
+	}
+	var tests = []struct {
+		n        uint
+		expected string
+	}{
+		{0, "Cannot remove zero units."},
+		{2, "You can't remove all units from an app."},
+		{3, "You can't remove 3 units from this app because it has only 2 units."},
+	}
+	for _, t := range tests {
+		err := app.RemoveUnits(t.n, "")
+		c.Assert(err, NotNil)
+		e, ok := err.(*ValidationError)
+		c.Assert(ok, Equals, true)
+		c.Assert(e.Message, Equals, t.expected)
+	}
+}
+
+func (s *S) TestRemoveUnitsFailureInProvisioner(c *C) {
+	s.provisioner.PrepareFailure("RemoveUnits", errors.New("juju failed"))
+	app := App{
+		Name:  "chemistry",
+		Units: []Unit{{Name: "chemistry/0"}, {Name: "chemistry/1"}},
+	}
+	err := app.RemoveUnits(1, "")
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "juju failed")
+	c.Assert(app.Units, HasLen, 2)
+}
+
+func (s *S) TestRemoveUnit(c *C) {
+	server := testing.FakeQueueServer{}
+	server.Start("127.0.0.1:0")
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err != nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	app := App{Name: "chemistry", Framework: "python"}
+	err = db.Session.Apps().Insert(app)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": app.Name})
+	s.provisioner.Provision(&app)
+	defer s.provisioner.Destroy(&app)
+	err = app.AddUnits(3, "")
+	c.Assert(err, IsNil)
+	err = app.RemoveUnit("chemistry/1")
+	c.Assert(err, IsNil)
+	units := s.provisioner.GetUnits(&app)
+	c.Assert(units, HasLen, 2)
+	err = app.Get()
+	c.Assert(err, IsNil)
+	c.Assert(app.Units, HasLen, 2)
+	c.Assert(app.Units[0].Name, Equals, "chemistry/0")
+	c.Assert(app.Units[1].Name, Equals, "chemistry/2")
+}
+
+func (s *S) TestRemoveUnitNotFound(c *C) {
+	app := App{
+		Name:  "chemistry",
+		Units: []Unit{{Name: "chemistry/0"}, {Name: "chemistry/1"}},
+	}
+	err := app.RemoveUnit("chemistry/2")
+	c.Assert(err, NotNil)
+	e, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Message, Equals, "Unit chemistry/2 not found in the app chemistry.")
+}
+
+func (s *S) TestRemoveUnitLastUnit(c *C) {
+	app := App{
+		Name:  "chemistry",
+		Units: []Unit{{Name: "chemistry/0"}},
+	}
+	err := app.RemoveUnit("chemistry/0")
+	c.Assert(err, NotNil)
+	e, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Message, Equals, "You can't remove all units from an app.")
+}
+
 func (s *S) TestGrantAccess(c *C) {
 	a := App{Name: "appName", Framework: "django", Teams: []string{}}
 	err := a.Grant(&s.team)
@@ -372,15 +538,16 @@ func (s *S) TestSetEnvironmentVariableToApp(c *C) {
 	c.Assert(env.Public, Equals, true)
 }
 
-func (s *S) TestSetEnvRespectsThePublicOnlyFlagKeepPrivateVariablesWhenItsTrue(c *C) {
+func (s *S) TestSetEnvRespectsTheUserOnlyFlagKeepServiceVariablesWhenItsTrue(c *C) {
 	a := App{
 		Name:  "myapp",
 		Units: []Unit{{Machine: 1}},
 		Env: map[string]bind.EnvVar{
 			"DATABASE_HOST": {
-				Name:   "DATABASE_HOST",
-				Value:  "localhost",
-				Public: false,
+				Name:         "DATABASE_HOST",
+				Value:        "localhost",
+				Public:       false,
+				InstanceName: "mysql",
 			},
 		},
 	}
@@ -399,16 +566,17 @@ func (s *S) TestSetEnvRespectsThePublicOnlyFlagKeepPrivateVariablesWhenItsTrue(c
 			Public: true,
 		},
 	}
-	err = a.SetEnvsToApp(envs, true, false)
+	err = a.SetEnvsToApp(envs, true, false, "")
 	c.Assert(err, IsNil)
 	newApp := App{Name: a.Name}
 	err = newApp.Get()
 	c.Assert(err, IsNil)
 	expected := map[string]bind.EnvVar{
 		"DATABASE_HOST": {
-			Name:   "DATABASE_HOST",
-			Value:  "localhost",
-			Public: false,
+			Name:         "DATABASE_HOST",
+			Value:        "localhost",
+			Public:       false,
+			InstanceName: "mysql",
 		},
 		"DATABASE_PASSWORD": {
 			Name:   "DATABASE_PASSWORD",
@@ -419,7 +587,38 @@ func (s *S) TestSetEnvRespectsThePublicOnlyFlagKeepPrivateVariablesWhenItsTrue(c
 	c.Assert(newApp.Env, DeepEquals, expected)
 }
 
-func (s *S) TestSetEnvRespectsThePublicOnlyFlagOverwrittenAllVariablesWhenItsFalse(c *C) {
+func (s *S) TestSetEnvRespectsTheUserOnlyFlagOverwritesPrivateVariablesSetByUsers(c *C) {
+	a := App{
+		Name:  "myapp",
+		Units: []Unit{{Machine: 1}},
+		Env: map[string]bind.EnvVar{
+			"SECRET_TOKEN": {
+				Name:   "SECRET_TOKEN",
+				Value:  "abc",
+				Public: false,
+			},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	envs := []bind.EnvVar{{Name: "SECRET_TOKEN", Value: "xyz", Public: false}}
+	err = a.SetEnvsToApp(envs, true, false, "")
+	c.Assert(err, IsNil)
+	newApp := App{Name: a.Name}
+	err = newApp.Get()
+	c.Assert(err, IsNil)
+	expected := map[string]bind.EnvVar{
+		"SECRET_TOKEN": {
+			Name:   "SECRET_TOKEN",
+			Value:  "xyz",
+			Public: false,
+		},
+	}
+	c.Assert(newApp.Env, DeepEquals, expected)
+}
+
+func (s *S) TestSetEnvRespectsTheUserOnlyFlagOverwrittenAllVariablesWhenItsFalse(c *C) {
 	a := App{
 		Name: "myapp",
 		Units: []Unit{
@@ -448,7 +647,7 @@ func (s *S) TestSetEnvRespectsThePublicOnlyFlagOverwrittenAllVariablesWhenItsFal
 			Public: true,
 		},
 	}
-	err = a.SetEnvsToApp(envs, false, false)
+	err = a.SetEnvsToApp(envs, false, false, "")
 	c.Assert(err, IsNil)
 	newApp := App{Name: a.Name}
 	err = newApp.Get()
@@ -468,7 +667,7 @@ func (s *S) TestSetEnvRespectsThePublicOnlyFlagOverwrittenAllVariablesWhenItsFal
 	c.Assert(newApp.Env, DeepEquals, expected)
 }
 
-func (s *S) TestUnsetEnvRespectsThePublicOnlyFlagKeepPrivateVariablesWhenItsTrue(c *C) {
+func (s *S) TestUnsetEnvRespectsTheUserOnlyFlagKeepServiceVariablesWhenItsTrue(c *C) {
 	a := App{
 		Name: "myapp",
 		Units: []Unit{
@@ -476,9 +675,10 @@ func (s *S) TestUnsetEnvRespectsThePublicOnlyFlagKeepPrivateVariablesWhenItsTrue
 		},
 		Env: map[string]bind.EnvVar{
 			"DATABASE_HOST": {
-				Name:   "DATABASE_HOST",
-				Value:  "localhost",
-				Public: false,
+				Name:         "DATABASE_HOST",
+				Value:        "localhost",
+				Public:       false,
+				InstanceName: "mysql",
 			},
 			"DATABASE_PASSWORD": {
 				Name:   "DATABASE_PASSWORD",
@@ -490,22 +690,46 @@ func (s *S) TestUnsetEnvRespectsThePublicOnlyFlagKeepPrivateVariablesWhenItsTrue
 	err := db.Session.Apps().Insert(a)
 	c.Assert(err, IsNil)
 	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
-	err = a.UnsetEnvsFromApp([]string{"DATABASE_HOST", "DATABASE_PASSWORD"}, true, false)
+	err = a.UnsetEnvsFromApp([]string{"DATABASE_HOST", "DATABASE_PASSWORD"}, true, false, "")
 	c.Assert(err, IsNil)
 	newApp := App{Name: a.Name}
 	err = newApp.Get()
 	c.Assert(err, IsNil)
 	expected := map[string]bind.EnvVar{
 		"DATABASE_HOST": {
-			Name:   "DATABASE_HOST",
-			Value:  "localhost",
-			Public: false,
+			Name:         "DATABASE_HOST",
+			Value:        "localhost",
+			Public:       false,
+			InstanceName: "mysql",
 		},
 	}
 	c.Assert(newApp.Env, DeepEquals, expected)
 }
 
-func (s *S) TestUnsetEnvRespectsThePublicOnlyFlagUnsettingAllVariablesWhenItsFalse(c *C) {
+func (s *S) TestUnsetEnvRespectsTheUserOnlyFlagUnsettingPrivateVariablesSetByUsers(c *C) {
+	a := App{
+		Name:  "myapp",
+		Units: []Unit{{Machine: 1}},
+		Env: map[string]bind.EnvVar{
+			"SECRET_TOKEN": {
+				Name:   "SECRET_TOKEN",
+				Value:  "abc",
+				Public: false,
+			},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.UnsetEnvsFromApp([]string{"SECRET_TOKEN"}, true, false, "")
+	c.Assert(err, IsNil)
+	newApp := App{Name: a.Name}
+	err = newApp.Get()
+	c.Assert(err, IsNil)
+	c.Assert(newApp.Env, DeepEquals, map[string]bind.EnvVar{})
+}
+
+func (s *S) TestUnsetEnvRespectsTheUserOnlyFlagUnsettingAllVariablesWhenItsFalse(c *C) {
 	a := App{
 		Name: "myapp",
 		Units: []Unit{
@@ -527,7 +751,7 @@ func (s *S) TestUnsetEnvRespectsThePublicOnlyFlagUnsettingAllVariablesWhenItsFal
 	err := db.Session.Apps().Insert(a)
 	c.Assert(err, IsNil)
 	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
-	err = a.UnsetEnvsFromApp([]string{"DATABASE_HOST", "DATABASE_PASSWORD"}, false, false)
+	err = a.UnsetEnvsFromApp([]string{"DATABASE_HOST", "DATABASE_PASSWORD"}, false, false, "")
 	c.Assert(err, IsNil)
 	newApp := App{Name: a.Name}
 	err = newApp.Get()
@@ -812,6 +1036,260 @@ func (s *S) TestRestart(c *C) {
 	c.Assert(cmds, HasLen, 1)
 }
 
+func (s *S) TestRestartNotifiesWebhooks(c *C) {
+	events := make(chan webhook.Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e webhook.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer server.Close()
+	h := webhook.Hook{Team: s.team.Name, URL: server.URL, Events: []string{webhook.Restart}}
+	err := h.Create()
+	c.Assert(err, IsNil)
+	defer db.Session.Webhooks().RemoveId(h.Id)
+	defer db.Session.WebhookDeliveries().RemoveAll(bson.M{"hook": h.Id})
+	s.provisioner.PrepareOutput(nil) // loadHooks
+	s.provisioner.PrepareOutput([]byte("nothing"))
+	a := App{
+		Name:      "someApp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+	}
+	err = a.Restart(ioutil.Discard)
+	c.Assert(err, IsNil)
+	select {
+	case e := <-events:
+		c.Assert(e.Event, Equals, webhook.Restart)
+		c.Assert(e.App, Equals, "someApp")
+		c.Assert(e.Success, Equals, true)
+	case <-time.After(2 * time.Second):
+		c.Fatal("timed out waiting for the webhook to be notified")
+	}
+}
+
+func (s *S) TestRollingRestart(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	s.provisioner.PrepareOutput([]byte("restarted"))
+	s.provisioner.PrepareOutput([]byte("restarted"))
+	a := App{
+		Name:      "someApp",
+		Framework: "django",
+		State:     string(provision.StatusStarted),
+		hooks:     &conf{Healthcheck: &healthcheck{Path: "/"}},
+	}
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	units, err := s.provisioner.AddUnits(&a, 3, "web")
+	c.Assert(err, IsNil)
+	for _, u := range units {
+		a.AddUnit(&Unit{Name: u.Name, Machine: u.Machine, Ip: server.URL[len("http://"):], State: string(u.Status)})
+	}
+	var b bytes.Buffer
+	err = a.RollingRestart(&b, 2)
+	c.Assert(err, IsNil)
+	result := strings.Replace(b.String(), "\n", "#", -1)
+	c.Assert(result, Matches, ".*# ---> Restarting batch 1 of 2 \\(someApp/0, someApp/1\\)#.*")
+	c.Assert(result, Matches, ".*# ---> Restarting batch 2 of 2 \\(someApp/2\\)#.*")
+	cmds := s.provisioner.GetCmds("/var/lib/tsuru/hooks/restart", &a)
+	c.Assert(cmds, HasLen, 2)
+	c.Assert(a.ProvisionUnits(), HasLen, 3)
+}
+
+func (s *S) TestRollingRestartWaitsForTheUnitsToRestart(c *C) {
+	old := rollingInterval
+	rollingInterval = 10 * time.Millisecond
+	defer func() { rollingInterval = old }()
+	s.provisioner.PrepareOutput([]byte("restarted"))
+	a := App{
+		Name:      "someApp",
+		Framework: "django",
+		State:     string(provision.StatusStarted),
+		hooks:     &conf{},
+	}
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	units, err := s.provisioner.AddUnits(&a, 2, "web")
+	c.Assert(err, IsNil)
+	for _, u := range units {
+		a.AddUnit(&Unit{Name: u.Name, Machine: u.Machine, State: string(u.Status)})
+	}
+	s.provisioner.PrepareStatus("someApp/0", provision.StatusStarted, provision.StatusPending, provision.StatusPending)
+	s.provisioner.PrepareStatus("someApp/1", provision.StatusDown)
+	var b bytes.Buffer
+	err = a.RollingRestart(&b, 2)
+	c.Assert(err, IsNil)
+	units, err = s.provisioner.CollectStatus()
+	c.Assert(err, IsNil)
+	for _, u := range units {
+		c.Assert(u.Status, Equals, provision.StatusStarted)
+	}
+}
+
+func (s *S) TestRollingRestartAbortsIfABatchFails(c *C) {
+	s.provisioner.PrepareFailure("ExecuteCommand", errors.New("exit status 1"))
+	s.provisioner.PrepareOutput([]byte("failed to restart"))
+	a := App{
+		Name:      "someApp",
+		Framework: "django",
+		State:     string(provision.StatusStarted),
+		hooks:     &conf{},
+	}
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	units, err := s.provisioner.AddUnits(&a, 2, "web")
+	c.Assert(err, IsNil)
+	for _, u := range units {
+		a.AddUnit(&Unit{Name: u.Name, Machine: u.Machine, State: string(u.Status)})
+	}
+	var b bytes.Buffer
+	err = a.RollingRestart(&b, 1)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Rolling restart aborted in batch 1 of 2: exit status 1")
+	cmds := s.provisioner.GetCmds("/var/lib/tsuru/hooks/restart", &a)
+	c.Assert(cmds, HasLen, 1)
+}
+
+func (s *S) TestRollingRestartAbortsIfUnitsAreNotStarted(c *C) {
+	old := rollingTimeout
+	rollingTimeout = 1e6
+	defer func() { rollingTimeout = old }()
+	s.provisioner.PrepareOutput([]byte("restarted"))
+	a := App{
+		Name:      "someApp",
+		Framework: "django",
+		State:     string(provision.StatusStarted),
+		hooks:     &conf{},
+	}
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	units, err := s.provisioner.AddUnits(&a, 2, "web")
+	c.Assert(err, IsNil)
+	for _, u := range units {
+		a.AddUnit(&Unit{Name: u.Name, Machine: u.Machine, State: string(u.Status)})
+	}
+	err = s.provisioner.Stop(&a)
+	c.Assert(err, IsNil)
+	var b bytes.Buffer
+	err = a.RollingRestart(&b, 1)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `Rolling restart aborted in batch 1 of 2: timed out waiting for units to be "started".`)
+	cmds := s.provisioner.GetCmds("/var/lib/tsuru/hooks/restart", &a)
+	c.Assert(cmds, HasLen, 1)
+}
+
+func (s *S) TestRollingRestartRunsHooksOnce(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	for i := 0; i < 5; i++ {
+		s.provisioner.PrepareOutput([]byte("ok"))
+	}
+	a := App{
+		Name:      "someApp",
+		Framework: "django",
+		State:     string(provision.StatusStarted),
+		hooks: &conf{
+			PreRestart:  []string{"pre.sh"},
+			PosRestart:  []string{"pos.sh"},
+			Healthcheck: &healthcheck{Path: "/"},
+		},
+	}
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	units, err := s.provisioner.AddUnits(&a, 3, "web")
+	c.Assert(err, IsNil)
+	for _, u := range units {
+		a.AddUnit(&Unit{Name: u.Name, Machine: u.Machine, Ip: server.URL[len("http://"):], State: string(u.Status)})
+	}
+	var b bytes.Buffer
+	err = a.RollingRestart(&b, 1)
+	c.Assert(err, IsNil)
+	c.Assert(s.provisioner.GetCmds("/var/lib/tsuru/hooks/restart", &a), HasLen, 3)
+	c.Assert(strings.Count(b.String(), " ---> Running pre-restart\n"), Equals, 1)
+	c.Assert(strings.Count(b.String(), " ---> Running pos-restart\n"), Equals, 1)
+	result := strings.Replace(b.String(), "\n", "#", -1)
+	c.Assert(result, Matches, ".*Running pre-restart#.*Restarting batch 1 of 3.*Restarting batch 3 of 3.*Running pos-restart#.*")
+}
+
+func (s *S) TestWaitUnits(c *C) {
+	old := rollingInterval
+	rollingInterval = 10 * time.Millisecond
+	defer func() { rollingInterval = old }()
+	a := App{Name: "someApp", Framework: "django", hooks: &conf{}}
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	units, err := s.provisioner.AddUnits(&a, 2, "web")
+	c.Assert(err, IsNil)
+	s.provisioner.PrepareStatus(units[0].Name, provision.StatusPending)
+	s.provisioner.PrepareStatus(units[1].Name, provision.StatusStarted, provision.StatusDown, provision.StatusPending)
+	err = a.waitUnits([]Unit{{Name: units[0].Name}, {Name: units[1].Name}})
+	c.Assert(err, IsNil)
+	units, err = s.provisioner.CollectStatus()
+	c.Assert(err, IsNil)
+	c.Assert(units[1].Status, Equals, provision.StatusStarted)
+}
+
+func (s *S) TestWaitUnitsTimesOutIfAUnitIsNotRestarted(c *C) {
+	old, oldInterval := rollingTimeout, rollingInterval
+	rollingTimeout, rollingInterval = 50*time.Millisecond, 10*time.Millisecond
+	defer func() { rollingTimeout, rollingInterval = old, oldInterval }()
+	a := App{Name: "someApp", Framework: "django", hooks: &conf{}}
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	units, err := s.provisioner.AddUnits(&a, 1, "web")
+	c.Assert(err, IsNil)
+	err = a.waitUnits([]Unit{{Name: units[0].Name}})
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `timed out waiting for units to be "started".`)
+}
+
+func (s *S) TestWaitUnitsWithHealthcheck(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	a := App{Name: "someApp", Framework: "django", hooks: &conf{Healthcheck: &healthcheck{Path: "/status"}}}
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	units, err := s.provisioner.AddUnits(&a, 1, "web")
+	c.Assert(err, IsNil)
+	err = a.waitUnits([]Unit{{Name: units[0].Name, Ip: server.URL[len("http://"):]}})
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TestWaitUnitsTimesOutIfAUnitIsNotStarted(c *C) {
+	old, oldInterval := rollingTimeout, rollingInterval
+	rollingTimeout, rollingInterval = 50*time.Millisecond, 10*time.Millisecond
+	defer func() { rollingTimeout, rollingInterval = old, oldInterval }()
+	a := App{Name: "someApp", Framework: "django"}
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	_, err := s.provisioner.AddUnits(&a, 1, "web")
+	c.Assert(err, IsNil)
+	stopped := App{Name: "otherApp", Framework: "django"}
+	s.provisioner.Provision(&stopped)
+	defer s.provisioner.Destroy(&stopped)
+	_, err = s.provisioner.AddUnits(&stopped, 1, "web")
+	c.Assert(err, IsNil)
+	err = s.provisioner.Stop(&stopped)
+	c.Assert(err, IsNil)
+	err = a.waitUnits([]Unit{{Name: "someApp/0"}, {Name: "otherApp/0"}})
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `timed out waiting for units to be "started".`)
+}
+
+func (s *S) TestRollingRestartInvalidBatchSize(c *C) {
+	a := App{Name: "someApp", State: string(provision.StatusStarted)}
+	var b bytes.Buffer
+	err := a.RollingRestart(&b, 0)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "The batch size must be greater than zero.")
+}
+
 func (s *S) TestRestartRunsPreRestartHook(c *C) {
 	s.provisioner.PrepareOutput([]byte("pre-restart-by-restart"))
 	s.provisioner.PrepareOutput([]byte("restart"))
@@ -848,6 +1326,93 @@ func (s *S) TestRestartRunsPosRestartHook(c *C) {
 	c.Assert(content, Matches, "^.*### ---> Running pos-restart###.*$")
 }
 
+func (s *S) TestStop(c *C) {
+	a := App{
+		Name:      "wonderwall",
+		Framework: "python",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+		Units:     []Unit{{Name: "wonderwall/0", State: string(provision.StatusStarted)}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	var buf bytes.Buffer
+	err = a.Stop(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "\n ---> Stopping your app\n")
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.State, Equals, string(provision.StatusDown))
+	c.Assert(a.Stopped, Equals, true)
+	c.Assert(a.Units[0].State, Equals, string(provision.StatusDown))
+}
+
+func (s *S) TestStopFailureInProvisioner(c *C) {
+	s.provisioner.PrepareFailure("Stop", errors.New("Failed to stop."))
+	a := App{
+		Name:      "wonderwall",
+		Framework: "python",
+		State:     string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	var buf bytes.Buffer
+	err = a.Stop(&buf)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Failed to stop.")
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.State, Equals, string(provision.StatusStarted))
+}
+
+func (s *S) TestStart(c *C) {
+	a := App{
+		Name:      "wonderwall",
+		Framework: "python",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusDown),
+		Stopped:   true,
+		Units:     []Unit{{Name: "wonderwall/0", State: string(provision.StatusDown)}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	var buf bytes.Buffer
+	err = a.Start(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "\n ---> Starting your app\n")
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.State, Equals, string(provision.StatusStarted))
+	c.Assert(a.Stopped, Equals, false)
+	c.Assert(a.Units[0].State, Equals, string(provision.StatusStarted))
+}
+
+func (s *S) TestStartFailureInProvisioner(c *C) {
+	s.provisioner.PrepareFailure("Start", errors.New("Failed to start."))
+	a := App{
+		Name:      "wonderwall",
+		Framework: "python",
+		State:     string(provision.StatusDown),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	var buf bytes.Buffer
+	err = a.Start(&buf)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Failed to start.")
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.State, Equals, string(provision.StatusDown))
+}
+
 func (s *S) TestLog(c *C) {
 	a := App{Name: "newApp"}
 	err := db.Session.Apps().Insert(a)
@@ -855,11 +1420,12 @@ func (s *S) TestLog(c *C) {
 	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
 	err = a.Log("last log msg", "tsuru")
 	c.Assert(err, IsNil)
-	var instance App
-	err = db.Session.Apps().Find(bson.M{"name": a.Name}).One(&instance)
-	logLen := len(instance.Logs)
-	c.Assert(instance.Logs[logLen-1].Message, Equals, "last log msg")
-	c.Assert(instance.Logs[logLen-1].Source, Equals, "tsuru")
+	var logs []Applog
+	err = db.Session.Logs().Find(bson.M{"appname": a.Name}).All(&logs)
+	c.Assert(err, IsNil)
+	logLen := len(logs)
+	c.Assert(logs[logLen-1].Message, Equals, "last log msg")
+	c.Assert(logs[logLen-1].Source, Equals, "tsuru")
 }
 
 func (s *S) TestLogShouldAddOneRecordByLine(c *C) {
@@ -869,11 +1435,12 @@ func (s *S) TestLogShouldAddOneRecordByLine(c *C) {
 	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
 	err = a.Log("last log msg\nfirst log", "source")
 	c.Assert(err, IsNil)
-	instance := App{}
-	err = db.Session.Apps().Find(bson.M{"name": a.Name}).One(&instance)
-	logLen := len(instance.Logs)
-	c.Assert(instance.Logs[logLen-2].Message, Equals, "last log msg")
-	c.Assert(instance.Logs[logLen-1].Message, Equals, "first log")
+	var logs []Applog
+	err = db.Session.Logs().Find(bson.M{"appname": a.Name}).All(&logs)
+	c.Assert(err, IsNil)
+	logLen := len(logs)
+	c.Assert(logs[logLen-2].Message, Equals, "last log msg")
+	c.Assert(logs[logLen-1].Message, Equals, "first log")
 }
 
 func (s *S) TestLogShouldNotLogBlankLines(c *C) {
@@ -887,10 +1454,11 @@ func (s *S) TestLogShouldNotLogBlankLines(c *C) {
 	c.Assert(err, IsNil)
 	err = a.Log("", "")
 	c.Assert(err, IsNil)
-	var instance App
-	err = db.Session.Apps().Find(bson.M{"name": a.Name}).One(&instance)
-	logLen := len(instance.Logs)
-	c.Assert(instance.Logs[logLen-1].Message, Not(Equals), "")
+	var logs []Applog
+	err = db.Session.Logs().Find(bson.M{"appname": a.Name}).All(&logs)
+	c.Assert(err, IsNil)
+	logLen := len(logs)
+	c.Assert(logs[logLen-1].Message, Not(Equals), "")
 }
 
 func (s *S) TestGetTeams(c *C) {
@@ -932,6 +1500,18 @@ func (s *S) TestAppMarshalJson(c *C) {
 	expected["Repository"] = repository.GetUrl(app.Name)
 	expected["Teams"] = []interface{}{"team1"}
 	expected["Units"] = interface{}(nil)
+	expected["Maintenance"] = map[string]interface{}{"Enabled": false, "User": "", "Since": "0001-01-01T00:00:00Z"}
+	expected["Healing"] = map[string]interface{}{"Enabled": false, "After": float64(0)}
+	expected["Autoscale"] = map[string]interface{}{
+		"Enabled":   false,
+		"Process":   "",
+		"MinUnits":  float64(0),
+		"MaxUnits":  float64(0),
+		"Metric":    "",
+		"Threshold": float64(0),
+		"Cooldown":  float64(0),
+		"LastScale": "0001-01-01T00:00:00Z",
+	}
 	data, err := app.MarshalJSON()
 	c.Assert(err, IsNil)
 	result := make(map[string]interface{})
@@ -940,6 +1520,40 @@ func (s *S) TestAppMarshalJson(c *C) {
 	c.Assert(result, DeepEquals, expected)
 }
 
+func (s *S) TestAppMarshalJsonReportsTheRefOfUnits(c *C) {
+	app := App{
+		Name:  "Name",
+		Units: []Unit{{Name: "i-00000zz8", Ref: "v1.0"}},
+	}
+	data, err := app.MarshalJSON()
+	c.Assert(err, IsNil)
+	var result struct {
+		Units []Unit
+	}
+	err = json.Unmarshal(data, &result)
+	c.Assert(err, IsNil)
+	c.Assert(result.Units, HasLen, 1)
+	c.Assert(result.Units[0].Ref, Equals, "v1.0")
+}
+
+func (s *S) TestAppMarshalJsonReportsTheMaintenanceMode(c *C) {
+	since := time.Date(2013, 2, 1, 10, 30, 0, 0, time.UTC)
+	app := App{
+		Name:        "Name",
+		Maintenance: Maintenance{Enabled: true, User: "admin@tsuru.io", Since: since},
+	}
+	data, err := app.MarshalJSON()
+	c.Assert(err, IsNil)
+	var result struct {
+		Maintenance Maintenance
+	}
+	err = json.Unmarshal(data, &result)
+	c.Assert(err, IsNil)
+	c.Assert(result.Maintenance.Enabled, Equals, true)
+	c.Assert(result.Maintenance.User, Equals, "admin@tsuru.io")
+	c.Assert(result.Maintenance.Since.Equal(since), Equals, true)
+}
+
 func (s *S) TestRun(c *C) {
 	s.provisioner.PrepareOutput([]byte("a lot of files"))
 	app := App{Name: "myapp", State: string(provision.StatusStarted)}
@@ -965,6 +1579,78 @@ func (s *S) TestRunWithoutEnv(c *C) {
 	c.Assert(cmds, HasLen, 1)
 }
 
+func (s *S) TestRunWithTimeout(c *C) {
+	s.provisioner.PrepareOutput([]byte("migrated"))
+	app := App{Name: "myapp", State: string(provision.StatusStarted)}
+	var buf bytes.Buffer
+	err := app.RunWithTimeout("python manage.py migrate", &buf, 1500*time.Millisecond)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "migrated")
+	inner := "[ -f /home/application/apprc ] && source /home/application/apprc;"
+	inner += " [ -d /home/application/current ] && cd /home/application/current;"
+	inner += " python manage.py migrate"
+	cmds := s.provisioner.GetCmds("timeout 2 /bin/bash -c "+shellEscape(inner), &app)
+	c.Assert(cmds, HasLen, 1)
+}
+
+func (s *S) TestRunInUnit(c *C) {
+	s.provisioner.PrepareOutput([]byte("migrated"))
+	app := App{
+		Name:  "myapp",
+		State: string(provision.StatusStarted),
+		Units: []Unit{
+			{Name: "myapp/0", State: string(provision.StatusPending)},
+			{Name: "myapp/1", State: string(provision.StatusStarted)},
+		},
+	}
+	var buf bytes.Buffer
+	err := app.RunInUnit("python manage.py migrate", "myapp/1", &buf, 0)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "migrated")
+	c.Assert(app.batch, IsNil)
+	cmds := s.provisioner.GetCmds("", &app)
+	c.Assert(cmds, HasLen, 1)
+	c.Assert(cmds[0].Cmd, Matches, `.*python manage\.py migrate$`)
+}
+
+func (s *S) TestStartedUnit(c *C) {
+	app := App{
+		Name: "myapp",
+		Units: []Unit{
+			{Name: "myapp/0", State: string(provision.StatusPending)},
+			{Name: "myapp/1", State: string(provision.StatusStarted)},
+		},
+	}
+	unit, err := app.startedUnit("")
+	c.Assert(err, IsNil)
+	c.Assert(unit.Name, Equals, "myapp/1")
+	unit, err = app.startedUnit("myapp/1")
+	c.Assert(err, IsNil)
+	c.Assert(unit.Name, Equals, "myapp/1")
+}
+
+func (s *S) TestStartedUnitErrors(c *C) {
+	app := App{
+		Name:  "myapp",
+		Units: []Unit{{Name: "myapp/0", State: string(provision.StatusPending)}},
+	}
+	var tests = []struct {
+		name    string
+		message string
+	}{
+		{"", "The app myapp has no started units."},
+		{"myapp/0", `The unit myapp/0 is "pending", it must be started to run commands.`},
+		{"myapp/9", "Unit myapp/9 not found in the app myapp."},
+	}
+	for _, t := range tests {
+		_, err := app.startedUnit(t.name)
+		c.Assert(err, NotNil)
+		e, ok := err.(*ValidationError)
+		c.Assert(ok, Equals, true)
+		c.Check(e.Message, Equals, t.message)
+	}
+}
+
 func (s *S) TestCommand(c *C) {
 	s.provisioner.PrepareOutput([]byte("lots of files"))
 	app := App{Name: "myapp", State: string(provision.StatusStarted)}
@@ -998,12 +1684,45 @@ func (s *S) TestSerializeEnvVars(c *C) {
 	c.Assert(err, IsNil)
 	cmds := s.provisioner.GetCmds("", &app)
 	c.Assert(cmds, HasLen, 1)
-	cmdRegexp := `^cat > /home/application/apprc <<END # generated by tsuru .*`
-	cmdRegexp += ` export http_proxy="http://theirproxy.com:3128/" END $`
+	cmdRegexp := `^cat > /home/application/apprc <<'END' # generated by tsuru .*`
+	cmdRegexp += ` export http_proxy='http://theirproxy.com:3128/' END $`
 	cmd := strings.Replace(cmds[0].Cmd, "\n", " ", -1)
 	c.Assert(cmd, Matches, cmdRegexp)
 }
 
+func (s *S) TestSerializeEnvVarsEscapesValues(c *C) {
+	s.provisioner.PrepareOutput([]byte("exported"))
+	app := App{
+		Name:  "time",
+		State: string(provision.StatusStarted),
+		Env: map[string]bind.EnvVar{
+			"SECRET": {Name: "SECRET", Value: "$HOME 'quoted' \"double\" `ls`", Public: true},
+		},
+	}
+	err := app.SerializeEnvVars()
+	c.Assert(err, IsNil)
+	cmds := s.provisioner.GetCmds("", &app)
+	c.Assert(cmds, HasLen, 1)
+	expected := `export SECRET='$HOME '\''quoted'\'' "double" ` + "`ls`'\n"
+	c.Assert(strings.Contains(cmds[0].Cmd, expected), Equals, true)
+}
+
+func (s *S) TestShellEscape(c *C) {
+	var tests = []struct {
+		value    string
+		expected string
+	}{
+		{"", "''"},
+		{"simple", "'simple'"},
+		{"with spaces", "'with spaces'"},
+		{"it's", `'it'\''s'`},
+		{"$PATH:`pwd`", "'$PATH:`pwd`'"},
+	}
+	for _, t := range tests {
+		c.Check(shellEscape(t.value), Equals, t.expected)
+	}
+}
+
 func (s *S) TestSerializeEnvVarsErrorWithoutOutput(c *C) {
 	app := App{
 		Name: "intheend",
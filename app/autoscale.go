@@ -0,0 +1,256 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"github.com/globocom/tsuru/provision"
+	"labix.org/v2/mgo/bson"
+	"time"
+)
+
+// Metrics that autoscale policies may be based on. CPU is measured in
+// percentage, memory in megabytes and network in kilobytes received per
+// second, which follows the request rate of the app.
+const (
+	MetricCPU     = "cpu"
+	MetricMemory  = "memory"
+	MetricNetwork = "network"
+)
+
+// DefaultCooldown is the minimum interval between two scaling decisions of an
+// app, unless its autoscale policy says otherwise.
+const DefaultCooldown = 5 * time.Minute
+
+// autoscaleWindow is how old the metrics samples considered by the autoscale
+// may be.
+const autoscaleWindow = 5 * time.Minute
+
+// AutoscalePolicy is the autoscale policy of an app. When it's enabled, the
+// collector keeps between MinUnits and MaxUnits units running the process
+// Process, adding a unit when the average of Metric in the started units is
+// above Threshold, and removing a unit when the average would stay below
+// Threshold without it. Two scaling decisions are at least Cooldown apart.
+// Stopped apps and apps in maintenance mode are not scaled.
+type AutoscalePolicy struct {
+	Enabled   bool
+	Process   string
+	MinUnits  uint
+	MaxUnits  uint
+	Metric    string
+	Threshold float64
+	Cooldown  time.Duration
+	LastScale time.Time
+}
+
+// ProcessType returns the process type of the units scaled by the policy.
+func (p AutoscalePolicy) ProcessType() string {
+	if p.Process != "" {
+		return p.Process
+	}
+	return provision.DefaultProcessType
+}
+
+// CooldownPeriod returns the minimum interval between two scaling decisions.
+func (p AutoscalePolicy) CooldownPeriod() time.Duration {
+	if p.Cooldown > 0 {
+		return p.Cooldown
+	}
+	return DefaultCooldown
+}
+
+func (p AutoscalePolicy) String() string {
+	return fmt.Sprintf("%d to %d %s units, keeping the average %s under %s", p.MinUnits, p.MaxUnits,
+		p.ProcessType(), p.Metric, formatMetric(p.Metric, p.Threshold))
+}
+
+// ScaleDecision is a change in the number of units of an app, decided by its
+// autoscale policy.
+type ScaleDecision struct {
+	Process string
+	From    int
+	To      int
+	Reason  string
+}
+
+// SetAutoscalePolicy changes the autoscale policy of the app, on behalf of the
+// given user, and logs the change in the app log.
+func (a *App) SetAutoscalePolicy(policy AutoscalePolicy, user string) error {
+	if policy.Enabled {
+		if err := a.validateAutoscale(policy); err != nil {
+			return err
+		}
+	}
+	policy.LastScale = a.Autoscale.LastScale
+	a.Autoscale = policy
+	if err := a.update(bson.M{"$set": bson.M{"autoscale": a.Autoscale}}); err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("Autoscale turned off by %s", user)
+	if policy.Enabled {
+		msg = fmt.Sprintf("Autoscale turned on by %s: %s", user, policy)
+	}
+	return a.Log(msg, "tsuru")
+}
+
+func (a *App) validateAutoscale(p AutoscalePolicy) error {
+	switch {
+	case p.MinUnits == 0:
+		return &ValidationError{Message: "The minimum number of units must be at least 1."}
+	case p.MaxUnits < p.MinUnits:
+		return &ValidationError{Message: "The maximum number of units can't be less than the minimum."}
+	case p.Metric != MetricCPU && p.Metric != MetricMemory && p.Metric != MetricNetwork:
+		msg := fmt.Sprintf("Invalid metric %q, it must be %s, %s or %s.", p.Metric, MetricCPU, MetricMemory, MetricNetwork)
+		return &ValidationError{Message: msg}
+	case p.Threshold <= 0:
+		return &ValidationError{Message: "The threshold must be greater than 0."}
+	case p.Cooldown < 0:
+		return &ValidationError{Message: "The cooldown can't be negative."}
+	}
+	return a.validateProcessType(p.ProcessType())
+}
+
+// EvaluateAutoscale evaluates the autoscale policy of the app against the
+// metrics samples of its units, at now. It returns nil when the units should
+// not change: when autoscale is disabled, the app is stopped or in maintenance
+// mode, in the cooldown period, or when the average usage is within the
+// threshold.
+//
+// The number of units is brought within the limits of the policy regardless of
+// the metrics.
+func (a *App) EvaluateAutoscale(now time.Time) (*ScaleDecision, error) {
+	p := a.Autoscale
+	if !p.Enabled || a.Stopped || a.Maintenance.Enabled || now.Sub(p.LastScale) < p.CooldownPeriod() {
+		return nil, nil
+	}
+	process := p.ProcessType()
+	units := a.processUnits(process)
+	n := len(units)
+	decision := ScaleDecision{Process: process, From: n}
+	if n < int(p.MinUnits) {
+		decision.To = int(p.MinUnits)
+		decision.Reason = fmt.Sprintf("below the minimum of %d units", p.MinUnits)
+		return &decision, nil
+	} else if n > int(p.MaxUnits) {
+		decision.To = int(p.MaxUnits)
+		decision.Reason = fmt.Sprintf("above the maximum of %d units", p.MaxUnits)
+		return &decision, nil
+	}
+	metrics, err := a.Metrics(now.Add(-autoscaleWindow))
+	if err != nil {
+		return nil, err
+	}
+	avg, sampled := averageUsage(units, metrics, p.Metric)
+	if sampled == 0 {
+		return nil, nil
+	}
+	usage, threshold := formatMetric(p.Metric, avg), formatMetric(p.Metric, p.Threshold)
+	if avg > p.Threshold && n < int(p.MaxUnits) {
+		decision.To = n + 1
+		decision.Reason = fmt.Sprintf("average %s of %s is above the threshold of %s", p.Metric, usage, threshold)
+		return &decision, nil
+	}
+	if n > int(p.MinUnits) && sampled > 1 && avg*float64(sampled)/float64(sampled-1) < p.Threshold {
+		decision.To = n - 1
+		decision.Reason = fmt.Sprintf("average %s of %s would stay under the threshold of %s with one unit less",
+			p.Metric, usage, threshold)
+		return &decision, nil
+	}
+	return nil, nil
+}
+
+// Scale applies the scaling decision to the app, adding or removing units, and
+// logs it in the app log. Units that are not started are removed first. The
+// cooldown period starts at now, even if the decision fails.
+func (a *App) Scale(d *ScaleDecision, now time.Time) error {
+	a.Autoscale.LastScale = now
+	if err := a.update(bson.M{"$set": bson.M{"autoscale.lastscale": now}}); err != nil {
+		return err
+	}
+	a.Log(fmt.Sprintf("Autoscaling the %s units from %d to %d: %s.", d.Process, d.From, d.To, d.Reason), "tsuru")
+	var err error
+	if d.To > d.From {
+		err = a.AddUnits(uint(d.To-d.From), d.Process)
+	} else if d.To < d.From {
+		for _, u := range a.unitsToRemove(d.From-d.To, d.Process) {
+			if err = a.RemoveUnit(u.Name); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		a.Log(fmt.Sprintf("Failed to autoscale the %s units: %s", d.Process, err), "tsuru")
+	}
+	return err
+}
+
+// unitsToRemove returns n units running the given process type to be removed
+// when scaling the app down: units that are not started go first, followed by
+// the oldest ones.
+func (a *App) unitsToRemove(n int, process string) []Unit {
+	var started, others []Unit
+	for _, u := range a.processUnits(process) {
+		if u.State == provision.StatusStarted.String() {
+			started = append(started, u)
+		} else {
+			others = append(others, u)
+		}
+	}
+	units := append(others, started...)
+	if n < len(units) {
+		units = units[:n]
+	}
+	return units
+}
+
+// averageUsage returns the average of the given metric in the started units,
+// averaging the samples of each unit first, and the number of units sampled.
+func averageUsage(units []Unit, metrics []UnitMetrics, metric string) (float64, int) {
+	started := make(map[string]bool)
+	for _, u := range units {
+		if u.State == provision.StatusStarted.String() {
+			started[u.Name] = true
+		}
+	}
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	for _, m := range metrics {
+		if !started[m.Unit] {
+			continue
+		}
+		sums[m.Unit] += metricValue(m, metric)
+		counts[m.Unit]++
+	}
+	if len(sums) == 0 {
+		return 0, 0
+	}
+	var total float64
+	for unit, sum := range sums {
+		total += sum / float64(counts[unit])
+	}
+	return total / float64(len(sums)), len(sums)
+}
+
+// metricValue returns the value of the metric in the sample, in the unit used
+// by autoscale policies.
+func metricValue(m UnitMetrics, metric string) float64 {
+	switch metric {
+	case MetricMemory:
+		return float64(m.Memory) / (1024 * 1024)
+	case MetricNetwork:
+		return float64(m.NetworkIn) / 1024
+	}
+	return m.CPU
+}
+
+func formatMetric(metric string, value float64) string {
+	switch metric {
+	case MetricMemory:
+		return fmt.Sprintf("%.1f MB", value)
+	case MetricNetwork:
+		return fmt.Sprintf("%.1f KB/s", value)
+	}
+	return fmt.Sprintf("%.1f%%", value)
+}
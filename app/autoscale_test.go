@@ -0,0 +1,301 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/provision"
+	"github.com/globocom/tsuru/testing"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) TestAutoscalePolicyDefaults(c *C) {
+	c.Assert(AutoscalePolicy{}.ProcessType(), Equals, provision.DefaultProcessType)
+	c.Assert(AutoscalePolicy{Process: "worker"}.ProcessType(), Equals, "worker")
+	c.Assert(AutoscalePolicy{}.CooldownPeriod(), Equals, DefaultCooldown)
+	c.Assert(AutoscalePolicy{Cooldown: time.Minute}.CooldownPeriod(), Equals, time.Minute)
+}
+
+func (s *S) TestSetAutoscalePolicy(c *C) {
+	lastScale := time.Now().Add(-time.Hour).Round(time.Second)
+	a := App{Name: "elastic", Autoscale: AutoscalePolicy{LastScale: lastScale}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	policy := AutoscalePolicy{Enabled: true, MinUnits: 1, MaxUnits: 4, Metric: MetricCPU, Threshold: 70}
+	err = a.SetAutoscalePolicy(policy, "ops@tsuru.io")
+	c.Assert(err, IsNil)
+	var stored App
+	err = db.Session.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.Autoscale.Enabled, Equals, true)
+	c.Assert(stored.Autoscale.MaxUnits, Equals, uint(4))
+	c.Assert(stored.Autoscale.LastScale.Equal(lastScale), Equals, true)
+	var l Applog
+	err = db.Session.Logs().Find(bson.M{"appname": a.Name}).One(&l)
+	c.Assert(err, IsNil)
+	c.Assert(l.Message, Equals, "Autoscale turned on by ops@tsuru.io: 1 to 4 web units, keeping the average cpu under 70.0%")
+}
+
+func (s *S) TestSetAutoscalePolicyInvalid(c *C) {
+	a := App{Name: "elastic"}
+	policies := []AutoscalePolicy{
+		{Enabled: true, MinUnits: 0, MaxUnits: 4, Metric: MetricCPU, Threshold: 70},
+		{Enabled: true, MinUnits: 4, MaxUnits: 2, Metric: MetricCPU, Threshold: 70},
+		{Enabled: true, MinUnits: 1, MaxUnits: 4, Metric: "disk", Threshold: 70},
+		{Enabled: true, MinUnits: 1, MaxUnits: 4, Metric: MetricMemory, Threshold: 0},
+		{Enabled: true, MinUnits: 1, MaxUnits: 4, Metric: MetricNetwork, Threshold: 512, Cooldown: -time.Minute},
+	}
+	for _, p := range policies {
+		err := a.SetAutoscalePolicy(p, "ops@tsuru.io")
+		c.Check(err, FitsTypeOf, &ValidationError{}, Commentf("%#v", p))
+	}
+}
+
+func (s *S) TestEvaluateAutoscale(c *C) {
+	now := time.Now()
+	a := App{
+		Name: "elastic",
+		Autoscale: AutoscalePolicy{
+			Enabled:   true,
+			MinUnits:  1,
+			MaxUnits:  3,
+			Metric:    MetricCPU,
+			Threshold: 60,
+		},
+		Units: []Unit{
+			{Name: "elastic/0", State: "started"},
+			{Name: "elastic/1", State: "started"},
+			{Name: "elastic/2", State: "pending", ProcessType: "worker"},
+		},
+	}
+	defer db.Session.Metrics().RemoveAll(bson.M{"app": a.Name})
+	var tests = []struct {
+		cpu      []float64
+		to       int
+		decision bool
+	}{
+		{[]float64{90, 70, 80, 80}, 3, true},
+		{[]float64{50, 50, 40, 40}, 0, false},
+		{[]float64{20, 10, 30, 20}, 1, true},
+		{nil, 0, false},
+	}
+	for _, t := range tests {
+		db.Session.Metrics().RemoveAll(bson.M{"app": a.Name})
+		for i, cpu := range t.cpu {
+			unit := a.Units[i%2].Name
+			err := db.Session.Metrics().Insert(UnitMetrics{App: a.Name, Unit: unit, Date: now.Add(-time.Minute), CPU: cpu})
+			c.Assert(err, IsNil)
+		}
+		d, err := a.EvaluateAutoscale(now)
+		c.Assert(err, IsNil)
+		if !t.decision {
+			c.Check(d, IsNil, Commentf("%v", t.cpu))
+			continue
+		}
+		c.Assert(d, NotNil, Commentf("%v", t.cpu))
+		c.Check(d.Process, Equals, "web")
+		c.Check(d.From, Equals, 2)
+		c.Check(d.To, Equals, t.to, Commentf("%v", t.cpu))
+	}
+}
+
+func (s *S) TestEvaluateAutoscaleProjectsOnTheSampledUnits(c *C) {
+	now := time.Now()
+	a := App{
+		Name:      "elastic",
+		Autoscale: AutoscalePolicy{Enabled: true, MinUnits: 1, MaxUnits: 3, Metric: MetricCPU, Threshold: 60},
+		Units: []Unit{
+			{Name: "elastic/0", State: "started"},
+			{Name: "elastic/1", State: "started"},
+			{Name: "elastic/2", State: "pending"},
+		},
+	}
+	samples := []interface{}{
+		UnitMetrics{App: a.Name, Unit: "elastic/0", Date: now.Add(-time.Minute), CPU: 35},
+		UnitMetrics{App: a.Name, Unit: "elastic/1", Date: now.Add(-time.Minute), CPU: 35},
+	}
+	err := db.Session.Metrics().Insert(samples...)
+	c.Assert(err, IsNil)
+	defer db.Session.Metrics().RemoveAll(bson.M{"app": a.Name})
+	d, err := a.EvaluateAutoscale(now)
+	c.Assert(err, IsNil)
+	c.Assert(d, IsNil)
+}
+
+func (s *S) TestEvaluateAutoscaleSkipsStoppedAppsAndAppsInMaintenance(c *C) {
+	now := time.Now()
+	a := App{
+		Name:      "elastic",
+		Autoscale: AutoscalePolicy{Enabled: true, MinUnits: 2, MaxUnits: 3, Metric: MetricCPU, Threshold: 60},
+		Units:     []Unit{{Name: "elastic/0", State: "started"}},
+		Stopped:   true,
+	}
+	d, err := a.EvaluateAutoscale(now)
+	c.Assert(err, IsNil)
+	c.Assert(d, IsNil)
+	a.Stopped = false
+	a.Maintenance = Maintenance{Enabled: true, User: "admin@tsuru.io", Since: now}
+	d, err = a.EvaluateAutoscale(now)
+	c.Assert(err, IsNil)
+	c.Assert(d, IsNil)
+}
+
+func (s *S) TestEvaluateAutoscaleReason(c *C) {
+	now := time.Now()
+	a := App{
+		Name:      "elastic",
+		Autoscale: AutoscalePolicy{Enabled: true, MinUnits: 1, MaxUnits: 3, Metric: MetricMemory, Threshold: 256},
+		Units:     []Unit{{Name: "elastic/0", State: "started"}},
+	}
+	sample := UnitMetrics{App: a.Name, Unit: "elastic/0", Date: now.Add(-time.Minute), Memory: 512 * 1024 * 1024}
+	err := db.Session.Metrics().Insert(sample)
+	c.Assert(err, IsNil)
+	defer db.Session.Metrics().RemoveAll(bson.M{"app": a.Name})
+	d, err := a.EvaluateAutoscale(now)
+	c.Assert(err, IsNil)
+	c.Assert(d, DeepEquals, &ScaleDecision{
+		Process: "web",
+		From:    1,
+		To:      2,
+		Reason:  "average memory of 512.0 MB is above the threshold of 256.0 MB",
+	})
+}
+
+func (s *S) TestEvaluateAutoscaleIgnoresOldSamples(c *C) {
+	now := time.Now()
+	a := App{
+		Name:      "elastic",
+		Autoscale: AutoscalePolicy{Enabled: true, MinUnits: 1, MaxUnits: 3, Metric: MetricCPU, Threshold: 60},
+		Units:     []Unit{{Name: "elastic/0", State: "started"}},
+	}
+	sample := UnitMetrics{App: a.Name, Unit: "elastic/0", Date: now.Add(-time.Hour), CPU: 100}
+	err := db.Session.Metrics().Insert(sample)
+	c.Assert(err, IsNil)
+	defer db.Session.Metrics().RemoveAll(bson.M{"app": a.Name})
+	d, err := a.EvaluateAutoscale(now)
+	c.Assert(err, IsNil)
+	c.Assert(d, IsNil)
+}
+
+func (s *S) TestEvaluateAutoscaleKeepsUnitsWithinLimits(c *C) {
+	now := time.Now()
+	a := App{
+		Name:      "elastic",
+		Autoscale: AutoscalePolicy{Enabled: true, MinUnits: 2, MaxUnits: 3, Metric: MetricCPU, Threshold: 60},
+		Units:     []Unit{{Name: "elastic/0", State: "started"}},
+	}
+	d, err := a.EvaluateAutoscale(now)
+	c.Assert(err, IsNil)
+	c.Assert(d, DeepEquals, &ScaleDecision{Process: "web", From: 1, To: 2, Reason: "below the minimum of 2 units"})
+	a.Autoscale.MinUnits, a.Autoscale.MaxUnits = 0, 0
+	d, err = a.EvaluateAutoscale(now)
+	c.Assert(err, IsNil)
+	c.Assert(d, DeepEquals, &ScaleDecision{Process: "web", From: 1, To: 0, Reason: "above the maximum of 0 units"})
+}
+
+func (s *S) TestEvaluateAutoscaleInCooldown(c *C) {
+	now := time.Now()
+	a := App{
+		Name: "elastic",
+		Autoscale: AutoscalePolicy{
+			Enabled:   true,
+			MinUnits:  2,
+			MaxUnits:  3,
+			Metric:    MetricCPU,
+			Threshold: 60,
+			LastScale: now.Add(-time.Minute),
+		},
+		Units: []Unit{{Name: "elastic/0", State: "started"}},
+	}
+	d, err := a.EvaluateAutoscale(now)
+	c.Assert(err, IsNil)
+	c.Assert(d, IsNil)
+	a.Autoscale.Enabled = false
+	a.Autoscale.LastScale = time.Time{}
+	d, err = a.EvaluateAutoscale(now)
+	c.Assert(err, IsNil)
+	c.Assert(d, IsNil)
+}
+
+func (s *S) TestScale(c *C) {
+	server := testing.FakeQueueServer{}
+	server.Start("127.0.0.1:0")
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err == nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	a := App{Name: "elastic", Framework: "python"}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = a.AddUnits(1, "")
+	c.Assert(err, IsNil)
+	now := time.Now().Round(time.Second)
+	err = a.Scale(&ScaleDecision{Process: "web", From: 1, To: 2, Reason: "average cpu of 80.0% is above the threshold of 60.0%"}, now)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 2)
+	c.Assert(a.Autoscale.LastScale.Equal(now), Equals, true)
+	c.Assert(s.provisioner.GetUnits(&a), HasLen, 2)
+	msg := "Autoscaling the web units from 1 to 2: average cpu of 80.0% is above the threshold of 60.0%."
+	n, err := db.Session.Logs().Find(bson.M{"appname": a.Name, "message": msg}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	err = a.Scale(&ScaleDecision{Process: "web", From: 2, To: 1, Reason: "testing"}, now)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 1)
+	c.Assert(a.Units[0].Name, Equals, "elastic/1")
+}
+
+func (s *S) TestScaleFailure(c *C) {
+	a := App{Name: "elastic", Framework: "python", Units: []Unit{{Name: "elastic/0"}}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.Scale(&ScaleDecision{Process: "web", From: 1, To: 0, Reason: "testing"}, time.Now())
+	c.Assert(err, NotNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Autoscale.LastScale.IsZero(), Equals, false)
+	n, err := db.Session.Logs().Find(bson.M{"appname": a.Name, "message": bson.RegEx{Pattern: "^Failed to autoscale"}}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+}
+
+func (s *S) TestScaleRemovesUnitsThatAreNotStartedFirst(c *C) {
+	a := App{
+		Name:      "elastic",
+		Framework: "python",
+		Units: []Unit{
+			{Name: "elastic/0", State: "started"},
+			{Name: "elastic/1", State: "pending"},
+			{Name: "elastic/2", State: "started"},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	_, err = s.provisioner.AddUnits(&a, 3, "web")
+	c.Assert(err, IsNil)
+	err = a.Scale(&ScaleDecision{Process: "web", From: 3, To: 1, Reason: "testing"}, time.Now())
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 1)
+	c.Assert(a.Units[0].Name, Equals, "elastic/2")
+}
@@ -0,0 +1,166 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/log"
+	"github.com/globocom/tsuru/queue"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"strings"
+	"time"
+)
+
+// Cron is a scheduled job of an app: a command that runs in one unit of the
+// app whenever the schedule matches. Schedules are in the crontab(5) format,
+// in the local time of the tsuru server.
+//
+// Jobs are either added by users or declared in the cron section of app.conf.
+// The latter are replaced on every restart of the app, and can't be removed by
+// users.
+type Cron struct {
+	Id       string `bson:"_id"`
+	App      string
+	Schedule string
+	Command  string
+	Conf     bool
+	LastRun  time.Time
+	NextRun  time.Time
+}
+
+type confCron struct {
+	Schedule string `yaml:"schedule"`
+	Command  string `yaml:"command"`
+}
+
+// AddCron adds a scheduled job to the app.
+func (a *App) AddCron(schedule, command string) (*Cron, error) {
+	return a.addCron(schedule, command, false)
+}
+
+func (a *App) addCron(expr, command string, conf bool) (*Cron, error) {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return nil, &ValidationError{Message: "You must provide the command of the cron job."}
+	}
+	s, err := parseSchedule(expr)
+	if err != nil {
+		return nil, &ValidationError{Message: err.Error()}
+	}
+	next := s.next(time.Now())
+	if next.IsZero() {
+		return nil, &ValidationError{Message: fmt.Sprintf("The schedule %q never matches.", expr)}
+	}
+	c := Cron{
+		Id:       bson.NewObjectId().Hex(),
+		App:      a.Name,
+		Schedule: strings.TrimSpace(expr),
+		Command:  command,
+		Conf:     conf,
+		NextRun:  next,
+	}
+	if err = db.Session.Crons().Insert(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Crons returns the scheduled jobs of the app, sorted by their next run.
+func (a *App) Crons() ([]Cron, error) {
+	var crons []Cron
+	err := db.Session.Crons().Find(bson.M{"app": a.Name}).Sort("nextrun").All(&crons)
+	return crons, err
+}
+
+// RemoveCron removes a scheduled job from the app. Jobs declared in app.conf
+// can't be removed.
+func (a *App) RemoveCron(id string) error {
+	var c Cron
+	err := db.Session.Crons().Find(bson.M{"_id": id, "app": a.Name}).One(&c)
+	if err == mgo.ErrNotFound {
+		return &ValidationError{Message: fmt.Sprintf("Cron job %s not found in the app %s.", id, a.Name)}
+	} else if err != nil {
+		return err
+	}
+	if c.Conf {
+		return &ValidationError{Message: fmt.Sprintf("The cron job %s is declared in app.conf, remove it from there.", id)}
+	}
+	return db.Session.Crons().RemoveId(id)
+}
+
+// syncConfCrons replaces the scheduled jobs of the app declared in app.conf
+// with the ones currently declared there. Invalid jobs are skipped, and
+// reported in the app log.
+func (a *App) syncConfCrons() error {
+	if err := a.loadHooks(); err != nil {
+		return err
+	}
+	_, err := db.Session.Crons().RemoveAll(bson.M{"app": a.Name, "conf": true})
+	if err != nil {
+		return err
+	}
+	for _, c := range a.hooks.Cron {
+		if _, err = a.addCron(c.Schedule, c.Command, true); err != nil {
+			if e, ok := err.(*ValidationError); ok {
+				a.Log(fmt.Sprintf("Skipping the cron job %q of app.conf: %s", c.Command, e.Message), "tsuru")
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// DispatchCrons enqueues the scheduled jobs of all apps that are due at the
+// given time, moving their next run forward. Each job is dispatched once, even
+// if many collectors are running. It returns the number of dispatched jobs.
+func DispatchCrons(now time.Time) (int, error) {
+	var crons []Cron
+	err := db.Session.Crons().Find(bson.M{"nextrun": bson.M{"$lte": now}}).All(&crons)
+	if err != nil {
+		return 0, err
+	}
+	var msgs []queue.Message
+	for _, c := range crons {
+		s, err := parseSchedule(c.Schedule)
+		if err != nil {
+			log.Printf("Skipping the cron job %s of the app %q: %s", c.Id, c.App, err)
+			continue
+		}
+		query := bson.M{"_id": c.Id, "nextrun": c.NextRun}
+		update := bson.M{"$set": bson.M{"lastrun": now, "nextrun": s.next(now)}}
+		if err = db.Session.Crons().Update(query, update); err == mgo.ErrNotFound {
+			// dispatched by another collector.
+			continue
+		} else if err != nil {
+			return len(msgs), err
+		}
+		msgs = append(msgs, queue.Message{Action: RunCron, Args: []string{c.App, c.Id}})
+	}
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	return len(msgs), new(App).enqueue(msgs...)
+}
+
+// RunCron runs a scheduled job of the app in one of its started units. The
+// output of the job goes to the app log, with the source "cron".
+func (a *App) RunCron(id string) error {
+	var c Cron
+	err := db.Session.Crons().Find(bson.M{"_id": id, "app": a.Name}).One(&c)
+	if err != nil {
+		return fmt.Errorf("Cron job %s not found in the app %s.", id, a.Name)
+	}
+	var buf bytes.Buffer
+	err = a.RunInUnit(c.Command, "", &buf, 0)
+	a.Log(buf.String(), "cron")
+	if err != nil {
+		a.Log(fmt.Sprintf("The cron job %q failed: %s", c.Command, err), "cron")
+	}
+	return err
+}
@@ -0,0 +1,207 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/provision"
+	"github.com/globocom/tsuru/queue"
+	"github.com/globocom/tsuru/testing"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) TestAddCron(c *C) {
+	a := App{Name: "scheduled"}
+	cron, err := a.AddCron("*/5 * * * *", "python manage.py clearsessions")
+	c.Assert(err, IsNil)
+	defer db.Session.Crons().RemoveId(cron.Id)
+	c.Assert(cron.App, Equals, "scheduled")
+	c.Assert(cron.Conf, Equals, false)
+	c.Assert(cron.NextRun.After(time.Now()), Equals, true)
+	c.Assert(cron.NextRun.Minute()%5, Equals, 0)
+	var stored Cron
+	err = db.Session.Crons().FindId(cron.Id).One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.Schedule, Equals, "*/5 * * * *")
+	c.Assert(stored.Command, Equals, "python manage.py clearsessions")
+}
+
+func (s *S) TestAddCronInvalidSchedule(c *C) {
+	a := App{Name: "scheduled"}
+	_, err := a.AddCron("every day", "python manage.py clearsessions")
+	c.Assert(err, NotNil)
+	e, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Message, Matches, `Invalid schedule "every day": .*`)
+}
+
+func (s *S) TestAddCronScheduleThatNeverMatches(c *C) {
+	a := App{Name: "scheduled"}
+	_, err := a.AddCron("0 0 30 2 *", "python manage.py clearsessions")
+	c.Assert(err, NotNil)
+	e, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Message, Equals, `The schedule "0 0 30 2 *" never matches.`)
+}
+
+func (s *S) TestAddCronWithoutCommand(c *C) {
+	a := App{Name: "scheduled"}
+	_, err := a.AddCron("@daily", "  ")
+	c.Assert(err, NotNil)
+	_, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+}
+
+func (s *S) TestCrons(c *C) {
+	a := App{Name: "scheduled"}
+	daily, err := a.AddCron("@daily", "./backup.sh")
+	c.Assert(err, IsNil)
+	defer db.Session.Crons().RemoveId(daily.Id)
+	often, err := a.AddCron("* * * * *", "./poll.sh")
+	c.Assert(err, IsNil)
+	defer db.Session.Crons().RemoveId(often.Id)
+	other := App{Name: "other"}
+	cron, err := other.AddCron("* * * * *", "./poll.sh")
+	c.Assert(err, IsNil)
+	defer db.Session.Crons().RemoveId(cron.Id)
+	crons, err := a.Crons()
+	c.Assert(err, IsNil)
+	c.Assert(crons, HasLen, 2)
+	c.Assert(crons[0].Id, Equals, often.Id)
+	c.Assert(crons[1].Id, Equals, daily.Id)
+}
+
+func (s *S) TestRemoveCron(c *C) {
+	a := App{Name: "scheduled"}
+	cron, err := a.AddCron("@daily", "./backup.sh")
+	c.Assert(err, IsNil)
+	defer db.Session.Crons().RemoveId(cron.Id)
+	err = a.RemoveCron(cron.Id)
+	c.Assert(err, IsNil)
+	n, err := db.Session.Crons().FindId(cron.Id).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *S) TestRemoveCronNotFound(c *C) {
+	a := App{Name: "scheduled"}
+	other := App{Name: "other"}
+	cron, err := other.AddCron("@daily", "./backup.sh")
+	c.Assert(err, IsNil)
+	defer db.Session.Crons().RemoveId(cron.Id)
+	err = a.RemoveCron(cron.Id)
+	c.Assert(err, NotNil)
+	e, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Message, Equals, "Cron job "+cron.Id+" not found in the app scheduled.")
+}
+
+func (s *S) TestRemoveCronDeclaredInAppConf(c *C) {
+	a := App{Name: "scheduled"}
+	cron, err := a.addCron("@daily", "./backup.sh", true)
+	c.Assert(err, IsNil)
+	defer db.Session.Crons().RemoveId(cron.Id)
+	err = a.RemoveCron(cron.Id)
+	c.Assert(err, NotNil)
+	_, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+}
+
+func (s *S) TestSyncConfCrons(c *C) {
+	output := `cron:
+  - schedule: "*/10 * * * *"
+    command: ./poll.sh
+  - schedule: sometimes
+    command: ./invalid.sh
+`
+	s.provisioner.PrepareOutput([]byte(output))
+	a := App{Name: "scheduled", State: string(provision.StatusStarted)}
+	old, err := a.addCron("@daily", "./old.sh", true)
+	c.Assert(err, IsNil)
+	defer db.Session.Crons().RemoveId(old.Id)
+	mine, err := a.AddCron("@daily", "./backup.sh")
+	c.Assert(err, IsNil)
+	defer db.Session.Crons().RemoveId(mine.Id)
+	err = a.syncConfCrons()
+	c.Assert(err, IsNil)
+	defer db.Session.Crons().RemoveAll(bson.M{"app": a.Name})
+	crons, err := a.Crons()
+	c.Assert(err, IsNil)
+	c.Assert(crons, HasLen, 2)
+	commands := map[string]bool{}
+	for _, cron := range crons {
+		commands[cron.Command] = cron.Conf
+	}
+	c.Assert(commands, DeepEquals, map[string]bool{"./poll.sh": true, "./backup.sh": false})
+}
+
+func (s *S) TestDispatchCrons(c *C) {
+	server := testing.FakeQueueServer{}
+	server.Start("127.0.0.1:0")
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err == nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	now := time.Now()
+	due := Cron{Id: "due", App: "scheduled", Schedule: "@hourly", Command: "./poll.sh", NextRun: now.Add(-time.Minute)}
+	later := Cron{Id: "later", App: "scheduled", Schedule: "@hourly", Command: "./poll.sh", NextRun: now.Add(time.Hour)}
+	err = db.Session.Crons().Insert(&due, &later)
+	c.Assert(err, IsNil)
+	defer db.Session.Crons().RemoveAll(bson.M{"app": "scheduled"})
+	n, err := DispatchCrons(now)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	n, err = DispatchCrons(now)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+	err = db.Session.Crons().FindId("due").One(&due)
+	c.Assert(err, IsNil)
+	c.Assert(due.NextRun.After(now), Equals, true)
+	c.Assert(due.NextRun.Minute(), Equals, 0)
+	time.Sleep(1e8)
+	expected := queue.Message{Action: RunCron, Args: []string{"scheduled", "due"}}
+	c.Assert(server.Messages(), DeepEquals, []queue.Message{expected})
+}
+
+func (s *S) TestRunCron(c *C) {
+	s.provisioner.PrepareOutput([]byte("backup done"))
+	a := App{
+		Name:  "scheduled",
+		State: string(provision.StatusStarted),
+		Units: []Unit{{Name: "scheduled/0", State: string(provision.StatusStarted)}},
+	}
+	cron, err := a.AddCron("@daily", "./backup.sh")
+	c.Assert(err, IsNil)
+	defer db.Session.Crons().RemoveId(cron.Id)
+	err = a.RunCron(cron.Id)
+	c.Assert(err, IsNil)
+	c.Assert(a.batch, IsNil)
+	cmds := s.provisioner.GetCmds("", &a)
+	c.Assert(cmds, HasLen, 1)
+	c.Assert(cmds[0].Cmd, Matches, `.*\./backup\.sh$`)
+	var l Applog
+	err = db.Session.Logs().Find(bson.M{"appname": a.Name, "source": "cron"}).One(&l)
+	c.Assert(err, IsNil)
+	c.Assert(l.Message, Equals, "backup done")
+}
+
+func (s *S) TestRunCronWithoutStartedUnits(c *C) {
+	a := App{
+		Name:  "scheduled",
+		State: string(provision.StatusStarted),
+		Units: []Unit{{Name: "scheduled/0", State: string(provision.StatusPending)}},
+	}
+	cron, err := a.AddCron("@daily", "./backup.sh")
+	c.Assert(err, IsNil)
+	defer db.Session.Crons().RemoveId(cron.Id)
+	err = a.RunCron(cron.Id)
+	c.Assert(err, NotNil)
+	c.Assert(s.provisioner.GetCmds("", &a), HasLen, 0)
+}
@@ -0,0 +1,63 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo/bson"
+	"regexp"
+	"time"
+)
+
+// Deploy represents a deploy of an app: which ref and commit were deployed, by
+// whom, when, how long it took and whether it succeeded.
+type Deploy struct {
+	App       string
+	Ref       string
+	Commit    string
+	User      string
+	Timestamp time.Time
+	Duration  time.Duration
+	Success   bool
+	Error     string
+}
+
+// NewDeploy starts tracking a new deploy of the app, made by the given user.
+// The deploy is saved in the database only when it's finished (see
+// Deploy.Finish).
+func NewDeploy(a *App, user string) *Deploy {
+	return &Deploy{App: a.Name, User: user, Timestamp: time.Now()}
+}
+
+// Finish records the outcome of the deploy, saving it in the database. The
+// given error is the error returned by the deploy process, if any.
+func (d *Deploy) Finish(err error) error {
+	d.Duration = time.Since(d.Timestamp)
+	d.Success = err == nil
+	if err != nil {
+		d.Error = err.Error()
+	}
+	return db.Session.Deploys().Insert(d)
+}
+
+// Deploys returns the deploy history of the app, most recent first.
+func (a *App) Deploys() ([]Deploy, error) {
+	var deploys []Deploy
+	err := db.Session.Deploys().Find(bson.M{"app": a.Name}).Sort("-timestamp").All(&deploys)
+	return deploys, err
+}
+
+// FindDeploy returns the most recent successful deploy of the given commit.
+// The commit may be abbreviated.
+func (a *App) FindDeploy(commit string) (Deploy, error) {
+	var d Deploy
+	query := bson.M{
+		"app":     a.Name,
+		"success": true,
+		"commit":  bson.RegEx{Pattern: "^" + regexp.QuoteMeta(commit)},
+	}
+	err := db.Session.Deploys().Find(query).Sort("-timestamp").One(&d)
+	return d, err
+}
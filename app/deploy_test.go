@@ -0,0 +1,92 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"errors"
+	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) TestNewDeploy(c *C) {
+	a := App{Name: "fish"}
+	d := NewDeploy(&a, "someone@tsuru.io")
+	c.Assert(d.App, Equals, "fish")
+	c.Assert(d.User, Equals, "someone@tsuru.io")
+	c.Assert(d.Timestamp.IsZero(), Equals, false)
+}
+
+func (s *S) TestDeployFinish(c *C) {
+	a := App{Name: "fish"}
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	d := NewDeploy(&a, "someone@tsuru.io")
+	d.Commit = "6c7a8e93c1ab2c7b3b5d8bc4e3c2f3a8b3d1a2e4"
+	err := d.Finish(nil)
+	c.Assert(err, IsNil)
+	var saved Deploy
+	err = db.Session.Deploys().Find(bson.M{"app": a.Name}).One(&saved)
+	c.Assert(err, IsNil)
+	c.Assert(saved.Commit, Equals, d.Commit)
+	c.Assert(saved.User, Equals, "someone@tsuru.io")
+	c.Assert(saved.Success, Equals, true)
+	c.Assert(saved.Error, Equals, "")
+}
+
+func (s *S) TestDeployFinishWithError(c *C) {
+	a := App{Name: "fish"}
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	d := NewDeploy(&a, "someone@tsuru.io")
+	err := d.Finish(errors.New("failed to clone"))
+	c.Assert(err, IsNil)
+	var saved Deploy
+	err = db.Session.Deploys().Find(bson.M{"app": a.Name}).One(&saved)
+	c.Assert(err, IsNil)
+	c.Assert(saved.Success, Equals, false)
+	c.Assert(saved.Error, Equals, "failed to clone")
+}
+
+func (s *S) TestDeploysAreSortedByTimestamp(c *C) {
+	a := App{Name: "fish"}
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	now := time.Now()
+	deploys := []Deploy{
+		{App: a.Name, Commit: "first", Timestamp: now.Add(-2 * time.Hour)},
+		{App: a.Name, Commit: "third", Timestamp: now},
+		{App: a.Name, Commit: "second", Timestamp: now.Add(-time.Hour)},
+		{App: "otherapp", Commit: "other", Timestamp: now},
+	}
+	for _, d := range deploys {
+		err := db.Session.Deploys().Insert(d)
+		c.Assert(err, IsNil)
+	}
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": "otherapp"})
+	result, err := a.Deploys()
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 3)
+	c.Assert(result[0].Commit, Equals, "third")
+	c.Assert(result[1].Commit, Equals, "second")
+	c.Assert(result[2].Commit, Equals, "first")
+}
+
+func (s *S) TestFindDeploy(c *C) {
+	a := App{Name: "fish"}
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	now := time.Now()
+	deploys := []Deploy{
+		{App: a.Name, Commit: "6c7a8e93c1ab2c7b3b5d8bc4e3c2f3a8b3d1a2e4", Timestamp: now.Add(-time.Hour), Success: true},
+		{App: a.Name, Commit: "b5d8bc4e3c2f3a8b3d1a2e46c7a8e93c1ab2c7b3", Timestamp: now, Success: false},
+	}
+	for _, d := range deploys {
+		err := db.Session.Deploys().Insert(d)
+		c.Assert(err, IsNil)
+	}
+	d, err := a.FindDeploy("6c7a8e9")
+	c.Assert(err, IsNil)
+	c.Assert(d.Commit, Equals, "6c7a8e93c1ab2c7b3b5d8bc4e3c2f3a8b3d1a2e4")
+	_, err = a.FindDeploy("b5d8bc4")
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,432 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/db"
+	"io"
+	"io/ioutil"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var (
+	// drainBufferSize is the number of log entries a drain holds while
+	// waiting for delivery. Entries are discarded when the buffer is full.
+	drainBufferSize = 1000
+
+	// drainBatchSize is the maximum number of log entries delivered at once.
+	drainBatchSize = 100
+
+	// drainRetries is the number of times the delivery of a batch of log
+	// entries is attempted before giving up.
+	drainRetries = 3
+
+	// drainRetryInterval is the interval between delivery attempts.
+	drainRetryInterval = time.Second
+
+	// drainTimeout is how long a read or a write in the connection to an
+	// HTTP drain may block.
+	drainTimeout = 10 * time.Second
+
+	// drainsCacheTTL is how long the drains of an app are cached. Drains
+	// added or removed by other tsuru servers are noticed after this time.
+	drainsCacheTTL = 30 * time.Second
+
+	drainers   = make(map[string]*drainer)
+	drainersMu sync.Mutex
+
+	drainsCache   = make(map[string]cachedDrains)
+	drainsCacheMu sync.Mutex
+)
+
+// AddDrain registers a log drain in the app. Every entry logged by the app
+// from now on is forwarded to the drain.
+//
+// The drain is a URL, in one of the forms:
+//
+//	syslog://host:port      syslog (RFC 5424) over TCP
+//	syslog+tcp://host:port  syslog (RFC 5424) over TCP
+//	syslog+udp://host:port  syslog (RFC 5424) over UDP
+//	http://host/path        HTTP POST of batches of entries, encoded as a JSON array
+//	https://host/path       same as above, over TLS
+func (a *App) AddDrain(drain string) error {
+	if _, err := parseDrain(drain); err != nil {
+		return err
+	}
+	for _, d := range a.Drains {
+		if d == drain {
+			msg := fmt.Sprintf("The drain %s is already registered in the app %s.", drain, a.Name)
+			return &ValidationError{Message: msg}
+		}
+	}
+	if err := a.initField("drains", []string{}); err != nil {
+		return err
+	}
+	a.Drains = append(a.Drains, drain)
+	err := a.update(bson.M{"$addToSet": bson.M{"drains": drain}})
+	forgetDrains(a.Name)
+	return err
+}
+
+// RemoveDrain removes a log drain from the app, discarding the entries that
+// were not delivered yet.
+func (a *App) RemoveDrain(drain string) error {
+	index := -1
+	for i, d := range a.Drains {
+		if d == drain {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return &ValidationError{Message: fmt.Sprintf("Drain %s not found in the app %s.", drain, a.Name)}
+	}
+	copy(a.Drains[index:], a.Drains[index+1:])
+	a.Drains = a.Drains[:len(a.Drains)-1]
+	err := a.update(bson.M{"$pull": bson.M{"drains": drain}})
+	forgetDrains(a.Name)
+	if err != nil {
+		return err
+	}
+	closeDrainer(a.Name, drain)
+	return nil
+}
+
+// drain forwards the given log entries to all drains of the app. Delivery
+// happens in background, failures are reported in the app log.
+func (a *App) drain(logs []Applog) {
+	for _, d := range appDrains(a.Name) {
+		enqueueDrain(a.Name, d, logs)
+	}
+}
+
+type cachedDrains struct {
+	drains  []string
+	expires time.Time
+}
+
+// appDrains returns the drains of the app, loading them from the database at
+// most once every drainsCacheTTL.
+func appDrains(appName string) []string {
+	drainsCacheMu.Lock()
+	defer drainsCacheMu.Unlock()
+	if cached, ok := drainsCache[appName]; ok && time.Now().Before(cached.expires) {
+		return cached.drains
+	}
+	var app App
+	err := db.Session.Apps().Find(bson.M{"name": appName}).Select(bson.M{"drains": 1}).One(&app)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil
+	}
+	drainsCache[appName] = cachedDrains{drains: app.Drains, expires: time.Now().Add(drainsCacheTTL)}
+	return app.Drains
+}
+
+// forgetDrains removes the drains of the app from the cache, so they are
+// loaded again in the next call to appDrains.
+func forgetDrains(appName string) {
+	drainsCacheMu.Lock()
+	delete(drainsCache, appName)
+	drainsCacheMu.Unlock()
+}
+
+func parseDrain(drain string) (*url.URL, error) {
+	u, err := url.Parse(drain)
+	if err == nil && u.Host != "" {
+		switch u.Scheme {
+		case "http", "https":
+			return u, nil
+		case "syslog", "syslog+tcp", "syslog+udp":
+			if _, _, err := net.SplitHostPort(u.Host); err == nil {
+				return u, nil
+			}
+		}
+	}
+	msg := fmt.Sprintf("Invalid drain %q. Use syslog://host:port, syslog+udp://host:port or http(s)://host/path.", drain)
+	return nil, &ValidationError{Message: msg}
+}
+
+// reportDrain writes a message about a drain in the app log. The message is
+// not forwarded to any drain.
+func reportDrain(appName, drain, msg string) {
+	l := Applog{
+		Date:    time.Now(),
+		Message: fmt.Sprintf("Drain %s: %s", drain, msg),
+		Source:  "tsuru",
+		AppName: appName,
+	}
+	db.Session.Logs().Insert(l)
+}
+
+type drainSender interface {
+	send(logs []Applog) error
+	close()
+}
+
+func newDrainSender(drain string) (drainSender, error) {
+	u, err := parseDrain(drain)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "syslog", "syslog+tcp":
+		return &syslogSender{network: "tcp", addr: u.Host}, nil
+	case "syslog+udp":
+		return &syslogSender{network: "udp", addr: u.Host}, nil
+	}
+	return &httpSender{url: drain}, nil
+}
+
+// syslogSender sends log entries to a syslog server, in the format described
+// by RFC 5424. Over TCP, messages are framed with octet counting, as described
+// by RFC 6587.
+type syslogSender struct {
+	network string
+	addr    string
+	conn    net.Conn
+}
+
+func (s *syslogSender) send(logs []Applog) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.addr, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	var err error
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if s.network == "tcp" {
+		var buf bytes.Buffer
+		for _, l := range logs {
+			msg := formatSyslog(l)
+			fmt.Fprintf(&buf, "%d %s", len(msg), msg)
+		}
+		_, err = s.conn.Write(buf.Bytes())
+	} else {
+		for _, l := range logs {
+			if _, err = s.conn.Write([]byte(formatSyslog(l))); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		s.close()
+	}
+	return err
+}
+
+func (s *syslogSender) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// formatSyslog formats a log entry as a syslog message, using the user-level
+// facility and the informational severity. The unit is used as hostname, and
+// the source of the entry as process id.
+func formatSyslog(l Applog) string {
+	hostname := l.Unit
+	if hostname == "" {
+		hostname = "tsuru"
+	}
+	procid := l.Source
+	if procid == "" {
+		procid = "-"
+	}
+	timestamp := l.Date.UTC().Format("2006-01-02T15:04:05.000000Z07:00")
+	return fmt.Sprintf("<14>1 %s %s %s %s - - %s", timestamp, hostname, l.AppName, procid, l.Message)
+}
+
+// drainConn is a connection to an HTTP drain. Reads and writes that block for
+// longer than drainTimeout fail, so a connection can be reused by many
+// deliveries without letting a stuck drain hang its drainer.
+type drainConn struct {
+	net.Conn
+}
+
+func (c *drainConn) Read(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(drainTimeout))
+	return c.Conn.Read(b)
+}
+
+func (c *drainConn) Write(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(drainTimeout))
+	return c.Conn.Write(b)
+}
+
+// drainClient is the HTTP client used by HTTP drains.
+var drainClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.DialTimeout(network, addr, 10*time.Second)
+			if err != nil {
+				return nil, err
+			}
+			return &drainConn{conn}, nil
+		},
+	},
+}
+
+// httpSender posts batches of log entries, encoded as a JSON array, to an
+// HTTP endpoint.
+type httpSender struct {
+	url string
+}
+
+func (s *httpSender) send(logs []Applog) error {
+	b, err := json.Marshal(logs)
+	if err != nil {
+		return err
+	}
+	resp, err := drainClient.Post(s.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	// the body is read to the end so the connection can be reused.
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpSender) close() {}
+
+// drainer buffers the log entries of an app that must be sent to a drain,
+// delivering them in background.
+type drainer struct {
+	app    string
+	drain  string
+	sender drainSender
+	logs   chan Applog
+	full   bool
+}
+
+func drainerKey(appName, drain string) string {
+	return appName + " " + drain
+}
+
+// enqueueDrain buffers the given log entries for delivery to the drain,
+// starting a new drainer if needed.
+func enqueueDrain(appName, drain string, logs []Applog) {
+	drainersMu.Lock()
+	defer drainersMu.Unlock()
+	key := drainerKey(appName, drain)
+	d, ok := drainers[key]
+	if !ok {
+		sender, err := newDrainSender(drain)
+		if err != nil {
+			reportDrain(appName, drain, err.Error())
+			return
+		}
+		d = &drainer{
+			app:    appName,
+			drain:  drain,
+			sender: sender,
+			logs:   make(chan Applog, drainBufferSize),
+		}
+		drainers[key] = d
+		go d.run()
+	}
+	for _, l := range logs {
+		select {
+		case d.logs <- l:
+			d.full = false
+		default:
+			if !d.full {
+				d.full = true
+				reportDrain(appName, drain, "buffer is full, discarding log entries.")
+			}
+		}
+	}
+}
+
+// closeDrainer stops the delivery of log entries to the drain.
+func closeDrainer(appName, drain string) {
+	drainersMu.Lock()
+	defer drainersMu.Unlock()
+	key := drainerKey(appName, drain)
+	if d, ok := drainers[key]; ok {
+		close(d.logs)
+		delete(drainers, key)
+	}
+}
+
+// registered returns whether the drain is still registered in the app. The
+// drain may have been removed by another tsuru server.
+func (d *drainer) registered() bool {
+	for _, drain := range appDrains(d.app) {
+		if drain == d.drain {
+			return true
+		}
+	}
+	return false
+}
+
+// stop unregisters the drainer, discarding the log entries that were not
+// delivered yet.
+func (d *drainer) stop() {
+	drainersMu.Lock()
+	defer drainersMu.Unlock()
+	key := drainerKey(d.app, d.drain)
+	if drainers[key] == d {
+		delete(drainers, key)
+	}
+}
+
+// run delivers the buffered log entries in batches of up to drainBatchSize
+// entries, until the drainer is closed or the drain is removed from the app.
+func (d *drainer) run() {
+	defer d.sender.close()
+	var failing bool
+	for l := range d.logs {
+		batch := []Applog{l}
+	fill:
+		for len(batch) < drainBatchSize {
+			select {
+			case l, ok := <-d.logs:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, l)
+			default:
+				break fill
+			}
+		}
+		if !d.registered() {
+			d.stop()
+			return
+		}
+		var err error
+		for i := 0; i < drainRetries; i++ {
+			if i > 0 {
+				time.Sleep(drainRetryInterval)
+			}
+			if err = d.sender.send(batch); err == nil {
+				break
+			}
+		}
+		if err != nil && !failing {
+			failing = true
+			reportDrain(d.app, d.drain, fmt.Sprintf("failed to deliver log entries: %s.", err))
+		} else if err == nil && failing {
+			failing = false
+			reportDrain(d.app, d.drain, "delivering log entries again.")
+		}
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/db"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+func (s *S) TestAddDrain(c *C) {
+	a := App{Name: "drained", Framework: "python"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.AddDrain("syslog://logs.example.com:514")
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Drains, DeepEquals, []string{"syslog://logs.example.com:514"})
+}
+
+func (s *S) TestAddDrainInvalidURL(c *C) {
+	a := App{Name: "drained"}
+	drains := []string{"logs.example.com", "ftp://logs.example.com", "syslog://logs.example.com", "http://"}
+	for _, d := range drains {
+		err := a.AddDrain(d)
+		c.Check(err, NotNil)
+		_, ok := err.(*ValidationError)
+		c.Check(ok, Equals, true)
+	}
+}
+
+func (s *S) TestAddDrainDuplicated(c *C) {
+	a := App{Name: "drained", Drains: []string{"http://logs.example.com/drained"}}
+	err := a.AddDrain("http://logs.example.com/drained")
+	c.Assert(err, NotNil)
+	e, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Message, Equals, "The drain http://logs.example.com/drained is already registered in the app drained.")
+}
+
+func (s *S) TestRemoveDrain(c *C) {
+	a := App{
+		Name:   "drained",
+		Drains: []string{"syslog://logs.example.com:514", "http://logs.example.com/drained"},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.RemoveDrain("syslog://logs.example.com:514")
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Drains, DeepEquals, []string{"http://logs.example.com/drained"})
+}
+
+func (s *S) TestRemoveDrainNotFound(c *C) {
+	a := App{Name: "drained"}
+	err := a.RemoveDrain("syslog://logs.example.com:514")
+	c.Assert(err, NotNil)
+	e, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Message, Equals, "Drain syslog://logs.example.com:514 not found in the app drained.")
+}
+
+func (s *S) TestFormatSyslog(c *C) {
+	l := Applog{
+		Date:    time.Date(2012, 11, 20, 10, 30, 0, 0, time.UTC),
+		Message: "GET / 200",
+		Source:  "app",
+		AppName: "drained",
+		Unit:    "drained/0",
+	}
+	c.Assert(formatSyslog(l), Equals, "<14>1 2012-11-20T10:30:00.000000Z drained/0 drained app - - GET / 200")
+	l.Unit = ""
+	l.Source = ""
+	c.Assert(formatSyslog(l), Equals, "<14>1 2012-11-20T10:30:00.000000Z tsuru drained - - - GET / 200")
+}
+
+func (s *S) TestSyslogSenderTCP(c *C) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer listener.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+	}()
+	sender, err := newDrainSender("syslog://" + listener.Addr().String())
+	c.Assert(err, IsNil)
+	defer sender.close()
+	l := Applog{Date: time.Now(), Message: "hello\n", Source: "app", AppName: "drained"}
+	err = sender.send([]Applog{l})
+	c.Assert(err, IsNil)
+	msg := formatSyslog(l)
+	select {
+	case got := <-received:
+		c.Assert(got, Equals, fmt.Sprintf("%d %s", len(msg), msg))
+	case <-time.After(2 * time.Second):
+		c.Fatal("Timed out waiting for the syslog message.")
+	}
+}
+
+func (s *S) TestSyslogSenderUDP(c *C) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	sender, err := newDrainSender("syslog+udp://" + conn.LocalAddr().String())
+	c.Assert(err, IsNil)
+	defer sender.close()
+	l := Applog{Date: time.Now(), Message: "hello", Source: "app", AppName: "drained"}
+	err = sender.send([]Applog{l})
+	c.Assert(err, IsNil)
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, formatSyslog(l))
+}
+
+func (s *S) TestHTTPSender(c *C) {
+	received := make(chan []Applog, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var logs []Applog
+		b, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(b, &logs)
+		received <- logs
+	}))
+	defer server.Close()
+	sender, err := newDrainSender(server.URL + "/drained")
+	c.Assert(err, IsNil)
+	err = sender.send([]Applog{
+		{Message: "hello", Source: "app", AppName: "drained"},
+		{Message: "world", Source: "app", AppName: "drained"},
+	})
+	c.Assert(err, IsNil)
+	got := <-received
+	c.Assert(got, HasLen, 2)
+	c.Assert(got[0].Message, Equals, "hello")
+	c.Assert(got[0].AppName, Equals, "drained")
+	c.Assert(got[1].Message, Equals, "world")
+}
+
+func (s *S) TestHTTPSenderFailure(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	sender, err := newDrainSender(server.URL)
+	c.Assert(err, IsNil)
+	err = sender.send([]Applog{{Message: "hello"}})
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "unexpected status code 500")
+}
+
+func (s *S) TestHTTPSenderTimesOut(c *C) {
+	old := drainTimeout
+	drainTimeout = 100 * time.Millisecond
+	defer func() { drainTimeout = old }()
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	sender, err := newDrainSender(server.URL)
+	c.Assert(err, IsNil)
+	err = sender.send([]Applog{{Message: "hello"}})
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestLogIsForwardedToDrains(c *C) {
+	received := make(chan Applog, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var logs []Applog
+		b, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(b, &logs)
+		for _, l := range logs {
+			received <- l
+		}
+	}))
+	defer server.Close()
+	a := App{Name: "forwarded", Drains: []string{server.URL}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer closeDrainer(a.Name, server.URL)
+	err = a.Log("first line\nsecond line", "tsuru")
+	c.Assert(err, IsNil)
+	for _, msg := range []string{"first line", "second line"} {
+		select {
+		case l := <-received:
+			c.Check(l.Message, Equals, msg)
+		case <-time.After(2 * time.Second):
+			c.Fatal("Timed out waiting for the drain.")
+		}
+	}
+}
+
+func (s *S) TestDrainFailuresAreReportedInTheAppLog(c *C) {
+	oldInterval := drainRetryInterval
+	drainRetryInterval = time.Millisecond
+	defer func() { drainRetryInterval = oldInterval }()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	a := App{Name: "failingdrain", Drains: []string{server.URL}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer closeDrainer(a.Name, server.URL)
+	err = a.Log("lost line", "app")
+	c.Assert(err, IsNil)
+	query := bson.M{"appname": a.Name, "source": "tsuru"}
+	var l Applog
+	for i := 0; i < 100; i++ {
+		if err = db.Session.Logs().Find(query).One(&l); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	c.Assert(l.Message, Equals, "Drain "+server.URL+": failed to deliver log entries: unexpected status code 503.")
+}
+
+func (s *S) TestAppDrainsIsCached(c *C) {
+	a := App{Name: "cacheddrains", Drains: []string{"syslog://logs.example.com:514"}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer forgetDrains(a.Name)
+	c.Assert(appDrains(a.Name), DeepEquals, a.Drains)
+	err = db.Session.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": bson.M{"drains": []string{}}})
+	c.Assert(err, IsNil)
+	c.Assert(appDrains(a.Name), DeepEquals, a.Drains)
+	forgetDrains(a.Name)
+	c.Assert(appDrains(a.Name), HasLen, 0)
+}
+
+func (s *S) TestDrainerStopsIfTheDrainWasRemoved(c *C) {
+	old := drainsCacheTTL
+	drainsCacheTTL = 0
+	defer func() { drainsCacheTTL = old }()
+	requests := make(chan bool, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- true
+	}))
+	defer server.Close()
+	a := App{Name: "removeddrain", Drains: []string{server.URL}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer closeDrainer(a.Name, server.URL)
+	err = a.Log("delivered", "app")
+	c.Assert(err, IsNil)
+	select {
+	case <-requests:
+	case <-time.After(2 * time.Second):
+		c.Fatal("Timed out waiting for the drain.")
+	}
+	err = db.Session.Apps().Update(bson.M{"name": a.Name}, bson.M{"$pull": bson.M{"drains": server.URL}})
+	c.Assert(err, IsNil)
+	enqueueDrain(a.Name, server.URL, []Applog{{Message: "discarded", AppName: a.Name}})
+	key := drainerKey(a.Name, server.URL)
+	var running bool
+	for i := 0; i < 100; i++ {
+		drainersMu.Lock()
+		_, running = drainers[key]
+		drainersMu.Unlock()
+		if !running {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(running, Equals, false)
+	select {
+	case <-requests:
+		c.Fatal("The entry was delivered to a removed drain.")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/api/bind"
+	"github.com/globocom/tsuru/db"
+	"io"
+	"labix.org/v2/mgo/bson"
+	"strings"
+)
+
+// encryptedPrefix marks values of private environment variables that are
+// encrypted. Values without the prefix were stored before the encryption of
+// private variables and are returned unchanged by decryptValue.
+const encryptedPrefix = "$tsuru-enc$"
+
+var errInvalidCiphertext = errors.New("Invalid encrypted value.")
+
+// envKey holds the keys derived from a secret declared in tsuru.conf: one for
+// AES-256 in CTR mode and another one for HMAC-SHA256, following the
+// encrypt-then-MAC construction.
+type envKey struct {
+	id     string
+	cipher []byte
+	mac    []byte
+}
+
+func newEnvKey(secret string) *envKey {
+	derive := func(purpose string) []byte {
+		h := hmac.New(sha256.New, []byte(secret))
+		h.Write([]byte(purpose))
+		return h.Sum(nil)
+	}
+	id := derive("id")
+	return &envKey{
+		id:     hex.EncodeToString(id[:4]),
+		cipher: derive("encryption"),
+		mac:    derive("authentication"),
+	}
+}
+
+// currentEnvKey returns the key used to encrypt private environment variables,
+// declared in the setting env:encryption-key.
+func currentEnvKey() (*envKey, error) {
+	secret, err := config.GetString("env:encryption-key")
+	if err != nil || secret == "" {
+		return nil, errors.New(`The setting "env:encryption-key" is required to store private environment variables.`)
+	}
+	return newEnvKey(secret), nil
+}
+
+// envKeyByID returns the key with the given id. Besides the current key, it
+// looks for the key declared in the setting env:previous-encryption-key, so
+// values encrypted before a key rotation can still be read.
+func envKeyByID(id string) (*envKey, error) {
+	current, err := currentEnvKey()
+	if err != nil {
+		return nil, err
+	}
+	if current.id == id {
+		return current, nil
+	}
+	if secret, err := config.GetString("env:previous-encryption-key"); err == nil && secret != "" {
+		if previous := newEnvKey(secret); previous.id == id {
+			return previous, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown encryption key %q.", id)
+}
+
+func isEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// encryptValue encrypts the given value with the current key. The result has
+// the form $tsuru-enc$<key id>$<base64 of iv, ciphertext and mac>.
+func encryptValue(value string) (string, error) {
+	key, err := currentEnvKey()
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key.cipher)
+	if err != nil {
+		return "", err
+	}
+	data := make([]byte, aes.BlockSize+len(value)+sha256.Size)
+	iv := data[:aes.BlockSize]
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return "", err
+	}
+	ciphertext := data[aes.BlockSize : aes.BlockSize+len(value)]
+	cipher.NewCTR(block, iv).XORKeyStream(ciphertext, []byte(value))
+	h := hmac.New(sha256.New, key.mac)
+	h.Write(data[:aes.BlockSize+len(value)])
+	copy(data[aes.BlockSize+len(value):], h.Sum(nil))
+	return encryptedPrefix + key.id + "$" + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// decryptValue decrypts a value encrypted by encryptValue. Values that are not
+// encrypted are returned unchanged.
+func decryptValue(value string) (string, error) {
+	if !isEncrypted(value) {
+		return value, nil
+	}
+	parts := strings.SplitN(value[len(encryptedPrefix):], "$", 2)
+	if len(parts) != 2 {
+		return "", errInvalidCiphertext
+	}
+	key, err := envKeyByID(parts[0])
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(data) < aes.BlockSize+sha256.Size {
+		return "", errInvalidCiphertext
+	}
+	macStart := len(data) - sha256.Size
+	h := hmac.New(sha256.New, key.mac)
+	h.Write(data[:macStart])
+	if subtle.ConstantTimeCompare(h.Sum(nil), data[macStart:]) != 1 {
+		return "", errInvalidCiphertext
+	}
+	block, err := aes.NewCipher(key.cipher)
+	if err != nil {
+		return "", err
+	}
+	plaintext := make([]byte, macStart-aes.BlockSize)
+	cipher.NewCTR(block, data[:aes.BlockSize]).XORKeyStream(plaintext, data[aes.BlockSize:macStart])
+	return string(plaintext), nil
+}
+
+// reencryptEnv encrypts the private variables in the given set with the
+// current key, decrypting them first when they were encrypted with another
+// key. It returns whether any variable has changed.
+func reencryptEnv(env map[string]bind.EnvVar) (bool, error) {
+	current, err := currentEnvKey()
+	if err != nil {
+		return false, err
+	}
+	prefix := encryptedPrefix + current.id + "$"
+	var changed bool
+	for name, e := range env {
+		if e.Public || strings.HasPrefix(e.Value, prefix) {
+			continue
+		}
+		value, err := decryptValue(e.Value)
+		if err != nil {
+			return false, fmt.Errorf("Failed to decrypt %s: %s", name, err)
+		}
+		if e.Value, err = encryptValue(value); err != nil {
+			return false, err
+		}
+		env[name] = e
+		changed = true
+	}
+	return changed, nil
+}
+
+// RotateEnvKey re-encrypts the private environment variables of all apps with
+// the key declared in env:encryption-key. Variables encrypted with the key
+// declared in env:previous-encryption-key and variables stored before the
+// encryption of private variables are re-encrypted, including the ones kept in
+// the env history of the apps.
+//
+// It returns the number of apps that had variables re-encrypted.
+func RotateEnvKey() (int, error) {
+	var (
+		a     App
+		v     EnvVersion
+		count int
+		errs  bytes.Buffer
+	)
+	iter := db.Session.Apps().Find(bson.M{"env": bson.M{"$ne": nil}}).Iter()
+	for iter.Next(&a) {
+		var changed bool
+		err := a.modify(func() (bson.M, error) {
+			var err error
+			changed, err = reencryptEnv(a.Env)
+			if err != nil || !changed {
+				return nil, err
+			}
+			return bson.M{"$set": bson.M{"env": a.Env}}, nil
+		})
+		if err == nil && changed {
+			count++
+		}
+		if err != nil {
+			fmt.Fprintf(&errs, "App %s: %s\n", a.Name, err)
+		}
+		a = App{}
+	}
+	if err := iter.Close(); err != nil {
+		return count, err
+	}
+	iter = db.Session.EnvVersions().Find(nil).Iter()
+	for iter.Next(&v) {
+		changed, err := reencryptEnv(v.Env)
+		if err == nil && changed {
+			query := bson.M{"app": v.App, "version": v.Version}
+			err = db.Session.EnvVersions().Update(query, bson.M{"$set": bson.M{"env": v.Env}})
+		}
+		if err != nil {
+			fmt.Fprintf(&errs, "App %s, env version %d: %s\n", v.App, v.Version, err)
+		}
+		v = EnvVersion{}
+	}
+	if err := iter.Close(); err != nil {
+		return count, err
+	}
+	if errs.Len() > 0 {
+		return count, errors.New(strings.TrimSpace(errs.String()))
+	}
+	return count, nil
+}
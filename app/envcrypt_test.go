@@ -0,0 +1,145 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/api/bind"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/provision"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"strings"
+)
+
+func (s *S) TestEncryptValue(c *C) {
+	encrypted, err := encryptValue("s3cr3t")
+	c.Assert(err, IsNil)
+	c.Assert(isEncrypted(encrypted), Equals, true)
+	c.Assert(strings.Contains(encrypted, "s3cr3t"), Equals, false)
+	other, err := encryptValue("s3cr3t")
+	c.Assert(err, IsNil)
+	c.Assert(other, Not(Equals), encrypted)
+	value, err := decryptValue(encrypted)
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "s3cr3t")
+}
+
+func (s *S) TestEncryptValueWithoutKey(c *C) {
+	old, _ := config.Get("env:encryption-key")
+	defer config.Set("env:encryption-key", old)
+	config.Set("env:encryption-key", "")
+	_, err := encryptValue("s3cr3t")
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `The setting "env:encryption-key" is required to store private environment variables.`)
+}
+
+func (s *S) TestDecryptValueNotEncrypted(c *C) {
+	value, err := decryptValue("plain value")
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "plain value")
+}
+
+func (s *S) TestDecryptValueTampered(c *C) {
+	encrypted, err := encryptValue("s3cr3t")
+	c.Assert(err, IsNil)
+	tampered := encrypted[:len(encrypted)-4] + "AAA="
+	_, err = decryptValue(tampered)
+	c.Assert(err, Equals, errInvalidCiphertext)
+}
+
+func (s *S) TestDecryptValueWithPreviousKey(c *C) {
+	encrypted, err := encryptValue("s3cr3t")
+	c.Assert(err, IsNil)
+	old, _ := config.Get("env:encryption-key")
+	defer config.Set("env:encryption-key", old)
+	config.Set("env:encryption-key", "a-brand-new-key")
+	_, err = decryptValue(encrypted)
+	c.Assert(err, NotNil)
+	config.Set("env:previous-encryption-key", old)
+	defer config.Set("env:previous-encryption-key", "")
+	value, err := decryptValue(encrypted)
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "s3cr3t")
+}
+
+func (s *S) TestSetEnvEncryptsPrivateVariables(c *C) {
+	a := App{Name: "secretive"}
+	err := a.setEnv(bind.EnvVar{Name: "DATABASE_PASSWORD", Value: "s3cr3t", Public: false})
+	c.Assert(err, IsNil)
+	err = a.setEnv(bind.EnvVar{Name: "DATABASE_HOST", Value: "localhost", Public: true})
+	c.Assert(err, IsNil)
+	c.Assert(isEncrypted(a.Env["DATABASE_PASSWORD"].Value), Equals, true)
+	c.Assert(a.Env["DATABASE_HOST"].Value, Equals, "localhost")
+	var logs []Applog
+	err = db.Session.Logs().Find(bson.M{"appname": a.Name}).All(&logs)
+	c.Assert(err, IsNil)
+	for _, l := range logs {
+		c.Assert(strings.Contains(l.Message, "s3cr3t"), Equals, false)
+	}
+}
+
+func (s *S) TestInstanceEnvDecryptsPrivateVariables(c *C) {
+	a := App{Name: "secretive"}
+	err := a.setEnv(bind.EnvVar{Name: "DATABASE_PASSWORD", Value: "s3cr3t", InstanceName: "mysql"})
+	c.Assert(err, IsNil)
+	expected := map[string]bind.EnvVar{
+		"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Value: "s3cr3t", InstanceName: "mysql"},
+	}
+	c.Assert(a.InstanceEnv("mysql"), DeepEquals, expected)
+}
+
+func (s *S) TestSerializeEnvVarsDecryptsPrivateVariables(c *C) {
+	s.provisioner.PrepareOutput([]byte("exported"))
+	a := App{Name: "secretive", State: string(provision.StatusStarted)}
+	err := a.setEnv(bind.EnvVar{Name: "DATABASE_PASSWORD", Value: "s3cr3t"})
+	c.Assert(err, IsNil)
+	err = a.SerializeEnvVars()
+	c.Assert(err, IsNil)
+	cmds := s.provisioner.GetCmds("", &a)
+	c.Assert(cmds, HasLen, 1)
+	c.Assert(strings.Contains(cmds[0].Cmd, `export DATABASE_PASSWORD='s3cr3t'`), Equals, true)
+}
+
+func (s *S) TestRotateEnvKey(c *C) {
+	a := App{
+		Name: "secretive",
+		Env: map[string]bind.EnvVar{
+			"DATABASE_HOST":     {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+			"DATABASE_USER":     {Name: "DATABASE_USER", Value: "root", Public: false},
+			"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Public: false},
+		},
+	}
+	encrypted, err := encryptValue("s3cr3t")
+	c.Assert(err, IsNil)
+	a.Env["DATABASE_PASSWORD"] = bind.EnvVar{Name: "DATABASE_PASSWORD", Value: encrypted, Public: false}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	old, _ := config.Get("env:encryption-key")
+	defer config.Set("env:encryption-key", old)
+	config.Set("env:encryption-key", "a-brand-new-key")
+	config.Set("env:previous-encryption-key", old)
+	defer config.Set("env:previous-encryption-key", "")
+	count, err := RotateEnvKey()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 1)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Env["DATABASE_HOST"].Value, Equals, "localhost")
+	c.Assert(a.Env["DATABASE_PASSWORD"].Value, Not(Equals), encrypted)
+	config.Set("env:previous-encryption-key", "")
+	envs := map[string]string{"DATABASE_USER": "root", "DATABASE_PASSWORD": "s3cr3t"}
+	for name, expected := range envs {
+		value := a.Env[name].Value
+		c.Assert(isEncrypted(value), Equals, true)
+		value, err = decryptValue(value)
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, expected)
+	}
+	count, err = RotateEnvKey()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 0)
+}
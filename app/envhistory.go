@@ -0,0 +1,178 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"github.com/globocom/tsuru/api/bind"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/queue"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"sort"
+	"time"
+)
+
+// EnvVersion is a version of the environment variables of an app, recorded
+// every time they change: who changed them, when, and which variables were
+// added, changed or removed. Values of private variables are masked in the
+// list of changes.
+//
+// Env holds the complete set of variables in the version, with private values
+// encrypted, and is used to revert the app to the version. It's never sent to
+// clients.
+type EnvVersion struct {
+	App       string
+	Version   int
+	User      string
+	Timestamp time.Time
+	Added     []string
+	Changed   []string
+	Removed   []string
+	Revert    int
+	Env       map[string]bind.EnvVar `json:"-"`
+}
+
+// maskEnv formats the variable as NAME=value, masking the value of private
+// variables.
+func maskEnv(env bind.EnvVar) string {
+	if env.Public {
+		return env.Name + "=" + env.Value
+	}
+	return env.Name + "=***"
+}
+
+// plainValue returns the value of the variable, decrypting it if needed. It
+// doesn't fail: when the value can't be decrypted, the stored value is
+// returned.
+func plainValue(env bind.EnvVar) string {
+	if value, err := decryptValue(env.Value); err == nil {
+		return value
+	}
+	return env.Value
+}
+
+// diffEnvs compares two sets of variables, returning the masked variables
+// that were added and changed, and the names of the removed variables.
+func diffEnvs(old, new map[string]bind.EnvVar) (added, changed, removed []string) {
+	for name, env := range new {
+		if previous, ok := old[name]; !ok {
+			added = append(added, maskEnv(env))
+		} else if previous.Public != env.Public || plainValue(previous) != plainValue(env) {
+			changed = append(changed, maskEnv(env))
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(changed)
+	sort.Strings(removed)
+	return added, changed, removed
+}
+
+func (a *App) copyEnv() map[string]bind.EnvVar {
+	env := make(map[string]bind.EnvVar, len(a.Env))
+	for name, value := range a.Env {
+		env[name] = value
+	}
+	return env
+}
+
+// recordEnvVersion saves a new version of the environment variables of the
+// app, comparing them with the given previous set. Nothing is recorded if the
+// variables didn't change, unless the change is a revert.
+func (a *App) recordEnvVersion(old map[string]bind.EnvVar, user string, revert int) error {
+	added, changed, removed := diffEnvs(old, a.Env)
+	if len(added)+len(changed)+len(removed) == 0 && revert == 0 {
+		return nil
+	}
+	var last EnvVersion
+	err := db.Session.EnvVersions().Find(bson.M{"app": a.Name}).Sort("-version").One(&last)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	version := EnvVersion{
+		App:       a.Name,
+		Version:   last.Version + 1,
+		User:      user,
+		Timestamp: time.Now(),
+		Added:     added,
+		Changed:   changed,
+		Removed:   removed,
+		Revert:    revert,
+		Env:       a.copyEnv(),
+	}
+	return db.Session.EnvVersions().Insert(version)
+}
+
+// EnvHistory returns the versions of the environment variables of the app,
+// most recent first.
+func (a *App) EnvHistory() ([]EnvVersion, error) {
+	var versions []EnvVersion
+	err := db.Session.EnvVersions().Find(bson.M{"app": a.Name}).Sort("-version").All(&versions)
+	return versions, err
+}
+
+// RevertEnv restores the variables set by users to the given version,
+// recording the revert as a new version, and enqueues the regeneration of the
+// apprc file in the units of the app. Variables exported by service instances
+// are not reverted: the ones currently bound to the app are kept.
+func (a *App) RevertEnv(version int, user string) error {
+	var v EnvVersion
+	err := db.Session.EnvVersions().Find(bson.M{"app": a.Name, "version": version}).One(&v)
+	if err == mgo.ErrNotFound {
+		msg := fmt.Sprintf("Version %d not found in the env history of the app %s.", version, a.Name)
+		return &ValidationError{Message: msg}
+	} else if err != nil {
+		return err
+	}
+	old := a.copyEnv()
+	set, unset := bson.M{}, bson.M{}
+	for name, env := range old {
+		if env.InstanceName != "" {
+			continue
+		}
+		if previous, ok := v.Env[name]; !ok || previous.InstanceName != "" {
+			unset["env."+name] = ""
+			delete(a.Env, name)
+		}
+	}
+	for name, env := range v.Env {
+		if env.InstanceName != "" {
+			continue
+		}
+		if current, ok := old[name]; ok && current.InstanceName != "" {
+			continue
+		}
+		set["env."+name] = env
+		if a.Env == nil {
+			a.Env = make(map[string]bind.EnvVar)
+		}
+		a.Env[name] = env
+	}
+	change := bson.M{}
+	if len(set) > 0 {
+		if err := a.initField("env", bson.M{}); err != nil {
+			return err
+		}
+		change["$set"] = set
+	}
+	if len(unset) > 0 {
+		change["$unset"] = unset
+	}
+	if len(change) > 0 {
+		if err := a.update(change); err != nil {
+			return err
+		}
+	}
+	a.Log(fmt.Sprintf("reverting env to version %d", version), "tsuru")
+	if err := a.recordEnvVersion(old, user, version); err != nil {
+		return err
+	}
+	return a.enqueue(queue.Message{Action: RegenerateApprc, Args: []string{a.Name}})
+}
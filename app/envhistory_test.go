@@ -0,0 +1,166 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/api/bind"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/queue"
+	"github.com/globocom/tsuru/testing"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) TestDiffEnvs(c *C) {
+	password, err := encryptValue("s3cr3t")
+	c.Assert(err, IsNil)
+	samePassword, err := encryptValue("s3cr3t")
+	c.Assert(err, IsNil)
+	old := map[string]bind.EnvVar{
+		"DATABASE_HOST":     {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+		"DATABASE_USER":     {Name: "DATABASE_USER", Value: "root", Public: true},
+		"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Value: password},
+		"DEBUG":             {Name: "DEBUG", Value: "1", Public: true},
+	}
+	new := map[string]bind.EnvVar{
+		"DATABASE_HOST":     {Name: "DATABASE_HOST", Value: "remotehost", Public: true},
+		"DATABASE_USER":     {Name: "DATABASE_USER", Value: "root", Public: true},
+		"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Value: samePassword},
+		"SECRET_KEY":        {Name: "SECRET_KEY", Value: "abc"},
+		"WORKERS":           {Name: "WORKERS", Value: "4", Public: true},
+	}
+	added, changed, removed := diffEnvs(old, new)
+	c.Assert(added, DeepEquals, []string{"SECRET_KEY=***", "WORKERS=4"})
+	c.Assert(changed, DeepEquals, []string{"DATABASE_HOST=remotehost"})
+	c.Assert(removed, DeepEquals, []string{"DEBUG"})
+}
+
+func (s *S) TestSetEnvsToAppRecordsEnvVersion(c *C) {
+	a := App{Name: "versioned"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvVersions().RemoveAll(bson.M{"app": a.Name})
+	envs := []bind.EnvVar{
+		{Name: "DATABASE_HOST", Value: "localhost", Public: true},
+		{Name: "DATABASE_PASSWORD", Value: "s3cr3t", Public: false},
+	}
+	err = a.SetEnvsToApp(envs, false, false, "jimmy@page.com")
+	c.Assert(err, IsNil)
+	err = a.UnsetEnvsFromApp([]string{"DATABASE_HOST"}, false, false, "robert@plant.com")
+	c.Assert(err, IsNil)
+	versions, err := a.EnvHistory()
+	c.Assert(err, IsNil)
+	c.Assert(versions, HasLen, 2)
+	c.Assert(versions[0].Version, Equals, 2)
+	c.Assert(versions[0].User, Equals, "robert@plant.com")
+	c.Assert(versions[0].Removed, DeepEquals, []string{"DATABASE_HOST"})
+	c.Assert(versions[0].Env, HasLen, 1)
+	c.Assert(versions[1].Version, Equals, 1)
+	c.Assert(versions[1].User, Equals, "jimmy@page.com")
+	c.Assert(versions[1].Added, DeepEquals, []string{"DATABASE_HOST=localhost", "DATABASE_PASSWORD=***"})
+	c.Assert(versions[1].Env["DATABASE_PASSWORD"].Value, Not(Equals), "s3cr3t")
+}
+
+func (s *S) TestSetEnvsToAppDoesNotRecordEnvVersionWithoutChanges(c *C) {
+	a := App{
+		Name: "versioned",
+		Env: map[string]bind.EnvVar{
+			"DATABASE_HOST": {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	envs := []bind.EnvVar{{Name: "DATABASE_HOST", Value: "localhost", Public: true}}
+	err = a.SetEnvsToApp(envs, false, false, "jimmy@page.com")
+	c.Assert(err, IsNil)
+	versions, err := a.EnvHistory()
+	c.Assert(err, IsNil)
+	c.Assert(versions, HasLen, 0)
+}
+
+func (s *S) TestRevertEnv(c *C) {
+	server := testing.FakeQueueServer{}
+	server.Start("127.0.0.1:0")
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err != nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	a := App{Name: "versioned"}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvVersions().RemoveAll(bson.M{"app": a.Name})
+	envs := []bind.EnvVar{{Name: "DATABASE_HOST", Value: "localhost", Public: true}}
+	err = a.SetEnvsToApp(envs, false, true, "jimmy@page.com")
+	c.Assert(err, IsNil)
+	envs = []bind.EnvVar{{Name: "DATABASE_HOST", Value: "remotehost", Public: true}}
+	err = a.SetEnvsToApp(envs, false, true, "jimmy@page.com")
+	c.Assert(err, IsNil)
+	err = a.RevertEnv(1, "john@bonham.com")
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Env["DATABASE_HOST"].Value, Equals, "localhost")
+	versions, err := a.EnvHistory()
+	c.Assert(err, IsNil)
+	c.Assert(versions, HasLen, 3)
+	c.Assert(versions[0].Version, Equals, 3)
+	c.Assert(versions[0].Revert, Equals, 1)
+	c.Assert(versions[0].User, Equals, "john@bonham.com")
+	c.Assert(versions[0].Changed, DeepEquals, []string{"DATABASE_HOST=localhost"})
+	time.Sleep(1e6)
+	expected := queue.Message{Action: RegenerateApprc, Args: []string{a.Name}}
+	messages := server.Messages()
+	c.Assert(messages[len(messages)-1], DeepEquals, expected)
+}
+
+func (s *S) TestRevertEnvKeepsTheVariablesOfServiceInstances(c *C) {
+	a := App{Name: "versioned"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvVersions().RemoveAll(bson.M{"app": a.Name})
+	envs := []bind.EnvVar{
+		{Name: "DATABASE_HOST", Value: "localhost", Public: true},
+		{Name: "REDIS_HOST", Value: "redis.tsuru.io", Public: true, InstanceName: "redis"},
+	}
+	err = a.SetEnvsToApp(envs, false, true, "jimmy@page.com")
+	c.Assert(err, IsNil)
+	err = a.UnsetEnvsFromApp([]string{"REDIS_HOST"}, false, true, "tsuru")
+	c.Assert(err, IsNil)
+	envs = []bind.EnvVar{
+		{Name: "DATABASE_HOST", Value: "remotehost", Public: true},
+		{Name: "MYSQL_HOST", Value: "mysql.tsuru.io", Public: true, InstanceName: "mysql"},
+		{Name: "LOG_LEVEL", Value: "debug", Public: true},
+	}
+	err = a.SetEnvsToApp(envs, false, true, "jimmy@page.com")
+	c.Assert(err, IsNil)
+	err = a.RevertEnv(1, "john@bonham.com")
+	c.Assert(err, IsNil)
+	stored := App{Name: a.Name}
+	err = stored.Get()
+	c.Assert(err, IsNil)
+	for _, env := range []map[string]bind.EnvVar{a.Env, stored.Env} {
+		c.Assert(env, HasLen, 2)
+		c.Assert(env["DATABASE_HOST"].Value, Equals, "localhost")
+		c.Assert(env["MYSQL_HOST"].Value, Equals, "mysql.tsuru.io")
+		c.Assert(env["MYSQL_HOST"].InstanceName, Equals, "mysql")
+	}
+}
+
+func (s *S) TestRevertEnvUnknownVersion(c *C) {
+	a := App{Name: "versioned"}
+	err := a.RevertEnv(10, "john@bonham.com")
+	c.Assert(err, NotNil)
+	e, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Message, Equals, "Version 10 not found in the env history of the app versioned.")
+}
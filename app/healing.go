@@ -0,0 +1,89 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"github.com/globocom/tsuru/provision"
+	"labix.org/v2/mgo/bson"
+	"time"
+)
+
+// DefaultStuckTimeout is how long a unit may stay in the error or down state
+// before the collector considers it stuck, unless the healing policy of the
+// app says otherwise.
+const DefaultStuckTimeout = 10 * time.Minute
+
+// HealingPolicy is the self-healing policy of an app. When it's enabled, the
+// collector replaces the units that are stuck in the error or down state for
+// longer than After, adding a new unit of the same process type and removing
+// the stuck one.
+type HealingPolicy struct {
+	Enabled bool
+	After   time.Duration
+}
+
+// StuckTimeout returns how long units of the app may stay in the error or down
+// state before being considered stuck.
+func (p HealingPolicy) StuckTimeout() time.Duration {
+	if p.After > 0 {
+		return p.After
+	}
+	return DefaultStuckTimeout
+}
+
+// SetHealingPolicy changes the self-healing policy of the app, on behalf of
+// the given user, and logs the change in the app log.
+func (a *App) SetHealingPolicy(policy HealingPolicy, user string) error {
+	if policy.After < 0 {
+		return &ValidationError{Message: "The healing timeout can't be negative."}
+	}
+	a.Healing = policy
+	if err := a.update(bson.M{"$set": bson.M{"healing": a.Healing}}); err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("Self-healing turned off by %s", user)
+	if policy.Enabled {
+		msg = fmt.Sprintf("Self-healing turned on by %s, replacing units stuck for %s", user, policy.StuckTimeout())
+	}
+	return a.Log(msg, "tsuru")
+}
+
+// StuckUnits returns the units of the app that have been in the error or down
+// state for longer than the stuck timeout of its healing policy, at now.
+//
+// Units of stopped apps are down on purpose, so they are never stuck.
+func (a *App) StuckUnits(now time.Time) []Unit {
+	if a.Stopped {
+		return nil
+	}
+	var units []Unit
+	for _, u := range a.Units {
+		if u.State != provision.StatusError.String() && u.State != provision.StatusDown.String() {
+			continue
+		}
+		if !u.StateSince.IsZero() && now.Sub(u.StateSince) > a.Healing.StuckTimeout() {
+			units = append(units, u)
+		}
+	}
+	return units
+}
+
+// Heal replaces the given unit, which must be stuck, by a new unit running the
+// same process type. The new unit is added before the stuck one is removed, so
+// the app never loses capacity.
+func (a *App) Heal(u Unit) error {
+	a.Log(fmt.Sprintf("Replacing the unit %s, stuck in the %s state since %s.",
+		u.Name, u.State, u.StateSince.Format("2006-01-02 15:04:05")), "tsuru")
+	if err := a.AddUnits(1, u.GetProcessType()); err != nil {
+		a.Log(fmt.Sprintf("Failed to replace the unit %s: %s", u.Name, err), "tsuru")
+		return err
+	}
+	if err := a.RemoveUnit(u.Name); err != nil {
+		a.Log(fmt.Sprintf("Failed to remove the unit %s: %s", u.Name, err), "tsuru")
+		return err
+	}
+	return nil
+}
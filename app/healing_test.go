@@ -0,0 +1,107 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/provision"
+	"github.com/globocom/tsuru/testing"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) TestHealingPolicyStuckTimeout(c *C) {
+	c.Assert(HealingPolicy{}.StuckTimeout(), Equals, DefaultStuckTimeout)
+	c.Assert(HealingPolicy{After: time.Minute}.StuckTimeout(), Equals, time.Minute)
+}
+
+func (s *S) TestSetHealingPolicy(c *C) {
+	a := App{Name: "healthy"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.SetHealingPolicy(HealingPolicy{Enabled: true, After: 5 * time.Minute}, "ops@tsuru.io")
+	c.Assert(err, IsNil)
+	var stored App
+	err = db.Session.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.Healing, DeepEquals, HealingPolicy{Enabled: true, After: 5 * time.Minute})
+	var l Applog
+	err = db.Session.Logs().Find(bson.M{"appname": a.Name}).One(&l)
+	c.Assert(err, IsNil)
+	c.Assert(l.Message, Equals, "Self-healing turned on by ops@tsuru.io, replacing units stuck for 5m0s")
+}
+
+func (s *S) TestSetHealingPolicyWithNegativeTimeout(c *C) {
+	a := App{Name: "healthy"}
+	err := a.SetHealingPolicy(HealingPolicy{Enabled: true, After: -time.Minute}, "ops@tsuru.io")
+	c.Assert(err, NotNil)
+	_, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+}
+
+func (s *S) TestStuckUnits(c *C) {
+	now := time.Now()
+	a := App{
+		Name:    "healthy",
+		State:   StateDegraded,
+		Healing: HealingPolicy{After: time.Minute},
+		Units: []Unit{
+			{Name: "healthy/0", State: "started", StateSince: now.Add(-time.Hour)},
+			{Name: "healthy/1", State: "error", StateSince: now.Add(-2 * time.Minute)},
+			{Name: "healthy/2", State: "down", StateSince: now.Add(-30 * time.Second)},
+			{Name: "healthy/3", State: "down", StateSince: now.Add(-time.Hour)},
+		},
+	}
+	units := a.StuckUnits(now)
+	c.Assert(units, HasLen, 2)
+	c.Assert(units[0].Name, Equals, "healthy/1")
+	c.Assert(units[1].Name, Equals, "healthy/3")
+}
+
+func (s *S) TestStuckUnitsOfStoppedApps(c *C) {
+	now := time.Now()
+	a := App{
+		Name:    "healthy",
+		State:   "down",
+		Stopped: true,
+		Units:   []Unit{{Name: "healthy/0", State: "down", StateSince: now.Add(-time.Hour)}},
+	}
+	c.Assert(a.StuckUnits(now), HasLen, 0)
+}
+
+func (s *S) TestHeal(c *C) {
+	server := testing.FakeQueueServer{}
+	server.Start("127.0.0.1:0")
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err == nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	a := App{Name: "healthy", Framework: "python"}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = a.AddUnits(2, "")
+	c.Assert(err, IsNil)
+	stuck := a.Units[1]
+	stuck.State = provision.StatusError.String()
+	err = a.Heal(stuck)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 2)
+	c.Assert(a.Units[0].Name, Equals, "healthy/0")
+	c.Assert(a.Units[1].Name, Equals, "healthy/2")
+	c.Assert(s.provisioner.GetUnits(&a), HasLen, 2)
+	n, err := db.Session.Logs().Find(bson.M{"appname": a.Name, "message": bson.RegEx{Pattern: "^Replacing the unit healthy/1"}}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+}
@@ -0,0 +1,144 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultHealthcheckStatus  = http.StatusOK
+	defaultHealthcheckTimeout = 60
+)
+
+// healthcheckInterval is the interval between probes of a unit that is not
+// healthy yet.
+var healthcheckInterval = time.Second
+
+// healthcheckClient returns the HTTP client used to probe units until the
+// given deadline. Connections are not reused, so the deadline set when dialing
+// bounds every probe by the time left in the healthcheck.
+func healthcheckClient(deadline time.Time) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				conn, err := net.DialTimeout(network, addr, 5*time.Second)
+				if err != nil {
+					return nil, err
+				}
+				conn.SetDeadline(deadline)
+				return conn, nil
+			},
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+// healthcheck is the healthcheck section of app.conf, for example:
+//
+//	healthcheck:
+//	  path: /healthcheck
+//	  status: 200
+//	  timeout: 60
+//	  rollback: true
+//
+// The timeout is given in seconds. When rollback is true, tsuru rolls the app
+// back to its previous deploy if the units don't become healthy.
+type healthcheck struct {
+	Path     string
+	Status   int
+	Timeout  int
+	Rollback bool
+}
+
+// expectedStatus returns the status that healthy units respond with.
+func (hc *healthcheck) expectedStatus() int {
+	if hc.Status == 0 {
+		return defaultHealthcheckStatus
+	}
+	return hc.Status
+}
+
+// HealthcheckError is returned by CheckHealth when a unit of the app doesn't
+// become healthy within the healthcheck timeout.
+type HealthcheckError struct {
+	Unit     string
+	Reason   string
+	Rollback bool
+}
+
+func (err *HealthcheckError) Error() string {
+	return fmt.Sprintf("Unit %s is not healthy: %s.", err.Unit, err.Reason)
+}
+
+// CheckHealth probes all units of the app using the healthcheck declared in
+// app.conf, waiting for each of them to respond with the expected status. If
+// the app doesn't declare a healthcheck, CheckHealth does nothing.
+//
+// The results are written to w and to the app log.
+func (a *App) CheckHealth(w io.Writer) error {
+	if err := a.loadHooks(); err != nil {
+		return err
+	}
+	hc := a.hooks.Healthcheck
+	if hc == nil || hc.Path == "" {
+		a.Log("Skipping healthcheck...", "tsuru")
+		return nil
+	}
+	status := hc.expectedStatus()
+	timeout := hc.Timeout
+	if timeout <= 0 {
+		timeout = defaultHealthcheckTimeout
+	}
+	a.Log(fmt.Sprintf("Checking health of the units at %s...", hc.Path), "tsuru")
+	err := write(w, []byte("\n ---> Running healthcheck\n"))
+	if err != nil {
+		return err
+	}
+	for _, u := range a.Units {
+		reason := probeUnit(u.Ip, hc.Path, status, time.Duration(timeout)*time.Second)
+		if reason != "" {
+			herr := &HealthcheckError{Unit: u.Name, Reason: reason, Rollback: hc.Rollback}
+			a.Log(herr.Error(), "tsuru")
+			return herr
+		}
+		msg := fmt.Sprintf("Unit %s is healthy.", u.Name)
+		a.Log(msg, "tsuru")
+		if err = write(w, []byte(" "+msg+"\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// probeUnit sends requests to path in the unit with the given ip until it
+// responds with the expected status or the timeout is reached. It returns an
+// empty string when the unit is healthy, or the reason of the last failure.
+func probeUnit(ip, path string, status int, timeout time.Duration) string {
+	url := fmt.Sprintf("http://%s%s", ip, path)
+	deadline := time.Now().Add(timeout)
+	client := healthcheckClient(deadline)
+	var reason string
+	for {
+		resp, err := client.Get(url)
+		if err != nil {
+			reason = err.Error()
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode == status {
+				return ""
+			}
+			reason = fmt.Sprintf("expected status %d, got %d", status, resp.StatusCode)
+		}
+		if time.Now().Add(healthcheckInterval).After(deadline) {
+			return reason
+		}
+		time.Sleep(healthcheckInterval)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+func (s *S) TestLoadHooksWithHealthcheck(c *C) {
+	output := `pre-restart:
+  - testdata/pre.sh
+healthcheck:
+  path: /healthcheck
+  status: 204
+  timeout: 30
+  rollback: true
+`
+	s.provisioner.PrepareOutput([]byte(output))
+	a := App{Name: "something", Framework: "django"}
+	err := a.loadHooks()
+	c.Assert(err, IsNil)
+	expected := &healthcheck{Path: "/healthcheck", Status: 204, Timeout: 30, Rollback: true}
+	c.Assert(a.hooks.Healthcheck, DeepEquals, expected)
+}
+
+func (s *S) TestLoadHooksWithInvalidHealthcheckPath(c *C) {
+	output := `healthcheck:
+  path: healthcheck
+`
+	s.provisioner.PrepareOutput([]byte(output))
+	a := App{Name: "something", Framework: "django"}
+	err := a.loadHooks()
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `Invalid healthcheck path "healthcheck", it must start with a slash.`)
+	c.Assert(a.hooks, IsNil)
+}
+
+func (s *S) TestCheckHealthWithoutHealthcheck(c *C) {
+	a := App{
+		Name:  "something",
+		Units: []Unit{{Name: "something/0", Ip: "10.10.10.1"}},
+		hooks: &conf{},
+	}
+	var w bytes.Buffer
+	err := a.CheckHealth(&w)
+	c.Assert(err, IsNil)
+	c.Assert(w.String(), Equals, "")
+}
+
+func (s *S) TestCheckHealth(c *C) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+	}))
+	defer server.Close()
+	ip := server.URL[len("http://"):]
+	a := App{
+		Name: "healthy",
+		Units: []Unit{
+			{Name: "healthy/0", Ip: ip},
+			{Name: "healthy/1", Ip: ip},
+		},
+		hooks: &conf{Healthcheck: &healthcheck{Path: "/healthcheck"}},
+	}
+	var w bytes.Buffer
+	err := a.CheckHealth(&w)
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"/healthcheck", "/healthcheck"})
+	c.Assert(w.String(), Equals, "\n ---> Running healthcheck\n Unit healthy/0 is healthy.\n Unit healthy/1 is healthy.\n")
+}
+
+func (s *S) TestCheckHealthWaitsForTheUnit(c *C) {
+	old := healthcheckInterval
+	healthcheckInterval = 10 * time.Millisecond
+	defer func() { healthcheckInterval = old }()
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	a := App{
+		Name:  "healthy",
+		Units: []Unit{{Name: "healthy/0", Ip: server.URL[len("http://"):]}},
+		hooks: &conf{Healthcheck: &healthcheck{Path: "/", Timeout: 5}},
+	}
+	var w bytes.Buffer
+	err := a.CheckHealth(&w)
+	c.Assert(err, IsNil)
+	c.Assert(calls, Equals, 3)
+}
+
+func (s *S) TestCheckHealthUnitThatDoesNotRespond(c *C) {
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	a := App{
+		Name:  "unhealthy",
+		Units: []Unit{{Name: "unhealthy/0", Ip: server.URL[len("http://"):]}},
+		hooks: &conf{Healthcheck: &healthcheck{Path: "/", Timeout: 1}},
+	}
+	var w bytes.Buffer
+	start := time.Now()
+	err := a.CheckHealth(&w)
+	c.Assert(err, NotNil)
+	c.Assert(err, FitsTypeOf, &HealthcheckError{})
+	c.Assert(time.Since(start) < 3*time.Second, Equals, true)
+}
+
+func (s *S) TestCheckHealthUnhealthyUnit(c *C) {
+	old := healthcheckInterval
+	healthcheckInterval = 100 * time.Millisecond
+	defer func() { healthcheckInterval = old }()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	a := App{
+		Name:  "unhealthy",
+		Units: []Unit{{Name: "unhealthy/0", Ip: server.URL[len("http://"):]}},
+		hooks: &conf{Healthcheck: &healthcheck{Path: "/", Timeout: 1, Rollback: true}},
+	}
+	var w bytes.Buffer
+	err := a.CheckHealth(&w)
+	c.Assert(err, NotNil)
+	e, ok := err.(*HealthcheckError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Unit, Equals, "unhealthy/0")
+	c.Assert(e.Rollback, Equals, true)
+	c.Assert(e.Error(), Equals, "Unit unhealthy/0 is not healthy: expected status 200, got 500.")
+}
@@ -0,0 +1,165 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/log"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"time"
+)
+
+var (
+	// LockTimeout is how long the lock of an app lasts without being renewed.
+	// Locks are renewed while their operations run, so expired locks are
+	// considered abandoned (by a tsuru server that died in the middle of an
+	// operation, for example), and are taken over by the next operation.
+	LockTimeout = 30 * time.Minute
+
+	// lockInterval is the interval between attempts to acquire the lock of
+	// an app, when waiting for it.
+	lockInterval = time.Second
+)
+
+// Lock serializes the operations that change an app, like deploys, restarts
+// and scaling. There is at most one lock per app, stored in the database, and
+// it identifies the user that holds it and the operation being run.
+type Lock struct {
+	App       string `bson:"_id"`
+	Owner     string
+	Operation string
+	Token     string `json:"-"`
+	Acquired  time.Time
+	Expires   time.Time
+	released  chan bool
+}
+
+// LockedError is returned when the lock of an app is held by another
+// operation.
+type LockedError struct {
+	Lock Lock
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("The app %s is locked: %s is running the %s operation since %s.",
+		e.Lock.App, e.Lock.Owner, e.Lock.Operation, e.Lock.Acquired.Format("2006-01-02 15:04:05"))
+}
+
+// AcquireLock acquires the lock of the app for the given owner and operation.
+// If the app is locked, AcquireLock waits up to wait for the lock to be
+// released, and then returns a *LockedError. A zero wait means the lock is
+// tried only once.
+//
+// While the lock is held, it is renewed every third of LockTimeout, so long
+// operations, like deploys and rolling restarts, don't lose it. The lock must
+// be released with Release when the operation finishes.
+func AcquireLock(appName, owner, operation string, wait time.Duration) (*Lock, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		lock, err := tryLock(appName, owner, operation, true)
+		if err == nil {
+			lock.released = make(chan bool)
+			go lock.keepAlive()
+			return lock, nil
+		}
+		if _, ok := err.(*LockedError); !ok || !time.Now().Before(deadline) {
+			return nil, err
+		}
+		time.Sleep(lockInterval)
+	}
+}
+
+// tryLock makes a single attempt to acquire the lock of the app. Inserting the
+// lock document fails if the app is already locked; in this case, the current
+// lock is taken over if it's expired.
+func tryLock(appName, owner, operation string, retry bool) (*Lock, error) {
+	now := time.Now()
+	lock := Lock{
+		App:       appName,
+		Owner:     owner,
+		Operation: operation,
+		Token:     bson.NewObjectId().Hex(),
+		Acquired:  now,
+		Expires:   now.Add(LockTimeout),
+	}
+	err := db.Session.AppLocks().Insert(&lock)
+	if err == nil {
+		return &lock, nil
+	}
+	var current Lock
+	ferr := db.Session.AppLocks().FindId(appName).One(&current)
+	if ferr == mgo.ErrNotFound && retry {
+		// the lock was released after the insert failed.
+		return tryLock(appName, owner, operation, false)
+	} else if ferr != nil {
+		return nil, err
+	}
+	if current.Expires.After(now) {
+		return nil, &LockedError{Lock: current}
+	}
+	err = db.Session.AppLocks().Update(bson.M{"_id": appName, "token": current.Token}, &lock)
+	if err == mgo.ErrNotFound {
+		// another operation took the expired lock over first.
+		return nil, &LockedError{Lock: current}
+	} else if err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// keepAlive renews the lock until it is released, or until it is taken over
+// or removed by someone else.
+func (l *Lock) keepAlive() {
+	for {
+		select {
+		case <-l.released:
+			return
+		case <-time.After(LockTimeout / 3):
+		}
+		err := db.Session.AppLocks().Update(
+			bson.M{"_id": l.App, "token": l.Token},
+			bson.M{"$set": bson.M{"expires": time.Now().Add(LockTimeout)}},
+		)
+		if err == mgo.ErrNotFound {
+			log.Printf("The lock of the app %q was lost, it will not be renewed.", l.App)
+			return
+		} else if err != nil {
+			log.Printf("Failed to renew the lock of the app %q: %s", l.App, err)
+		}
+	}
+}
+
+// Release releases the lock. It does nothing if the lock expired and was taken
+// over by another operation.
+func (l *Lock) Release() error {
+	if l.released != nil {
+		close(l.released)
+		l.released = nil
+	}
+	err := db.Session.AppLocks().Remove(bson.M{"_id": l.App, "token": l.Token})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// ForceUnlock removes the lock of the app, whoever holds it, returning the
+// removed lock. It should only be used to remove locks of operations that are
+// known to be dead.
+func ForceUnlock(appName string) (*Lock, error) {
+	var lock Lock
+	err := db.Session.AppLocks().FindId(appName).One(&lock)
+	if err == mgo.ErrNotFound {
+		return nil, &ValidationError{Message: fmt.Sprintf("The app %s is not locked.", appName)}
+	} else if err != nil {
+		return nil, err
+	}
+	if err = db.Session.AppLocks().RemoveId(appName); err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+	return &lock, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/tsuru/db"
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) TestAcquireLock(c *C) {
+	lock, err := AcquireLock("myapp", "me@tsuru.io", "deploy", 0)
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	c.Assert(lock.App, Equals, "myapp")
+	c.Assert(lock.Owner, Equals, "me@tsuru.io")
+	c.Assert(lock.Operation, Equals, "deploy")
+	c.Assert(lock.Expires.Sub(lock.Acquired), Equals, LockTimeout)
+	var stored Lock
+	err = db.Session.AppLocks().FindId("myapp").One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.Token, Equals, lock.Token)
+}
+
+func (s *S) TestAcquireLockFailsIfTheAppIsLocked(c *C) {
+	lock, err := AcquireLock("myapp", "me@tsuru.io", "deploy", 0)
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	_, err = AcquireLock("myapp", "you@tsuru.io", "add-units", 0)
+	c.Assert(err, NotNil)
+	e, ok := err.(*LockedError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Lock.Owner, Equals, "me@tsuru.io")
+	c.Assert(e.Lock.Operation, Equals, "deploy")
+	c.Assert(e.Error(), Matches, "The app myapp is locked: me@tsuru.io is running the deploy operation since .*")
+}
+
+func (s *S) TestAcquireLockWaitsForTheLockToBeReleased(c *C) {
+	old := lockInterval
+	lockInterval = 10 * time.Millisecond
+	defer func() { lockInterval = old }()
+	lock, err := AcquireLock("myapp", "me@tsuru.io", "deploy", 0)
+	c.Assert(err, IsNil)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		lock.Release()
+	}()
+	other, err := AcquireLock("myapp", "you@tsuru.io", "deploy", time.Second)
+	c.Assert(err, IsNil)
+	defer other.Release()
+	c.Assert(other.Owner, Equals, "you@tsuru.io")
+}
+
+func (s *S) TestAcquireLockGivesUpWaiting(c *C) {
+	old := lockInterval
+	lockInterval = 10 * time.Millisecond
+	defer func() { lockInterval = old }()
+	lock, err := AcquireLock("myapp", "me@tsuru.io", "deploy", 0)
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	_, err = AcquireLock("myapp", "you@tsuru.io", "deploy", 50*time.Millisecond)
+	_, ok := err.(*LockedError)
+	c.Assert(ok, Equals, true)
+}
+
+func (s *S) TestAcquireLockTakesExpiredLocksOver(c *C) {
+	expired := Lock{
+		App:       "myapp",
+		Owner:     "me@tsuru.io",
+		Operation: "deploy",
+		Token:     "abc",
+		Acquired:  time.Now().Add(-2 * LockTimeout),
+		Expires:   time.Now().Add(-LockTimeout),
+	}
+	err := db.Session.AppLocks().Insert(expired)
+	c.Assert(err, IsNil)
+	lock, err := AcquireLock("myapp", "you@tsuru.io", "restart", 0)
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	var stored Lock
+	err = db.Session.AppLocks().FindId("myapp").One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.Owner, Equals, "you@tsuru.io")
+	err = expired.Release()
+	c.Assert(err, IsNil)
+	n, err := db.Session.AppLocks().FindId("myapp").Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+}
+
+func (s *S) TestAcquireLockRenewsTheLock(c *C) {
+	old := LockTimeout
+	LockTimeout = 300 * time.Millisecond
+	defer func() { LockTimeout = old }()
+	lock, err := AcquireLock("myapp", "me@tsuru.io", "deploy", 0)
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	time.Sleep(500 * time.Millisecond)
+	var stored Lock
+	err = db.Session.AppLocks().FindId("myapp").One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.Expires.After(lock.Expires), Equals, true)
+	_, err = AcquireLock("myapp", "you@tsuru.io", "restart", 0)
+	_, ok := err.(*LockedError)
+	c.Assert(ok, Equals, true)
+}
+
+func (s *S) TestReleaseLock(c *C) {
+	lock, err := AcquireLock("myapp", "me@tsuru.io", "deploy", 0)
+	c.Assert(err, IsNil)
+	err = lock.Release()
+	c.Assert(err, IsNil)
+	n, err := db.Session.AppLocks().FindId("myapp").Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *S) TestForceUnlock(c *C) {
+	_, err := AcquireLock("myapp", "me@tsuru.io", "deploy", 0)
+	c.Assert(err, IsNil)
+	lock, err := ForceUnlock("myapp")
+	c.Assert(err, IsNil)
+	c.Assert(lock.Owner, Equals, "me@tsuru.io")
+	n, err := db.Session.AppLocks().FindId("myapp").Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *S) TestForceUnlockReturnsValidationErrorIfTheAppIsNotLocked(c *C) {
+	_, err := ForceUnlock("myapp")
+	c.Assert(err, NotNil)
+	e, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Message, Equals, "The app myapp is not locked.")
+}
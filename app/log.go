@@ -0,0 +1,237 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/log"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"strings"
+	"time"
+)
+
+// Applog represents a log entry of an app.
+type Applog struct {
+	Date    time.Time
+	Message string
+	Source  string
+	AppName string
+	Unit    string
+}
+
+// LogFilter is used to select log entries of an app. Zero values match any
+// entry.
+type LogFilter struct {
+	Source string
+	Unit   string
+	Since  time.Time
+	Until  time.Time
+}
+
+func (f *LogFilter) query(appName string) bson.M {
+	query := bson.M{"appname": appName}
+	if f.Source != "" {
+		query["source"] = f.Source
+	}
+	if f.Unit != "" {
+		query["unit"] = f.Unit
+	}
+	date := bson.M{}
+	if !f.Since.IsZero() {
+		date["$gte"] = f.Since
+	}
+	if !f.Until.IsZero() {
+		date["$lte"] = f.Until
+	}
+	if len(date) > 0 {
+		query["date"] = date
+	}
+	return query
+}
+
+// followTimeout is the time FollowLogs waits for new log entries before
+// checking whether it should stop following.
+var followTimeout = 5 * time.Second
+
+// Log adds a log message to the app. Each line of the message is stored as a
+// separate entry in the logs collection. Blank lines are ignored.
+func (a *App) Log(message string, source string) error {
+	return a.UnitLog(message, source, "")
+}
+
+// UnitLog works like Log, but records the name of the unit that generated the
+// message. Log entries are also forwarded to the drains of the app.
+func (a *App) UnitLog(message, source, unit string) error {
+	log.Printf(message)
+	messages := strings.Split(message, "\n")
+	logs := make([]Applog, 0, len(messages))
+	docs := make([]interface{}, 0, len(messages))
+	for _, msg := range messages {
+		if msg != "" {
+			l := Applog{
+				Date:    time.Now(),
+				Message: msg,
+				Source:  source,
+				AppName: a.Name,
+				Unit:    unit,
+			}
+			logs = append(logs, l)
+			docs = append(docs, l)
+		}
+	}
+	if len(logs) == 0 {
+		return nil
+	}
+	err := db.Session.Logs().Insert(docs...)
+	if err != nil {
+		return err
+	}
+	a.drain(logs)
+	return nil
+}
+
+// LastLogs returns the last lines log entries of the app that match the given
+// filter, in chronological order. If lines is zero, it returns all matching
+// log entries. Entries with the same date are returned in the order they were
+// added.
+func (a *App) LastLogs(lines int, filter LogFilter) ([]Applog, error) {
+	q := db.Session.Logs().Find(filter.query(a.Name)).Sort("-date", "-_id")
+	if lines > 0 {
+		q = q.Limit(lines)
+	}
+	var logs []Applog
+	err := q.All(&logs)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(logs)-1; i < j; i, j = i+1, j-1 {
+		logs[i], logs[j] = logs[j], logs[i]
+	}
+	return logs, nil
+}
+
+// FollowLogs waits for new log entries of the app that match the given
+// filter, calling fn with each of them, in the order they're added.
+//
+// Whenever no entries arrive for a while, fn is called with an empty slice,
+// so the caller has the chance to check whether it still wants to follow the
+// logs. FollowLogs returns the first error returned by fn, or nil when the
+// Until time of the filter is reached.
+//
+// When the cursor dies, following resumes from the date of the last entry,
+// skipping the entries with that date that were already delivered, so entries
+// added in the same millisecond are not lost.
+func (a *App) FollowLogs(filter LogFilter, fn func([]Applog) error) error {
+	pos := logPosition{date: time.Now()}
+	iter := a.tailLogs(filter, pos.date)
+	defer func() { iter.Close() }()
+	for {
+		for {
+			var entry struct {
+				Id     bson.ObjectId `bson:"_id"`
+				Applog `bson:",inline"`
+			}
+			if !iter.Next(&entry) {
+				break
+			}
+			if !pos.advance(entry.Date, entry.Id) {
+				continue
+			}
+			if err := fn([]Applog{entry.Applog}); err != nil {
+				return err
+			}
+		}
+		if !filter.Until.IsZero() && time.Now().After(filter.Until) {
+			return nil
+		}
+		if !iter.Timeout() {
+			if err := iter.Close(); err != nil {
+				return err
+			}
+			// the cursor is dead, usually because there were no
+			// entries to tail yet, so we wait and try again.
+			time.Sleep(followTimeout)
+			iter = a.tailLogs(filter, pos.date)
+		}
+		if err := fn(nil); err != nil {
+			return err
+		}
+	}
+}
+
+// logPosition is the position of FollowLogs in the logs collection: the date
+// of the last delivered entry, and the ids of the delivered entries with that
+// date.
+type logPosition struct {
+	date time.Time
+	ids  []bson.ObjectId
+}
+
+// advance moves the position to the given entry. It returns false if the
+// entry was already delivered.
+func (p *logPosition) advance(date time.Time, id bson.ObjectId) bool {
+	if !date.Equal(p.date) {
+		p.date = date
+		p.ids = []bson.ObjectId{id}
+		return true
+	}
+	for _, i := range p.ids {
+		if i == id {
+			return false
+		}
+	}
+	p.ids = append(p.ids, id)
+	return true
+}
+
+// tailLogs returns a tailable iterator, in insertion order, over the log
+// entries of the app that match the filter and are dated from the given time
+// on.
+func (a *App) tailLogs(filter LogFilter, since time.Time) *mgo.Iter {
+	query := filter.query(a.Name)
+	date := bson.M{"$gte": since}
+	if !filter.Until.IsZero() {
+		date["$lte"] = filter.Until
+	}
+	query["date"] = date
+	return db.Session.Logs().Find(query).Sort("$natural").Tail(followTimeout)
+}
+
+// MigrateLogs moves the log entries embedded in app documents, as stored by
+// previous versions of tsuru, to the logs collection. It's safe to call it
+// more than once: apps that have already been migrated are skipped.
+//
+// It returns the number of migrated log entries.
+func MigrateLogs() (int, error) {
+	var a struct {
+		Name string
+		Logs []Applog
+	}
+	query := bson.M{"logs": bson.M{"$exists": true}}
+	iter := db.Session.Apps().Find(query).Select(bson.M{"name": 1, "logs": 1}).Iter()
+	var n int
+	for iter.Next(&a) {
+		logs := make([]interface{}, len(a.Logs))
+		for i, l := range a.Logs {
+			l.AppName = a.Name
+			logs[i] = l
+		}
+		if len(logs) > 0 {
+			if err := db.Session.Logs().Insert(logs...); err != nil {
+				iter.Close()
+				return n, err
+			}
+		}
+		err := db.Session.Apps().Update(bson.M{"name": a.Name}, bson.M{"$unset": bson.M{"logs": 1}})
+		if err != nil {
+			iter.Close()
+			return n, err
+		}
+		n += len(logs)
+		a.Logs = nil
+	}
+	return n, iter.Close()
+}
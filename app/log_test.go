@@ -0,0 +1,187 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) TestLastLogs(c *C) {
+	a := App{Name: "lastlogsapp"}
+	for i := 0; i < 15; i++ {
+		err := a.Log(fmt.Sprintf("message %d", i), "tsuru")
+		c.Assert(err, IsNil)
+	}
+	logs, err := a.LastLogs(10, LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 10)
+	for i, l := range logs {
+		c.Assert(l.Message, Equals, fmt.Sprintf("message %d", i+5))
+		c.Assert(l.AppName, Equals, a.Name)
+	}
+}
+
+func (s *S) TestLastLogsWithoutLimit(c *C) {
+	a := App{Name: "alllogsapp"}
+	a.Log("first", "tsuru")
+	a.Log("second", "tsuru")
+	logs, err := a.LastLogs(0, LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 2)
+	c.Assert(logs[0].Message, Equals, "first")
+	c.Assert(logs[1].Message, Equals, "second")
+}
+
+func (s *S) TestLastLogsFilteringBySource(c *C) {
+	a := App{Name: "sourcelogsapp"}
+	a.Log("from tsuru", "tsuru")
+	a.Log("from app", "app")
+	a.Log("from tsuru again", "tsuru")
+	logs, err := a.LastLogs(10, LogFilter{Source: "app"})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Message, Equals, "from app")
+	c.Assert(logs[0].Source, Equals, "app")
+}
+
+func (s *S) TestUnitLog(c *C) {
+	a := App{Name: "unitlogapp"}
+	err := a.UnitLog("started", "app", "unitlogapp/0")
+	c.Assert(err, IsNil)
+	var l Applog
+	err = db.Session.Logs().Find(bson.M{"appname": a.Name}).One(&l)
+	c.Assert(err, IsNil)
+	c.Assert(l.Message, Equals, "started")
+	c.Assert(l.Unit, Equals, "unitlogapp/0")
+}
+
+func (s *S) TestLastLogsFilteringByUnit(c *C) {
+	a := App{Name: "unitlogsapp"}
+	a.UnitLog("from unit 0", "app", "unitlogsapp/0")
+	a.UnitLog("from unit 1", "app", "unitlogsapp/1")
+	a.Log("from tsuru", "tsuru")
+	logs, err := a.LastLogs(10, LogFilter{Unit: "unitlogsapp/1"})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Message, Equals, "from unit 1")
+}
+
+func (s *S) TestLastLogsFilteringByTimeRange(c *C) {
+	a := App{Name: "rangelogsapp"}
+	now := time.Now()
+	logs := []interface{}{
+		Applog{AppName: a.Name, Message: "too old", Date: now.Add(-2 * time.Hour)},
+		Applog{AppName: a.Name, Message: "in range", Date: now.Add(-time.Hour)},
+		Applog{AppName: a.Name, Message: "too new", Date: now},
+	}
+	err := db.Session.Logs().Insert(logs...)
+	c.Assert(err, IsNil)
+	filter := LogFilter{Since: now.Add(-90 * time.Minute), Until: now.Add(-30 * time.Minute)}
+	result, err := a.LastLogs(10, filter)
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 1)
+	c.Assert(result[0].Message, Equals, "in range")
+}
+
+func (s *S) TestFollowLogs(c *C) {
+	old := followTimeout
+	followTimeout = 50 * time.Millisecond
+	defer func() { followTimeout = old }()
+	a := App{Name: "followlogsapp"}
+	a.Log("before following", "tsuru")
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		a.Log("from tsuru", "tsuru")
+		a.Log("from app", "app")
+		a.Log("from app again", "app")
+	}()
+	var got []string
+	stop := errors.New("stop")
+	err := a.FollowLogs(LogFilter{Source: "app"}, func(logs []Applog) error {
+		for _, l := range logs {
+			got = append(got, l.Message)
+		}
+		if len(got) == 2 {
+			return stop
+		}
+		return nil
+	})
+	c.Assert(err, Equals, stop)
+	c.Assert(got, DeepEquals, []string{"from app", "from app again"})
+}
+
+func (s *S) TestLastLogsWithTheSameDate(c *C) {
+	a := App{Name: "samedatelogsapp"}
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		err := db.Session.Logs().Insert(Applog{AppName: a.Name, Message: fmt.Sprintf("message %d", i), Date: now})
+		c.Assert(err, IsNil)
+	}
+	logs, err := a.LastLogs(3, LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 3)
+	for i, l := range logs {
+		c.Check(l.Message, Equals, fmt.Sprintf("message %d", i+2))
+	}
+}
+
+func (s *S) TestLogPositionAdvance(c *C) {
+	now := time.Now()
+	first, second, third := bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()
+	pos := logPosition{date: now.Add(-time.Minute)}
+	c.Assert(pos.advance(now, first), Equals, true)
+	c.Assert(pos.advance(now, first), Equals, false)
+	c.Assert(pos.advance(now, second), Equals, true)
+	c.Assert(pos.advance(now, first), Equals, false)
+	c.Assert(pos.advance(now, second), Equals, false)
+	c.Assert(pos.advance(now.Add(time.Second), third), Equals, true)
+	c.Assert(pos.date, Equals, now.Add(time.Second))
+	c.Assert(pos.ids, DeepEquals, []bson.ObjectId{third})
+}
+
+func (s *S) TestFollowLogsStopsAtTheEndOfTheTimeRange(c *C) {
+	a := App{Name: "followlogsapp"}
+	var calls int
+	err := a.FollowLogs(LogFilter{Until: time.Now().Add(-time.Minute)}, func(logs []Applog) error {
+		calls++
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(calls, Equals, 0)
+}
+
+func (s *S) TestMigrateLogs(c *C) {
+	now := time.Now()
+	app := bson.M{
+		"name": "oldlogsapp",
+		"logs": []bson.M{
+			{"date": now, "message": "old message 1", "source": "tsuru"},
+			{"date": now, "message": "old message 2", "source": "app"},
+		},
+	}
+	err := db.Session.Apps().Insert(app)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": "oldlogsapp"})
+	n, err := MigrateLogs()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+	var logs []Applog
+	err = db.Session.Logs().Find(bson.M{"appname": "oldlogsapp"}).All(&logs)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 2)
+	c.Assert(logs[0].Message, Equals, "old message 1")
+	c.Assert(logs[1].Source, Equals, "app")
+	count, err := db.Session.Apps().Find(bson.M{"name": "oldlogsapp", "logs": bson.M{"$exists": true}}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 0)
+	n, err = MigrateLogs()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
@@ -0,0 +1,40 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"labix.org/v2/mgo/bson"
+	"time"
+)
+
+// Maintenance is the maintenance mode of an app. While it's enabled, only
+// admin users can deploy the app, run commands in it, change its environment
+// variables or scale it.
+//
+// User and Since record who last turned the maintenance mode on or off, and
+// when.
+type Maintenance struct {
+	Enabled bool
+	User    string
+	Since   time.Time
+}
+
+// SetMaintenance turns the maintenance mode of the app on or off, on behalf of
+// the given user, and logs the change in the app log.
+func (a *App) SetMaintenance(enabled bool, user string) error {
+	state := "off"
+	if enabled {
+		state = "on"
+	}
+	if a.Maintenance.Enabled == enabled {
+		return &ValidationError{Message: fmt.Sprintf("The maintenance mode of the app %s is already %s.", a.Name, state)}
+	}
+	a.Maintenance = Maintenance{Enabled: enabled, User: user, Since: time.Now()}
+	if err := a.update(bson.M{"$set": bson.M{"maintenance": a.Maintenance}}); err != nil {
+		return err
+	}
+	return a.Log(fmt.Sprintf("Maintenance mode turned %s by %s", state, user), "tsuru")
+}
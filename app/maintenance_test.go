@@ -0,0 +1,52 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestSetMaintenance(c *C) {
+	a := App{Name: "frozen", Framework: "python"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.SetMaintenance(true, "admin@tsuru.io")
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Maintenance.Enabled, Equals, true)
+	c.Assert(a.Maintenance.User, Equals, "admin@tsuru.io")
+	c.Assert(a.Maintenance.Since.IsZero(), Equals, false)
+	var l Applog
+	err = db.Session.Logs().Find(bson.M{"appname": a.Name}).One(&l)
+	c.Assert(err, IsNil)
+	c.Assert(l.Message, Equals, "Maintenance mode turned on by admin@tsuru.io")
+	c.Assert(l.Source, Equals, "tsuru")
+}
+
+func (s *S) TestSetMaintenanceOff(c *C) {
+	a := App{Name: "frozen", Maintenance: Maintenance{Enabled: true, User: "admin@tsuru.io"}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.SetMaintenance(false, "other@tsuru.io")
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Maintenance.Enabled, Equals, false)
+	c.Assert(a.Maintenance.User, Equals, "other@tsuru.io")
+}
+
+func (s *S) TestSetMaintenanceAlreadyInTheState(c *C) {
+	a := App{Name: "frozen", Maintenance: Maintenance{Enabled: true}}
+	err := a.SetMaintenance(true, "admin@tsuru.io")
+	c.Assert(err, NotNil)
+	e, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Message, Equals, "The maintenance mode of the app frozen is already on.")
+}
@@ -0,0 +1,235 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"errors"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/log"
+	"github.com/globocom/tsuru/provision"
+	"labix.org/v2/mgo/bson"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MetricsRetention is how long the samples of the resources used by units are
+// kept.
+const MetricsRetention = 24 * time.Hour
+
+// metricsScript is the script that samples the resources used by a unit when
+// the provisioner is not a provision.MetricsProvisioner. CPU and network
+// counters are read twice, one second apart, so usage can be computed from
+// their difference. See parseMetrics for the format of the output.
+const metricsScript = "cat /proc/stat /proc/net/dev; sleep 1; echo ---; cat /proc/stat /proc/net/dev; " +
+	"echo ---; cat /proc/meminfo; echo ---; df -P -k /"
+
+// UnitMetrics is a sample of the resources used by a unit of an app, taken at
+// the given date. See provision.Metrics for the meaning of each field.
+type UnitMetrics struct {
+	App        string
+	Unit       string
+	Date       time.Time
+	CPU        float64
+	Memory     uint64
+	Disk       uint64
+	NetworkIn  uint64
+	NetworkOut uint64
+}
+
+// CollectMetrics samples the resources used by the started units of the app
+// and stores the samples, discarding the ones older than MetricsRetention.
+func (a *App) CollectMetrics(now time.Time) ([]UnitMetrics, error) {
+	var (
+		metrics []provision.Metrics
+		err     error
+	)
+	if p, ok := Provisioner.(provision.MetricsProvisioner); ok {
+		if metrics, err = p.CollectMetrics(a); err != nil {
+			return nil, err
+		}
+	} else {
+		metrics = a.sampleUnits()
+	}
+	samples := make([]UnitMetrics, len(metrics))
+	docs := make([]interface{}, len(metrics))
+	for i, m := range metrics {
+		samples[i] = UnitMetrics{
+			App:        a.Name,
+			Unit:       m.Unit,
+			Date:       now,
+			CPU:        m.CPU,
+			Memory:     m.Memory,
+			Disk:       m.Disk,
+			NetworkIn:  m.NetworkIn,
+			NetworkOut: m.NetworkOut,
+		}
+		docs[i] = samples[i]
+	}
+	if len(docs) > 0 {
+		if err = db.Session.Metrics().Insert(docs...); err != nil {
+			return nil, err
+		}
+	}
+	_, err = db.Session.Metrics().RemoveAll(bson.M{"app": a.Name, "date": bson.M{"$lt": now.Add(-MetricsRetention)}})
+	return samples, err
+}
+
+// sampleUnits runs metricsScript in each started unit of the app. Units that
+// fail to be sampled are skipped.
+func (a *App) sampleUnits() []provision.Metrics {
+	defer func() { a.batch = nil }()
+	var metrics []provision.Metrics
+	for _, u := range a.Units {
+		if u.State != string(provision.StatusStarted) {
+			continue
+		}
+		var buf bytes.Buffer
+		a.batch = []Unit{u}
+		if err := Provisioner.ExecuteCommand(&buf, &buf, a, metricsScript); err != nil {
+			log.Printf("Failed to sample the resources of the unit %s: %s", u.Name, err)
+			continue
+		}
+		m, err := parseMetrics(buf.String())
+		if err != nil {
+			log.Printf("Failed to sample the resources of the unit %s: %s", u.Name, err)
+			continue
+		}
+		m.Unit = u.Name
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// Metrics returns the samples of the resources used by the units of the app
+// taken since the given date, sorted by unit and date.
+func (a *App) Metrics(since time.Time) ([]UnitMetrics, error) {
+	var metrics []UnitMetrics
+	query := bson.M{"app": a.Name, "date": bson.M{"$gte": since}}
+	err := db.Session.Metrics().Find(query).Sort("unit", "date").All(&metrics)
+	return metrics, err
+}
+
+// parseMetrics parses the output of metricsScript, made of four sections
+// separated by "---" lines: the contents of /proc/stat and /proc/net/dev,
+// twice, then the contents of /proc/meminfo and the output of df.
+func parseMetrics(output string) (provision.Metrics, error) {
+	var m provision.Metrics
+	sections := strings.Split(output, "\n---\n")
+	if len(sections) != 4 {
+		return m, errors.New("unexpected output from the metrics script")
+	}
+	total1, idle1, err := parseCPU(sections[0])
+	if err != nil {
+		return m, err
+	}
+	total2, idle2, err := parseCPU(sections[1])
+	if err != nil {
+		return m, err
+	}
+	if total2 > total1 {
+		busy := (total2 - total1) - (idle2 - idle1)
+		m.CPU = float64(busy) * 100 / float64(total2-total1)
+	}
+	in1, out1 := parseNetwork(sections[0])
+	in2, out2 := parseNetwork(sections[1])
+	if in2 >= in1 && out2 >= out1 {
+		m.NetworkIn, m.NetworkOut = in2-in1, out2-out1
+	}
+	if m.Memory, err = parseMemory(sections[2]); err != nil {
+		return m, err
+	}
+	m.Disk, err = parseDisk(sections[3])
+	return m, err
+}
+
+// parseCPU returns the total and the idle CPU time from the "cpu" line of
+// /proc/stat. Time waiting for I/O is considered idle.
+func parseCPU(stat string) (total, idle uint64, err error) {
+	for _, line := range strings.Split(stat, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		for i, f := range fields[1:] {
+			n, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return 0, 0, err
+			}
+			total += n
+			if i == 3 || i == 4 {
+				idle += n
+			}
+		}
+		return total, idle, nil
+	}
+	return 0, 0, errors.New("cpu times not found in the metrics output")
+}
+
+// parseNetwork returns the bytes received and sent by all interfaces but the
+// loopback, from the contents of /proc/net/dev.
+func parseNetwork(dev string) (in, out uint64) {
+	for _, line := range strings.Split(dev, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "lo" {
+			continue
+		}
+		fields := strings.Fields(parts[1])
+		if len(fields) < 9 {
+			continue
+		}
+		rx, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		tx, err := strconv.ParseUint(fields[8], 10, 64)
+		if err != nil {
+			continue
+		}
+		in += rx
+		out += tx
+	}
+	return in, out
+}
+
+// parseMemory returns the memory in use, in bytes, from the contents of
+// /proc/meminfo. Buffers and cache are not considered in use.
+func parseMemory(meminfo string) (uint64, error) {
+	values := make(map[string]uint64)
+	for _, line := range strings.Split(meminfo, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if n, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[strings.TrimRight(fields[0], ":")] = n * 1024
+		}
+	}
+	total, ok := values["MemTotal"]
+	if !ok {
+		return 0, errors.New("memory usage not found in the metrics output")
+	}
+	free := values["MemFree"] + values["Buffers"] + values["Cached"]
+	if free > total {
+		return 0, nil
+	}
+	return total - free, nil
+}
+
+// parseDisk returns the space used in the filesystem, in bytes, from the
+// output of "df -P -k".
+func parseDisk(df string) (uint64, error) {
+	lines := strings.Split(strings.TrimSpace(df), "\n")
+	if len(lines) < 2 {
+		return 0, errors.New("disk usage not found in the metrics output")
+	}
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) < 3 {
+		return 0, errors.New("disk usage not found in the metrics output")
+	}
+	used, err := strconv.ParseUint(fields[2], 10, 64)
+	return used * 1024, err
+}
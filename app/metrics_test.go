@@ -0,0 +1,166 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/provision"
+	"github.com/globocom/tsuru/testing"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+const metricsOutput = `cpu  1000 0 500 8000 500 0 0 0 0 0
+cpu0 1000 0 500 8000 500 0 0 0 0 0
+intr 123456
+Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:   50000     100    0    0    0     0          0         0    50000     100    0    0    0     0       0          0
+  eth0:  100000     200    0    0    0     0          0         0    20000     150    0    0    0     0       0          0
+---
+cpu  1060 0 520 8100 520 0 0 0 0 0
+cpu0 1060 0 520 8100 520 0 0 0 0 0
+intr 123789
+Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:   90000     180    0    0    0     0          0         0    90000     180    0    0    0     0       0          0
+  eth0:  104096     210    0    0    0     0          0         0    21024     160    0    0    0     0       0          0
+---
+MemTotal:        2048000 kB
+MemFree:          512000 kB
+Buffers:          256000 kB
+Cached:           256000 kB
+SwapTotal:             0 kB
+---
+Filesystem     1024-blocks    Used Available Capacity Mounted on
+/dev/xvda1        10321208 2097152   7699628      22% /
+`
+
+type metricsProvisioner struct {
+	*testing.FakeProvisioner
+	metrics []provision.Metrics
+}
+
+func (p *metricsProvisioner) CollectMetrics(app provision.App) ([]provision.Metrics, error) {
+	return p.metrics, nil
+}
+
+func (s *S) TestParseMetrics(c *C) {
+	m, err := parseMetrics(metricsOutput)
+	c.Assert(err, IsNil)
+	c.Assert(m.CPU, Equals, 40.0)
+	c.Assert(m.Memory, Equals, uint64(1024000*1024))
+	c.Assert(m.Disk, Equals, uint64(2097152*1024))
+	c.Assert(m.NetworkIn, Equals, uint64(4096))
+	c.Assert(m.NetworkOut, Equals, uint64(1024))
+}
+
+func (s *S) TestParseMetricsInvalidOutput(c *C) {
+	outputs := []string{
+		"",
+		"sleep: command not found",
+		"---\n---\n---\n",
+		"cpu 1 2 3 4 5\n---\ncpu 1 2 3 4 5\n---\nMemTotal: 10 kB\n---\n",
+	}
+	for _, output := range outputs {
+		_, err := parseMetrics(output)
+		c.Check(err, NotNil, Commentf("%q", output))
+	}
+}
+
+func (s *S) TestCollectMetrics(c *C) {
+	s.provisioner.PrepareOutput([]byte(metricsOutput))
+	a := App{
+		Name: "measured",
+		Units: []Unit{
+			{Name: "measured/0", State: string(provision.StatusStarted)},
+			{Name: "measured/1", State: string(provision.StatusPending)},
+		},
+	}
+	defer db.Session.Metrics().RemoveAll(bson.M{"app": a.Name})
+	now := time.Now()
+	metrics, err := a.CollectMetrics(now)
+	c.Assert(err, IsNil)
+	c.Assert(a.batch, IsNil)
+	c.Assert(metrics, HasLen, 1)
+	c.Assert(metrics[0].Unit, Equals, "measured/0")
+	c.Assert(metrics[0].CPU, Equals, 40.0)
+	cmds := s.provisioner.GetCmds(metricsScript, &a)
+	c.Assert(cmds, HasLen, 1)
+	stored, err := a.Metrics(now.Add(-time.Minute))
+	c.Assert(err, IsNil)
+	c.Assert(stored, HasLen, 1)
+	c.Assert(stored[0].Disk, Equals, uint64(2097152*1024))
+}
+
+func (s *S) TestCollectMetricsSkipsUnitsThatFail(c *C) {
+	s.provisioner.PrepareOutput([]byte("sleep: command not found"))
+	a := App{
+		Name:  "measured",
+		Units: []Unit{{Name: "measured/0", State: string(provision.StatusStarted)}},
+	}
+	defer db.Session.Metrics().RemoveAll(bson.M{"app": a.Name})
+	metrics, err := a.CollectMetrics(time.Now())
+	c.Assert(err, IsNil)
+	c.Assert(metrics, HasLen, 0)
+}
+
+func (s *S) TestCollectMetricsWithMetricsProvisioner(c *C) {
+	p := &metricsProvisioner{
+		FakeProvisioner: s.provisioner,
+		metrics:         []provision.Metrics{{Unit: "measured/0", CPU: 12.5, Memory: 1024}},
+	}
+	Provisioner = p
+	defer func() { Provisioner = s.provisioner }()
+	a := App{
+		Name:  "measured",
+		Units: []Unit{{Name: "measured/0", State: string(provision.StatusStarted)}},
+	}
+	defer db.Session.Metrics().RemoveAll(bson.M{"app": a.Name})
+	metrics, err := a.CollectMetrics(time.Now())
+	c.Assert(err, IsNil)
+	c.Assert(metrics, HasLen, 1)
+	c.Assert(metrics[0].CPU, Equals, 12.5)
+	c.Assert(metrics[0].Memory, Equals, uint64(1024))
+	c.Assert(s.provisioner.GetCmds("", &a), HasLen, 0)
+}
+
+func (s *S) TestCollectMetricsDiscardsOldSamples(c *C) {
+	a := App{Name: "measured"}
+	defer db.Session.Metrics().RemoveAll(bson.M{"app": a.Name})
+	now := time.Now()
+	old := UnitMetrics{App: a.Name, Unit: "measured/0", Date: now.Add(-MetricsRetention - time.Minute)}
+	recent := UnitMetrics{App: a.Name, Unit: "measured/0", Date: now.Add(-time.Hour)}
+	err := db.Session.Metrics().Insert(old, recent)
+	c.Assert(err, IsNil)
+	_, err = a.CollectMetrics(now)
+	c.Assert(err, IsNil)
+	n, err := db.Session.Metrics().Find(bson.M{"app": a.Name}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+}
+
+func (s *S) TestMetrics(c *C) {
+	a := App{Name: "measured"}
+	defer db.Session.Metrics().RemoveAll(bson.M{"app": a.Name})
+	now := time.Now()
+	samples := []interface{}{
+		UnitMetrics{App: a.Name, Unit: "measured/1", Date: now.Add(-time.Minute), CPU: 3},
+		UnitMetrics{App: a.Name, Unit: "measured/0", Date: now, CPU: 2},
+		UnitMetrics{App: a.Name, Unit: "measured/0", Date: now.Add(-time.Minute), CPU: 1},
+		UnitMetrics{App: a.Name, Unit: "measured/0", Date: now.Add(-2 * time.Hour), CPU: 0},
+		UnitMetrics{App: "other", Unit: "other/0", Date: now, CPU: 4},
+	}
+	err := db.Session.Metrics().Insert(samples...)
+	c.Assert(err, IsNil)
+	defer db.Session.Metrics().RemoveAll(bson.M{"app": "other"})
+	metrics, err := a.Metrics(now.Add(-time.Hour))
+	c.Assert(err, IsNil)
+	c.Assert(metrics, HasLen, 3)
+	c.Assert(metrics[0].CPU, Equals, 1.0)
+	c.Assert(metrics[1].CPU, Equals, 2.0)
+	c.Assert(metrics[2].CPU, Equals, 3.0)
+}
@@ -0,0 +1,170 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/globocom/tsuru/provision"
+	"github.com/globocom/tsuru/repository"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var procfileLine = regexp.MustCompile(`^([A-Za-z0-9_-]+):\s*(.+)$`)
+
+// parseProcfile parses the content of a Procfile, returning a map of process
+// types to their commands. Each line of a Procfile declares a process type in
+// the form "<type>: <command>". Blank lines and lines starting with "#" are
+// ignored.
+func parseProcfile(content []byte) (map[string]string, error) {
+	processes := make(map[string]string)
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		matches := procfileLine.FindStringSubmatch(line)
+		if matches == nil {
+			return nil, fmt.Errorf("Invalid Procfile, line %d: %q.", i+1, line)
+		}
+		processes[matches[1]] = matches[2]
+	}
+	return processes, nil
+}
+
+// loadProcfile reads and parses the Procfile in the root of the app
+// repository. It returns nil if the app doesn't have a Procfile.
+func (a *App) loadProcfile() (map[string]string, error) {
+	uRepo, err := repository.GetPath()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	cmd := "cat " + path.Join(uRepo, "Procfile")
+	if err := a.run(cmd, &buf); err != nil {
+		a.Log(fmt.Sprintf("Got error while reading the Procfile: %s", err), "tsuru")
+		return nil, nil
+	}
+	return parseProcfile(buf.Bytes())
+}
+
+// validateProcessType checks that the given process type is declared in the
+// Procfile of the app. The default process type is always valid.
+func (a *App) validateProcessType(processType string) error {
+	if processType == provision.DefaultProcessType {
+		return nil
+	}
+	processes, err := a.loadProcfile()
+	if err != nil {
+		return &ValidationError{Message: err.Error()}
+	}
+	if _, ok := processes[processType]; !ok {
+		msg := fmt.Sprintf("Process type %q is not declared in the Procfile of the app %s.", processType, a.Name)
+		return &ValidationError{Message: msg}
+	}
+	return nil
+}
+
+// processUnits returns the units of the app that run the given process type.
+func (a *App) processUnits(processType string) []Unit {
+	var units []Unit
+	for _, u := range a.Units {
+		if u.GetProcessType() == processType {
+			units = append(units, u)
+		}
+	}
+	return units
+}
+
+// Units that run a process type other than the default one don't run the
+// application server started by the restart hook. Instead, tsuru runs the
+// command of their process type, as declared in the Procfile, in background,
+// in a session of its own. The pid of the session is kept in processPidFile
+// and its output goes to processLogFile, in the home of the application.
+
+func processPidFile(processType string) string {
+	return "/home/application/" + processType + ".pid"
+}
+
+func processLogFile(processType string) string {
+	return "/home/application/" + processType + ".log"
+}
+
+// stopProcessCmd returns the command that stops the process of the given type
+// in a unit, if it's running.
+func stopProcessCmd(processType string) string {
+	pid := processPidFile(processType)
+	return fmt.Sprintf("if [ -f %s ]; then kill -- -$(cat %s) 2>/dev/null; rm -f %s; fi", pid, pid, pid)
+}
+
+// startProcessCmd returns the command that starts, or restarts, the process of
+// the given type in a unit.
+func startProcessCmd(processType, command string) string {
+	return fmt.Sprintf("%s; setsid sh -c %s >> %s 2>&1 < /dev/null & echo $! > %s",
+		stopProcessCmd(processType), shellEscape(command), processLogFile(processType), processPidFile(processType))
+}
+
+// splitUnits separates the units that run the default process type from the
+// others, which are grouped by process type, in the order they appear.
+func splitUnits(units []Unit) (web []Unit, others map[string][]Unit, types []string) {
+	others = make(map[string][]Unit)
+	for _, u := range units {
+		processType := u.GetProcessType()
+		if processType == provision.DefaultProcessType {
+			web = append(web, u)
+			continue
+		}
+		if _, ok := others[processType]; !ok {
+			types = append(types, processType)
+		}
+		others[processType] = append(others[processType], u)
+	}
+	return web, others, types
+}
+
+// startProcesses starts the Procfile command of each process type in its
+// units.
+func (a *App) startProcesses(w io.Writer, others map[string][]Unit, types []string) error {
+	if len(types) == 0 {
+		return nil
+	}
+	batch := a.batch
+	defer func() { a.batch = batch }()
+	a.batch = others[types[0]][:1]
+	processes, err := a.loadProcfile()
+	if err != nil {
+		return err
+	}
+	for _, processType := range types {
+		command, ok := processes[processType]
+		if !ok {
+			return fmt.Errorf("Process type %q is not declared in the Procfile of the app %s.", processType, a.Name)
+		}
+		if err = write(w, []byte(fmt.Sprintf("\n ---> Starting the %s process\n", processType))); err != nil {
+			return err
+		}
+		a.batch = others[processType]
+		if err = a.Run(startProcessCmd(processType, command), w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopProcesses stops the Procfile command of each process type in its units.
+func (a *App) stopProcesses(w io.Writer, others map[string][]Unit, types []string) error {
+	batch := a.batch
+	defer func() { a.batch = batch }()
+	for _, processType := range types {
+		a.batch = others[processType]
+		if err := a.Run(stopProcessCmd(processType), w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/provision"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"strings"
+)
+
+func (s *S) TestParseProcfile(c *C) {
+	content := `# processes of the app
+web: gunicorn -b 0.0.0.0:8080 app:app
+
+worker: celery worker --app=tasks
+clock:python clock.py
+`
+	processes, err := parseProcfile([]byte(content))
+	c.Assert(err, IsNil)
+	expected := map[string]string{
+		"web":    "gunicorn -b 0.0.0.0:8080 app:app",
+		"worker": "celery worker --app=tasks",
+		"clock":  "python clock.py",
+	}
+	c.Assert(processes, DeepEquals, expected)
+}
+
+func (s *S) TestParseProcfileInvalidLine(c *C) {
+	processes, err := parseProcfile([]byte("web: python app.py\nworker celery\n"))
+	c.Assert(processes, IsNil)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `Invalid Procfile, line 2: "worker celery".`)
+}
+
+func (s *S) TestLoadProcfile(c *C) {
+	s.provisioner.PrepareOutput([]byte("web: python app.py\nworker: python worker.py\n"))
+	a := App{Name: "procs", State: string(provision.StatusStarted)}
+	processes, err := a.loadProcfile()
+	c.Assert(err, IsNil)
+	c.Assert(processes, DeepEquals, map[string]string{"web": "python app.py", "worker": "python worker.py"})
+	cmds := s.provisioner.GetCmds("cat /home/application/current/Procfile", &a)
+	c.Assert(cmds, HasLen, 1)
+}
+
+func (s *S) TestUnitGetProcessType(c *C) {
+	u := Unit{Name: "procs/0"}
+	c.Assert(u.GetProcessType(), Equals, "web")
+	u.ProcessType = "worker"
+	c.Assert(u.GetProcessType(), Equals, "worker")
+}
+
+func (s *S) TestAddUnitsWithProcessType(c *C) {
+	a := App{Name: "procs", Framework: "python", State: string(provision.StatusStarted)}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.PrepareOutput([]byte("web: python app.py\nworker: python worker.py\n"))
+	err = a.AddUnits(2, "worker")
+	c.Assert(err, IsNil)
+	for _, u := range s.provisioner.GetUnits(&a) {
+		c.Assert(u.ProcessType, Equals, "worker")
+	}
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 2)
+	c.Assert(a.Units[0].ProcessType, Equals, "worker")
+	c.Assert(a.Units[1].ProcessType, Equals, "worker")
+}
+
+func (s *S) TestAddUnitsWithUndeclaredProcessType(c *C) {
+	a := App{Name: "procs", Framework: "python", State: string(provision.StatusStarted)}
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.PrepareOutput([]byte("web: python app.py\n"))
+	err := a.AddUnits(2, "clock")
+	c.Assert(err, NotNil)
+	e, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Message, Equals, `Process type "clock" is not declared in the Procfile of the app procs.`)
+	c.Assert(s.provisioner.GetUnits(&a), HasLen, 0)
+}
+
+func (s *S) TestRemoveUnitsWithProcessType(c *C) {
+	a := App{Name: "procs", Framework: "python"}
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	web, err := s.provisioner.AddUnits(&a, 1, "web")
+	c.Assert(err, IsNil)
+	workers, err := s.provisioner.AddUnits(&a, 2, "worker")
+	c.Assert(err, IsNil)
+	a.AddUnit(&Unit{Name: web[0].Name})
+	for _, u := range workers {
+		a.AddUnit(&Unit{Name: u.Name, ProcessType: "worker"})
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.RemoveUnits(1, "worker")
+	c.Assert(err, IsNil)
+	c.Assert(s.provisioner.GetUnits(&a), HasLen, 2)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 2)
+	c.Assert(a.Units[0].Name, Equals, web[0].Name)
+	c.Assert(a.Units[1].Name, Equals, workers[1].Name)
+}
+
+func (s *S) TestRemoveUnitsWithProcessTypeInvalidValues(c *C) {
+	a := App{
+		Name: "procs",
+		Units: []Unit{
+			{Name: "procs/0"},
+			{Name: "procs/1", ProcessType: "worker"},
+		},
+	}
+	var tests = []struct {
+		n        uint
+		process  string
+		expected string
+	}{
+		{0, "worker", "Cannot remove zero units."},
+		{2, "worker", "You can't remove 2 worker units from this app because it has only 1 worker units."},
+		{1, "clock", "You can't remove 1 clock units from this app because it has only 0 clock units."},
+	}
+	for _, t := range tests {
+		err := a.RemoveUnits(t.n, t.process)
+		c.Assert(err, NotNil)
+		e, ok := err.(*ValidationError)
+		c.Assert(ok, Equals, true)
+		c.Assert(e.Message, Equals, t.expected)
+	}
+	a.Units = a.Units[1:]
+	err := a.RemoveUnits(1, "worker")
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "You can't remove all units from an app.")
+}
+
+func (s *S) TestStartProcessCmd(c *C) {
+	cmd := startProcessCmd("worker", "celery worker --app='tasks'")
+	expected := "if [ -f /home/application/worker.pid ]; then kill -- -$(cat /home/application/worker.pid) 2>/dev/null; rm -f /home/application/worker.pid; fi; " +
+		`setsid sh -c 'celery worker --app='\''tasks'\''' >> /home/application/worker.log 2>&1 < /dev/null & echo $! > /home/application/worker.pid`
+	c.Assert(cmd, Equals, expected)
+}
+
+func (s *S) TestSplitUnits(c *C) {
+	units := []Unit{
+		{Name: "app/0"},
+		{Name: "app/1", ProcessType: "worker"},
+		{Name: "app/2", ProcessType: "clock"},
+		{Name: "app/3", ProcessType: "web"},
+		{Name: "app/4", ProcessType: "worker"},
+	}
+	web, others, types := splitUnits(units)
+	c.Assert(web, DeepEquals, []Unit{units[0], units[3]})
+	c.Assert(types, DeepEquals, []string{"worker", "clock"})
+	c.Assert(others["worker"], DeepEquals, []Unit{units[1], units[4]})
+	c.Assert(others["clock"], DeepEquals, []Unit{units[2]})
+}
+
+// processCmds returns the commands run in the app that contain the given
+// string.
+func (s *S) processCmds(a *App, contains string) []string {
+	var cmds []string
+	for _, cmd := range s.provisioner.GetCmds("", a) {
+		if strings.Contains(cmd.Cmd, contains) {
+			cmds = append(cmds, cmd.Cmd)
+		}
+	}
+	return cmds
+}
+
+func (s *S) TestRestartRunsTheProcfileCommandOfOtherProcessTypes(c *C) {
+	s.provisioner.PrepareOutput([]byte("restarted"))
+	s.provisioner.PrepareOutput([]byte("web: gunicorn app:app\nworker: celery worker\n"))
+	s.provisioner.PrepareOutput(nil)
+	a := App{
+		Name:      "someApp",
+		Framework: "django",
+		State:     string(provision.StatusStarted),
+		Units:     []Unit{{Name: "someApp/0"}, {Name: "someApp/1", ProcessType: "worker"}},
+		hooks:     &conf{},
+	}
+	var b bytes.Buffer
+	err := a.Restart(&b)
+	c.Assert(err, IsNil)
+	c.Assert(b.String(), Matches, "(?s).* ---> Restarting your app\n.* ---> Starting the worker process\n.*")
+	c.Assert(s.provisioner.GetCmds("/var/lib/tsuru/hooks/restart", &a), HasLen, 1)
+	c.Assert(s.processCmds(&a, "setsid sh -c 'celery worker'"), HasLen, 1)
+	c.Assert(a.batch, IsNil)
+}
+
+func (s *S) TestRestartOnlyWorkerUnits(c *C) {
+	s.provisioner.PrepareOutput([]byte("worker: celery worker\n"))
+	s.provisioner.PrepareOutput(nil)
+	a := App{
+		Name:      "someApp",
+		Framework: "django",
+		State:     string(provision.StatusStarted),
+		Units:     []Unit{{Name: "someApp/0", ProcessType: "worker"}},
+		hooks:     &conf{},
+	}
+	var b bytes.Buffer
+	err := a.Restart(&b)
+	c.Assert(err, IsNil)
+	c.Assert(s.provisioner.GetCmds("/var/lib/tsuru/hooks/restart", &a), HasLen, 0)
+	c.Assert(s.processCmds(&a, "setsid sh -c 'celery worker'"), HasLen, 1)
+}
+
+func (s *S) TestRestartWithUndeclaredProcessType(c *C) {
+	s.provisioner.PrepareOutput([]byte("web: gunicorn app:app\n"))
+	a := App{
+		Name:      "someApp",
+		Framework: "django",
+		State:     string(provision.StatusStarted),
+		Units:     []Unit{{Name: "someApp/0", ProcessType: "worker"}},
+		hooks:     &conf{},
+	}
+	var b bytes.Buffer
+	err := a.Restart(&b)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `Process type "worker" is not declared in the Procfile of the app someApp.`)
+}
+
+func (s *S) TestStopStopsTheProcfileCommandOfOtherProcessTypes(c *C) {
+	s.provisioner.PrepareOutput(nil)
+	a := App{
+		Name:      "someApp",
+		Framework: "django",
+		State:     string(provision.StatusStarted),
+		Units:     []Unit{{Name: "someApp/0"}, {Name: "someApp/1", ProcessType: "worker"}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	var b bytes.Buffer
+	err = a.Stop(&b)
+	c.Assert(err, IsNil)
+	c.Assert(s.processCmds(&a, stopProcessCmd("worker")), HasLen, 1)
+}
+
+func (s *S) TestStartStartsTheProcfileCommandOfOtherProcessTypes(c *C) {
+	s.provisioner.PrepareOutput([]byte("worker: celery worker\n"))
+	s.provisioner.PrepareOutput(nil)
+	a := App{
+		Name:      "someApp",
+		Framework: "django",
+		State:     string(provision.StatusDown),
+		Stopped:   true,
+		Units:     []Unit{{Name: "someApp/0"}, {Name: "someApp/1", ProcessType: "worker"}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	var b bytes.Buffer
+	err = a.Start(&b)
+	c.Assert(err, IsNil)
+	c.Assert(b.String(), Equals, "\n ---> Starting your app\n\n ---> Starting the worker process\n")
+	c.Assert(s.processCmds(&a, "setsid sh -c 'celery worker'"), HasLen, 1)
+}
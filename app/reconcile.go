@@ -0,0 +1,97 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/log"
+	"github.com/globocom/tsuru/provision"
+	"labix.org/v2/mgo/bson"
+	"time"
+)
+
+// pruneGrace is how long a unit must be known before Reconcile removes it for
+// not being reported by the provisioner. It protects units that were just
+// added from a status collected before their creation.
+var pruneGrace = 5 * time.Minute
+
+// Reconcile updates the units of the app with the given units, reported by
+// the provisioner at now, and saves the app:
+//
+//   - units are added or updated with the reported status, and changes in
+//     their state are recorded in the unit history;
+//   - if prune is true, units that are no longer reported are removed;
+//   - units stuck in the error or down state are flagged;
+//   - the state of the app is computed from the states of all its units.
+//
+// Removed and stuck units are logged in the app log.
+func (a *App) Reconcile(units []provision.Unit, prune bool, now time.Time) error {
+	var (
+		changes  []interface{}
+		messages []string
+	)
+	err := a.modify(func() (bson.M, error) {
+		changes, messages = nil, nil
+		states := make(map[string]string, len(a.Units))
+		for _, u := range a.Units {
+			states[u.Name] = u.State
+		}
+		reported := make(map[string]bool, len(units))
+		for _, unit := range units {
+			u := Unit{
+				Name:    unit.Name,
+				Type:    unit.Type,
+				Machine: unit.Machine,
+				Ip:      unit.Ip,
+				State:   string(unit.Status),
+			}
+			a.AddUnit(&u)
+			reported[u.Name] = true
+			if old, ok := states[u.Name]; !ok || old != u.State {
+				change := UnitStatusChange{App: a.Name, Unit: u.Name, From: old, To: u.State, Date: u.StateSince}
+				changes = append(changes, change)
+			}
+		}
+		if prune {
+			kept := make([]Unit, 0, len(a.Units))
+			for _, u := range a.Units {
+				if reported[u.Name] || now.Sub(u.StateSince) < pruneGrace {
+					kept = append(kept, u)
+					continue
+				}
+				messages = append(messages, fmt.Sprintf("Removing the unit %s: it's no longer reported by the provisioner.", u.Name))
+				changes = append(changes, UnitStatusChange{App: a.Name, Unit: u.Name, From: u.State, Date: now})
+			}
+			a.Units = kept
+		}
+		a.State = a.ComputeState()
+		for _, u := range a.StuckUnits(now) {
+			if u.Stuck {
+				continue
+			}
+			for i := range a.Units {
+				if a.Units[i].Name == u.Name {
+					a.Units[i].Stuck = true
+				}
+			}
+			messages = append(messages, fmt.Sprintf("The unit %s is stuck in the %s state since %s.",
+				u.Name, u.State, u.StateSince.Format("2006-01-02 15:04:05")))
+		}
+		return bson.M{"$set": bson.M{"state": a.State, "units": a.Units}}, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		a.Log(msg, "tsuru")
+	}
+	if len(changes) > 0 {
+		if err := db.Session.UnitHistory().Insert(changes...); err != nil {
+			log.Printf("Failed to record the unit history of the app %s: %s", a.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) TestScheduleNext(c *C) {
+	from := time.Date(2013, 1, 31, 10, 30, 45, 0, time.UTC)
+	var tests = []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2013, 1, 31, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2013, 1, 31, 10, 45, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2013, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2013, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2013, 2, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2013, 1, 31, 13, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2013, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2013, 2, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2013, 2, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2016, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 10 * 1", time.Date(2013, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, t := range tests {
+		s, err := parseSchedule(t.expr)
+		c.Assert(err, IsNil)
+		c.Check(s.next(from), Equals, t.next, Commentf("%q", t.expr))
+	}
+}
+
+func (s *S) TestScheduleNextNeverMatches(c *C) {
+	sched, err := parseSchedule("0 0 30 2 *")
+	c.Assert(err, IsNil)
+	c.Assert(sched.next(time.Now()).IsZero(), Equals, true)
+}
+
+func (s *S) TestParseScheduleInvalid(c *C) {
+	exprs := []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *",
+		"* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@often"}
+	for _, expr := range exprs {
+		_, err := parseSchedule(expr)
+		c.Check(err, NotNil, Commentf("%q", expr))
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/tsuru/provision"
+)
+
+const (
+	// StatePartiallyStarted is the state of apps that have started units
+	// while other units are still being provisioned.
+	StatePartiallyStarted = "partially-started"
+
+	// StateDegraded is the state of apps that have started units while
+	// other units are down or failed.
+	StateDegraded = "degraded"
+)
+
+// ComputeState returns the state of the app, computed from the states of all
+// its units:
+//
+//   - when all units are in the same state, it's the state of the app;
+//   - when some units are started, the app is degraded if any other unit is
+//     down or failed, and partially started otherwise;
+//   - when no unit is started, the app is in error if any unit is, down if any
+//     unit is, and pending otherwise.
+//
+// Apps without units keep their current state, and stopped apps are down.
+func (a *App) ComputeState() string {
+	if a.Stopped {
+		return provision.StatusDown.String()
+	}
+	if len(a.Units) == 0 {
+		return a.State
+	}
+	counts := make(map[string]int)
+	for _, u := range a.Units {
+		counts[u.State]++
+	}
+	if len(counts) == 1 {
+		return a.Units[0].State
+	}
+	failed := counts[provision.StatusError.String()] + counts[provision.StatusDown.String()]
+	if counts[provision.StatusStarted.String()] > 0 {
+		if failed > 0 {
+			return StateDegraded
+		}
+		return StatePartiallyStarted
+	}
+	if counts[provision.StatusError.String()] > 0 {
+		return provision.StatusError.String()
+	} else if counts[provision.StatusDown.String()] > 0 {
+		return provision.StatusDown.String()
+	}
+	return provision.StatusPending.String()
+}
+
+// Available returns true if the app has started units, so it can run commands
+// and receive deploys, even if some of its units are not started.
+func (a *App) Available() bool {
+	switch a.State {
+	case provision.StatusStarted.String(), StatePartiallyStarted, StateDegraded:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestComputeState(c *C) {
+	var tests = []struct {
+		units    []string
+		expected string
+	}{
+		{[]string{"started"}, "started"},
+		{[]string{"started", "started"}, "started"},
+		{[]string{"pending", "pending"}, "pending"},
+		{[]string{"error"}, "error"},
+		{[]string{"started", "pending"}, "partially-started"},
+		{[]string{"installing", "started", "creating"}, "partially-started"},
+		{[]string{"started", "error"}, "degraded"},
+		{[]string{"down", "started", "pending"}, "degraded"},
+		{[]string{"pending", "error"}, "error"},
+		{[]string{"down", "installing"}, "down"},
+		{[]string{"installing", "pending"}, "pending"},
+	}
+	for _, t := range tests {
+		a := App{Name: "myapp", State: "stopped"}
+		for _, state := range t.units {
+			a.Units = append(a.Units, Unit{State: state})
+		}
+		c.Check(a.ComputeState(), Equals, t.expected, Commentf("%v", t.units))
+	}
+}
+
+func (s *S) TestComputeStateOfStoppedApps(c *C) {
+	a := App{
+		Name:    "myapp",
+		State:   "down",
+		Stopped: true,
+		Units:   []Unit{{State: "started"}, {State: "down"}},
+	}
+	c.Assert(a.ComputeState(), Equals, "down")
+}
+
+func (s *S) TestComputeStateWithoutUnits(c *C) {
+	a := App{Name: "myapp", State: "pending"}
+	c.Assert(a.ComputeState(), Equals, "pending")
+}
+
+func (s *S) TestAvailable(c *C) {
+	var tests = []struct {
+		state    string
+		expected bool
+	}{
+		{"started", true},
+		{"partially-started", true},
+		{"degraded", true},
+		{"pending", false},
+		{"error", false},
+		{"down", false},
+	}
+	for _, t := range tests {
+		a := App{State: t.state}
+		c.Check(a.Available(), Equals, t.expected, Commentf("%q", t.state))
+	}
+}
@@ -6,15 +6,32 @@ package app
 
 import (
 	"github.com/globocom/tsuru/provision"
+	"time"
 )
 
 type Unit struct {
-	Name    string
-	Type    string
-	Machine int
-	Ip      string
-	State   string
-	app     *App
+	Name        string
+	Type        string
+	Machine     int
+	Ip          string
+	State       string
+	StateSince  time.Time
+	Stuck       bool
+	Ref         string
+	ProcessType string
+	app         *App
+}
+
+// UnitStatusChange is an entry in the status history of units, recorded by
+// the collector whenever a unit changes its state. From is empty for units
+// that were not known before, and To is empty for units that were removed
+// because the provisioner no longer reports them.
+type UnitStatusChange struct {
+	App  string
+	Unit string
+	From string
+	To   string
+	Date time.Time
 }
 
 func (u *Unit) GetName() string {
@@ -32,3 +49,12 @@ func (u *Unit) GetIp() string {
 func (u *Unit) GetStatus() provision.Status {
 	return provision.Status(u.State)
 }
+
+// GetProcessType returns the process type that runs in the unit. Units added
+// before the support for process types run the default one.
+func (u *Unit) GetProcessType() string {
+	if u.ProcessType == "" {
+		return provision.DefaultProcessType
+	}
+	return u.ProcessType
+}
@@ -0,0 +1,103 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"errors"
+	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+)
+
+// maxModifyAttempts is how many times modify tries to save a change to an app
+// that keeps being changed by concurrent writers.
+const maxModifyAttempts = 5
+
+// ErrConcurrentUpdate is returned when a change to an app conflicts with
+// concurrent changes more than maxModifyAttempts times.
+var ErrConcurrentUpdate = errors.New("The app is being changed concurrently, please try again.")
+
+// versioned returns a copy of the given modifier that also increments the
+// version of the app.
+func versioned(change bson.M) bson.M {
+	result := make(bson.M, len(change)+1)
+	for k, v := range change {
+		result[k] = v
+	}
+	inc := bson.M{"version": 1}
+	if other, ok := change["$inc"].(bson.M); ok {
+		for k, v := range other {
+			inc[k] = v
+		}
+	}
+	result["$inc"] = inc
+	return result
+}
+
+// update applies the given modifier to the app document. It's meant for
+// changes that don't depend on the current state of the document, like setting
+// a field or pushing an item to a list, so it never conflicts with other
+// writers.
+func (a *App) update(change bson.M) error {
+	err := db.Session.Apps().Update(bson.M{"name": a.Name}, versioned(change))
+	if err == nil {
+		a.Version++
+	}
+	return err
+}
+
+// modify saves a change that depends on the current state of the app. fn
+// changes the app in memory and returns the modifier that saves the change,
+// which is applied only if the app was not changed since it was read. On
+// conflicts, the app is reloaded from the database and fn runs again, up to
+// maxModifyAttempts times, so fn must not have side effects besides changing
+// the app. A nil modifier means there's nothing to save.
+func (a *App) modify(fn func() (bson.M, error)) error {
+	for i := 0; i < maxModifyAttempts; i++ {
+		change, err := fn()
+		if err != nil || change == nil {
+			return err
+		}
+		query := bson.M{"name": a.Name, "version": a.Version}
+		if a.Version == 0 {
+			// apps stored before the version field was added.
+			query["version"] = bson.M{"$in": []interface{}{0, nil}}
+		}
+		err = db.Session.Apps().Update(query, versioned(change))
+		if err == nil {
+			a.Version++
+			return nil
+		} else if err != mgo.ErrNotFound {
+			return err
+		}
+		if err = a.reload(); err != nil {
+			return err
+		}
+	}
+	return ErrConcurrentUpdate
+}
+
+// reload reads the app from the database again, keeping the hooks and the
+// batch of units that are only held in memory.
+func (a *App) reload() error {
+	var current App
+	if err := db.Session.Apps().Find(bson.M{"name": a.Name}).One(&current); err != nil {
+		return err
+	}
+	current.hooks, current.batch = a.hooks, a.batch
+	*a = current
+	return nil
+}
+
+// initField replaces a null field of the app document, stored for nil maps and
+// slices, with the given empty value, so it can be changed with targeted
+// modifiers like $set of a key or $push.
+func (a *App) initField(field string, empty interface{}) error {
+	err := db.Session.Apps().Update(bson.M{"name": a.Name, field: nil}, bson.M{"$set": bson.M{field: empty}})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
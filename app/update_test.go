@@ -0,0 +1,182 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/api/bind"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/testing"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"sync"
+)
+
+func (s *S) TestVersioned(c *C) {
+	change := bson.M{"$set": bson.M{"state": "started"}, "$inc": bson.M{"deploys": 1}}
+	expected := bson.M{"$set": bson.M{"state": "started"}, "$inc": bson.M{"deploys": 1, "version": 1}}
+	c.Assert(versioned(change), DeepEquals, expected)
+	c.Assert(change["$inc"], DeepEquals, bson.M{"deploys": 1})
+}
+
+func (s *S) TestUpdateIncrementsTheVersion(c *C) {
+	a := App{Name: "versioned"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.update(bson.M{"$set": bson.M{"state": "started"}})
+	c.Assert(err, IsNil)
+	c.Assert(a.Version, Equals, 1)
+	var stored App
+	err = db.Session.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.State, Equals, "started")
+	c.Assert(stored.Version, Equals, 1)
+}
+
+func (s *S) TestModifyReloadsTheAppOnConflicts(c *C) {
+	a := App{Name: "versioned", Units: []Unit{{Name: "versioned/0"}}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	other := App{Name: a.Name}
+	err = other.Get()
+	c.Assert(err, IsNil)
+	err = other.update(bson.M{"$push": bson.M{"units": Unit{Name: "versioned/1"}}})
+	c.Assert(err, IsNil)
+	err = a.SetRef("v2.0")
+	c.Assert(err, IsNil)
+	c.Assert(a.Version, Equals, 2)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 2)
+	c.Assert(a.Units[0].Ref, Equals, "v2.0")
+	c.Assert(a.Units[1].Ref, Equals, "v2.0")
+}
+
+func (s *S) TestModifyGivesUpAfterTooManyConflicts(c *C) {
+	a := App{Name: "versioned"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	var attempts int
+	err = a.modify(func() (bson.M, error) {
+		attempts++
+		db.Session.Apps().Update(bson.M{"name": a.Name}, bson.M{"$inc": bson.M{"version": 1}})
+		return bson.M{"$set": bson.M{"state": "started"}}, nil
+	})
+	c.Assert(err, Equals, ErrConcurrentUpdate)
+	c.Assert(attempts, Equals, maxModifyAttempts)
+}
+
+func (s *S) TestInitField(c *C) {
+	err := db.Session.Apps().Insert(bson.M{"name": "versioned", "env": nil})
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": "versioned"})
+	a := App{Name: "versioned"}
+	err = a.initField("env", bson.M{})
+	c.Assert(err, IsNil)
+	err = a.update(bson.M{"$set": bson.M{"env.DATABASE_HOST": bind.EnvVar{Name: "DATABASE_HOST", Value: "localhost", Public: true}}})
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Env["DATABASE_HOST"].Value, Equals, "localhost")
+}
+
+func (s *S) TestConcurrentEnvAndUnitUpdatesSurvive(c *C) {
+	server := testing.FakeQueueServer{}
+	server.Start("127.0.0.1:0")
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err == nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	a := App{Name: "versioned", Framework: "python"}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvVersions().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	envWriter := App{Name: a.Name}
+	err = envWriter.Get()
+	c.Assert(err, IsNil)
+	unitWriter := App{Name: a.Name}
+	err = unitWriter.Get()
+	c.Assert(err, IsNil)
+	envs := []bind.EnvVar{{Name: "DATABASE_HOST", Value: "localhost", Public: true}}
+	err = envWriter.SetEnvsToApp(envs, false, true, "jimmy@page.com")
+	c.Assert(err, IsNil)
+	err = unitWriter.AddUnits(2, "")
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 2)
+	c.Assert(a.Env["DATABASE_HOST"].Value, Equals, "localhost")
+	c.Assert(a.Version, Equals, 2)
+}
+
+func (s *S) TestConcurrentEnvUpdatesSurvive(c *C) {
+	server := testing.FakeQueueServer{}
+	server.Start("127.0.0.1:0")
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err == nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	a := App{Name: "versioned"}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvVersions().RemoveAll(bson.M{"app": a.Name})
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			writer := App{Name: a.Name}
+			if err := writer.Get(); err != nil {
+				errs <- err
+				return
+			}
+			name := fmt.Sprintf("VAR%d", i)
+			envs := []bind.EnvVar{{Name: name, Value: "value", Public: true}}
+			errs <- writer.SetEnvsToApp(envs, false, true, "jimmy@page.com")
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Assert(err, IsNil)
+	}
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Env, HasLen, n)
+	c.Assert(a.Version, Equals, n)
+}
+
+func (s *S) TestConcurrentUnitUpdatesSurvive(c *C) {
+	a := App{Name: "versioned", Units: []Unit{{Name: "versioned/0", State: "pending"}}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	writer := App{Name: a.Name}
+	err = writer.Get()
+	c.Assert(err, IsNil)
+	err = a.update(bson.M{"$push": bson.M{"units": Unit{Name: "versioned/1", State: "pending"}}})
+	c.Assert(err, IsNil)
+	err = writer.setState("started", false)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 2)
+	c.Assert(a.Units[0].State, Equals, "started")
+	c.Assert(a.Units[1].State, Equals, "started")
+}
@@ -0,0 +1,203 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package audit implements the audit trail of tsuru: the record of the
+// requests, and of the automatic actions, that changed its state.
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/globocom/tsuru/db"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// bodyLimit is the maximum number of bytes of the request body stored in
+	// an entry.
+	bodyLimit = 4096
+	redacted  = "***"
+)
+
+var (
+	// secretBodies are the actions whose bodies are not stored at all, because
+	// they may carry secrets in free form: passwords and commands.
+	secretBodies = map[string]bool{
+		"PUT /users/password":    true,
+		"POST /apps/:name/run":   true,
+		"POST /apps/:name/crons": true,
+	}
+	sensitiveName  = regexp.MustCompile(`(?i)password|secret|token|key|credential`)
+	sensitiveValue = regexp.MustCompile(`(?i)\b(\w*(password|secret|token|key|credential)\w*)=\S+`)
+)
+
+// Entry records a request that changed the state of tsuru: who made it,
+// the action (the method and the route of the request), the app and team it
+// targeted, its parameters and its outcome. Entries of requests targeting an
+// app also record the teams of the app. Secrets are redacted from the
+// parameters and from the body.
+type Entry struct {
+	User      string
+	Action    string
+	App       string            `bson:",omitempty" json:",omitempty"`
+	Team      string            `bson:",omitempty" json:",omitempty"`
+	Teams     []string          `bson:",omitempty" json:",omitempty"`
+	Params    map[string]string `bson:",omitempty" json:",omitempty"`
+	Body      string            `bson:",omitempty" json:",omitempty"`
+	Status    int
+	Error     string `bson:",omitempty" json:",omitempty"`
+	Timestamp time.Time
+}
+
+// IsMutating returns whether the request changes the state of tsuru, and thus
+// should be audited. Besides the requests that don't use the GET method, app
+// restarts are mutating.
+func IsMutating(r *http.Request) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return true
+	}
+	return strings.HasPrefix(r.URL.Path, "/apps/") && strings.HasSuffix(r.URL.Path, "/restart")
+}
+
+// NewEntry starts the entry of a request made by the given user. It reads the
+// body of the request, replacing it with a copy, so the handler can still read
+// it.
+func NewEntry(r *http.Request, user string) *Entry {
+	entry := Entry{
+		User:      user,
+		Action:    r.Method + " " + routeOf(r),
+		Timestamp: time.Now(),
+	}
+	query := r.URL.Query()
+	entry.App = query.Get(":app")
+	if entry.App == "" && strings.HasPrefix(r.URL.Path, "/apps/") {
+		entry.App = query.Get(":name")
+	}
+	if entry.App != "" {
+		entry.Teams = appTeams(entry.App)
+	}
+	entry.Team = query.Get(":team")
+	if entry.Team == "" && strings.HasPrefix(r.URL.Path, "/teams/") {
+		entry.Team = query.Get(":name")
+	}
+	for k := range query {
+		if strings.HasPrefix(k, ":") {
+			continue
+		}
+		if entry.Params == nil {
+			entry.Params = make(map[string]string)
+		}
+		entry.Params[k] = query.Get(k)
+		if sensitiveName.MatchString(k) {
+			entry.Params[k] = redacted
+		}
+	}
+	if r.Body != nil {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if err == nil {
+			entry.Body = redactBody(entry.Action, body)
+		}
+	}
+	return &entry
+}
+
+// appTeams returns the teams of the app with the given name, or nil if the
+// app doesn't exist.
+func appTeams(name string) []string {
+	var app struct{ Teams []string }
+	err := db.Session.Apps().Find(bson.M{"name": name}).Select(bson.M{"teams": 1}).One(&app)
+	if err != nil {
+		return nil
+	}
+	return app.Teams
+}
+
+// routeOf returns the route of the request, replacing the values of the URL
+// parameters in the path by the names of the parameters, for example:
+// "/apps/myapp/env" becomes "/apps/:name/env".
+func routeOf(r *http.Request) string {
+	query := r.URL.Query()
+	parts := strings.Split(r.URL.Path, "/")
+	used := make(map[string]bool)
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		for k := range query {
+			if strings.HasPrefix(k, ":") && !used[k] && query.Get(k) == part {
+				parts[i] = k
+				used[k] = true
+				break
+			}
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// redactBody returns the body of a request of the given action with secrets
+// redacted. In JSON bodies, values of sensitive keys and of private
+// environment variables are redacted. In other bodies, values of sensitive
+// NAME=value pairs are redacted. Bodies of the actions in secretBodies are
+// entirely redacted.
+func redactBody(action string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if secretBodies[action] {
+		return redacted
+	}
+	var data interface{}
+	if json.Unmarshal(body, &data) == nil {
+		if b, err := json.Marshal(redactJSON(data)); err == nil {
+			body = b
+		}
+	} else {
+		body = sensitiveValue.ReplaceAll(body, []byte("$1="+redacted))
+	}
+	if len(body) > bodyLimit {
+		return string(body[:bodyLimit]) + "..."
+	}
+	return string(body)
+}
+
+func redactJSON(data interface{}) interface{} {
+	switch d := data.(type) {
+	case map[string]interface{}:
+		for k, v := range d {
+			if sensitiveName.MatchString(k) {
+				d[k] = redacted
+			} else {
+				d[k] = redactJSON(v)
+			}
+		}
+		if public, ok := d["public"].(bool); ok && !public {
+			if _, ok := d["value"]; ok {
+				d["value"] = redacted
+			}
+		}
+	case []interface{}:
+		for i, v := range d {
+			d[i] = redactJSON(v)
+		}
+	}
+	return data
+}
+
+// Record completes the entry with the outcome of the request, given by the
+// status code of the response and the error returned by the handler, and
+// stores it.
+func (e *Entry) Record(status int, err error) error {
+	e.Status = status
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return db.Session.Audit().Insert(e)
+}
@@ -0,0 +1,130 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package audit
+
+import (
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (s *S) TestIsMutating(c *C) {
+	var tests = []struct {
+		method   string
+		path     string
+		expected bool
+	}{
+		{"GET", "/apps/myapp/env", false},
+		{"HEAD", "/apps", false},
+		{"POST", "/apps/myapp/env", true},
+		{"PUT", "/apps/myapp/units", true},
+		{"DELETE", "/apps/myapp", true},
+		{"GET", "/apps/myapp/restart", true},
+	}
+	for _, t := range tests {
+		request, err := http.NewRequest(t.method, t.path, nil)
+		c.Assert(err, IsNil)
+		c.Check(IsMutating(request), Equals, t.expected)
+	}
+}
+
+func (s *S) TestNewEntry(c *C) {
+	body := strings.NewReader("DATABASE_HOST=localhost DATABASE_PASSWORD=s3cr3t")
+	request, err := http.NewRequest("POST", "/apps/myapp/env?:name=myapp&process=web&token=abc", body)
+	c.Assert(err, IsNil)
+	entry := NewEntry(request, "me@tsuru.io")
+	c.Assert(entry.User, Equals, "me@tsuru.io")
+	c.Assert(entry.Action, Equals, "POST /apps/:name/env")
+	c.Assert(entry.App, Equals, "myapp")
+	c.Assert(entry.Team, Equals, "")
+	c.Assert(entry.Params, DeepEquals, map[string]string{"process": "web", "token": "***"})
+	c.Assert(entry.Body, Equals, "DATABASE_HOST=localhost DATABASE_PASSWORD=***")
+	b, err := ioutil.ReadAll(request.Body)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "DATABASE_HOST=localhost DATABASE_PASSWORD=s3cr3t")
+}
+
+func (s *S) TestNewEntryTargets(c *C) {
+	var tests = []struct {
+		method string
+		url    string
+		action string
+		app    string
+		team   string
+	}{
+		{"PUT", "/apps/myapp/myteam?:app=myapp&:team=myteam", "PUT /apps/:app/:team", "myapp", "myteam"},
+		{"PUT", "/services/instances/mysql/myapp?:instance=mysql&:app=myapp", "PUT /services/instances/:instance/:app", "myapp", ""},
+		{"DELETE", "/teams/myteam?:name=myteam", "DELETE /teams/:name", "", "myteam"},
+		{"PUT", "/teams/myteam/me@tsuru.io?:team=myteam&:user=me@tsuru.io", "PUT /teams/:team/:user", "", "myteam"},
+		{"DELETE", "/services/mysql?:name=mysql", "DELETE /services/:name", "", ""},
+	}
+	for _, t := range tests {
+		request, err := http.NewRequest(t.method, t.url, nil)
+		c.Assert(err, IsNil)
+		entry := NewEntry(request, "me@tsuru.io")
+		c.Check(entry.Action, Equals, t.action)
+		c.Check(entry.App, Equals, t.app)
+		c.Check(entry.Team, Equals, t.team)
+	}
+}
+
+func (s *S) TestRedactBody(c *C) {
+	var tests = []struct {
+		action   string
+		body     string
+		expected string
+	}{
+		{"POST /apps/:name/env", "", ""},
+		{"POST /apps/:name/env", "DEBUG=1 API_KEY=abc", "DEBUG=1 API_KEY=***"},
+		{
+			"POST /apps/:name/env",
+			`[{"name":"DEBUG","value":"1","public":true},{"name":"DB_URL","value":"mysql://root:pass@db","public":false}]`,
+			`[{"name":"DEBUG","public":true,"value":"1"},{"name":"DB_URL","public":false,"value":"***"}]`,
+		},
+		{"POST /users", `{"email":"me@tsuru.io","password":"123456"}`, `{"email":"me@tsuru.io","password":"***"}`},
+		{"PUT /users/password", `{"old":"123456","new":"654321"}`, "***"},
+		{"POST /apps/:name/run", "mysql -p s3cr3t", "***"},
+		{"POST /apps/:name/crons", `{"schedule":"@hourly","command":"curl -u admin:s3cr3t localhost"}`, "***"},
+		{"PUT /apps/:name/units", "3", "3"},
+	}
+	for _, t := range tests {
+		c.Check(redactBody(t.action, []byte(t.body)), Equals, t.expected)
+	}
+}
+
+func (s *S) TestRedactBodyTruncatesLongBodies(c *C) {
+	body := strings.Repeat("a", bodyLimit+10)
+	c.Assert(redactBody("POST /services", []byte(body)), Equals, body[:bodyLimit]+"...")
+}
+
+func (s *S) TestNewEntryRecordsTheTeamsOfTheApp(c *C) {
+	err := db.Session.Apps().Insert(bson.M{"name": "myapp", "teams": []string{"ops", "dev"}})
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": "myapp"})
+	request, err := http.NewRequest("POST", "/apps/myapp/run?:name=myapp", strings.NewReader("ls"))
+	c.Assert(err, IsNil)
+	entry := NewEntry(request, "me@tsuru.io")
+	c.Assert(entry.App, Equals, "myapp")
+	c.Assert(entry.Teams, DeepEquals, []string{"ops", "dev"})
+	c.Assert(entry.Body, Equals, "***")
+}
+
+func (s *S) TestEntryRecord(c *C) {
+	entry := Entry{User: "me@tsuru.io", Action: "DELETE /apps/:name", App: "myapp", Timestamp: time.Now()}
+	err := entry.Record(http.StatusNotFound, &errors.Http{Code: http.StatusNotFound, Message: "App myapp not found."})
+	c.Assert(err, IsNil)
+	defer db.Session.Audit().Remove(bson.M{"app": "myapp"})
+	var stored Entry
+	err = db.Session.Audit().Find(bson.M{"app": "myapp"}).One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.Action, Equals, "DELETE /apps/:name")
+	c.Assert(stored.Status, Equals, http.StatusNotFound)
+	c.Assert(stored.Error, Equals, "App myapp not found.")
+}
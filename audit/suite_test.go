@@ -0,0 +1,28 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package audit
+
+import (
+	"github.com/globocom/tsuru/db"
+	. "launchpad.net/gocheck"
+	"testing"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type S struct{}
+
+var _ = Suite(&S{})
+
+func (s *S) SetUpSuite(c *C) {
+	var err error
+	db.Session, err = db.Open("127.0.0.1:27017", "tsuru_audit_test")
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TearDownSuite(c *C) {
+	defer db.Session.Close()
+	db.Session.Audit().Database.DropDatabase()
+}
@@ -96,7 +96,10 @@ func (m *Manager) Run(args []string) {
 	context := Context{args, m.stdout, m.stderr, m.stdin}
 	client := NewClient(&http.Client{}, &context, m)
 	err := command.(Command).Run(&context, client)
-	if err != nil {
+	if e, ok := err.(*ExitError); ok {
+		m.finisher().Exit(e.Code)
+		return
+	} else if err != nil {
 		re := regexp.MustCompile(`^((Invalid token)|(You must provide the Authorization header))`)
 		errorMsg := err.Error()
 		if re.MatchString(errorMsg) {
@@ -126,6 +129,16 @@ type Command interface {
 	Run(context *Context, client Doer) error
 }
 
+// ExitError is returned by commands that want the program to exit with the
+// given status, without writing any error message.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
 type Context struct {
 	Args   []string
 	Stdout io.Writer
@@ -51,6 +51,16 @@ func (c *ErrorCommand) Run(context *Context, client Doer) error {
 	return errors.New(c.msg)
 }
 
+type ExitCommand struct{}
+
+func (c *ExitCommand) Info() *Info {
+	return &Info{Name: "exit"}
+}
+
+func (c *ExitCommand) Run(context *Context, client Doer) error {
+	return &ExitError{Code: 3}
+}
+
 func (s *S) TestRegister(c *C) {
 	manager.Register(&TestCommand{})
 	badCall := func() { manager.Register(&TestCommand{}) }
@@ -69,6 +79,13 @@ func (s *S) TestManagerRunShouldReturnStatus1WhenCommandFail(c *C) {
 	c.Assert(manager.e.(*recordingExiter).value(), Equals, 1)
 }
 
+func (s *S) TestManagerRunShouldExitWithTheStatusOfExitErrors(c *C) {
+	manager.Register(&ExitCommand{})
+	manager.Run([]string{"exit"})
+	c.Assert(manager.e.(*recordingExiter).value(), Equals, 3)
+	c.Assert(manager.stderr.(*bytes.Buffer).String(), Equals, "")
+}
+
 func (s *S) TestManagerRunShouldAppendNewLineOnErrorWhenItsNotPresent(c *C) {
 	manager.Register(&ErrorCommand{msg: "You are wrong"})
 	manager.Run([]string{"error"})
@@ -12,13 +12,27 @@ import (
 	"io/ioutil"
 	"launchpad.net/gnuflag"
 	"net/http"
+	"sort"
 	"strings"
+	"time"
 )
 
 var AppName = gnuflag.String("app", "", "App name for running app related commands.")
 var AssumeYes = gnuflag.Bool("assume-yes", false, "Don't ask for confirmation on operations.")
 var LogLines = gnuflag.Int("lines", 10, "The number of log lines to display")
 var LogSource = gnuflag.String("source", "", "The log from the given source")
+var UnitName = gnuflag.String("unit", "", "The name of the unit")
+var LogSince = gnuflag.String("since", "", "Only show log entries newer than the given date or duration")
+var LogUntil = gnuflag.String("until", "", "Only show log entries older than the given date or duration")
+var LogFollow = gnuflag.Bool("follow", false, "Keep showing new log entries as they arrive")
+
+func init() {
+	gnuflag.BoolVar(LogFollow, "f", false, "Keep showing new log entries as they arrive")
+}
+
+var Rolling = gnuflag.Bool("rolling", false, "Restart the units of the app in batches.")
+var BatchSize = gnuflag.Int("batch-size", 1, "The number of units restarted at a time in a rolling restart")
+var ProcessType = gnuflag.String("process", "", "The process type of the units, as declared in the Procfile")
 
 type AppInfo struct {
 	GuessingCommand
@@ -61,18 +75,48 @@ func (c *AppInfo) Run(context *cmd.Context, client cmd.Doer) error {
 }
 
 type unit struct {
-	Name  string
-	Ip    string
-	State string
+	Name        string
+	Ip          string
+	State       string
+	StateSince  time.Time
+	Stuck       bool
+	Ref         string
+	ProcessType string
+}
+
+// process returns the process type of the unit. Units that don't have a
+// process type run the web process.
+func (u *unit) process() string {
+	if u.ProcessType == "" {
+		return "web"
+	}
+	return u.ProcessType
 }
 
 type app struct {
-	Name       string
-	Framework  string
-	Repository string
-	State      string
-	Teams      []string
-	Units      []unit
+	Name        string
+	Framework   string
+	Repository  string
+	State       string
+	Teams       []string
+	Units       []unit
+	Maintenance struct {
+		Enabled bool
+		User    string
+		Since   time.Time
+	}
+	Healing struct {
+		Enabled bool
+		After   time.Duration
+	}
+	Autoscale struct {
+		Enabled   bool
+		Process   string
+		MinUnits  uint
+		MaxUnits  uint
+		Metric    string
+		Threshold float64
+	}
 }
 
 func (a *app) String() string {
@@ -83,15 +127,65 @@ Platform: %s
 Teams: %s
 `
 	teams := strings.Join(a.Teams, ", ")
+	// servers that don't keep the unit history don't send StateSince.
+	var history bool
+	for _, unit := range a.Units {
+		history = history || !unit.StateSince.IsZero()
+	}
 	units := cmd.NewTable()
-	units.Headers = cmd.Row([]string{"Unit", "Ip", "State"})
+	units.Headers = cmd.Row([]string{"Unit", "Process", "Ip", "State", "Ref"})
+	if history {
+		units.Headers = append(units.Headers, "Last change")
+	}
+	counts := make(map[string]int)
 	for _, unit := range a.Units {
-		units.AddRow(cmd.Row([]string{unit.Name, unit.Ip, unit.State}))
+		state := unit.State
+		if unit.Stuck {
+			state += " (stuck)"
+		}
+		row := cmd.Row([]string{unit.Name, unit.process(), unit.Ip, state, unit.Ref})
+		if history {
+			var since string
+			if !unit.StateSince.IsZero() {
+				since = unit.StateSince.Format("2006-01-02 15:04:05")
+			}
+			row = append(row, since)
+		}
+		units.AddRow(row)
+		counts[unit.process()]++
+	}
+	processes := make([]string, 0, len(counts))
+	for process := range counts {
+		processes = append(processes, process)
+	}
+	sort.Strings(processes)
+	for i, process := range processes {
+		processes[i] = fmt.Sprintf("%s (%d)", process, counts[process])
 	}
 	args := []interface{}{a.Name, a.State, a.Repository, a.Framework, teams}
+	if a.Maintenance.Enabled {
+		format += "Maintenance: on since %s, set by %s\n"
+		args = append(args, a.Maintenance.Since.Format("2006-01-02 15:04:05"), a.Maintenance.User)
+	}
+	if a.Healing.Enabled {
+		after := a.Healing.After
+		if after == 0 {
+			after = 10 * time.Minute
+		}
+		format += "Self-healing: on, replacing units stuck for %s\n"
+		args = append(args, after)
+	}
+	if as := a.Autoscale; as.Enabled {
+		process := as.Process
+		if process == "" {
+			process = "web"
+		}
+		format += "Autoscale: on, %d to %d %s units, keeping the average %s under %s\n"
+		args = append(args, as.MinUnits, as.MaxUnits, process, as.Metric, formatThreshold(as.Metric, as.Threshold))
+	}
 	if len(a.Units) > 0 {
-		format += "Units:\n%s"
-		args = append(args, units)
+		format += "Processes: %s\nUnits:\n%s"
+		args = append(args, strings.Join(processes, ", "), units)
 	}
 	return fmt.Sprintf(format, args...)
 }
@@ -243,6 +337,9 @@ func (c *AppRestart) Run(context *cmd.Context, client cmd.Doer) error {
 		return err
 	}
 	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/restart", appName))
+	if Rolling != nil && *Rolling {
+		url = fmt.Sprintf("%s?rolling=true&batch-size=%d", url, *BatchSize)
+	}
 	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return err
@@ -262,9 +359,79 @@ func (c *AppRestart) Run(context *cmd.Context, client cmd.Doer) error {
 func (c *AppRestart) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "restart",
-		Usage: "restart [--app appname]",
+		Usage: "restart [--app appname] [--rolling] [--batch-size N]",
 		Desc: `restarts an app.
 
+With --rolling, the units are restarted in batches of --batch-size units
+(default: 1), waiting for each batch to be started again before restarting the
+next one. If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+type AppStop struct {
+	GuessingCommand
+}
+
+func (c *AppStop) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/stop", appName))
+	request, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(context.Stdout, response.Body)
+	return err
+}
+
+func (c *AppStop) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-stop",
+		Usage: "app-stop [--app appname]",
+		Desc: `stops an app, without destroying its units.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+type AppStart struct {
+	GuessingCommand
+}
+
+func (c *AppStart) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/start", appName))
+	request, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(context.Stdout, response.Body)
+	return err
+}
+
+func (c *AppStart) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-start",
+		Usage: "app-start [--app appname]",
+		Desc: `starts an app that has been stopped.
+
 If you don't provide the app name, tsuru will try to guess it.`,
 		MinArgs: 0,
 	}
@@ -14,20 +14,167 @@ import (
 func (s *S) TestAppInfo(c *C) {
 	*AppName = "app1"
 	var stdout, stderr bytes.Buffer
-	result := `{"Name":"app1","Framework":"php","Repository":"git@git.com:php.git","State":"dead", "Units":[{"Ip":"10.10.10.10","Name":"app1/0","State":"started"}, {"Ip":"9.9.9.9","Name":"app1/1","State":"started"}, {"Ip":"","Name":"app1/2","State":"pending"}],"Teams":["tsuruteam","crane"]}`
+	result := `{"Name":"app1","Framework":"php","Repository":"git@git.com:php.git","State":"dead", "Units":[{"Ip":"10.10.10.10","Name":"app1/0","State":"started","Ref":"v1.0"}, {"Ip":"9.9.9.9","Name":"app1/1","State":"started","Ref":"v1.0"}, {"Ip":"","Name":"app1/2","State":"pending"}],"Teams":["tsuruteam","crane"]}`
 	expected := `Application: app1
 State: dead
 Repository: git@git.com:php.git
 Platform: php
 Teams: tsuruteam, crane
+Processes: web (3)
 Units:
-+--------+-------------+---------+
-| Unit   | Ip          | State   |
-+--------+-------------+---------+
-| app1/0 | 10.10.10.10 | started |
-| app1/1 | 9.9.9.9     | started |
-| app1/2 |             | pending |
-+--------+-------------+---------+
++--------+---------+-------------+---------+------+
+| Unit   | Process | Ip          | State   | Ref  |
++--------+---------+-------------+---------+------+
+| app1/0 | web     | 10.10.10.10 | started | v1.0 |
+| app1/1 | web     | 9.9.9.9     | started | v1.0 |
+| app1/2 | web     |             | pending |      |
++--------+---------+-------------+---------+------+
+
+`
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: result, status: http.StatusOK}}, nil, manager)
+	command := AppInfo{}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestAppInfoWithUnitHistory(c *C) {
+	*AppName = "app1"
+	var stdout, stderr bytes.Buffer
+	result := `{"Name":"app1","Framework":"php","Repository":"git@git.com:php.git","State":"partially-started","Units":[{"Ip":"10.10.10.10","Name":"app1/0","State":"started","StateSince":"2013-02-01T10:30:00Z","Ref":"v1.0"},{"Ip":"","Name":"app1/1","State":"pending","StateSince":"2013-02-01T11:05:12Z"}],"Teams":["tsuruteam"]}`
+	expected := `Application: app1
+State: partially-started
+Repository: git@git.com:php.git
+Platform: php
+Teams: tsuruteam
+Processes: web (2)
+Units:
++--------+---------+-------------+---------+------+---------------------+
+| Unit   | Process | Ip          | State   | Ref  | Last change         |
++--------+---------+-------------+---------+------+---------------------+
+| app1/0 | web     | 10.10.10.10 | started | v1.0 | 2013-02-01 10:30:00 |
+| app1/1 | web     |             | pending |      | 2013-02-01 11:05:12 |
++--------+---------+-------------+---------+------+---------------------+
+
+`
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: result, status: http.StatusOK}}, nil, manager)
+	command := AppInfo{}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestAppInfoWithSelfHealing(c *C) {
+	*AppName = "app1"
+	var stdout, stderr bytes.Buffer
+	result := `{"Name":"app1","Framework":"php","Repository":"git@git.com:php.git","State":"degraded","Units":[{"Ip":"10.10.10.10","Name":"app1/0","State":"started"},{"Ip":"9.9.9.9","Name":"app1/1","State":"error","Stuck":true}],"Teams":["tsuruteam"],"Healing":{"Enabled":true,"After":300000000000}}`
+	expected := `Application: app1
+State: degraded
+Repository: git@git.com:php.git
+Platform: php
+Teams: tsuruteam
+Self-healing: on, replacing units stuck for 5m0s
+Processes: web (2)
+Units:
++--------+---------+-------------+---------------+-----+
+| Unit   | Process | Ip          | State         | Ref |
++--------+---------+-------------+---------------+-----+
+| app1/0 | web     | 10.10.10.10 | started       |     |
+| app1/1 | web     | 9.9.9.9     | error (stuck) |     |
++--------+---------+-------------+---------------+-----+
+
+`
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: result, status: http.StatusOK}}, nil, manager)
+	command := AppInfo{}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestAppInfoWithAutoscale(c *C) {
+	*AppName = "app1"
+	var stdout, stderr bytes.Buffer
+	result := `{"Name":"app1","Framework":"php","Repository":"git@git.com:php.git","State":"started","Units":[{"Ip":"10.10.10.10","Name":"app1/0","State":"started"}],"Teams":["tsuruteam"],"Autoscale":{"Enabled":true,"Process":"","MinUnits":1,"MaxUnits":4,"Metric":"cpu","Threshold":70}}`
+	expected := `Application: app1
+State: started
+Repository: git@git.com:php.git
+Platform: php
+Teams: tsuruteam
+Autoscale: on, 1 to 4 web units, keeping the average cpu under 70.0%
+Processes: web (1)
+Units:
++--------+---------+-------------+---------+-----+
+| Unit   | Process | Ip          | State   | Ref |
++--------+---------+-------------+---------+-----+
+| app1/0 | web     | 10.10.10.10 | started |     |
++--------+---------+-------------+---------+-----+
+
+`
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: result, status: http.StatusOK}}, nil, manager)
+	command := AppInfo{}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestAppInfoInMaintenance(c *C) {
+	*AppName = "app1"
+	var stdout, stderr bytes.Buffer
+	result := `{"Name":"app1","Framework":"php","Repository":"git@git.com:php.git","State":"started","Teams":["tsuruteam"],"Maintenance":{"Enabled":true,"User":"admin@tsuru.io","Since":"2013-02-01T10:30:00Z"}}`
+	expected := `Application: app1
+State: started
+Repository: git@git.com:php.git
+Platform: php
+Teams: tsuruteam
+Maintenance: on since 2013-02-01 10:30:00, set by admin@tsuru.io
+
+`
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: result, status: http.StatusOK}}, nil, manager)
+	command := AppInfo{}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestAppInfoWithProcesses(c *C) {
+	*AppName = "app1"
+	var stdout, stderr bytes.Buffer
+	result := `{"Name":"app1","Framework":"ruby","Repository":"git@git.com:app1.git","State":"started", "Units":[{"Ip":"10.10.10.10","Name":"app1/0","State":"started","ProcessType":"web"}, {"Ip":"10.10.10.11","Name":"app1/1","State":"started","ProcessType":"worker"}, {"Ip":"10.10.10.12","Name":"app1/2","State":"started","ProcessType":"worker"}, {"Ip":"10.10.10.13","Name":"app1/3","State":"started","ProcessType":"clock"}],"Teams":["tsuruteam"]}`
+	expected := `Application: app1
+State: started
+Repository: git@git.com:app1.git
+Platform: ruby
+Teams: tsuruteam
+Processes: clock (1), web (1), worker (2)
+Units:
++--------+---------+-------------+---------+-----+
+| Unit   | Process | Ip          | State   | Ref |
++--------+---------+-------------+---------+-----+
+| app1/0 | web     | 10.10.10.10 | started |     |
+| app1/1 | worker  | 10.10.10.11 | started |     |
+| app1/2 | worker  | 10.10.10.12 | started |     |
+| app1/3 | clock   | 10.10.10.13 | started |     |
++--------+---------+-------------+---------+-----+
 
 `
 	context := cmd.Context{
@@ -71,13 +218,14 @@ State: dead
 Repository: git@git.com:php.git
 Platform: ruby
 Teams: tsuruteam, crane
+Processes: web (2)
 Units:
-+----------+-------------+---------+
-| Unit     | Ip          | State   |
-+----------+-------------+---------+
-| secret/0 | 10.10.10.10 | started |
-| secret/1 | 9.9.9.9     | pending |
-+----------+-------------+---------+
++----------+---------+-------------+---------+-----+
+| Unit     | Process | Ip          | State   | Ref |
++----------+---------+-------------+---------+-----+
+| secret/0 | web     | 10.10.10.10 | started |     |
+| secret/1 | web     | 9.9.9.9     | pending |     |
++----------+---------+-------------+---------+-----+
 
 `
 	context := cmd.Context{
@@ -287,13 +435,46 @@ func (s *S) TestAppRestartWithoutTheFlag(c *C) {
 	c.Assert(stdout.String(), Equals, "Restarted")
 }
 
+func (s *S) TestAppRestartRolling(c *C) {
+	*AppName = "handful_of_nothing"
+	*Rolling = true
+	*BatchSize = 2
+	defer func() { *BatchSize = 1 }()
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "Restarted",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/handful_of_nothing/restart" && req.Method == "GET" &&
+				req.URL.Query().Get("rolling") == "true" && req.URL.Query().Get("batch-size") == "2"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&AppRestart{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "Restarted")
+}
+
 func (s *S) TestAppRestartInfo(c *C) {
 	expected := &cmd.Info{
 		Name:  "restart",
-		Usage: "restart [--app appname]",
+		Usage: "restart [--app appname] [--rolling] [--batch-size N]",
 		Desc: `restarts an app.
 
-If you don't provide the app name, tsuru will try to guess it.`,
+With --rolling, the units are restarted in batches of --batch-size units
+(default: 1), waiting for each batch to be started again before restarting the
+next one. If you don't provide the app name, tsuru will try to guess it.`,
 		MinArgs: 0,
 	}
 	c.Assert((&AppRestart{}).Info(), DeepEquals, expected)
@@ -306,3 +487,124 @@ func (s *S) TestAppRestartIsACommand(c *C) {
 func (s *S) TestAppRestartIsAnInfoer(c *C) {
 	var _ cmd.Infoer = &AppRestart{}
 }
+
+func (s *S) TestAppStop(c *C) {
+	*AppName = "handful_of_nothing"
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "Stopped",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/handful_of_nothing/stop" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&AppStop{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "Stopped")
+}
+
+func (s *S) TestAppStopWithoutTheFlag(c *C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "Stopped",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/motorbreath/stop" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "motorbreath"}
+	err := (&AppStop{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "Stopped")
+}
+
+func (s *S) TestAppStopInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "app-stop",
+		Usage: "app-stop [--app appname]",
+		Desc: `stops an app, without destroying its units.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+	c.Assert((&AppStop{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestAppStopIsACommand(c *C) {
+	var _ cmd.Command = &AppStop{}
+}
+
+func (s *S) TestAppStopIsAnInfoer(c *C) {
+	var _ cmd.Infoer = &AppStop{}
+}
+
+func (s *S) TestAppStart(c *C) {
+	*AppName = "handful_of_nothing"
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "Started",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/handful_of_nothing/start" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&AppStart{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "Started")
+}
+
+func (s *S) TestAppStartInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "app-start",
+		Usage: "app-start [--app appname]",
+		Desc: `starts an app that has been stopped.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+	c.Assert((&AppStart{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestAppStartIsACommand(c *C) {
+	var _ cmd.Command = &AppStart{}
+}
+
+func (s *S) TestAppStartIsAnInfoer(c *C) {
+	var _ cmd.Infoer = &AppStart{}
+}
@@ -0,0 +1,98 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"launchpad.net/gnuflag"
+	"net/http"
+	"strconv"
+)
+
+var (
+	AutoscaleMin       = gnuflag.Int("min", 1, "The minimum number of units kept by the autoscale")
+	AutoscaleMax       = gnuflag.Int("max", 0, "The maximum number of units kept by the autoscale")
+	AutoscaleMetric    = gnuflag.String("metric", "cpu", "The metric that drives the autoscale: cpu, memory or network")
+	AutoscaleThreshold = gnuflag.String("threshold", "", "The average usage of the metric above which units are added")
+	AutoscaleCooldown  = gnuflag.String("cooldown", "", "The minimum interval between two scaling decisions")
+)
+
+type AppAutoscale struct {
+	GuessingCommand
+}
+
+func (c *AppAutoscale) Info() *cmd.Info {
+	return &cmd.Info{
+		Name: "app-autoscale",
+		Usage: "app-autoscale <on|off> [--min units] [--max units] [--metric cpu|memory|network] " +
+			"[--threshold value] [--cooldown duration] [--process type] [--app appname]",
+		Desc: `turns the autoscale of an app on or off.
+
+When autoscale is on, tsuru keeps between --min and --max units of the given
+process (web by default), adding a unit when the average usage of the metric
+in the units is above the threshold, and removing a unit when the average
+would stay below the threshold without it. The threshold is a percentage for
+cpu, megabytes for memory and kilobytes received per second for network.
+Scaling decisions are at least --cooldown apart (5 minutes by default).
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *AppAutoscale) Run(context *cmd.Context, client cmd.Doer) error {
+	mode := context.Args[0]
+	if mode != "on" && mode != "off" {
+		return errors.New(`Invalid mode, it must be "on" or "off".`)
+	}
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	params := map[string]interface{}{"enabled": mode == "on"}
+	if mode == "on" {
+		threshold, err := strconv.ParseFloat(*AutoscaleThreshold, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid threshold %q, it must be a number.", *AutoscaleThreshold)
+		}
+		params["min"] = *AutoscaleMin
+		params["max"] = *AutoscaleMax
+		params["metric"] = *AutoscaleMetric
+		params["threshold"] = threshold
+		params["cooldown"] = *AutoscaleCooldown
+		params["process"] = *ProcessType
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/autoscale", appName))
+	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if _, err = client.Do(request); err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Autoscale of the app %s turned %s.\n", appName, mode)
+	return nil
+}
+
+// formatThreshold formats the threshold of an autoscale policy in the unit of
+// its metric.
+func formatThreshold(metric string, value float64) string {
+	switch metric {
+	case "memory":
+		return fmt.Sprintf("%.1f MB", value)
+	case "network":
+		return fmt.Sprintf("%.1f KB/s", value)
+	}
+	return fmt.Sprintf("%.1f%%", value)
+}
@@ -0,0 +1,80 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net/http"
+)
+
+func (s *S) TestAppAutoscaleInfo(c *C) {
+	info := (&AppAutoscale{}).Info()
+	c.Assert(info.Name, Equals, "app-autoscale")
+	c.Assert(info.Usage, Equals, "app-autoscale <on|off> [--min units] [--max units] [--metric cpu|memory|network] "+
+		"[--threshold value] [--cooldown duration] [--process type] [--app appname]")
+	c.Assert(info.MinArgs, Equals, 1)
+}
+
+func (s *S) TestAppAutoscaleOn(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{"on"}, Stdout: &stdout, Stderr: &stderr}
+	trans := &conditionalTransport{
+		transport{msg: "", status: http.StatusOK},
+		func(req *http.Request) bool {
+			var body map[string]interface{}
+			b, _ := ioutil.ReadAll(req.Body)
+			json.Unmarshal(b, &body)
+			return req.URL.Path == "/apps/ble/autoscale" && req.Method == "POST" &&
+				body["enabled"] == true && body["min"] == float64(2) && body["max"] == float64(5) &&
+				body["metric"] == "memory" && body["threshold"] == 256.5 && body["cooldown"] == "10m" &&
+				body["process"] == "worker"
+		},
+	}
+	*AutoscaleMin, *AutoscaleMax = 2, 5
+	*AutoscaleMetric, *AutoscaleThreshold, *AutoscaleCooldown = "memory", "256.5", "10m"
+	*ProcessType = "worker"
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&AppAutoscale{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "Autoscale of the app ble turned on.\n")
+}
+
+func (s *S) TestAppAutoscaleOff(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{"off"}, Stdout: &stdout, Stderr: &stderr}
+	trans := &conditionalTransport{
+		transport{msg: "", status: http.StatusOK},
+		func(req *http.Request) bool {
+			b, _ := ioutil.ReadAll(req.Body)
+			return req.URL.Path == "/apps/ble/autoscale" && req.Method == "POST" && string(b) == `{"enabled":false}`
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&AppAutoscale{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "Autoscale of the app ble turned off.\n")
+}
+
+func (s *S) TestAppAutoscaleInvalidThreshold(c *C) {
+	context := cmd.Context{Args: []string{"on"}}
+	*AutoscaleThreshold = "high"
+	fake := &FakeGuesser{name: "ble"}
+	err := (&AppAutoscale{GuessingCommand{G: fake}}).Run(&context, nil)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `Invalid threshold "high", it must be a number.`)
+}
+
+func (s *S) TestAppAutoscaleInvalidMode(c *C) {
+	context := cmd.Context{Args: []string{"sometimes"}}
+	err := (&AppAutoscale{}).Run(&context, nil)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `Invalid mode, it must be "on" or "off".`)
+}
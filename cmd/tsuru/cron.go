@@ -0,0 +1,173 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type cron struct {
+	Id       string
+	Schedule string
+	Command  string
+	Conf     bool
+	LastRun  time.Time
+	NextRun  time.Time
+}
+
+type CronAdd struct {
+	GuessingCommand
+}
+
+func (c *CronAdd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "cron-add",
+		Usage: `cron-add "<schedule>" <command> [--app appname]`,
+		Desc: `adds a scheduled job to an app.
+
+The schedule is in the crontab format, with five fields (minute, hour, day of
+month, month and day of week), and must be quoted. The shortcuts @hourly,
+@daily, @weekly, @monthly and @yearly are also accepted. The command runs in
+one unit of the app, and its output goes to the app log.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 2,
+	}
+}
+
+func (c *CronAdd) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]string{
+		"schedule": context.Args[0],
+		"command":  strings.Join(context.Args[1:], " "),
+	})
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/crons", appName))
+	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var job cron
+	if err = json.Unmarshal(result, &job); err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Cron job %s successfully added to the app %s. Next run: %s.\n",
+		job.Id, appName, job.NextRun.Format("2006-01-02 15:04"))
+	return nil
+}
+
+type CronList struct {
+	GuessingCommand
+}
+
+func (c *CronList) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "cron-list",
+		Usage: "cron-list [--app appname]",
+		Desc: `lists the scheduled jobs of an app, including the ones declared in app.conf.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *CronList) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", cmd.GetUrl(fmt.Sprintf("/apps/%s/crons", appName)), nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var jobs []cron
+	if err = json.Unmarshal(result, &jobs); err != nil {
+		return err
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"Id", "Schedule", "Command", "Next run", "Last run"})
+	for _, j := range jobs {
+		id := j.Id
+		if j.Conf {
+			id += " (app.conf)"
+		}
+		var last string
+		if !j.LastRun.IsZero() {
+			last = j.LastRun.Format("2006-01-02 15:04")
+		}
+		table.AddRow(cmd.Row([]string{id, j.Schedule, j.Command, j.NextRun.Format("2006-01-02 15:04"), last}))
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
+
+type CronRemove struct {
+	GuessingCommand
+}
+
+func (c *CronRemove) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "cron-remove",
+		Usage: "cron-remove <id> [--app appname]",
+		Desc: `removes a scheduled job from an app.
+
+Jobs declared in app.conf can't be removed with this command, remove them from
+app.conf instead.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *CronRemove) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/crons/%s", appName, context.Args[0]))
+	request, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	if _, err = client.Do(request); err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Cron job %s successfully removed from the app %s.\n", context.Args[0], appName)
+	return nil
+}
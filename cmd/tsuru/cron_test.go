@@ -0,0 +1,116 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net/http"
+)
+
+func (s *S) TestCronAddInfo(c *C) {
+	info := (&CronAdd{}).Info()
+	c.Assert(info.Name, Equals, "cron-add")
+	c.Assert(info.Usage, Equals, `cron-add "<schedule>" <command> [--app appname]`)
+	c.Assert(info.MinArgs, Equals, 2)
+}
+
+func (s *S) TestCronAdd(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"*/5 * * * *", "python", "manage.py", "clearsessions"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	result := `{"Id":"abc123","Schedule":"*/5 * * * *","Command":"python manage.py clearsessions","NextRun":"2013-02-01T10:35:00Z"}`
+	trans := &conditionalTransport{
+		transport{msg: result, status: http.StatusOK},
+		func(req *http.Request) bool {
+			var body map[string]string
+			b, _ := ioutil.ReadAll(req.Body)
+			json.Unmarshal(b, &body)
+			return req.URL.Path == "/apps/ble/crons" && req.Method == "POST" &&
+				body["schedule"] == "*/5 * * * *" && body["command"] == "python manage.py clearsessions"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&CronAdd{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "Cron job abc123 successfully added to the app ble. Next run: 2013-02-01 10:35.\n")
+}
+
+func (s *S) TestCronListInfo(c *C) {
+	info := (&CronList{}).Info()
+	c.Assert(info.Name, Equals, "cron-list")
+	c.Assert(info.Usage, Equals, "cron-list [--app appname]")
+	c.Assert(info.MinArgs, Equals, 0)
+}
+
+func (s *S) TestCronList(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	result := `[{"Id":"abc123","Schedule":"*/5 * * * *","Command":"./poll.sh","NextRun":"2013-02-01T10:35:00Z","LastRun":"2013-02-01T10:30:00Z"},
+{"Id":"def456","Schedule":"@daily","Command":"./backup.sh","Conf":true,"NextRun":"2013-02-02T00:00:00Z"}]`
+	trans := &conditionalTransport{
+		transport{msg: result, status: http.StatusOK},
+		func(req *http.Request) bool {
+			return req.URL.Path == "/apps/ble/crons" && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&CronList{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	expected := `+-------------------+-------------+-------------+------------------+------------------+
+| Id                | Schedule    | Command     | Next run         | Last run         |
++-------------------+-------------+-------------+------------------+------------------+
+| abc123            | */5 * * * * | ./poll.sh   | 2013-02-01 10:35 | 2013-02-01 10:30 |
+| def456 (app.conf) | @daily      | ./backup.sh | 2013-02-02 00:00 |                  |
++-------------------+-------------+-------------+------------------+------------------+
+`
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestCronListWithoutJobs(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &transport{msg: "", status: http.StatusNoContent}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&CronList{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "")
+}
+
+func (s *S) TestCronRemoveInfo(c *C) {
+	info := (&CronRemove{}).Info()
+	c.Assert(info.Name, Equals, "cron-remove")
+	c.Assert(info.Usage, Equals, "cron-remove <id> [--app appname]")
+	c.Assert(info.MinArgs, Equals, 1)
+}
+
+func (s *S) TestCronRemove(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"abc123"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{msg: "", status: http.StatusOK},
+		func(req *http.Request) bool {
+			return req.URL.Path == "/apps/ble/crons/abc123" && req.Method == "DELETE"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&CronRemove{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "Cron job abc123 successfully removed from the app ble.\n")
+}
@@ -0,0 +1,162 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type deploy struct {
+	Ref       string
+	Commit    string
+	User      string
+	Timestamp time.Time
+	Duration  time.Duration
+	Success   bool
+	Error     string
+}
+
+type AppDeploy struct {
+	GuessingCommand
+}
+
+func (c *AppDeploy) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-deploy",
+		Usage: "app-deploy [ref] [--app appname]",
+		Desc: `deploys a branch, tag or commit of an app.
+
+If you don't provide the ref, tsuru will deploy master. If you don't provide the
+app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *AppDeploy) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	var ref string
+	if len(context.Args) > 0 {
+		ref = context.Args[0]
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/deploy", appName))
+	request, err := http.NewRequest("POST", url, strings.NewReader(ref))
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(context.Stdout, response.Body)
+	return err
+}
+
+type AppDeployList struct {
+	GuessingCommand
+}
+
+func (c *AppDeployList) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-deploy-list",
+		Usage: "app-deploy-list [--app appname]",
+		Desc: `list the deploy history of an app, most recent first.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *AppDeployList) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/deploys", appName))
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	defer response.Body.Close()
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var deploys []deploy
+	err = json.Unmarshal(result, &deploys)
+	if err != nil {
+		return err
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"Ref", "Commit", "User", "Date", "Duration", "Status"})
+	for _, d := range deploys {
+		commit := d.Commit
+		if len(commit) > 7 {
+			commit = commit[:7]
+		}
+		status := "success"
+		if !d.Success {
+			status = "failed"
+		}
+		date := d.Timestamp.Format("2006-01-02 15:04:05")
+		duration := (d.Duration / time.Second * time.Second).String()
+		table.AddRow(cmd.Row([]string{d.Ref, commit, d.User, date, duration, status}))
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
+
+type AppRollback struct {
+	GuessingCommand
+}
+
+func (c *AppRollback) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-rollback",
+		Usage: "app-rollback <commit> [--app appname]",
+		Desc: `rolls an app back to a previously deployed commit.
+
+The commit may be abbreviated, and must have been successfully deployed
+before (see app-deploy-list). If you don't provide the app name, tsuru will
+try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *AppRollback) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/rollback", appName))
+	request, err := http.NewRequest("POST", url, strings.NewReader(context.Args[0]))
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(context.Stdout, response.Body)
+	return err
+}
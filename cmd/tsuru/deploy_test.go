@@ -0,0 +1,174 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net/http"
+)
+
+func (s *S) TestAppDeploy(c *C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Args:   []string{"v1.0"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{msg: "Deploy done!", status: http.StatusOK},
+		func(req *http.Request) bool {
+			called = true
+			body, _ := ioutil.ReadAll(req.Body)
+			return req.URL.Path == "/apps/ble/deploy" && req.Method == "POST" && string(body) == "v1.0"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&AppDeploy{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "Deploy done!")
+}
+
+func (s *S) TestAppDeployWithoutRef(c *C) {
+	*AppName = "ble"
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{msg: "Deploy done!", status: http.StatusOK},
+		func(req *http.Request) bool {
+			called = true
+			body, _ := ioutil.ReadAll(req.Body)
+			return req.URL.Path == "/apps/ble/deploy" && req.Method == "POST" && len(body) == 0
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&AppDeploy{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+}
+
+func (s *S) TestAppDeployInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "app-deploy",
+		Usage: "app-deploy [ref] [--app appname]",
+		Desc: `deploys a branch, tag or commit of an app.
+
+If you don't provide the ref, tsuru will deploy master. If you don't provide the
+app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+	c.Assert((&AppDeploy{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestAppDeployList(c *C) {
+	*AppName = "ble"
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	result := `[{"Ref":"v1.0","Commit":"6c7a8e93c1ab2c7b3b5d8bc4e3c2f3a8b3d1a2e4","User":"someone@tsuru.io","Timestamp":"2012-11-20T10:00:00Z","Duration":65400000000,"Success":true,"Error":""},
+{"Ref":"master","Commit":"b5d8bc4e3c2f3a8b3d1a2e46c7a8e93c1ab2c7b3","User":"","Timestamp":"2012-11-19T10:00:00Z","Duration":3000000000,"Success":false,"Error":"failed"}]`
+	expected := `+--------+---------+------------------+---------------------+----------+---------+
+| Ref    | Commit  | User             | Date                | Duration | Status  |
++--------+---------+------------------+---------------------+----------+---------+
+| v1.0   | 6c7a8e9 | someone@tsuru.io | 2012-11-20 10:00:00 | 1m5s     | success |
+| master | b5d8bc4 |                  | 2012-11-19 10:00:00 | 3s       | failed  |
++--------+---------+------------------+---------------------+----------+---------+
+`
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{msg: result, status: http.StatusOK},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/ble/deploys" && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&AppDeployList{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestAppDeployListWithoutDeploys(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: "", status: http.StatusNoContent}}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&AppDeployList{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "")
+}
+
+func (s *S) TestAppDeployListInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "app-deploy-list",
+		Usage: "app-deploy-list [--app appname]",
+		Desc: `list the deploy history of an app, most recent first.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+	c.Assert((&AppDeployList{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestAppRollback(c *C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Args:   []string{"6c7a8e9"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{msg: "Rollback done!", status: http.StatusOK},
+		func(req *http.Request) bool {
+			called = true
+			body, _ := ioutil.ReadAll(req.Body)
+			return req.URL.Path == "/apps/ble/rollback" && req.Method == "POST" && string(body) == "6c7a8e9"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&AppRollback{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "Rollback done!")
+}
+
+func (s *S) TestAppRollbackInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "app-rollback",
+		Usage: "app-rollback <commit> [--app appname]",
+		Desc: `rolls an app back to a previously deployed commit.
+
+The commit may be abbreviated, and must have been successfully deployed
+before (see app-deploy-list). If you don't provide the app name, tsuru will
+try to guess it.`,
+		MinArgs: 1,
+	}
+	c.Assert((&AppRollback{}).Info(), DeepEquals, expected)
+}
@@ -103,9 +103,13 @@ type UnitAdd struct {
 
 func (c *UnitAdd) Info() *cmd.Info {
 	return &cmd.Info{
-		Name:    "unit-add",
-		Usage:   "unit-add <# of units> [--app appname]",
-		Desc:    "add new units to an app.",
+		Name:  "unit-add",
+		Usage: "unit-add <# of units> [--process type] [--app appname]",
+		Desc: `add new units to an app.
+
+The new units run the given process type, which must be declared in the
+Procfile of the app. If you don't provide the process type, the units run the
+web process.`,
 		MinArgs: 1,
 	}
 }
@@ -116,6 +120,9 @@ func (c *UnitAdd) Run(context *cmd.Context, client cmd.Doer) error {
 		return err
 	}
 	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/units", appName))
+	if *tsuru.ProcessType != "" {
+		url += "?process=" + *tsuru.ProcessType
+	}
 	request, err := http.NewRequest("PUT", url, bytes.NewBufferString(context.Args[0]))
 	if err != nil {
 		return err
@@ -127,3 +134,39 @@ func (c *UnitAdd) Run(context *cmd.Context, client cmd.Doer) error {
 	fmt.Fprintln(context.Stdout, "Units successfully added!")
 	return nil
 }
+
+type UnitRemove struct {
+	tsuru.GuessingCommand
+}
+
+func (c *UnitRemove) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "unit-remove",
+		Usage: "unit-remove <# of units | unit name> [--process type] [--app appname]",
+		Desc: `remove units from an app.
+
+If you provide the process type, only units running that process are removed.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *UnitRemove) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/units", appName))
+	if *tsuru.ProcessType != "" {
+		url += "?process=" + *tsuru.ProcessType
+	}
+	request, err := http.NewRequest("DELETE", url, bytes.NewBufferString(context.Args[0]))
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(context.Stdout, "Units successfully removed!")
+	return nil
+}
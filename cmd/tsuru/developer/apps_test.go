@@ -195,6 +195,33 @@ func (s *S) TestUnitAdd(c *C) {
 	c.Assert(stdout.String(), Equals, expected)
 }
 
+func (s *S) TestUnitAddWithProcessType(c *C) {
+	*tsuru.AppName = "radio"
+	*tsuru.ProcessType = "worker"
+	var stdout, stderr bytes.Buffer
+	var called bool
+	context := cmd.Context{
+		Args:   []string{"3"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/radio/units" && req.URL.RawQuery == "process=worker"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := UnitAdd{}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+}
+
 func (s *S) TestUnitAddFailure(c *C) {
 	*tsuru.AppName = "radio"
 	var stdout, stderr bytes.Buffer
@@ -212,9 +239,13 @@ func (s *S) TestUnitAddFailure(c *C) {
 
 func (s *S) TestUnitAddInfo(c *C) {
 	expected := &cmd.Info{
-		Name:    "unit-add",
-		Usage:   "unit-add <# of units> [--app appname]",
-		Desc:    "add new units to an app.",
+		Name:  "unit-add",
+		Usage: "unit-add <# of units> [--process type] [--app appname]",
+		Desc: `add new units to an app.
+
+The new units run the given process type, which must be declared in the
+Procfile of the app. If you don't provide the process type, the units run the
+web process.`,
 		MinArgs: 1,
 	}
 	c.Assert((&UnitAdd{}).Info(), DeepEquals, expected)
@@ -227,3 +258,121 @@ func (s *S) TestUnitAddIsACommand(c *C) {
 func (s *S) TestUnitAddIsAnInfoer(c *C) {
 	var _ cmd.Infoer = &UnitAdd{}
 }
+
+func (s *S) TestUnitRemove(c *C) {
+	*tsuru.AppName = "vapor"
+	var stdout, stderr bytes.Buffer
+	var called bool
+	context := cmd.Context{
+		Args:   []string{"2"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			b, err := ioutil.ReadAll(req.Body)
+			c.Assert(err, IsNil)
+			c.Assert(string(b), Equals, "2")
+			return req.URL.Path == "/apps/vapor/units" && req.Method == "DELETE"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := UnitRemove{}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	expected := "Units successfully removed!\n"
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestUnitRemoveByName(c *C) {
+	*tsuru.AppName = "vapor"
+	var stdout, stderr bytes.Buffer
+	var called bool
+	context := cmd.Context{
+		Args:   []string{"vapor/1"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			b, err := ioutil.ReadAll(req.Body)
+			c.Assert(err, IsNil)
+			c.Assert(string(b), Equals, "vapor/1")
+			return req.URL.Path == "/apps/vapor/units" && req.Method == "DELETE"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := UnitRemove{}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+}
+
+func (s *S) TestUnitRemoveWithProcessType(c *C) {
+	*tsuru.AppName = "vapor"
+	*tsuru.ProcessType = "clock"
+	var stdout, stderr bytes.Buffer
+	var called bool
+	context := cmd.Context{
+		Args:   []string{"1"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/vapor/units" && req.URL.RawQuery == "process=clock" && req.Method == "DELETE"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := UnitRemove{}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+}
+
+func (s *S) TestUnitRemoveFailure(c *C) {
+	*tsuru.AppName = "vapor"
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"1"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: "Failed to remove.", status: 500}}, nil, manager)
+	command := UnitRemove{}
+	err := command.Run(&context, client)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Failed to remove.")
+}
+
+func (s *S) TestUnitRemoveInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "unit-remove",
+		Usage: "unit-remove <# of units | unit name> [--process type] [--app appname]",
+		Desc: `remove units from an app.
+
+If you provide the process type, only units running that process are removed.`,
+		MinArgs: 1,
+	}
+	c.Assert((&UnitRemove{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestUnitRemoveIsACommand(c *C) {
+	var _ cmd.Command = &UnitRemove{}
+}
@@ -38,13 +38,39 @@ The currently available commands are (grouped by subject):
 	app-grant         allows a team to have access to an app
 	app-revoke        revokes access to an app from a team
 	unit-add          adds new units to an app
+	unit-remove       removes units from an app
 	log               shows log for an app
 	run               runs a command in all units of an app
 	restart           restarts the app's application server
+	app-stop          stops the app's application server
+	app-start         starts the app's application server
+	app-healing       turns the self-healing of an app on or off
+	app-metrics       displays the resources used by the units of an app
+	app-autoscale     turns the autoscale of an app on or off
+	app-deploy        deploys a branch, tag or commit of an app
+	app-deploy-list   lists the deploy history of an app
+	app-rollback      rolls an app back to a previously deployed commit
+	drain-add         adds a log drain to an app
+	drain-remove      removes a log drain from an app
+	drain-list        lists the log drains of an app
 
 	env-get           display environment variables for an app
 	env-set           set environment variable(s) to an app
 	env-unset         unset environment variable(s) from an app
+	env-history       list the versions of the environment variables of an app
+	env-revert        restore the environment variables of an app to a previous version
+
+	apply             converges an app to the state declared in a manifest
+	export            writes the manifest of an app
+
+	webhook-add       registers a webhook for an app or a team
+	webhook-list      lists the webhooks of an app or a team
+	webhook-remove    removes a webhook
+	webhook-deliveries shows the latest deliveries of a webhook
+
+	cron-add          adds a scheduled job to an app
+	cron-list         lists the scheduled jobs of an app
+	cron-remove       removes a scheduled job from an app
 
 	bind              binds an app to a service instance
 	unbind            unbinds an app from a service instance
@@ -62,8 +88,11 @@ Use "tsuru help <command>" for more information about a command.
 Guessing app names
 
 In some app-related commands (app-remove, app-info, app-grant, app-revoke, log,
-run, restart, env-get, env-set, env-unset, bind and unbind), there is an
-optional parameter --app, used to specify the name of the app.
+run, restart, app-stop, app-start, app-healing, app-metrics, app-autoscale,
+app-deploy, app-deploy-list, app-rollback, drain-add, drain-remove, drain-list,
+env-get, env-set, env-unset, env-history, env-revert, export, webhook-add,
+cron-add, cron-list, cron-remove, bind and unbind), there is an optional
+parameter --app, used to specify the name of the app.
 
 The --app parameter is optional, if omitted, tsuru will try to "guess" the name
 of the app based in the configuration of the git repository. It will try to
@@ -293,6 +322,14 @@ app-info will display some informations about an specific app (its state,
 platform, git repository, etc.). You need to be a member of a team that access
 to the app to be able to see informations about it.
 
+app-info also displays the process type of each unit, and how many units run
+each process type, and when the state of each unit last changed.
+
+The state of the app is computed from the states of all its units. When only
+some of the units are started, the app is "partially-started" if the other
+units are still being provisioned, and "degraded" if any of them is down or
+failed.
+
 The --app flag is optional, see "Guessing app names" section for more details.
 
 
@@ -326,11 +363,32 @@ Add new units to the app
 
 Usage:
 
-	% tsuru unit-add <# of units> [--app appname]
+	% tsuru unit-add <# of units> [--process type] [--app appname]
 
 unit-add will add new units (instances) to an app. You need to have access to
 the app to be able to add new units to it.
 
+The --process flag sets the process type that runs in the new units. Process
+types are declared in the Procfile of the app, in the form "<type>: <command>",
+and the default process type is web.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Remove units from the app
+
+Usage:
+
+	% tsuru unit-remove <# of units | unit name> [--process type] [--app appname]
+
+unit-remove will remove units (instances) from an app. It takes either the
+number of units to remove, in which case the oldest units are removed, or the
+name of the unit to remove, as displayed in app-info. An app must keep at least
+one unit. You need to have access to the app to be able to remove its units.
+
+The --process flag restricts the removal to units running the given process
+type.
+
 The --app flag is optional, see "Guessing app names" section for more details.
 
 
@@ -338,7 +396,7 @@ See app's logs
 
 Usage:
 
-	% tsuru log [--app appname] [--lines numberOfLines] [--source source]
+	% tsuru log [--app appname] [--lines numberOfLines] [--source source] [--unit unit] [--since date] [--until date] [--follow]
 
 Log will show log entries for an app. These logs are not related to the code of
 the app itself, but to actions of the app in tsuru server (deployments,
@@ -347,13 +405,20 @@ restarts, etc.).
 The --app flag is optional, see "Guessing app names" section for more details.
 The --lines flag is optional and by default its value is 10.
 The --source flag is optional.
+The --unit flag is optional, and filters the entries generated by the given
+unit.
+The --since and --until flags are optional, and take either a date, in the
+format "2006-01-02 15:04:05", or a duration relative to now, like "30m".
+The --follow flag (or -f) is optional. When provided, tsuru keeps the
+connection open and shows new log entries as they arrive, until you interrupt
+it.
 
 
 Run an arbitrary command in the app machine
 
 Usage:
 
-	% tsuru run <command> [commandarg1] [commandarg2] ... [commandargn] [--app appname]
+	% tsuru run <command> [commandarg1] [commandarg2] ... [commandargn] [--app appname] [--unit unitname] [--once] [--timeout duration]
 
 Run will run an arbitrary command in the app machine. Base directory for all
 commands is the root of the app. For example, in a Django app, "tsuru run" may
@@ -374,6 +439,16 @@ show the following output:
 	urls.py
 	urls.pyc
 
+By default, the command runs in all units of the app. Use --unit to run it in
+a single unit, or --once to run it in any of the started units, which is what
+you want for database migrations:
+
+	% tsuru run --once --timeout 10m python manage.py migrate
+
+With --timeout, the command is killed if it runs for longer than the given
+duration. tsuru exits with the exit status of the command, so it can be used in
+scripts; killed commands exit with the status 124.
+
 The --app flag is optional, see "Guessing app names" section for more details.
 
 
@@ -381,11 +456,188 @@ Restart the app's application server
 
 Usage:
 
-	% tsuru restart [--app appname]
+	% tsuru restart [--app appname] [--rolling] [--batch-size N]
 
 Restart will restart the application server (as defined in Procfile) of the
 application.
 
+By default, all units are restarted at once. With --rolling, tsuru restarts
+--batch-size units at a time (default: 1), waiting for the units of each batch
+to be started again before restarting the next batch, so the app is never down
+as a whole. If a batch fails, the rolling restart is aborted and the remaining
+units are not restarted.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Stop the app's application server
+
+Usage:
+
+	% tsuru app-stop [--app appname]
+
+app-stop will stop the application server in all units of the app, taking the
+app offline without destroying its units. The app can be brought back online
+with app-start.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Start the app's application server
+
+Usage:
+
+	% tsuru app-start [--app appname]
+
+app-start will start the application server in all units of an app that has
+been stopped with app-stop.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Turn the self-healing of an app on or off
+
+Usage:
+
+	% tsuru app-healing <on|off> [--after duration] [--app appname]
+
+tsuru removes the units that are no longer reported by the provisioner, and
+flags in the app log the units that stay in the error or down state for longer
+than 10 minutes. When self-healing is on, these stuck units are also replaced
+by new units running the same process. The --after flag changes how long units
+may stay in the error or down state, like 5m or 1h:
+
+	% tsuru app-healing on --after 5m
+
+Units are only replaced while the app has started units. All actions are
+logged in the app log.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Display the resources used by the units of an app
+
+Usage:
+
+	% tsuru app-metrics [--since duration] [--app appname]
+
+tsuru collector samples, every minute, the CPU, memory, disk and network usage
+of the started units of each app, and keeps the samples for 24 hours.
+app-metrics displays a table with the latest sample of each unit, followed by
+a summary of each resource, with its minimum, average and maximum values in
+the period. By default, the period is the last hour, the --since flag changes
+it, like 30m or 6h:
+
+	% tsuru app-metrics --since 6h
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Turn the autoscale of an app on or off
+
+Usage:
+
+	% tsuru app-autoscale <on|off> [--min units] [--max units] [--metric cpu|memory|network] [--threshold value] [--cooldown duration] [--process type] [--app appname]
+
+When autoscale is on, tsuru collector keeps between --min and --max units
+running the given process (web by default). Using the metrics of the last five
+minutes (see app-metrics), it adds a unit when the average usage of the metric
+in the started units is above the threshold, and removes a unit when the
+average would stay below the threshold without it. The threshold is a
+percentage for cpu, megabytes for memory and kilobytes received per second for
+network, which follows the request rate of the app:
+
+	% tsuru app-autoscale on --min 2 --max 6 --metric cpu --threshold 70
+
+Scaling decisions are at least 5 minutes apart, the --cooldown flag changes
+this interval, like 10m. Every decision, with its reason, is logged in the app
+log and recorded in the audit trail.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Deploy a branch, tag or commit of an app
+
+Usage:
+
+	% tsuru app-deploy [ref] [--app appname]
+
+app-deploy will update the code in all units of the app to the given ref, which
+may be a branch, a tag or a commit, install its dependencies and restart the
+app. If the ref is omitted, tsuru deploys master, just like a "git push". The
+deployed ref is displayed for each unit in app-info.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+List the deploy history of an app
+
+Usage:
+
+	% tsuru app-deploy-list [--app appname]
+
+app-deploy-list will list the deploys of the app, most recent first. For each
+deploy, it displays the deployed ref and commit, the user that made the deploy,
+the date, how long it took and whether it succeeded.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Roll an app back to a previous deploy
+
+Usage:
+
+	% tsuru app-rollback <commit> [--app appname]
+
+app-rollback will check out the given commit in all units of the app, install
+its dependencies and restart the app. The commit may be abbreviated, and must
+have been successfully deployed before (see app-deploy-list). The rollback is
+recorded in the deploy history as a new deploy.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Add a log drain to an app
+
+Usage:
+
+	% tsuru drain-add <url> [--app appname]
+
+drain-add will forward every log entry of the app to the given drain. The drain
+may be a syslog server, receiving messages in the RFC 5424 format, or an HTTP
+endpoint, receiving each entry as a JSON object in a POST request. Use one of
+the following URL forms:
+
+	syslog://host:port        syslog over TCP
+	syslog+udp://host:port    syslog over UDP
+	http://host/path          HTTP (or https://host/path for HTTPS)
+
+Entries are buffered and delivery is retried when it fails. Delivery failures
+are reported in the app log (see the log command).
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Remove a log drain from an app
+
+Usage:
+
+	% tsuru drain-remove <url> [--app appname]
+
+drain-remove will stop forwarding the log entries of the app to the given
+drain.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+List the log drains of an app
+
+Usage:
+
+	% tsuru drain-list [--app appname]
+
+drain-list will list the URLs of the log drains of the app.
+
 The --app flag is optional, see "Guessing app names" section for more details.
 
 
@@ -423,21 +675,38 @@ Define the value of one or more environment variables
 
 Usage:
 
-	% tsuru env-set <NAME_1=VALUE_1> [NAME_2=VALUE_2] ... [NAME_N=VALUE_N] [--app appname]
+	% tsuru env-set <NAME_1=VALUE_1> [NAME_2=VALUE_2] ... [NAME_N=VALUE_N] [--file .env] [--private] [--app appname]
 
 env-set will (re)define environment variables for your app.  You can specify
 one or more environment variables to (re)define. env-set cannot redefine
-private variables, and all variables defined using env-set will be public (its
-value will be displayed in env-get). env-set does not restart the application
-after exporting the variables, for doing that, see restart command. Examples of
-use:
+variables exported by service binds. Variables defined using env-set are public (their values
+will be displayed in env-get), unless the --private flag is given. env-set does
+not restart the application after exporting the variables, for doing that, see
+restart command. Examples of use:
 
 	% tsuru env-set myapp MYSQL_DATABASE_NAME=myapp_sql2 MYSQL_PASSWORD=1234
 	% tsuru env-get myapp MYSQL_DATABASE_NAME MYSQL_PASSWORD
 	MYSQL_DATABASE_NAME=myapp_sql
 	MYSQL_PASSWORD=*** (private variable)
 
-Notice that env-set will fail silently to redefine private variables.
+Values are taken literally, including spaces, "=", quotes and "$", as long as
+they are quoted for your shell:
+
+	% tsuru env-set 'DATABASE_URL=mysql://user:p@ss$word@db/myapp?charset=utf8'
+
+With the --file flag, env-set reads the variables from a .env file, with one
+NAME=value per line. Lines starting with "#" are ignored and values may be
+wrapped in single or double quotes. Variables given in the command line
+override the ones declared in the file:
+
+	% cat .env
+	# database settings
+	MYSQL_HOST=db.example.com
+	MYSQL_PASSWORD="s3cr3t with spaces"
+	% tsuru env-set --file .env --private
+
+Notice that env-set will fail silently to redefine variables exported by
+service binds.
 
 The --app flag is optional, see "Guessing app names" section for more details.
 
@@ -449,14 +718,142 @@ Usage:
 	% tsuru env-unset <NAME_1> [NAME_2] ... [NAME_N] [--app appname]
 
 env-unset will undefine environments variables in your app.  You can specify
-one or more environment variables to undefine.  env-unset cannot remove private
-variables. Examples of use:
+one or more environment variables to undefine.  env-unset cannot remove
+variables exported by service binds. Examples of use:
 
 	% tsuru env-unset myapp MYSQL_DATABASE_NAME MYSQL_PASSWORD
 	% tsuru env-get myapp MYSQL_DATABASE_NAME MYSQL_PASSWORD
 	MYSQL_PASSWORD=*** (private variable)
 
-Notice that env-unset will fail silently to undefine private variables.
+Notice that env-unset will fail silently to undefine variables exported by
+service binds.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+List the versions of the environment variables of an app
+
+Usage:
+
+	% tsuru env-history [--app appname]
+
+Every change in the environment variables of an app creates a new version.
+env-history lists the versions, most recent first, with the user that made the
+change and the variables that were added (+), changed (~) and removed (-).
+Values of private variables are not displayed. Example of output:
+
+	% tsuru env-history --app myapp
+	+---------+-----------------+---------------------+---------------------------+
+	| Version | User            | Date                | Changes                   |
+	+---------+-----------------+---------------------+---------------------------+
+	| 2       | john@bonham.com | 2012-11-20 10:30:00 | ~DEBUG=1, -WORKERS        |
+	| 1       | john@bonham.com | 2012-11-20 10:20:00 | +DEBUG=0, +SECRET_KEY=*** |
+	+---------+-----------------+---------------------+---------------------------+
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Restore the environment variables of an app to a previous version
+
+Usage:
+
+	% tsuru env-revert <version> [--app appname]
+
+env-revert restores the environment variables of an app to the given version,
+as listed by env-history. The revert itself creates a new version.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Manage an app with a manifest
+
+Usage:
+
+	% tsuru apply <manifest.yaml> [--dry-run]
+	% tsuru export [--app appname]
+
+A manifest is a YAML file that declares an app: its framework, the number of
+units running the web process, the teams with access to it, its public
+environment variables and the service instances bound to it. For example:
+
+	name: myapp
+	framework: python
+	units: 2
+	teams:
+	- myteam
+	env:
+	  DEBUG: "0"
+	services:
+	- mysql-myapp
+
+apply compares the manifest with the current state of the app and makes the
+changes needed for the app to match it, creating the app if it doesn't exist.
+Sections omitted from the manifest are left unchanged. With the --dry-run flag,
+apply only displays the changes it would make:
+
+	% tsuru apply myapp.yaml --dry-run
+	 ---> grant access to the team myteam
+	 ---> set DEBUG=0
+	 ---> add 1 unit(s)
+	Dry run: 3 change(s) would be made to the app myapp.
+
+export writes the manifest of an existing app to the standard output. Private
+environment variables are not exported:
+
+	% tsuru export --app myapp > myapp.yaml
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Notify external services about app events
+
+Usage:
+
+	% tsuru webhook-add <url> [--app appname | --team teamname] [--events event1,event2]
+	% tsuru webhook-list [--app appname | --team teamname]
+	% tsuru webhook-remove <id>
+	% tsuru webhook-deliveries <id>
+
+webhook-add registers a webhook for an app, or for all apps of a team. When an
+event finishes, tsuru POSTs a JSON payload describing it to the URL of the
+webhook:
+
+	{"event":"deploy","app":"myapp","success":true,"data":{"ref":"master"},"timestamp":"..."}
+
+The events are deploy, restart, add-units, bind, unbind and destroy. Use the
+--events flag to be notified about some of them only.
+
+webhook-add displays the secret of the webhook. Each payload is signed with it,
+and the X-Tsuru-Signature header contains "sha256=" followed by the hex encoded
+HMAC-SHA256 of the body. Failed deliveries are retried a few times, and
+webhook-deliveries shows the latest deliveries of a webhook.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Schedule jobs
+
+Usage:
+
+	% tsuru cron-add "<schedule>" <command> [--app appname]
+	% tsuru cron-list [--app appname]
+	% tsuru cron-remove <id> [--app appname]
+
+cron-add adds a scheduled job to an app. The schedule is in the crontab format,
+with five fields (minute, hour, day of month, month and day of week), in the
+local time of the tsuru server. The shortcuts @hourly, @daily, @weekly,
+@monthly and @yearly are also accepted:
+
+	% tsuru cron-add "30 * * * *" python manage.py clearsessions
+
+Each run of the job executes the command in one unit of the app, and its output
+goes to the app log, with the source "cron". Jobs may also be declared in the
+app.conf file, in the root of the app repository. These jobs are loaded when
+the app is restarted, and can't be removed with cron-remove:
+
+	cron:
+	  - schedule: "0 3 * * *"
+	    command: ./backup.sh
 
 The --app flag is optional, see "Guessing app names" section for more details.
 
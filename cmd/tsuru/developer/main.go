@@ -23,14 +23,37 @@ func buildManager(name string) *cmd.Manager {
 	m.Register(&AppCreate{})
 	m.Register(&AppRemove{})
 	m.Register(&UnitAdd{})
+	m.Register(&UnitRemove{})
 	m.Register(&tsuru.AppList{})
 	m.Register(&tsuru.AppLog{})
 	m.Register(&tsuru.AppGrant{})
 	m.Register(&tsuru.AppRevoke{})
 	m.Register(&tsuru.AppRestart{})
+	m.Register(&tsuru.AppStop{})
+	m.Register(&tsuru.AppStart{})
+	m.Register(&tsuru.AppHealing{})
+	m.Register(&tsuru.AppMetrics{})
+	m.Register(&tsuru.AppAutoscale{})
+	m.Register(&tsuru.AppDeploy{})
+	m.Register(&tsuru.AppDeployList{})
+	m.Register(&tsuru.AppRollback{})
+	m.Register(&tsuru.DrainAdd{})
+	m.Register(&tsuru.DrainRemove{})
+	m.Register(&tsuru.DrainList{})
 	m.Register(&tsuru.EnvGet{})
 	m.Register(&tsuru.EnvSet{})
 	m.Register(&tsuru.EnvUnset{})
+	m.Register(&tsuru.EnvHistory{})
+	m.Register(&tsuru.EnvRevert{})
+	m.Register(&tsuru.Apply{})
+	m.Register(&tsuru.Export{})
+	m.Register(&tsuru.WebhookAdd{})
+	m.Register(&tsuru.WebhookList{})
+	m.Register(&tsuru.WebhookRemove{})
+	m.Register(&tsuru.WebhookDeliveries{})
+	m.Register(&tsuru.CronAdd{})
+	m.Register(&tsuru.CronList{})
+	m.Register(&tsuru.CronRemove{})
 	m.Register(&KeyAdd{})
 	m.Register(&KeyRemove{})
 	m.Register(&tsuru.ServiceList{})
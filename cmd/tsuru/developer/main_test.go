@@ -76,6 +76,62 @@ func (s *S) TestAppRestartIsRegistered(c *C) {
 	c.Assert(restart, FitsTypeOf, &tsuru.AppRestart{})
 }
 
+func (s *S) TestAppStopIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	stop, ok := manager.Commands["app-stop"]
+	c.Assert(ok, Equals, true)
+	c.Assert(stop, FitsTypeOf, &tsuru.AppStop{})
+}
+
+func (s *S) TestAppStartIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	start, ok := manager.Commands["app-start"]
+	c.Assert(ok, Equals, true)
+	c.Assert(start, FitsTypeOf, &tsuru.AppStart{})
+}
+
+func (s *S) TestAppDeployIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	deploy, ok := manager.Commands["app-deploy"]
+	c.Assert(ok, Equals, true)
+	c.Assert(deploy, FitsTypeOf, &tsuru.AppDeploy{})
+}
+
+func (s *S) TestAppDeployListIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	list, ok := manager.Commands["app-deploy-list"]
+	c.Assert(ok, Equals, true)
+	c.Assert(list, FitsTypeOf, &tsuru.AppDeployList{})
+}
+
+func (s *S) TestAppRollbackIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	rollback, ok := manager.Commands["app-rollback"]
+	c.Assert(ok, Equals, true)
+	c.Assert(rollback, FitsTypeOf, &tsuru.AppRollback{})
+}
+
+func (s *S) TestDrainAddIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	add, ok := manager.Commands["drain-add"]
+	c.Assert(ok, Equals, true)
+	c.Assert(add, FitsTypeOf, &tsuru.DrainAdd{})
+}
+
+func (s *S) TestDrainRemoveIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	remove, ok := manager.Commands["drain-remove"]
+	c.Assert(ok, Equals, true)
+	c.Assert(remove, FitsTypeOf, &tsuru.DrainRemove{})
+}
+
+func (s *S) TestDrainListIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	list, ok := manager.Commands["drain-list"]
+	c.Assert(ok, Equals, true)
+	c.Assert(list, FitsTypeOf, &tsuru.DrainList{})
+}
+
 func (s *S) TestEnvGetIsRegistered(c *C) {
 	manager := buildManager("tsuru")
 	get, ok := manager.Commands["env-get"]
@@ -97,6 +153,34 @@ func (s *S) TestEnvUnsetIsRegistered(c *C) {
 	c.Assert(unset, FitsTypeOf, &tsuru.EnvUnset{})
 }
 
+func (s *S) TestEnvHistoryIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	history, ok := manager.Commands["env-history"]
+	c.Assert(ok, Equals, true)
+	c.Assert(history, FitsTypeOf, &tsuru.EnvHistory{})
+}
+
+func (s *S) TestEnvRevertIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	revert, ok := manager.Commands["env-revert"]
+	c.Assert(ok, Equals, true)
+	c.Assert(revert, FitsTypeOf, &tsuru.EnvRevert{})
+}
+
+func (s *S) TestApplyIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	apply, ok := manager.Commands["apply"]
+	c.Assert(ok, Equals, true)
+	c.Assert(apply, FitsTypeOf, &tsuru.Apply{})
+}
+
+func (s *S) TestExportIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	export, ok := manager.Commands["export"]
+	c.Assert(ok, Equals, true)
+	c.Assert(export, FitsTypeOf, &tsuru.Export{})
+}
+
 func (s *S) TestKeyAddIsRegistered(c *C) {
 	manager := buildManager("tsuru")
 	add, ok := manager.Commands["key-add"]
@@ -180,3 +264,80 @@ func (s *S) TestUnitAddIsRegistered(c *C) {
 	c.Assert(ok, Equals, true)
 	c.Assert(addunit, FitsTypeOf, &UnitAdd{})
 }
+
+func (s *S) TestUnitRemoveIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	rmunit, ok := manager.Commands["unit-remove"]
+	c.Assert(ok, Equals, true)
+	c.Assert(rmunit, FitsTypeOf, &UnitRemove{})
+}
+
+func (s *S) TestWebhookAddIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	add, ok := manager.Commands["webhook-add"]
+	c.Assert(ok, Equals, true)
+	c.Assert(add, FitsTypeOf, &tsuru.WebhookAdd{})
+}
+
+func (s *S) TestWebhookListIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	list, ok := manager.Commands["webhook-list"]
+	c.Assert(ok, Equals, true)
+	c.Assert(list, FitsTypeOf, &tsuru.WebhookList{})
+}
+
+func (s *S) TestWebhookRemoveIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	remove, ok := manager.Commands["webhook-remove"]
+	c.Assert(ok, Equals, true)
+	c.Assert(remove, FitsTypeOf, &tsuru.WebhookRemove{})
+}
+
+func (s *S) TestWebhookDeliveriesIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	deliveries, ok := manager.Commands["webhook-deliveries"]
+	c.Assert(ok, Equals, true)
+	c.Assert(deliveries, FitsTypeOf, &tsuru.WebhookDeliveries{})
+}
+
+func (s *S) TestAppHealingIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	healing, ok := manager.Commands["app-healing"]
+	c.Assert(ok, Equals, true)
+	c.Assert(healing, FitsTypeOf, &tsuru.AppHealing{})
+}
+
+func (s *S) TestAppMetricsIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	metrics, ok := manager.Commands["app-metrics"]
+	c.Assert(ok, Equals, true)
+	c.Assert(metrics, FitsTypeOf, &tsuru.AppMetrics{})
+}
+
+func (s *S) TestAppAutoscaleIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	autoscale, ok := manager.Commands["app-autoscale"]
+	c.Assert(ok, Equals, true)
+	c.Assert(autoscale, FitsTypeOf, &tsuru.AppAutoscale{})
+}
+
+func (s *S) TestCronAddIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	add, ok := manager.Commands["cron-add"]
+	c.Assert(ok, Equals, true)
+	c.Assert(add, FitsTypeOf, &tsuru.CronAdd{})
+}
+
+func (s *S) TestCronListIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	list, ok := manager.Commands["cron-list"]
+	c.Assert(ok, Equals, true)
+	c.Assert(list, FitsTypeOf, &tsuru.CronList{})
+}
+
+func (s *S) TestCronRemoveIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	remove, ok := manager.Commands["cron-remove"]
+	c.Assert(ok, Equals, true)
+	c.Assert(remove, FitsTypeOf, &tsuru.CronRemove{})
+}
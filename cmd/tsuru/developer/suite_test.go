@@ -53,4 +53,5 @@ func (s *S) SetUpTest(c *C) {
 	manager = cmd.NewManager("glb", version, header, &stdout, &stderr, os.Stdin)
 	tsuru.AppName = new(string)
 	tsuru.AssumeYes = new(bool)
+	tsuru.ProcessType = new(string)
 }
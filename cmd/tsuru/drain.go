@@ -0,0 +1,135 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+func drainRequest(method, appName, drain string, client cmd.Doer) error {
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/drains", appName))
+	request, err := http.NewRequest(method, url, strings.NewReader(drain))
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	return err
+}
+
+type DrainAdd struct {
+	GuessingCommand
+}
+
+func (c *DrainAdd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "drain-add",
+		Usage: "drain-add <url> [--app appname]",
+		Desc: `adds a log drain to an app.
+
+Every log entry of the app will be forwarded to the drain. The URL may be a
+syslog endpoint (syslog://host:port for TCP, syslog+udp://host:port for UDP) or
+an HTTP endpoint (http://host/path or https://host/path).
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *DrainAdd) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	err = drainRequest("POST", appName, context.Args[0], client)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Drain %s successfully added to the app %s.\n", context.Args[0], appName)
+	return nil
+}
+
+type DrainRemove struct {
+	GuessingCommand
+}
+
+func (c *DrainRemove) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "drain-remove",
+		Usage: "drain-remove <url> [--app appname]",
+		Desc: `removes a log drain from an app.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *DrainRemove) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	err = drainRequest("DELETE", appName, context.Args[0], client)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Drain %s successfully removed from the app %s.\n", context.Args[0], appName)
+	return nil
+}
+
+type DrainList struct {
+	GuessingCommand
+}
+
+func (c *DrainList) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "drain-list",
+		Usage: "drain-list [--app appname]",
+		Desc: `lists the log drains of an app.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *DrainList) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/drains", appName))
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	defer response.Body.Close()
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var drains []string
+	err = json.Unmarshal(result, &drains)
+	if err != nil {
+		return err
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"Drain"})
+	for _, d := range drains {
+		table.AddRow(cmd.Row([]string{d}))
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
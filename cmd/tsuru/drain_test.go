@@ -0,0 +1,145 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net/http"
+)
+
+func (s *S) TestDrainAdd(c *C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Args:   []string{"syslog://logs.example.com:514"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{msg: "", status: http.StatusOK},
+		func(req *http.Request) bool {
+			called = true
+			body, _ := ioutil.ReadAll(req.Body)
+			return req.URL.Path == "/apps/ble/drains" && req.Method == "POST" && string(body) == "syslog://logs.example.com:514"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&DrainAdd{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "Drain syslog://logs.example.com:514 successfully added to the app ble.\n")
+}
+
+func (s *S) TestDrainAddInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "drain-add",
+		Usage: "drain-add <url> [--app appname]",
+		Desc: `adds a log drain to an app.
+
+Every log entry of the app will be forwarded to the drain. The URL may be a
+syslog endpoint (syslog://host:port for TCP, syslog+udp://host:port for UDP) or
+an HTTP endpoint (http://host/path or https://host/path).
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+	c.Assert((&DrainAdd{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestDrainRemove(c *C) {
+	*AppName = "ble"
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Args:   []string{"http://logs.example.com/ble"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{msg: "", status: http.StatusOK},
+		func(req *http.Request) bool {
+			called = true
+			body, _ := ioutil.ReadAll(req.Body)
+			return req.URL.Path == "/apps/ble/drains" && req.Method == "DELETE" && string(body) == "http://logs.example.com/ble"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&DrainRemove{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "Drain http://logs.example.com/ble successfully removed from the app ble.\n")
+}
+
+func (s *S) TestDrainRemoveInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "drain-remove",
+		Usage: "drain-remove <url> [--app appname]",
+		Desc: `removes a log drain from an app.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+	c.Assert((&DrainRemove{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestDrainList(c *C) {
+	var stdout, stderr bytes.Buffer
+	result := `["syslog://logs.example.com:514","http://logs.example.com/ble"]`
+	expected := `+-------------------------------+
+| Drain                         |
++-------------------------------+
+| syslog://logs.example.com:514 |
+| http://logs.example.com/ble   |
++-------------------------------+
+`
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{msg: result, status: http.StatusOK},
+		func(req *http.Request) bool {
+			return req.URL.Path == "/apps/ble/drains" && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&DrainList{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestDrainListWithoutDrains(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: "", status: http.StatusNoContent}}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&DrainList{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "")
+}
+
+func (s *S) TestDrainListInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "drain-list",
+		Usage: "drain-list [--app appname]",
+		Desc: `lists the log drains of an app.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+	c.Assert((&DrainList{}).Info(), DeepEquals, expected)
+}
@@ -5,11 +5,18 @@
 package tsuru
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/globocom/tsuru/cmd"
 	"io/ioutil"
+	"launchpad.net/gnuflag"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type EnvGet struct {
@@ -36,6 +43,62 @@ func (c *EnvGet) Run(context *cmd.Context, client cmd.Doer) error {
 	return nil
 }
 
+var EnvPrivate = gnuflag.Bool("private", false, "Set the environment variables as private")
+var EnvFile = gnuflag.String("file", "", "Read the environment variables from the given .env file")
+
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type envVar struct {
+	Name         string `json:"name"`
+	Value        string `json:"value"`
+	Public       bool   `json:"public"`
+	InstanceName string `json:"instanceName,omitempty"`
+}
+
+// parseEnvArgs parses environment variables in the form NAME=value. Only the
+// first "=" separates the name from the value.
+func parseEnvArgs(args []string) ([]envVar, error) {
+	envs := make([]envVar, len(args))
+	for i, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || !envNameRegexp.MatchString(parts[0]) {
+			return nil, fmt.Errorf("Invalid environment variable: %q. Use the format NAME=value.", arg)
+		}
+		envs[i] = envVar{Name: parts[0], Value: parts[1]}
+	}
+	return envs, nil
+}
+
+// parseEnvFile parses the content of a .env file. Each line declares a
+// variable in the form NAME=value, optionally prefixed by "export". Values may
+// be wrapped in single quotes, taken literally, or in double quotes, which
+// support the escapes \n, \" and \\. Blank lines and lines starting with "#"
+// are ignored.
+func parseEnvFile(content string) ([]envVar, error) {
+	var envs []envVar
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "export ") {
+			line = strings.TrimSpace(line[len("export "):])
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || !envNameRegexp.MatchString(strings.TrimSpace(parts[0])) {
+			return nil, fmt.Errorf("Invalid env file, line %d: %q.", i+1, line)
+		}
+		value := strings.TrimSpace(parts[1])
+		if n := len(value); n >= 2 && value[0] == '\'' && value[n-1] == '\'' {
+			value = value[1 : n-1]
+		} else if n >= 2 && value[0] == '"' && value[n-1] == '"' {
+			value = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(value[1 : n-1])
+		}
+		envs = append(envs, envVar{Name: strings.TrimSpace(parts[0]), Value: value})
+	}
+	return envs, nil
+}
+
 type EnvSet struct {
 	GuessingCommand
 }
@@ -43,16 +106,59 @@ type EnvSet struct {
 func (c *EnvSet) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "env-set",
-		Usage: "env-set <NAME=value> [NAME=value] ... [--app appname]",
+		Usage: "env-set <NAME=value> [NAME=value] ... [--file .env] [--private] [--app appname]",
 		Desc: `set environment variables for an app.
 
+Values are taken literally: they may contain spaces, "=" and any other
+character, as long as they are quoted for your shell. Variables may also be
+read from a .env file, using the --file flag. Variables given in the command
+line override the ones declared in the file.
+
+With the --private flag, the variables are set as private: their values are
+stored encrypted and are not displayed by env-get.
+
 If you don't provide the app name, tsuru will try to guess it.`,
-		MinArgs: 1,
+		MinArgs: 0,
 	}
 }
 
 func (c *EnvSet) Run(context *cmd.Context, client cmd.Doer) error {
-	_, err := requestEnvUrl("POST", c.GuessingCommand, context.Args, client)
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	var envs []envVar
+	if *EnvFile != "" {
+		content, err := ioutil.ReadFile(*EnvFile)
+		if err != nil {
+			return err
+		}
+		if envs, err = parseEnvFile(string(content)); err != nil {
+			return err
+		}
+	}
+	args, err := parseEnvArgs(context.Args)
+	if err != nil {
+		return err
+	}
+	envs = append(envs, args...)
+	if len(envs) == 0 {
+		return errors.New("You must provide the environment variables.")
+	}
+	for i := range envs {
+		envs[i].Public = !*EnvPrivate
+	}
+	body, err := json.Marshal(envs)
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/env", appName))
+	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	_, err = client.Do(request)
 	if err != nil {
 		return err
 	}
@@ -84,6 +190,124 @@ func (c *EnvUnset) Run(context *cmd.Context, client cmd.Doer) error {
 	return nil
 }
 
+type envVersion struct {
+	Version   int
+	User      string
+	Timestamp time.Time
+	Added     []string
+	Changed   []string
+	Removed   []string
+	Revert    int
+}
+
+// changes returns a summary of the changes in the version: added variables are
+// prefixed by "+", changed variables by "~" and removed variables by "-".
+func (v *envVersion) changes() string {
+	var changes []string
+	if v.Revert > 0 {
+		changes = append(changes, fmt.Sprintf("revert to %d", v.Revert))
+	}
+	for _, env := range v.Added {
+		changes = append(changes, "+"+env)
+	}
+	for _, env := range v.Changed {
+		changes = append(changes, "~"+env)
+	}
+	for _, env := range v.Removed {
+		changes = append(changes, "-"+env)
+	}
+	return strings.Join(changes, ", ")
+}
+
+type EnvHistory struct {
+	GuessingCommand
+}
+
+func (c *EnvHistory) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "env-history",
+		Usage: "env-history [--app appname]",
+		Desc: `list the versions of the environment variables of an app, most recent first.
+
+Added variables are prefixed by "+", changed variables by "~" and removed
+variables by "-". Values of private variables are not displayed.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *EnvHistory) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/env/history", appName))
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	defer response.Body.Close()
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var versions []envVersion
+	err = json.Unmarshal(result, &versions)
+	if err != nil {
+		return err
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"Version", "User", "Date", "Changes"})
+	for _, v := range versions {
+		date := v.Timestamp.Format("2006-01-02 15:04:05")
+		table.AddRow(cmd.Row([]string{strconv.Itoa(v.Version), v.User, date, v.changes()}))
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
+
+type EnvRevert struct {
+	GuessingCommand
+}
+
+func (c *EnvRevert) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "env-revert",
+		Usage: "env-revert <version> [--app appname]",
+		Desc: `restore the environment variables of an app to a previous version.
+
+The versions are listed by env-history. If you don't provide the app name,
+tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *EnvRevert) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/env/revert", appName))
+	request, err := http.NewRequest("POST", url, strings.NewReader(context.Args[0]))
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Environment variables of the app %s successfully reverted to version %s.\n", appName, context.Args[0])
+	return nil
+}
+
 func requestEnvUrl(method string, g GuessingCommand, args []string, client cmd.Doer) (string, error) {
 	appName, err := g.Guess()
 	if err != nil {
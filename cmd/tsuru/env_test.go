@@ -6,9 +6,13 @@ package tsuru
 
 import (
 	"bytes"
+	"encoding/json"
 	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
 	"net/http"
+	"os"
+	"time"
 )
 
 func (s *S) TestEnvGetInfo(c *C) {
@@ -84,11 +88,19 @@ func (s *S) TestEnvSetInfo(c *C) {
 	i := e.Info()
 	desc := `set environment variables for an app.
 
+Values are taken literally: they may contain spaces, "=" and any other
+character, as long as they are quoted for your shell. Variables may also be
+read from a .env file, using the --file flag. Variables given in the command
+line override the ones declared in the file.
+
+With the --private flag, the variables are set as private: their values are
+stored encrypted and are not displayed by env-get.
+
 If you don't provide the app name, tsuru will try to guess it.`
 	c.Assert(i.Name, Equals, "env-set")
-	c.Assert(i.Usage, Equals, "env-set <NAME=value> [NAME=value] ... [--app appname]")
+	c.Assert(i.Usage, Equals, "env-set <NAME=value> [NAME=value] ... [--file .env] [--private] [--app appname]")
 	c.Assert(i.Desc, Equals, desc)
-	c.Assert(i.MinArgs, Equals, 1)
+	c.Assert(i.MinArgs, Equals, 0)
 }
 
 func (s *S) TestEnvSetRun(c *C) {
@@ -145,6 +157,140 @@ func (s *S) TestEnvSetWithoutFlag(c *C) {
 	c.Assert(stdout.String(), Equals, result)
 }
 
+func (s *S) TestEnvSetRunSendsJSON(c *C) {
+	var body []byte
+	context := cmd.Context{
+		Args:   []string{"DATABASE_URL=mysql://root@localhost/db?a=1", "GREETING=hello  world", `SECRET=$ec'r"et`},
+		Stdout: new(bytes.Buffer),
+		Stderr: new(bytes.Buffer),
+	}
+	trans := &conditionalTransport{
+		transport{msg: "", status: http.StatusOK},
+		func(req *http.Request) bool {
+			body, _ = ioutil.ReadAll(req.Body)
+			return req.URL.Path == "/apps/otherapp/env" && req.Method == "POST" &&
+				req.Header.Get("Content-Type") == "application/json"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "otherapp"}
+	err := (&EnvSet{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	var envs []envVar
+	err = json.Unmarshal(body, &envs)
+	c.Assert(err, IsNil)
+	expected := []envVar{
+		{Name: "DATABASE_URL", Value: "mysql://root@localhost/db?a=1", Public: true},
+		{Name: "GREETING", Value: "hello  world", Public: true},
+		{Name: "SECRET", Value: `$ec'r"et`, Public: true},
+	}
+	c.Assert(envs, DeepEquals, expected)
+}
+
+func (s *S) TestEnvSetRunPrivate(c *C) {
+	*EnvPrivate = true
+	var body []byte
+	context := cmd.Context{
+		Args:   []string{"SECRET=s3cr3t"},
+		Stdout: new(bytes.Buffer),
+		Stderr: new(bytes.Buffer),
+	}
+	trans := &conditionalTransport{
+		transport{msg: "", status: http.StatusOK},
+		func(req *http.Request) bool {
+			body, _ = ioutil.ReadAll(req.Body)
+			return true
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "otherapp"}
+	err := (&EnvSet{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, `[{"name":"SECRET","value":"s3cr3t","public":false}]`)
+}
+
+func (s *S) TestEnvSetRunWithFile(c *C) {
+	f, err := ioutil.TempFile("", "tsuru-env")
+	c.Assert(err, IsNil)
+	defer os.Remove(f.Name())
+	f.WriteString("# database\nDATABASE_HOST=localhost\nexport DEBUG=0\n")
+	f.Close()
+	*EnvFile = f.Name()
+	var body []byte
+	context := cmd.Context{
+		Args:   []string{"DEBUG=1"},
+		Stdout: new(bytes.Buffer),
+		Stderr: new(bytes.Buffer),
+	}
+	trans := &conditionalTransport{
+		transport{msg: "", status: http.StatusOK},
+		func(req *http.Request) bool {
+			body, _ = ioutil.ReadAll(req.Body)
+			return true
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "otherapp"}
+	err = (&EnvSet{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	var envs []envVar
+	err = json.Unmarshal(body, &envs)
+	c.Assert(err, IsNil)
+	expected := []envVar{
+		{Name: "DATABASE_HOST", Value: "localhost", Public: true},
+		{Name: "DEBUG", Value: "0", Public: true},
+		{Name: "DEBUG", Value: "1", Public: true},
+	}
+	c.Assert(envs, DeepEquals, expected)
+}
+
+func (s *S) TestEnvSetRunInvalidVariable(c *C) {
+	context := cmd.Context{
+		Args:   []string{"DATABASE_HOST"},
+		Stdout: new(bytes.Buffer),
+		Stderr: new(bytes.Buffer),
+	}
+	fake := &FakeGuesser{name: "otherapp"}
+	err := (&EnvSet{GuessingCommand{G: fake}}).Run(&context, nil)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `Invalid environment variable: "DATABASE_HOST". Use the format NAME=value.`)
+}
+
+func (s *S) TestEnvSetRunWithoutVariables(c *C) {
+	context := cmd.Context{Stdout: new(bytes.Buffer), Stderr: new(bytes.Buffer)}
+	fake := &FakeGuesser{name: "otherapp"}
+	err := (&EnvSet{GuessingCommand{G: fake}}).Run(&context, nil)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "You must provide the environment variables.")
+}
+
+func (s *S) TestParseEnvFile(c *C) {
+	content := `# comment
+DATABASE_HOST=localhost
+
+export DEBUG = 1
+URL=http://example.com/?a=1&b=2
+SINGLE='$HOME "literal"'
+DOUBLE="line\nbreak \"quoted\""
+`
+	envs, err := parseEnvFile(content)
+	c.Assert(err, IsNil)
+	expected := []envVar{
+		{Name: "DATABASE_HOST", Value: "localhost"},
+		{Name: "DEBUG", Value: "1"},
+		{Name: "URL", Value: "http://example.com/?a=1&b=2"},
+		{Name: "SINGLE", Value: `$HOME "literal"`},
+		{Name: "DOUBLE", Value: "line\nbreak \"quoted\""},
+	}
+	c.Assert(envs, DeepEquals, expected)
+}
+
+func (s *S) TestParseEnvFileInvalidLine(c *C) {
+	_, err := parseEnvFile("DATABASE_HOST=localhost\nnot a variable\n")
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `Invalid env file, line 2: "not a variable".`)
+}
+
 func (s *S) TestEnvUnsetInfo(c *C) {
 	e := EnvUnset{}
 	i := e.Info()
@@ -204,3 +350,101 @@ func (s *S) TestRequestEnvUrl(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(b, Equals, result)
 }
+
+func (s *S) TestEnvHistoryInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "env-history",
+		Usage: "env-history [--app appname]",
+		Desc: `list the versions of the environment variables of an app, most recent first.
+
+Added variables are prefixed by "+", changed variables by "~" and removed
+variables by "-". Values of private variables are not displayed.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+	c.Assert((&EnvHistory{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestEnvHistoryRun(c *C) {
+	var stdout, stderr bytes.Buffer
+	date := time.Date(2012, 11, 20, 10, 30, 0, 0, time.Local).Format(time.RFC3339)
+	result := `[{"Version":3,"User":"john@bonham.com","Timestamp":"` + date + `","Changed":["DEBUG=0"],"Revert":1},
+{"Version":2,"User":"jimmy@page.com","Timestamp":"` + date + `","Changed":["DEBUG=1"],"Removed":["WORKERS"]},
+{"Version":1,"User":"jimmy@page.com","Timestamp":"` + date + `","Added":["DEBUG=0","SECRET_KEY=***"]}]`
+	expected := `+---------+-----------------+---------------------+---------------------------+
+| Version | User            | Date                | Changes                   |
++---------+-----------------+---------------------+---------------------------+
+| 3       | john@bonham.com | 2012-11-20 10:30:00 | revert to 1, ~DEBUG=0     |
+| 2       | jimmy@page.com  | 2012-11-20 10:30:00 | ~DEBUG=1, -WORKERS        |
+| 1       | jimmy@page.com  | 2012-11-20 10:30:00 | +DEBUG=0, +SECRET_KEY=*** |
++---------+-----------------+---------------------+---------------------------+
+`
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{msg: result, status: http.StatusOK},
+		func(req *http.Request) bool {
+			return req.URL.Path == "/apps/seek/env/history" && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "seek"}
+	err := (&EnvHistory{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestEnvHistoryRunWithoutVersions(c *C) {
+	*AppName = "seek"
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: "", status: http.StatusNoContent}}, nil, manager)
+	err := (&EnvHistory{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "")
+}
+
+func (s *S) TestEnvRevertInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "env-revert",
+		Usage: "env-revert <version> [--app appname]",
+		Desc: `restore the environment variables of an app to a previous version.
+
+The versions are listed by env-history. If you don't provide the app name,
+tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+	c.Assert((&EnvRevert{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestEnvRevertRun(c *C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Args:   []string{"2"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{msg: "", status: http.StatusOK},
+		func(req *http.Request) bool {
+			called = true
+			body, _ := ioutil.ReadAll(req.Body)
+			return req.URL.Path == "/apps/seek/env/revert" && req.Method == "POST" && string(body) == "2"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "seek"}
+	err := (&EnvRevert{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "Environment variables of the app seek successfully reverted to version 2.\n")
+}
@@ -0,0 +1,65 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"launchpad.net/gnuflag"
+	"net/http"
+)
+
+var HealingAfter = gnuflag.String("after", "", "How long units may be in the error or down state before being replaced")
+
+type AppHealing struct {
+	GuessingCommand
+}
+
+func (c *AppHealing) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-healing",
+		Usage: "app-healing <on|off> [--after duration] [--app appname]",
+		Desc: `turns the self-healing of an app on or off.
+
+When self-healing is on, units that stay in the error or down state for longer
+than the given duration (10 minutes by default) are replaced by new units.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *AppHealing) Run(context *cmd.Context, client cmd.Doer) error {
+	mode := context.Args[0]
+	if mode != "on" && mode != "off" {
+		return errors.New(`Invalid mode, it must be "on" or "off".`)
+	}
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	params := map[string]interface{}{"enabled": mode == "on"}
+	if HealingAfter != nil && *HealingAfter != "" {
+		params["after"] = *HealingAfter
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/healing", appName))
+	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if _, err = client.Do(request); err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Self-healing of the app %s turned %s.\n", appName, mode)
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net/http"
+)
+
+func (s *S) TestAppHealingInfo(c *C) {
+	info := (&AppHealing{}).Info()
+	c.Assert(info.Name, Equals, "app-healing")
+	c.Assert(info.Usage, Equals, "app-healing <on|off> [--after duration] [--app appname]")
+	c.Assert(info.MinArgs, Equals, 1)
+}
+
+func (s *S) TestAppHealing(c *C) {
+	*HealingAfter = "15m"
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"on"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{msg: "", status: http.StatusOK},
+		func(req *http.Request) bool {
+			var body map[string]interface{}
+			b, _ := ioutil.ReadAll(req.Body)
+			json.Unmarshal(b, &body)
+			return req.URL.Path == "/apps/ble/healing" && req.Method == "POST" &&
+				body["enabled"] == true && body["after"] == "15m"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&AppHealing{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "Self-healing of the app ble turned on.\n")
+}
+
+func (s *S) TestAppHealingInvalidMode(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"maybe"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: "", status: http.StatusOK}}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&AppHealing{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, ErrorMatches, `Invalid mode, it must be "on" or "off".`)
+}
@@ -6,10 +6,12 @@ package tsuru
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/globocom/tsuru/cmd"
-	"io/ioutil"
+	"io"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -20,10 +22,14 @@ type AppLog struct {
 func (c *AppLog) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "log",
-		Usage: "log [--app appname] [--lines numberOfLines] [--source source]",
+		Usage: "log [--app appname] [--lines numberOfLines] [--source source] [--unit unit] [--since date] [--until date] [--follow]",
 		Desc: `show logs for an app.
 
-If you don't provide the app name, tsuru will try to guess it. The default number of lines is 10.`,
+If you don't provide the app name, tsuru will try to guess it. The default number of lines is 10.
+
+The --since and --until flags take either a date, in the format "2006-01-02 15:04:05",
+or a duration relative to now, like "30m" or "2h". With --follow (or -f), tsuru
+keeps showing new log entries as they arrive, until you interrupt it.`,
 		MinArgs: 0,
 	}
 }
@@ -32,6 +38,22 @@ type log struct {
 	Date    time.Time
 	Message string
 	Source  string
+	Unit    string
+}
+
+// ParseDate parses a date given to flags like --since and --until, which may
+// be either a date or a duration relative to now.
+func ParseDate(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.New(`Invalid date: use the format "2006-01-02 15:04:05" or a duration, like "30m".`)
 }
 
 func (c *AppLog) Run(context *cmd.Context, client cmd.Doer) error {
@@ -39,10 +61,31 @@ func (c *AppLog) Run(context *cmd.Context, client cmd.Doer) error {
 	if err != nil {
 		return err
 	}
-	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/log?lines=%d", appName, *LogLines))
+	params := url.Values{}
+	params.Set("lines", fmt.Sprint(*LogLines))
 	if LogSource != nil && *LogSource != "" {
-		url = fmt.Sprintf("%s&source=%s", url, *LogSource)
+		params.Set("source", *LogSource)
 	}
+	if UnitName != nil && *UnitName != "" {
+		params.Set("unit", *UnitName)
+	}
+	dates := []struct {
+		name  string
+		value *string
+	}{{"since", LogSince}, {"until", LogUntil}}
+	for _, d := range dates {
+		if d.value != nil && *d.value != "" {
+			t, err := ParseDate(*d.value)
+			if err != nil {
+				return err
+			}
+			params.Set(d.name, t.Format(time.RFC3339))
+		}
+	}
+	if LogFollow != nil && *LogFollow {
+		params.Set("follow", "true")
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/log?%s", appName, params.Encode()))
 	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return err
@@ -55,17 +98,24 @@ func (c *AppLog) Run(context *cmd.Context, client cmd.Doer) error {
 		return nil
 	}
 	defer response.Body.Close()
-	result, err := ioutil.ReadAll(response.Body)
-	logs := []log{}
-	err = json.Unmarshal(result, &logs)
-	if err != nil {
-		return err
-	}
-	for _, l := range logs {
-		date := l.Date.Format("2006-01-02 15:04:05")
-		prefix := fmt.Sprintf("%s [%s]:", date, l.Source)
-		msg := fmt.Sprintf("%s %s\n", cmd.Colorfy(prefix, "blue", "", ""), l.Message)
-		context.Stdout.Write([]byte(msg))
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var logs []log
+		err = decoder.Decode(&logs)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, l := range logs {
+			date := l.Date.Format("2006-01-02 15:04:05")
+			prefix := fmt.Sprintf("%s [%s]:", date, l.Source)
+			if l.Unit != "" {
+				prefix = fmt.Sprintf("%s [%s][%s]:", date, l.Source, l.Unit)
+			}
+			msg := fmt.Sprintf("%s %s\n", cmd.Colorfy(prefix, "blue", "", ""), l.Message)
+			context.Stdout.Write([]byte(msg))
+		}
 	}
-	return err
 }
@@ -10,6 +10,7 @@ import (
 	. "launchpad.net/gocheck"
 	"net/http"
 	"strings"
+	"time"
 )
 
 func (s *S) TestAppLog(c *C) {
@@ -76,10 +77,14 @@ func (s *S) TestAppLogShouldReturnNilIfHasNoContent(c *C) {
 func (s *S) TestAppLogInfo(c *C) {
 	expected := &cmd.Info{
 		Name:  "log",
-		Usage: "log [--app appname] [--lines numberOfLines] [--source source]",
+		Usage: "log [--app appname] [--lines numberOfLines] [--source source] [--unit unit] [--since date] [--until date] [--follow]",
 		Desc: `show logs for an app.
 
-If you don't provide the app name, tsuru will try to guess it. The default number of lines is 10.`,
+If you don't provide the app name, tsuru will try to guess it. The default number of lines is 10.
+
+The --since and --until flags take either a date, in the format "2006-01-02 15:04:05",
+or a duration relative to now, like "30m" or "2h". With --follow (or -f), tsuru
+keeps showing new log entries as they arrive, until you interrupt it.`,
 		MinArgs: 0,
 	}
 	c.Assert((&AppLog{}).Info(), DeepEquals, expected)
@@ -113,3 +118,110 @@ func (s *S) TestAppLogBySource(c *C) {
 	got = strings.Replace(got, "-0300 -0300", "-0300 BRT", -1)
 	c.Assert(got, Equals, expected)
 }
+
+func (s *S) TestAppLogByUnit(c *C) {
+	*UnitName = "hitthelights/0"
+	var stdout, stderr bytes.Buffer
+	result := `[{"Source":"app","Unit":"hitthelights/0","Date":"2012-06-20T11:17:22.75-03:00","Message":"starting"}]`
+	expected := cmd.Colorfy("2012-06-20 11:17:22 [app][hitthelights/0]:", "blue", "", "") + " starting\n"
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	fake := &FakeGuesser{name: "hitthelights"}
+	command := AppLog{GuessingCommand{G: fake}}
+	trans := &conditionalTransport{
+		transport{
+			msg:    result,
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			return req.URL.Query().Get("unit") == "hitthelights/0"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestAppLogByTimeRange(c *C) {
+	*LogSince = "2012-06-20 11:00:00"
+	*LogUntil = "2012-06-20 12:00:00"
+	var stdout, stderr bytes.Buffer
+	since := time.Date(2012, 6, 20, 11, 0, 0, 0, time.Local).Format(time.RFC3339)
+	until := time.Date(2012, 6, 20, 12, 0, 0, 0, time.Local).Format(time.RFC3339)
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	fake := &FakeGuesser{name: "hitthelights"}
+	command := AppLog{GuessingCommand{G: fake}}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "[]",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			return req.URL.Query().Get("since") == since && req.URL.Query().Get("until") == until
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "")
+}
+
+func (s *S) TestAppLogWithInvalidDate(c *C) {
+	*LogSince = "yesterday"
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	fake := &FakeGuesser{name: "hitthelights"}
+	command := AppLog{GuessingCommand{G: fake}}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: "[]", status: http.StatusOK}}, nil, manager)
+	err := command.Run(&context, client)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `Invalid date: use the format "2006-01-02 15:04:05" or a duration, like "30m".`)
+}
+
+func (s *S) TestParseLogDateWithDuration(c *C) {
+	t, err := ParseDate("30m")
+	c.Assert(err, IsNil)
+	diff := time.Now().Add(-30 * time.Minute).Sub(t)
+	c.Assert(diff < time.Second, Equals, true)
+}
+
+func (s *S) TestAppLogFollow(c *C) {
+	*LogFollow = true
+	var stdout, stderr bytes.Buffer
+	result := `[{"Source":"tsuru","Date":"2012-06-20T11:17:22.75-03:00","Message":"creating app lost"}]
+[{"Source":"app","Date":"2012-06-20T11:17:23.75-03:00","Message":"app lost started"}]
+
+
+[{"Source":"app","Date":"2012-06-20T11:17:30.75-03:00","Message":"handling request"}]`
+	expected := cmd.Colorfy("2012-06-20 11:17:22 [tsuru]:", "blue", "", "") + " creating app lost\n"
+	expected = expected + cmd.Colorfy("2012-06-20 11:17:23 [app]:", "blue", "", "") + " app lost started\n"
+	expected = expected + cmd.Colorfy("2012-06-20 11:17:30 [app]:", "blue", "", "") + " handling request\n"
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	fake := &FakeGuesser{name: "hitthelights"}
+	command := AppLog{GuessingCommand{G: fake}}
+	trans := &conditionalTransport{
+		transport{
+			msg:    result,
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			return req.URL.Query().Get("follow") == "true"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, expected)
+}
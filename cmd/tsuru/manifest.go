@@ -0,0 +1,433 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"io"
+	"io/ioutil"
+	"launchpad.net/gnuflag"
+	"launchpad.net/goyaml"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var DryRun = gnuflag.Bool("dry-run", false, "Show the changes that would be made, without making them")
+
+// manifest describes an app in YAML, for example:
+//
+//	name: myapp
+//	framework: python
+//	units: 2
+//	teams:
+//	- admin
+//	- myteam
+//	env:
+//	  DEBUG: "0"
+//	services:
+//	- mysql-myapp
+//
+// Only public environment variables are declared in manifests, and units is the
+// number of units running the web process. Sections omitted from a manifest
+// are left unchanged by apply.
+type manifest struct {
+	Name      string            `yaml:"name"`
+	Framework string            `yaml:"framework"`
+	Units     int               `yaml:"units,omitempty"`
+	Teams     []string          `yaml:"teams,omitempty"`
+	Env       map[string]string `yaml:"env,omitempty"`
+	Services  []string          `yaml:"services,omitempty"`
+}
+
+func parseManifest(content []byte) (*manifest, error) {
+	var m manifest
+	if err := goyaml.Unmarshal(content, &m); err != nil {
+		return nil, fmt.Errorf("Invalid manifest: %s", err)
+	}
+	if m.Name == "" {
+		return nil, errors.New("Invalid manifest: the app name is required.")
+	}
+	if m.Framework == "" {
+		return nil, errors.New("Invalid manifest: the framework is required.")
+	}
+	if m.Units < 0 {
+		return nil, errors.New("Invalid manifest: the number of units must not be negative.")
+	}
+	for name := range m.Env {
+		if !envNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("Invalid manifest: invalid environment variable name %q.", name)
+		}
+	}
+	return &m, nil
+}
+
+func getJSON(url string, client cmd.Doer, header http.Header, v interface{}) error {
+	request, err := http.NewRequest("GET", cmd.GetUrl(url), nil)
+	if err != nil {
+		return err
+	}
+	for k := range header {
+		request.Header.Set(k, header.Get(k))
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// loadManifest builds the manifest of an app from its current state. It
+// returns nil if the user doesn't have access to an app with the given name.
+//
+// Only the public variables set by users are loaded: the variables of the
+// bound service instances are managed by binding the services.
+func loadManifest(appName string, client cmd.Doer) (*manifest, error) {
+	var apps []app
+	if err := getJSON("/apps", client, nil, &apps); err != nil {
+		return nil, err
+	}
+	var m *manifest
+	for _, a := range apps {
+		if a.Name == appName {
+			m = &manifest{Name: a.Name, Framework: a.Framework, Teams: a.Teams}
+			for _, u := range a.Units {
+				if u.process() == "web" {
+					m.Units++
+				}
+			}
+			break
+		}
+	}
+	if m == nil {
+		return nil, nil
+	}
+	var envs []envVar
+	header := http.Header{"Accept": []string{"application/json"}}
+	if err := getJSON(fmt.Sprintf("/apps/%s/env", appName), client, header, &envs); err != nil {
+		return nil, err
+	}
+	m.Env = make(map[string]string)
+	for _, env := range envs {
+		if env.Public && env.InstanceName == "" {
+			m.Env[env.Name] = env.Value
+		}
+	}
+	var services []struct {
+		Service   string
+		Instances []string
+	}
+	if err := getJSON("/services/instances", client, nil, &services); err != nil {
+		return nil, err
+	}
+	for _, s := range services {
+		if len(s.Instances) == 0 {
+			continue
+		}
+		var instances []struct {
+			Name string
+			Apps []string
+		}
+		if err := getJSON("/services/"+s.Service, client, nil, &instances); err != nil {
+			return nil, err
+		}
+		for _, instance := range instances {
+			if contains(instance.Apps, appName) {
+				m.Services = append(m.Services, instance.Name)
+			}
+		}
+	}
+	sort.Strings(m.Teams)
+	sort.Strings(m.Services)
+	return m, nil
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// manifestChange is a request that converges an app to its manifest.
+type manifestChange struct {
+	description string
+	method      string
+	path        string
+	body        []byte
+	contentType string
+}
+
+func (c *manifestChange) apply(client cmd.Doer) error {
+	var body io.Reader
+	if c.body != nil {
+		body = bytes.NewReader(c.body)
+	}
+	request, err := http.NewRequest(c.method, cmd.GetUrl(c.path), body)
+	if err != nil {
+		return err
+	}
+	if c.contentType != "" {
+		request.Header.Set("Content-Type", c.contentType)
+	}
+	_, err = client.Do(request)
+	return err
+}
+
+// planChanges returns the changes needed to converge the app from the current
+// manifest to the desired one: grants, env changes, bindings and units, in
+// this order. Teams are granted before being revoked, so the app never runs
+// out of teams. Teams, variables and service instances are only revoked,
+// unset and unbound when the desired manifest declares the respective section.
+func planChanges(desired, current *manifest) ([]manifestChange, error) {
+	var changes []manifestChange
+	name := desired.Name
+	for _, team := range desired.Teams {
+		if !contains(current.Teams, team) {
+			changes = append(changes, manifestChange{
+				description: "grant access to the team " + team,
+				method:      "PUT",
+				path:        fmt.Sprintf("/apps/%s/%s", name, team),
+			})
+		}
+	}
+	for _, team := range current.Teams {
+		if desired.Teams != nil && !contains(desired.Teams, team) {
+			changes = append(changes, manifestChange{
+				description: "revoke access from the team " + team,
+				method:      "DELETE",
+				path:        fmt.Sprintf("/apps/%s/%s", name, team),
+			})
+		}
+	}
+	var (
+		envs  []envVar
+		set   []string
+		unset []string
+	)
+	for _, k := range sortedKeys(desired.Env) {
+		if v, ok := current.Env[k]; !ok || v != desired.Env[k] {
+			envs = append(envs, envVar{Name: k, Value: desired.Env[k], Public: true})
+			set = append(set, k+"="+desired.Env[k])
+		}
+	}
+	if len(envs) > 0 {
+		body, err := json.Marshal(envs)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, manifestChange{
+			description: "set " + strings.Join(set, ", "),
+			method:      "POST",
+			path:        fmt.Sprintf("/apps/%s/env", name),
+			body:        body,
+			contentType: "application/json",
+		})
+	}
+	if desired.Env != nil {
+		for _, k := range sortedKeys(current.Env) {
+			if _, ok := desired.Env[k]; !ok {
+				unset = append(unset, k)
+			}
+		}
+	}
+	if len(unset) > 0 {
+		changes = append(changes, manifestChange{
+			description: "unset " + strings.Join(unset, ", "),
+			method:      "DELETE",
+			path:        fmt.Sprintf("/apps/%s/env", name),
+			body:        []byte(strings.Join(unset, " ")),
+		})
+	}
+	for _, instance := range desired.Services {
+		if !contains(current.Services, instance) {
+			changes = append(changes, manifestChange{
+				description: "bind the service instance " + instance,
+				method:      "PUT",
+				path:        fmt.Sprintf("/services/instances/%s/%s", instance, name),
+			})
+		}
+	}
+	for _, instance := range current.Services {
+		if desired.Services != nil && !contains(desired.Services, instance) {
+			changes = append(changes, manifestChange{
+				description: "unbind the service instance " + instance,
+				method:      "DELETE",
+				path:        fmt.Sprintf("/services/instances/%s/%s", instance, name),
+			})
+		}
+	}
+	if desired.Units > 0 && desired.Units != current.Units {
+		change := manifestChange{path: fmt.Sprintf("/apps/%s/units?process=web", name)}
+		if desired.Units > current.Units {
+			n := desired.Units - current.Units
+			change.description = fmt.Sprintf("add %d unit(s)", n)
+			change.method = "PUT"
+			change.body = []byte(strconv.Itoa(n))
+		} else {
+			n := current.Units - desired.Units
+			change.description = fmt.Sprintf("remove %d unit(s)", n)
+			change.method = "DELETE"
+			change.body = []byte(strconv.Itoa(n))
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type Apply struct{}
+
+func (c *Apply) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "apply",
+		Usage: "apply <manifest.yaml> [--dry-run]",
+		Desc: `converge an app to the state declared in a manifest.
+
+The manifest declares the framework, number of units, teams, public
+environment variables and service instances of the app. apply creates the app
+if it doesn't exist, and then grants and revokes teams, sets and unsets
+environment variables, binds and unbinds service instances and adds or removes
+units, until the app matches the manifest.
+
+With the --dry-run flag, apply only displays the changes it would make.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *Apply) Run(context *cmd.Context, client cmd.Doer) error {
+	content, err := ioutil.ReadFile(context.Args[0])
+	if err != nil {
+		return err
+	}
+	desired, err := parseManifest(content)
+	if err != nil {
+		return err
+	}
+	current, err := loadManifest(desired.Name, client)
+	if err != nil {
+		return err
+	}
+	var applied int
+	if current == nil {
+		body, err := json.Marshal(map[string]string{"name": desired.Name, "framework": desired.Framework})
+		if err != nil {
+			return err
+		}
+		create := manifestChange{
+			description: fmt.Sprintf("create the app %s (%s)", desired.Name, desired.Framework),
+			method:      "POST",
+			path:        "/apps",
+			body:        body,
+			contentType: "application/json",
+		}
+		if err = c.applyChange(context, client, &create); err != nil {
+			return err
+		}
+		applied++
+		current = &manifest{Name: desired.Name, Framework: desired.Framework, Units: 1}
+		if !*DryRun {
+			if current, err = loadManifest(desired.Name, client); err != nil {
+				return err
+			}
+			if current == nil {
+				return fmt.Errorf("The app %s was created, but could not be loaded.", desired.Name)
+			}
+		}
+	} else if current.Framework != desired.Framework {
+		return fmt.Errorf("The app %s uses the framework %s, not %s. The framework of an existing app can't be changed.",
+			desired.Name, current.Framework, desired.Framework)
+	}
+	changes, err := planChanges(desired, current)
+	if err != nil {
+		return err
+	}
+	for i := range changes {
+		if err = c.applyChange(context, client, &changes[i]); err != nil {
+			return err
+		}
+	}
+	applied += len(changes)
+	switch {
+	case applied == 0:
+		fmt.Fprintf(context.Stdout, "The app %s is up to date.\n", desired.Name)
+	case *DryRun:
+		fmt.Fprintf(context.Stdout, "Dry run: %d change(s) would be made to the app %s.\n", applied, desired.Name)
+	default:
+		fmt.Fprintf(context.Stdout, "The app %s was successfully updated with %d change(s).\n", desired.Name, applied)
+	}
+	return nil
+}
+
+// applyChange displays the change and, unless running in dry-run mode, sends
+// its request.
+func (c *Apply) applyChange(context *cmd.Context, client cmd.Doer, change *manifestChange) error {
+	fmt.Fprintf(context.Stdout, " ---> %s\n", change.description)
+	if *DryRun {
+		return nil
+	}
+	return change.apply(client)
+}
+
+type Export struct {
+	GuessingCommand
+}
+
+func (c *Export) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "export",
+		Usage: "export [--app appname]",
+		Desc: `write the manifest of an app to the standard output.
+
+The manifest can be used with the apply command. Private environment variables
+are not exported.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *Export) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	m, err := loadManifest(appName, client)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return fmt.Errorf("App %s not found.", appName)
+	}
+	b, err := goyaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	context.Stdout.Write(b)
+	return nil
+}
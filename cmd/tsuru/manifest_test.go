@@ -0,0 +1,330 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"launchpad.net/goyaml"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// routerTransport responds to requests according to their method and path,
+// recording the requests that change the state of the app.
+type routerTransport struct {
+	routes   map[string]string
+	requests []string
+}
+
+func (t *routerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	route := req.Method + " " + req.URL.Path
+	if req.Method != "GET" {
+		var body []byte
+		if req.Body != nil {
+			body, _ = ioutil.ReadAll(req.Body)
+		}
+		request := route
+		if req.URL.RawQuery != "" {
+			request += "?" + req.URL.RawQuery
+		}
+		t.requests = append(t.requests, strings.TrimSpace(request+" "+string(body)))
+	}
+	msg, ok := t.routes[route]
+	status := http.StatusOK
+	if !ok && req.Method == "GET" {
+		status = http.StatusNotFound
+	}
+	return &http.Response{
+		Body:       ioutil.NopCloser(bytes.NewBufferString(msg)),
+		StatusCode: status,
+	}, nil
+}
+
+func newManifestRoutes() map[string]string {
+	return map[string]string{
+		"GET /apps": `[{"Name":"myapp","Framework":"python","Teams":["ops","admin"],
+"Units":[{"Name":"myapp/0"},{"Name":"myapp/1","ProcessType":"worker"}]}]`,
+		"GET /apps/myapp/env": `[{"name":"DEBUG","value":"1","public":true},
+{"name":"OLD","value":"x","public":true},{"name":"SECRET","value":"***","public":false},
+{"name":"MYSQL_HOST","value":"10.0.0.1","public":true,"instanceName":"mysql-myapp"}]`,
+		"GET /services/instances": `[{"Service":"mysql","Instances":["mysql-myapp"]},{"Service":"redis","Instances":["redis-myapp"]},{"Service":"mongodb"}]`,
+		"GET /services/mysql":     `[{"Name":"mysql-myapp","Apps":["myapp"]}]`,
+		"GET /services/redis":     `[{"Name":"redis-myapp","Apps":["otherapp"]}]`,
+	}
+}
+
+func writeManifest(c *C, content string) string {
+	f, err := ioutil.TempFile("", "tsuru-manifest")
+	c.Assert(err, IsNil)
+	defer f.Close()
+	_, err = f.WriteString(content)
+	c.Assert(err, IsNil)
+	return f.Name()
+}
+
+func (s *S) TestParseManifest(c *C) {
+	content := `name: myapp
+framework: python
+units: 2
+teams:
+- admin
+env:
+  DEBUG: "0"
+services:
+- mysql-myapp
+`
+	m, err := parseManifest([]byte(content))
+	c.Assert(err, IsNil)
+	expected := &manifest{
+		Name:      "myapp",
+		Framework: "python",
+		Units:     2,
+		Teams:     []string{"admin"},
+		Env:       map[string]string{"DEBUG": "0"},
+		Services:  []string{"mysql-myapp"},
+	}
+	c.Assert(m, DeepEquals, expected)
+}
+
+func (s *S) TestParseManifestValidation(c *C) {
+	var tests = []struct {
+		content string
+		msg     string
+	}{
+		{"framework: python\n", "Invalid manifest: the app name is required."},
+		{"name: myapp\n", "Invalid manifest: the framework is required."},
+		{"name: myapp\nframework: python\nunits: -1\n", "Invalid manifest: the number of units must not be negative."},
+		{"name: myapp\nframework: python\nenv:\n  MY VAR: x\n", `Invalid manifest: invalid environment variable name "MY VAR".`},
+	}
+	for _, t := range tests {
+		_, err := parseManifest([]byte(t.content))
+		c.Check(err, NotNil)
+		if err != nil {
+			c.Check(err.Error(), Equals, t.msg)
+		}
+	}
+}
+
+func (s *S) TestLoadManifest(c *C) {
+	trans := &routerTransport{routes: newManifestRoutes()}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	m, err := loadManifest("myapp", client)
+	c.Assert(err, IsNil)
+	expected := &manifest{
+		Name:      "myapp",
+		Framework: "python",
+		Units:     1,
+		Teams:     []string{"admin", "ops"},
+		Env:       map[string]string{"DEBUG": "1", "OLD": "x"},
+		Services:  []string{"mysql-myapp"},
+	}
+	c.Assert(m, DeepEquals, expected)
+}
+
+func (s *S) TestLoadManifestAppNotFound(c *C) {
+	trans := &routerTransport{routes: newManifestRoutes()}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	m, err := loadManifest("unknown", client)
+	c.Assert(err, IsNil)
+	c.Assert(m, IsNil)
+}
+
+func (s *S) TestPlanChanges(c *C) {
+	current := &manifest{
+		Name:      "myapp",
+		Framework: "python",
+		Units:     1,
+		Teams:     []string{"admin", "ops"},
+		Env:       map[string]string{"DEBUG": "1", "OLD": "x"},
+		Services:  []string{"mysql-myapp"},
+	}
+	desired := &manifest{
+		Name:      "myapp",
+		Framework: "python",
+		Units:     3,
+		Teams:     []string{"admin", "dev"},
+		Env:       map[string]string{"DEBUG": "0", "WORKERS": "4"},
+		Services:  []string{"redis-myapp"},
+	}
+	changes, err := planChanges(desired, current)
+	c.Assert(err, IsNil)
+	var descriptions []string
+	for _, change := range changes {
+		descriptions = append(descriptions, change.description)
+	}
+	expected := []string{
+		"grant access to the team dev",
+		"revoke access from the team ops",
+		"set DEBUG=0, WORKERS=4",
+		"unset OLD",
+		"bind the service instance redis-myapp",
+		"unbind the service instance mysql-myapp",
+		"add 2 unit(s)",
+	}
+	c.Assert(descriptions, DeepEquals, expected)
+	c.Assert(string(changes[2].body), Equals, `[{"name":"DEBUG","value":"0","public":true},{"name":"WORKERS","value":"4","public":true}]`)
+	c.Assert(changes[3].method, Equals, "DELETE")
+	c.Assert(string(changes[3].body), Equals, "OLD")
+}
+
+func (s *S) TestPlanChangesLeavesOmittedSectionsUnchanged(c *C) {
+	current := &manifest{
+		Name:      "myapp",
+		Framework: "python",
+		Units:     2,
+		Teams:     []string{"admin"},
+		Env:       map[string]string{"DEBUG": "1"},
+		Services:  []string{"mysql-myapp"},
+	}
+	desired := &manifest{Name: "myapp", Framework: "python"}
+	changes, err := planChanges(desired, current)
+	c.Assert(err, IsNil)
+	c.Assert(changes, HasLen, 0)
+}
+
+func (s *S) TestApplyInfo(c *C) {
+	info := (&Apply{}).Info()
+	c.Assert(info.Name, Equals, "apply")
+	c.Assert(info.Usage, Equals, "apply <manifest.yaml> [--dry-run]")
+	c.Assert(info.MinArgs, Equals, 1)
+}
+
+func (s *S) TestApplyRun(c *C) {
+	path := writeManifest(c, `name: myapp
+framework: python
+units: 2
+teams:
+- admin
+env:
+  DEBUG: "1"
+  WORKERS: "4"
+services:
+- mysql-myapp
+`)
+	defer os.Remove(path)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{path}, Stdout: &stdout, Stderr: &stderr}
+	trans := &routerTransport{routes: newManifestRoutes()}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&Apply{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	expected := ` ---> revoke access from the team ops
+ ---> set WORKERS=4
+ ---> unset OLD
+ ---> add 1 unit(s)
+The app myapp was successfully updated with 4 change(s).
+`
+	c.Assert(stdout.String(), Equals, expected)
+	requests := []string{
+		"DELETE /apps/myapp/ops",
+		`POST /apps/myapp/env [{"name":"WORKERS","value":"4","public":true}]`,
+		"DELETE /apps/myapp/env OLD",
+		"PUT /apps/myapp/units?process=web 1",
+	}
+	c.Assert(trans.requests, DeepEquals, requests)
+}
+
+func (s *S) TestApplyRunDryRun(c *C) {
+	*DryRun = true
+	path := writeManifest(c, "name: myapp\nframework: python\nunits: 1\nteams:\n- admin\n- dev\n")
+	defer os.Remove(path)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{path}, Stdout: &stdout, Stderr: &stderr}
+	trans := &routerTransport{routes: newManifestRoutes()}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&Apply{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	expected := ` ---> grant access to the team dev
+ ---> revoke access from the team ops
+Dry run: 2 change(s) would be made to the app myapp.
+`
+	c.Assert(stdout.String(), Equals, expected)
+	c.Assert(trans.requests, HasLen, 0)
+}
+
+func (s *S) TestApplyRunCreatesTheApp(c *C) {
+	*DryRun = true
+	path := writeManifest(c, "name: newapp\nframework: ruby\nunits: 2\nenv:\n  DEBUG: \"0\"\n")
+	defer os.Remove(path)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{path}, Stdout: &stdout, Stderr: &stderr}
+	trans := &routerTransport{routes: newManifestRoutes()}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&Apply{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	expected := ` ---> create the app newapp (ruby)
+ ---> set DEBUG=0
+ ---> add 1 unit(s)
+Dry run: 3 change(s) would be made to the app newapp.
+`
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestApplyRunUpToDate(c *C) {
+	path := writeManifest(c, "name: myapp\nframework: python\nunits: 1\n")
+	defer os.Remove(path)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{path}, Stdout: &stdout, Stderr: &stderr}
+	trans := &routerTransport{routes: newManifestRoutes()}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&Apply{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "The app myapp is up to date.\n")
+}
+
+func (s *S) TestApplyRunDifferentFramework(c *C) {
+	path := writeManifest(c, "name: myapp\nframework: ruby\n")
+	defer os.Remove(path)
+	context := cmd.Context{Args: []string{path}, Stdout: new(bytes.Buffer), Stderr: new(bytes.Buffer)}
+	trans := &routerTransport{routes: newManifestRoutes()}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&Apply{}).Run(&context, client)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "The app myapp uses the framework python, not ruby. The framework of an existing app can't be changed.")
+	c.Assert(trans.requests, HasLen, 0)
+}
+
+func (s *S) TestExportInfo(c *C) {
+	info := (&Export{}).Info()
+	c.Assert(info.Name, Equals, "export")
+	c.Assert(info.Usage, Equals, "export [--app appname]")
+	c.Assert(info.MinArgs, Equals, 0)
+}
+
+func (s *S) TestExportRun(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &routerTransport{routes: newManifestRoutes()}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "myapp"}
+	err := (&Export{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	var m manifest
+	err = goyaml.Unmarshal(stdout.Bytes(), &m)
+	c.Assert(err, IsNil)
+	expected := manifest{
+		Name:      "myapp",
+		Framework: "python",
+		Units:     1,
+		Teams:     []string{"admin", "ops"},
+		Env:       map[string]string{"DEBUG": "1", "OLD": "x"},
+		Services:  []string{"mysql-myapp"},
+	}
+	c.Assert(m, DeepEquals, expected)
+}
+
+func (s *S) TestExportRunAppNotFound(c *C) {
+	context := cmd.Context{Stdout: new(bytes.Buffer), Stderr: new(bytes.Buffer)}
+	trans := &routerTransport{routes: newManifestRoutes()}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "unknown"}
+	err := (&Export{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "App unknown not found.")
+}
@@ -0,0 +1,191 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// sparklineWidth is the maximum number of bars in a sparkline. Samples are
+// averaged to fit in it.
+const sparklineWidth = 60
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+type unitMetrics struct {
+	Unit       string
+	Date       time.Time
+	CPU        float64
+	Memory     uint64
+	Disk       uint64
+	NetworkIn  uint64
+	NetworkOut uint64
+}
+
+type AppMetrics struct {
+	GuessingCommand
+}
+
+func (c *AppMetrics) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-metrics",
+		Usage: "app-metrics [--since duration] [--app appname]",
+		Desc: `displays the resources used by the units of an app.
+
+The table shows the latest sample of each unit, followed by a summary of the
+samples taken in the given period (the last hour by default), like 30m or 6h.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *AppMetrics) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	u := cmd.GetUrl(fmt.Sprintf("/apps/%s/metrics", appName))
+	if LogSince != nil && *LogSince != "" {
+		u += "?since=" + url.QueryEscape(*LogSince)
+	}
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNoContent {
+		fmt.Fprintf(context.Stdout, "No metrics collected for the app %s.\n", appName)
+		return nil
+	}
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var metrics []unitMetrics
+	if err = json.Unmarshal(result, &metrics); err != nil {
+		return err
+	}
+	var units []string
+	samples := make(map[string][]unitMetrics)
+	for _, m := range metrics {
+		if _, ok := samples[m.Unit]; !ok {
+			units = append(units, m.Unit)
+		}
+		samples[m.Unit] = append(samples[m.Unit], m)
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"Unit", "CPU", "Memory", "Disk", "Net in", "Net out", "Date"})
+	for _, unit := range units {
+		last := samples[unit][len(samples[unit])-1]
+		table.AddRow(cmd.Row([]string{
+			unit,
+			formatPercent(last.CPU),
+			formatBytes(float64(last.Memory)),
+			formatBytes(float64(last.Disk)),
+			formatRate(float64(last.NetworkIn)),
+			formatRate(float64(last.NetworkOut)),
+			last.Date.Format("2006-01-02 15:04"),
+		}))
+	}
+	context.Stdout.Write(table.Bytes())
+	for _, unit := range units {
+		fmt.Fprintf(context.Stdout, "\n%s (%d samples):\n", unit, len(samples[unit]))
+		writeSummary(context, "CPU", samples[unit], formatPercent, func(m unitMetrics) float64 { return m.CPU })
+		writeSummary(context, "Memory", samples[unit], formatBytes, func(m unitMetrics) float64 { return float64(m.Memory) })
+		writeSummary(context, "Disk", samples[unit], formatBytes, func(m unitMetrics) float64 { return float64(m.Disk) })
+		writeSummary(context, "Net in", samples[unit], formatRate, func(m unitMetrics) float64 { return float64(m.NetworkIn) })
+		writeSummary(context, "Net out", samples[unit], formatRate, func(m unitMetrics) float64 { return float64(m.NetworkOut) })
+	}
+	return nil
+}
+
+// writeSummary writes the sparkline of the given metric, with its minimum,
+// average and maximum values.
+func writeSummary(context *cmd.Context, name string, samples []unitMetrics, format func(float64) string, value func(unitMetrics) float64) {
+	values := make([]float64, len(samples))
+	min, max, sum := value(samples[0]), value(samples[0]), 0.0
+	for i, s := range samples {
+		v := value(s)
+		values[i] = v
+		sum += v
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	avg := sum / float64(len(values))
+	fmt.Fprintf(context.Stdout, "  %-8s %s  min %s, avg %s, max %s\n",
+		name, sparkline(values, sparklineWidth), format(min), format(avg), format(max))
+}
+
+// sparkline draws the values with block characters, one for each value. When
+// there are more than width values, consecutive values are averaged.
+func sparkline(values []float64, width int) string {
+	if len(values) > width {
+		buckets := make([]float64, width)
+		for i := range buckets {
+			start, end := i*len(values)/width, (i+1)*len(values)/width
+			var sum float64
+			for _, v := range values[start:end] {
+				sum += v
+			}
+			buckets[i] = sum / float64(end-start)
+		}
+		values = buckets
+	}
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	line := make([]rune, len(values))
+	for i, v := range values {
+		var level int
+		if max > min {
+			level = int((v - min) / (max - min) * float64(len(sparks)-1))
+		}
+		line[i] = sparks[level]
+	}
+	return string(line)
+}
+
+func formatPercent(v float64) string {
+	return fmt.Sprintf("%.1f%%", v)
+}
+
+// formatBytes formats an amount of bytes using the largest unit in which the
+// amount is at least one.
+func formatBytes(v float64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	i := 0
+	for ; v >= 1024 && i < len(units)-1; i++ {
+		v /= 1024
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", v, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
+
+func formatRate(v float64) string {
+	return formatBytes(v) + "/s"
+}
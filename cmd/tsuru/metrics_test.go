@@ -0,0 +1,85 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"github.com/globocom/tsuru/cmd"
+	. "launchpad.net/gocheck"
+	"net/http"
+)
+
+func (s *S) TestAppMetricsInfo(c *C) {
+	info := (&AppMetrics{}).Info()
+	c.Assert(info.Name, Equals, "app-metrics")
+	c.Assert(info.Usage, Equals, "app-metrics [--since duration] [--app appname]")
+	c.Assert(info.MinArgs, Equals, 0)
+}
+
+func (s *S) TestAppMetrics(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	result := `[{"Unit":"ble/0","Date":"2013-02-01T10:30:00Z","CPU":10,"Memory":1048576,"Disk":1073741824,"NetworkIn":1024,"NetworkOut":0},
+{"Unit":"ble/0","Date":"2013-02-01T10:31:00Z","CPU":30,"Memory":2097152,"Disk":1073741824,"NetworkIn":3072,"NetworkOut":512},
+{"Unit":"ble/1","Date":"2013-02-01T10:31:00Z","CPU":5.5,"Memory":512,"Disk":2048,"NetworkIn":0,"NetworkOut":0}]`
+	trans := &conditionalTransport{
+		transport{msg: result, status: http.StatusOK},
+		func(req *http.Request) bool {
+			return req.URL.Path == "/apps/ble/metrics" && req.Method == "GET" &&
+				req.URL.Query().Get("since") == "6h"
+		},
+	}
+	*LogSince = "6h"
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&AppMetrics{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	expected := `+-------+-------+--------+--------+----------+---------+------------------+
+| Unit  | CPU   | Memory | Disk   | Net in   | Net out | Date             |
++-------+-------+--------+--------+----------+---------+------------------+
+| ble/0 | 30.0% | 2.0 MB | 1.0 GB | 3.0 KB/s | 512 B/s | 2013-02-01 10:31 |
+| ble/1 | 5.5%  | 512 B  | 2.0 KB | 0 B/s    | 0 B/s   | 2013-02-01 10:31 |
++-------+-------+--------+--------+----------+---------+------------------+
+
+ble/0 (2 samples):
+  CPU      ▁█  min 10.0%, avg 20.0%, max 30.0%
+  Memory   ▁█  min 1.0 MB, avg 1.5 MB, max 2.0 MB
+  Disk     ▁▁  min 1.0 GB, avg 1.0 GB, max 1.0 GB
+  Net in   ▁█  min 1.0 KB/s, avg 2.0 KB/s, max 3.0 KB/s
+  Net out  ▁█  min 0 B/s, avg 256 B/s, max 512 B/s
+
+ble/1 (1 samples):
+  CPU      ▁  min 5.5%, avg 5.5%, max 5.5%
+  Memory   ▁  min 512 B, avg 512 B, max 512 B
+  Disk     ▁  min 2.0 KB, avg 2.0 KB, max 2.0 KB
+  Net in   ▁  min 0 B/s, avg 0 B/s, max 0 B/s
+  Net out  ▁  min 0 B/s, avg 0 B/s, max 0 B/s
+`
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestAppMetricsWithoutSamples(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &transport{msg: "", status: http.StatusNoContent}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&AppMetrics{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "No metrics collected for the app ble.\n")
+}
+
+func (s *S) TestSparkline(c *C) {
+	c.Assert(sparkline([]float64{0, 1, 2, 3, 4, 5, 6, 7}, 60), Equals, "▁▂▃▄▅▆▇█")
+	c.Assert(sparkline([]float64{3, 3, 3}, 60), Equals, "▁▁▁")
+	c.Assert(sparkline([]float64{0, 0, 7, 7}, 2), Equals, "▁█")
+}
+
+func (s *S) TestFormatBytes(c *C) {
+	c.Assert(formatBytes(512), Equals, "512 B")
+	c.Assert(formatBytes(1536), Equals, "1.5 KB")
+	c.Assert(formatBytes(3*1024*1024*1024), Equals, "3.0 GB")
+	c.Assert(formatBytes(2048*1024*1024*1024), Equals, "2048.0 GB")
+}
@@ -0,0 +1,108 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/cmd/tsuru"
+	"io/ioutil"
+	"launchpad.net/gnuflag"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	AuditUser  = gnuflag.String("user", "", "Only show audit entries of the given user")
+	AuditLimit = gnuflag.Int("limit", 100, "The maximum number of audit entries to display")
+)
+
+type auditEntry struct {
+	User      string
+	Action    string
+	App       string
+	Team      string
+	Status    int
+	Error     string
+	Timestamp time.Time
+}
+
+type Audit struct{}
+
+func (c *Audit) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "audit",
+		Usage: "audit [--user email] [--app appname] [--team teamname] [--since date] [--until date] [--limit number]",
+		Desc: `show the audit trail of the requests that changed tsuru, most recent first.
+
+The --since and --until flags take either a date, in the format "2006-01-02 15:04:05",
+or a duration relative to now, like "30m" or "2h". The default limit is 100 entries.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *Audit) Run(context *cmd.Context, client cmd.Doer) error {
+	params := url.Values{}
+	filters := []struct {
+		name  string
+		value *string
+	}{{"user", AuditUser}, {"app", tsuru.AppName}, {"team", tsuru.TeamName}}
+	for _, f := range filters {
+		if f.value != nil && *f.value != "" {
+			params.Set(f.name, *f.value)
+		}
+	}
+	dates := []struct {
+		name  string
+		value *string
+	}{{"since", tsuru.LogSince}, {"until", tsuru.LogUntil}}
+	for _, d := range dates {
+		if d.value != nil && *d.value != "" {
+			t, err := tsuru.ParseDate(*d.value)
+			if err != nil {
+				return err
+			}
+			params.Set(d.name, t.Format(time.RFC3339))
+		}
+	}
+	if AuditLimit != nil {
+		params.Set("limit", strconv.Itoa(*AuditLimit))
+	}
+	request, err := http.NewRequest("GET", cmd.GetUrl("/audit?"+params.Encode()), nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var entries []auditEntry
+	if err = json.Unmarshal(b, &entries); err != nil {
+		return err
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"Date", "User", "Action", "App", "Team", "Status"})
+	for _, e := range entries {
+		status := strconv.Itoa(e.Status)
+		if e.Error != "" {
+			status = fmt.Sprintf("%d (%s)", e.Status, e.Error)
+		}
+		date := e.Timestamp.Format("2006-01-02 15:04:05")
+		table.AddRow(cmd.Row([]string{date, e.User, e.Action, e.App, e.Team, status}))
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/cmd/tsuru"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"os"
+)
+
+func (s *S) TestAuditInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "audit",
+		Usage: "audit [--user email] [--app appname] [--team teamname] [--since date] [--until date] [--limit number]",
+		Desc: `show the audit trail of the requests that changed tsuru, most recent first.
+
+The --since and --until flags take either a date, in the format "2006-01-02 15:04:05",
+or a duration relative to now, like "30m" or "2h". The default limit is 100 entries.`,
+		MinArgs: 0,
+	}
+	c.Assert((&Audit{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestAudit(c *C) {
+	var stdout, stderr bytes.Buffer
+	*AuditUser = "me@tsuru.io"
+	*tsuru.AppName = "myapp"
+	*AuditLimit = 20
+	defer func() {
+		*AuditUser = ""
+		*tsuru.AppName = ""
+		*AuditLimit = 100
+	}()
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	result := `[
+{"User":"me@tsuru.io","Action":"POST /apps/:name/env","App":"myapp","Status":200,"Timestamp":"2013-01-02T15:04:05Z"},
+{"User":"me@tsuru.io","Action":"DELETE /apps/:name","App":"myapp","Status":403,"Error":"forbidden","Timestamp":"2013-01-02T14:04:05Z"}
+]`
+	trans := &conditionalTransport{
+		transport{msg: result, status: http.StatusOK},
+		func(req *http.Request) bool {
+			query := req.URL.Query()
+			return req.URL.Path == "/audit" && req.Method == "GET" &&
+				query.Get("user") == "me@tsuru.io" && query.Get("app") == "myapp" &&
+				query.Get("team") == "" && query.Get("limit") == "20"
+		},
+	}
+	manager := cmd.NewManager("tsuru-admin", version, header, &stdout, &stderr, os.Stdin)
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&Audit{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	expected := `+---------------------+-------------+----------------------+-------+------+-----------------+
+| Date                | User        | Action               | App   | Team | Status          |
++---------------------+-------------+----------------------+-------+------+-----------------+
+| 2013-01-02 15:04:05 | me@tsuru.io | POST /apps/:name/env | myapp |      | 200             |
+| 2013-01-02 14:04:05 | me@tsuru.io | DELETE /apps/:name   | myapp |      | 403 (forbidden) |
++---------------------+-------------+----------------------+-------+------+-----------------+
+`
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestAuditWithoutEntries(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &transport{msg: "", status: http.StatusNoContent}
+	manager := cmd.NewManager("tsuru-admin", version, header, &stdout, &stderr, os.Stdin)
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&Audit{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "")
+}
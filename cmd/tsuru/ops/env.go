@@ -0,0 +1,50 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
+	"net/http"
+)
+
+type EnvKeyRotate struct{}
+
+func (c *EnvKeyRotate) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "env-key-rotate",
+		Usage: "env-key-rotate",
+		Desc: `re-encrypts the private environment variables of all apps.
+
+Before running this command, set the new key in the env:encryption-key setting
+and the old key in the env:previous-encryption-key setting of tsuru.conf, and
+restart tsuru. After the command finishes, the old key can be removed.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *EnvKeyRotate) Run(context *cmd.Context, client cmd.Doer) error {
+	request, err := http.NewRequest("POST", cmd.GetUrl("/env/rotate-key"), nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var result map[string]int
+	if err = json.Unmarshal(b, &result); err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Private environment variables of %d apps successfully re-encrypted.\n", result["apps"])
+	return nil
+}
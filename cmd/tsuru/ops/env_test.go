@@ -0,0 +1,51 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"github.com/globocom/tsuru/cmd"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"os"
+)
+
+func (s *S) TestEnvKeyRotateInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "env-key-rotate",
+		Usage: "env-key-rotate",
+		Desc: `re-encrypts the private environment variables of all apps.
+
+Before running this command, set the new key in the env:encryption-key setting
+and the old key in the env:previous-encryption-key setting of tsuru.conf, and
+restart tsuru. After the command finishes, the old key can be removed.`,
+		MinArgs: 0,
+	}
+	c.Assert((&EnvKeyRotate{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestEnvKeyRotate(c *C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{msg: `{"apps":3}`, status: http.StatusOK},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/env/rotate-key" && req.Method == "POST"
+		},
+	}
+	manager := cmd.NewManager("tsuru-admin", version, header, &stdout, &stderr, os.Stdin)
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&EnvKeyRotate{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "Private environment variables of 3 apps successfully re-encrypted.\n")
+}
@@ -0,0 +1,58 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+type AppUnlock struct{}
+
+func (c *AppUnlock) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-unlock",
+		Usage: "app-unlock <appname>",
+		Desc: `removes the lock of an app, whoever holds it.
+
+Deploys, restarts, scaling and other operations that change an app hold its
+lock while running, and fail (or wait, in the case of deploys) if another
+operation holds it. Use this command only to remove the lock of an operation
+that is known to be dead.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *AppUnlock) Run(context *cmd.Context, client cmd.Doer) error {
+	appName := context.Args[0]
+	request, err := http.NewRequest("DELETE", cmd.GetUrl(fmt.Sprintf("/apps/%s/lock", appName)), nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var lock struct {
+		Owner     string
+		Operation string
+		Acquired  time.Time
+	}
+	if err = json.Unmarshal(b, &lock); err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "The app %s was unlocked. The lock was held by %s for the %s operation since %s.\n",
+		appName, lock.Owner, lock.Operation, lock.Acquired.Format("2006-01-02 15:04:05"))
+	return nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"github.com/globocom/tsuru/cmd"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"os"
+)
+
+func (s *S) TestAppUnlockInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "app-unlock",
+		Usage: "app-unlock <appname>",
+		Desc: `removes the lock of an app, whoever holds it.
+
+Deploys, restarts, scaling and other operations that change an app hold its
+lock while running, and fail (or wait, in the case of deploys) if another
+operation holds it. Use this command only to remove the lock of an operation
+that is known to be dead.`,
+		MinArgs: 1,
+	}
+	c.Assert((&AppUnlock{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestAppUnlock(c *C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Args:   []string{"myapp"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	result := `{"App":"myapp","Owner":"me@tsuru.io","Operation":"deploy","Acquired":"2013-01-02T15:04:05Z"}`
+	trans := &conditionalTransport{
+		transport{msg: result, status: http.StatusOK},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/myapp/lock" && req.Method == "DELETE"
+		},
+	}
+	manager := cmd.NewManager("tsuru-admin", version, header, &stdout, &stderr, os.Stdin)
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&AppUnlock{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	expected := "The app myapp was unlocked. The lock was held by me@tsuru.io for the deploy operation since 2013-01-02 15:04:05.\n"
+	c.Assert(stdout.String(), Equals, expected)
+}
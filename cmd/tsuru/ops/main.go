@@ -8,6 +8,7 @@ package main
 import (
 	"github.com/globocom/tsuru/cmd"
 	"github.com/globocom/tsuru/cmd/tsuru"
+	"launchpad.net/gnuflag"
 	"os"
 )
 
@@ -19,12 +20,23 @@ const (
 func buildManager(name string) *cmd.Manager {
 	m := cmd.BuildBaseManager(name, version, header)
 	m.Register(&tsuru.AppList{})
+	m.Register(&EnvKeyRotate{})
+	m.Register(&Audit{})
+	m.Register(&AppUnlock{})
+	m.Register(&AppMaintenance{})
 	return m
 }
 
+// parseFlags parses the flags in the command line, returning the remaining
+// arguments, starting with the name of the command.
+func parseFlags() []string {
+	gnuflag.Parse(true)
+	return gnuflag.Args()
+}
+
 func main() {
 	name := cmd.ExtractProgramName(os.Args[0])
 	manager := buildManager(name)
-	args := os.Args[1:]
+	args := parseFlags()
 	manager.Run(args)
 }
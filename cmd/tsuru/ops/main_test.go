@@ -5,9 +5,12 @@
 package main
 
 import (
+	"bytes"
 	"github.com/globocom/tsuru/cmd"
 	"github.com/globocom/tsuru/cmd/tsuru"
 	. "launchpad.net/gocheck"
+	"net/http"
+	"os"
 )
 
 func (s *S) TestAppListIsRegistered(c *C) {
@@ -17,6 +20,34 @@ func (s *S) TestAppListIsRegistered(c *C) {
 	c.Assert(list, FitsTypeOf, &tsuru.AppList{})
 }
 
+func (s *S) TestEnvKeyRotateIsRegistered(c *C) {
+	manager := buildManager("tsuru-admin")
+	rotate, ok := manager.Commands["env-key-rotate"]
+	c.Assert(ok, Equals, true)
+	c.Assert(rotate, FitsTypeOf, &EnvKeyRotate{})
+}
+
+func (s *S) TestAuditIsRegistered(c *C) {
+	manager := buildManager("tsuru-admin")
+	audit, ok := manager.Commands["audit"]
+	c.Assert(ok, Equals, true)
+	c.Assert(audit, FitsTypeOf, &Audit{})
+}
+
+func (s *S) TestAppUnlockIsRegistered(c *C) {
+	manager := buildManager("tsuru-admin")
+	unlock, ok := manager.Commands["app-unlock"]
+	c.Assert(ok, Equals, true)
+	c.Assert(unlock, FitsTypeOf, &AppUnlock{})
+}
+
+func (s *S) TestAppMaintenanceIsRegistered(c *C) {
+	manager := buildManager("tsuru-admin")
+	maintenance, ok := manager.Commands["app-maintenance"]
+	c.Assert(ok, Equals, true)
+	c.Assert(maintenance, FitsTypeOf, &AppMaintenance{})
+}
+
 func (s *S) TestCommandsFromBaseManagerAreRegistered(c *C) {
 	baseManager := cmd.BuildBaseManager("tsuru", version, header)
 	manager := buildManager("tsuru")
@@ -26,3 +57,46 @@ func (s *S) TestCommandsFromBaseManagerAreRegistered(c *C) {
 		c.Assert(command, FitsTypeOf, instance)
 	}
 }
+
+func (s *S) TestParseFlags(c *C) {
+	old := os.Args
+	defer func() {
+		os.Args = old
+		*AuditUser = ""
+		*tsuru.AppName = ""
+		*AuditLimit = 100
+	}()
+	os.Args = []string{"tsuru-admin", "audit", "--user", "me@tsuru.io", "--app", "myapp", "--limit", "20"}
+	args := parseFlags()
+	c.Assert(args, DeepEquals, []string{"audit"})
+	c.Assert(*AuditUser, Equals, "me@tsuru.io")
+	c.Assert(*tsuru.AppName, Equals, "myapp")
+	c.Assert(*AuditLimit, Equals, 20)
+}
+
+func (s *S) TestRunCommandWithFlags(c *C) {
+	old := os.Args
+	defer func() {
+		os.Args = old
+		*AuditUser = ""
+		*tsuru.TeamName = ""
+		*AuditLimit = 100
+	}()
+	os.Args = []string{"tsuru-admin", "audit", "--user=me@tsuru.io", "--team", "ops", "--limit", "5"}
+	args := parseFlags()
+	var stdout, stderr bytes.Buffer
+	trans := &conditionalTransport{
+		transport{msg: "", status: http.StatusNoContent},
+		func(req *http.Request) bool {
+			query := req.URL.Query()
+			return req.URL.Path == "/audit" && query.Get("user") == "me@tsuru.io" &&
+				query.Get("team") == "ops" && query.Get("limit") == "5"
+		},
+	}
+	manager := buildManager("tsuru-admin")
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := manager.Commands[args[0]].(cmd.Command)
+	context := cmd.Context{Args: args[1:], Stdout: &stdout, Stderr: &stderr}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+}
@@ -0,0 +1,54 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/cmd/tsuru"
+	"net/http"
+	"strings"
+)
+
+type AppMaintenance struct {
+	tsuru.GuessingCommand
+}
+
+func (c *AppMaintenance) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-maintenance",
+		Usage: "app-maintenance <on|off> [--app appname]",
+		Desc: `turns the maintenance mode of an app on or off.
+
+While the maintenance mode is on, only admin users can deploy the app, run
+commands in it, change its environment variables or scale it. Use it to freeze
+an app during data migrations, for example.
+
+If you don't provide the app name, tsuru-admin will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *AppMaintenance) Run(context *cmd.Context, client cmd.Doer) error {
+	mode := context.Args[0]
+	if mode != "on" && mode != "off" {
+		return errors.New(`The maintenance mode must be either "on" or "off".`)
+	}
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/maintenance", appName))
+	request, err := http.NewRequest("POST", url, strings.NewReader(mode))
+	if err != nil {
+		return err
+	}
+	if _, err = client.Do(request); err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "The maintenance mode of the app %s was turned %s.\n", appName, mode)
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/cmd/tsuru"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"os"
+)
+
+func (s *S) TestAppMaintenanceInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "app-maintenance",
+		Usage: "app-maintenance <on|off> [--app appname]",
+		Desc: `turns the maintenance mode of an app on or off.
+
+While the maintenance mode is on, only admin users can deploy the app, run
+commands in it, change its environment variables or scale it. Use it to freeze
+an app during data migrations, for example.
+
+If you don't provide the app name, tsuru-admin will try to guess it.`,
+		MinArgs: 1,
+	}
+	c.Assert((&AppMaintenance{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestAppMaintenance(c *C) {
+	*tsuru.AppName = "frozen"
+	defer func() {
+		*tsuru.AppName = ""
+	}()
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Args:   []string{"on"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{msg: "", status: http.StatusOK},
+		func(req *http.Request) bool {
+			called = true
+			body, _ := ioutil.ReadAll(req.Body)
+			return req.URL.Path == "/apps/frozen/maintenance" && req.Method == "POST" && string(body) == "on"
+		},
+	}
+	manager := cmd.NewManager("tsuru-admin", version, header, &stdout, &stderr, os.Stdin)
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&AppMaintenance{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "The maintenance mode of the app frozen was turned on.\n")
+}
+
+func (s *S) TestAppMaintenanceWithAppFlag(c *C) {
+	old := os.Args
+	defer func() {
+		os.Args = old
+		*tsuru.AppName = ""
+	}()
+	os.Args = []string{"tsuru-admin", "app-maintenance", "off", "--app", "frozen"}
+	args := parseFlags()
+	c.Assert(args, DeepEquals, []string{"app-maintenance", "off"})
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	trans := &conditionalTransport{
+		transport{msg: "", status: http.StatusOK},
+		func(req *http.Request) bool {
+			called = true
+			body, _ := ioutil.ReadAll(req.Body)
+			return req.URL.Path == "/apps/frozen/maintenance" && req.Method == "POST" && string(body) == "off"
+		},
+	}
+	manager := buildManager("tsuru-admin")
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	context := cmd.Context{Args: args[1:], Stdout: &stdout, Stderr: &stderr}
+	err := manager.Commands[args[0]].(cmd.Command).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "The maintenance mode of the app frozen was turned off.\n")
+}
+
+func (s *S) TestAppMaintenanceInvalidMode(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"maybe"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	err := (&AppMaintenance{}).Run(&context, nil)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `The maintenance mode must be either "on" or "off".`)
+}
@@ -8,10 +8,25 @@ import (
 	"fmt"
 	"github.com/globocom/tsuru/cmd"
 	"io"
+	"launchpad.net/gnuflag"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
+var RunOnce = gnuflag.Bool("once", false, "Run the command in only one of the started units")
+var RunTimeout = gnuflag.String("timeout", "", "Kill the command if it runs for longer than the given duration")
+
+// exitStatusTrailer matches the line the server writes after the output of
+// commands that exit with a non-zero status.
+var exitStatusTrailer = regexp.MustCompile(`\n ---> Exit status: (\d+)\n$`)
+
+// trailerSize is the amount of output held back while copying, enough to
+// contain the exit status trailer.
+const trailerSize = 64
+
 type AppRun struct {
 	GuessingCommand
 }
@@ -21,11 +36,16 @@ func (c *AppRun) Info() *cmd.Info {
 Notice that you may need quotes to run your command if you want to deal with
 input and outputs redirects, and pipes.
 
+Use --unit to run the command in a single unit, or --once to run it in any of
+the started units. With --timeout, the command is killed if it runs for longer
+than the given duration, like 30s or 5m. tsuru exits with the exit status of
+the command.
+
 If you don't provide the app name, tsuru will try to guess it.
 `
 	return &cmd.Info{
 		Name:    "run",
-		Usage:   `run <command> [commandarg1] [commandarg2] ... [commandargn] [--app appname]`,
+		Usage:   `run <command> [commandarg1] [commandarg2] ... [commandargn] [--app appname] [--unit unitname] [--once] [--timeout duration]`,
 		Desc:    desc,
 		MinArgs: 1,
 	}
@@ -36,9 +56,22 @@ func (c *AppRun) Run(context *cmd.Context, client cmd.Doer) error {
 	if err != nil {
 		return err
 	}
-	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/run", appName))
+	params := url.Values{}
+	if UnitName != nil && *UnitName != "" {
+		params.Set("unit", *UnitName)
+	}
+	if RunOnce != nil && *RunOnce {
+		params.Set("once", "true")
+	}
+	if RunTimeout != nil && *RunTimeout != "" {
+		params.Set("timeout", *RunTimeout)
+	}
+	path := fmt.Sprintf("/apps/%s/run", appName)
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
 	b := strings.NewReader(strings.Join(context.Args, " "))
-	request, err := http.NewRequest("POST", url, b)
+	request, err := http.NewRequest("POST", cmd.GetUrl(path), b)
 	if err != nil {
 		return err
 	}
@@ -47,6 +80,41 @@ func (c *AppRun) Run(context *cmd.Context, client cmd.Doer) error {
 		return err
 	}
 	defer r.Body.Close()
-	_, err = io.Copy(context.Stdout, r.Body)
-	return err
+	status, err := copyOutput(context.Stdout, r.Body)
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		return &cmd.ExitError{Code: status}
+	}
+	return nil
+}
+
+// copyOutput copies the output of the command from r to w, stripping the exit
+// status trailer, and returns the exit status of the command.
+func copyOutput(w io.Writer, r io.Reader) (int, error) {
+	var pending []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		pending = append(pending, buf[:n]...)
+		if len(pending) > trailerSize {
+			if _, err := w.Write(pending[:len(pending)-trailerSize]); err != nil {
+				return 0, err
+			}
+			pending = append([]byte(nil), pending[len(pending)-trailerSize:]...)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	var status int
+	if m := exitStatusTrailer.FindSubmatchIndex(pending); m != nil {
+		status, _ = strconv.Atoi(string(pending[m[2]:m[3]]))
+		pending = pending[:m[0]]
+	}
+	_, err := w.Write(pending)
+	return status, err
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/globocom/tsuru/cmd"
 	. "launchpad.net/gocheck"
 	"net/http"
+	"strings"
 )
 
 func (s *S) TestAppRun(c *C) {
@@ -89,16 +90,102 @@ func (s *S) TestAppRunWithoutTheFlag(c *C) {
 	c.Assert(stdout.String(), Equals, expected)
 }
 
+func (s *S) TestAppRunInASingleUnit(c *C) {
+	*UnitName = "bla/1"
+	*RunTimeout = "5m"
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"./migrate"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{msg: "migrated", status: http.StatusOK},
+		func(req *http.Request) bool {
+			q := req.URL.Query()
+			return req.URL.Path == "/apps/bla/run" && q.Get("unit") == "bla/1" &&
+				q.Get("timeout") == "5m" && q.Get("once") == ""
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "bla"}
+	err := (&AppRun{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "migrated")
+}
+
+func (s *S) TestAppRunOnce(c *C) {
+	*RunOnce = true
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"./migrate"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{msg: "migrated", status: http.StatusOK},
+		func(req *http.Request) bool {
+			return req.URL.Path == "/apps/bla/run" && req.URL.Query().Get("once") == "true"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "bla"}
+	err := (&AppRun{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "migrated")
+}
+
+func (s *S) TestAppRunReturnsTheExitStatusOfTheCommand(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"./migrate"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &transport{msg: "table already exists\n ---> Exit status: 2\n", status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "bla"}
+	err := (&AppRun{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, NotNil)
+	e, ok := err.(*cmd.ExitError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, 2)
+	c.Assert(stdout.String(), Equals, "table already exists")
+}
+
+func (s *S) TestCopyOutputWithLongOutput(c *C) {
+	var buf bytes.Buffer
+	output := strings.Repeat("a lot of output\n", 1000)
+	status, err := copyOutput(&buf, strings.NewReader(output+"\n ---> Exit status: 124\n"))
+	c.Assert(err, IsNil)
+	c.Assert(status, Equals, 124)
+	c.Assert(buf.String(), Equals, output)
+}
+
+func (s *S) TestCopyOutputWithoutTrailer(c *C) {
+	var buf bytes.Buffer
+	output := "the output mentions\n ---> Exit status: 1\n but it is not the end"
+	status, err := copyOutput(&buf, strings.NewReader(output))
+	c.Assert(err, IsNil)
+	c.Assert(status, Equals, 0)
+	c.Assert(buf.String(), Equals, output)
+}
+
 func (s *S) TestInfoAppRun(c *C) {
 	desc := `run a command in all instances of the app, and prints the output.
 Notice that you may need quotes to run your command if you want to deal with
 input and outputs redirects, and pipes.
 
+Use --unit to run the command in a single unit, or --once to run it in any of
+the started units. With --timeout, the command is killed if it runs for longer
+than the given duration, like 30s or 5m. tsuru exits with the exit status of
+the command.
+
 If you don't provide the app name, tsuru will try to guess it.
 `
 	expected := &cmd.Info{
 		Name:    "run",
-		Usage:   `run <command> [commandarg1] [commandarg2] ... [commandargn] [--app appname]`,
+		Usage:   `run <command> [commandarg1] [commandarg2] ... [commandargn] [--app appname] [--unit unitname] [--once] [--timeout duration]`,
 		Desc:    desc,
 		MinArgs: 1,
 	}
@@ -52,4 +52,23 @@ func (s *S) SetUpTest(c *C) {
 	manager = cmd.NewManager("glb", "0.x", "Foo-Tsuru", &stdout, &stderr, os.Stdin)
 	AppName = new(string)
 	AssumeYes = new(bool)
+	Rolling = new(bool)
+	ProcessType = new(string)
+	UnitName = new(string)
+	RunOnce = new(bool)
+	RunTimeout = new(string)
+	HealingAfter = new(string)
+	AutoscaleMin = new(int)
+	AutoscaleMax = new(int)
+	AutoscaleMetric = new(string)
+	AutoscaleThreshold = new(string)
+	AutoscaleCooldown = new(string)
+	LogSince = new(string)
+	LogUntil = new(string)
+	LogFollow = new(bool)
+	EnvPrivate = new(bool)
+	EnvFile = new(string)
+	DryRun = new(bool)
+	TeamName = new(string)
+	WebhookEvents = new(string)
 }
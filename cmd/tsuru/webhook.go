@@ -0,0 +1,249 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
+	"launchpad.net/gnuflag"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var TeamName = gnuflag.String("team", "", "Team name for running team related commands.")
+var WebhookEvents = gnuflag.String("events", "", "Comma-separated list of events the webhook is notified about")
+
+type webhook struct {
+	Id     string
+	App    string
+	Team   string
+	URL    string
+	Events []string
+	Secret string
+}
+
+type WebhookAdd struct {
+	GuessingCommand
+}
+
+func (c *WebhookAdd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "webhook-add",
+		Usage: "webhook-add <url> [--app appname | --team teamname] [--events event1,event2]",
+		Desc: `registers a webhook for an app or for a team.
+
+tsuru POSTs a JSON payload to the URL when an event of the app, or of any app of
+the team, finishes. The events are deploy, restart, add-units, bind, unbind and
+destroy. Without the --events flag, the webhook is notified about all events.
+
+The payloads are signed with the secret displayed by this command: the
+X-Tsuru-Signature header contains "sha256=" followed by the hex encoded
+HMAC-SHA256 of the body, using the secret as key.
+
+If you provide neither the app name nor the team name, tsuru will try to guess
+the app name.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *WebhookAdd) Run(context *cmd.Context, client cmd.Doer) error {
+	h := webhook{URL: context.Args[0]}
+	if TeamName != nil && *TeamName != "" {
+		h.Team = *TeamName
+	} else {
+		appName, err := c.Guess()
+		if err != nil {
+			return err
+		}
+		h.App = appName
+	}
+	if WebhookEvents != nil && *WebhookEvents != "" {
+		for _, e := range strings.Split(*WebhookEvents, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				h.Events = append(h.Events, e)
+			}
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{"app": h.App, "team": h.Team, "url": h.URL, "events": h.Events})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", cmd.GetUrl("/webhooks"), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(result, &h); err != nil {
+		return err
+	}
+	target := "app " + h.App
+	if h.Team != "" {
+		target = "team " + h.Team
+	}
+	fmt.Fprintf(context.Stdout, "Webhook %s successfully added to the %s.\n", h.Id, target)
+	fmt.Fprintf(context.Stdout, "Secret: %s\n", h.Secret)
+	return nil
+}
+
+type WebhookList struct{}
+
+func (c *WebhookList) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "webhook-list",
+		Usage: "webhook-list [--app appname | --team teamname]",
+		Desc: `lists the webhooks of an app or of a team.
+
+Without flags, lists the webhooks of all apps and teams you have access to.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *WebhookList) Run(context *cmd.Context, client cmd.Doer) error {
+	params := url.Values{}
+	if AppName != nil && *AppName != "" {
+		params.Set("app", *AppName)
+	} else if TeamName != nil && *TeamName != "" {
+		params.Set("team", *TeamName)
+	}
+	path := "/webhooks"
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+	request, err := http.NewRequest("GET", cmd.GetUrl(path), nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var hooks []webhook
+	if err = json.Unmarshal(result, &hooks); err != nil {
+		return err
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"Id", "App", "Team", "URL", "Events"})
+	for _, h := range hooks {
+		events := "all"
+		if len(h.Events) > 0 {
+			events = strings.Join(h.Events, ", ")
+		}
+		table.AddRow(cmd.Row([]string{h.Id, h.App, h.Team, h.URL, events}))
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
+
+type WebhookRemove struct{}
+
+func (c *WebhookRemove) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "webhook-remove",
+		Usage:   "webhook-remove <id>",
+		Desc:    "removes a webhook.",
+		MinArgs: 1,
+	}
+}
+
+func (c *WebhookRemove) Run(context *cmd.Context, client cmd.Doer) error {
+	url := cmd.GetUrl("/webhooks/" + context.Args[0])
+	request, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	if _, err = client.Do(request); err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Webhook %s successfully removed.\n", context.Args[0])
+	return nil
+}
+
+type webhookDelivery struct {
+	Event     string
+	App       string
+	Attempts  int
+	Status    int
+	Success   bool
+	Error     string
+	Timestamp time.Time
+}
+
+func (d *webhookDelivery) result() string {
+	switch {
+	case d.Success:
+		return fmt.Sprintf("delivered (%d)", d.Status)
+	case d.Attempts == 0:
+		return "pending"
+	}
+	return fmt.Sprintf("failed (%s)", d.Error)
+}
+
+type WebhookDeliveries struct{}
+
+func (c *WebhookDeliveries) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "webhook-deliveries",
+		Usage: "webhook-deliveries <id>",
+		Desc: `shows the latest deliveries of a webhook, most recent first.
+
+Failed deliveries are retried a few times before being given up.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *WebhookDeliveries) Run(context *cmd.Context, client cmd.Doer) error {
+	url := cmd.GetUrl(fmt.Sprintf("/webhooks/%s/deliveries", context.Args[0]))
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var deliveries []webhookDelivery
+	if err = json.Unmarshal(result, &deliveries); err != nil {
+		return err
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"Date", "Event", "App", "Attempts", "Result"})
+	for _, d := range deliveries {
+		date := d.Timestamp.Format("2006-01-02 15:04:05")
+		table.AddRow(cmd.Row([]string{date, d.Event, d.App, strconv.Itoa(d.Attempts), d.result()}))
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
@@ -0,0 +1,210 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net/http"
+)
+
+func (s *S) TestWebhookAdd(c *C) {
+	*WebhookEvents = "deploy, restart"
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"http://ci.tsuru.io/hook"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	result := `{"Id":"abc123","App":"ble","URL":"http://ci.tsuru.io/hook","Events":["deploy","restart"],"Secret":"s3cr3t"}`
+	trans := &conditionalTransport{
+		transport{msg: result, status: http.StatusOK},
+		func(req *http.Request) bool {
+			var body map[string]interface{}
+			b, _ := ioutil.ReadAll(req.Body)
+			json.Unmarshal(b, &body)
+			return req.URL.Path == "/webhooks" && req.Method == "POST" &&
+				req.Header.Get("Content-Type") == "application/json" &&
+				body["app"] == "ble" && body["team"] == "" && body["url"] == "http://ci.tsuru.io/hook" &&
+				len(body["events"].([]interface{})) == 2
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&WebhookAdd{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "Webhook abc123 successfully added to the app ble.\nSecret: s3cr3t\n")
+}
+
+func (s *S) TestWebhookAddForATeam(c *C) {
+	*TeamName = "myteam"
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"http://chat.tsuru.io/hook"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	result := `{"Id":"abc123","Team":"myteam","URL":"http://chat.tsuru.io/hook","Secret":"s3cr3t"}`
+	trans := &conditionalTransport{
+		transport{msg: result, status: http.StatusOK},
+		func(req *http.Request) bool {
+			var body map[string]interface{}
+			b, _ := ioutil.ReadAll(req.Body)
+			json.Unmarshal(b, &body)
+			return body["team"] == "myteam" && body["app"] == "" && body["events"] == nil
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "ble"}
+	err := (&WebhookAdd{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "Webhook abc123 successfully added to the team myteam.\nSecret: s3cr3t\n")
+}
+
+func (s *S) TestWebhookList(c *C) {
+	*AppName = "ble"
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	result := `[{"Id":"abc123","App":"ble","URL":"http://ci.tsuru.io/hook","Events":["deploy","restart"]},
+{"Id":"def456","Team":"myteam","URL":"http://chat.tsuru.io/hook"}]`
+	trans := &conditionalTransport{
+		transport{msg: result, status: http.StatusOK},
+		func(req *http.Request) bool {
+			return req.URL.Path == "/webhooks" && req.URL.Query().Get("app") == "ble"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&WebhookList{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	expected := `+--------+-----+--------+---------------------------+-----------------+
+| Id     | App | Team   | URL                       | Events          |
++--------+-----+--------+---------------------------+-----------------+
+| abc123 | ble |        | http://ci.tsuru.io/hook   | deploy, restart |
+| def456 |     | myteam | http://chat.tsuru.io/hook | all             |
++--------+-----+--------+---------------------------+-----------------+
+`
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestWebhookListWithoutWebhooks(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &conditionalTransport{
+		transport{msg: "", status: http.StatusNoContent},
+		func(req *http.Request) bool {
+			return req.URL.Path == "/webhooks" && req.URL.RawQuery == ""
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&WebhookList{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "")
+}
+
+func (s *S) TestWebhookRemove(c *C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{Args: []string{"abc123"}, Stdout: &stdout, Stderr: &stderr}
+	trans := &conditionalTransport{
+		transport{msg: "", status: http.StatusOK},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/webhooks/abc123" && req.Method == "DELETE"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&WebhookRemove{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "Webhook abc123 successfully removed.\n")
+}
+
+func (s *S) TestWebhookDeliveries(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{"abc123"}, Stdout: &stdout, Stderr: &stderr}
+	result := `[
+{"Event":"restart","App":"ble","Attempts":3,"Status":500,"Success":false,"Error":"unexpected status code: 500","Timestamp":"2013-01-02T15:04:05Z"},
+{"Event":"deploy","App":"ble","Attempts":1,"Status":200,"Success":true,"Timestamp":"2013-01-02T14:04:05Z"},
+{"Event":"deploy","App":"ble","Attempts":0,"Timestamp":"2013-01-02T13:04:05Z"}
+]`
+	trans := &conditionalTransport{
+		transport{msg: result, status: http.StatusOK},
+		func(req *http.Request) bool {
+			return req.URL.Path == "/webhooks/abc123/deliveries" && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&WebhookDeliveries{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	expected := `+---------------------+---------+-----+----------+--------------------------------------+
+| Date                | Event   | App | Attempts | Result                               |
++---------------------+---------+-----+----------+--------------------------------------+
+| 2013-01-02 15:04:05 | restart | ble | 3        | failed (unexpected status code: 500) |
+| 2013-01-02 14:04:05 | deploy  | ble | 1        | delivered (200)                      |
+| 2013-01-02 13:04:05 | deploy  | ble | 0        | pending                              |
++---------------------+---------+-----+----------+--------------------------------------+
+`
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestWebhookAddInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "webhook-add",
+		Usage: "webhook-add <url> [--app appname | --team teamname] [--events event1,event2]",
+		Desc: `registers a webhook for an app or for a team.
+
+tsuru POSTs a JSON payload to the URL when an event of the app, or of any app of
+the team, finishes. The events are deploy, restart, add-units, bind, unbind and
+destroy. Without the --events flag, the webhook is notified about all events.
+
+The payloads are signed with the secret displayed by this command: the
+X-Tsuru-Signature header contains "sha256=" followed by the hex encoded
+HMAC-SHA256 of the body, using the secret as key.
+
+If you provide neither the app name nor the team name, tsuru will try to guess
+the app name.`,
+		MinArgs: 1,
+	}
+	c.Assert((&WebhookAdd{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestWebhookListInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "webhook-list",
+		Usage: "webhook-list [--app appname | --team teamname]",
+		Desc: `lists the webhooks of an app or of a team.
+
+Without flags, lists the webhooks of all apps and teams you have access to.`,
+		MinArgs: 0,
+	}
+	c.Assert((&WebhookList{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestWebhookRemoveInfo(c *C) {
+	expected := &cmd.Info{
+		Name:    "webhook-remove",
+		Usage:   "webhook-remove <id>",
+		Desc:    "removes a webhook.",
+		MinArgs: 1,
+	}
+	c.Assert((&WebhookRemove{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestWebhookDeliveriesInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "webhook-deliveries",
+		Usage: "webhook-deliveries <id>",
+		Desc: `shows the latest deliveries of a webhook, most recent first.
+
+Failed deliveries are retried a few times before being given up.`,
+		MinArgs: 1,
+	}
+	c.Assert((&WebhookDeliveries{}).Info(), DeepEquals, expected)
+}
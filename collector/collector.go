@@ -6,29 +6,175 @@ package main
 
 import (
 	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/audit"
 	"github.com/globocom/tsuru/db"
 	"github.com/globocom/tsuru/log"
 	"github.com/globocom/tsuru/provision"
 	"labix.org/v2/mgo/bson"
+	"net/http"
+	"strconv"
+	"time"
 )
 
+// auditUser is the user of the entries recorded by the collector in the audit
+// trail.
+const auditUser = "tsuru-collector"
+
+// update reconciles the apps with the units reported by the provisioner (see
+// app.App.Reconcile). Stuck units are replaced when the healing policy of the
+// app is enabled.
+//
+// An empty list of units is more likely a failure of the provisioner than the
+// removal of all units, so units are not removed in this case.
 func update(units []provision.Unit) {
 	log.Print("updating status from provisioner")
+	now := time.Now()
+	var names []string
+	appUnits := make(map[string][]provision.Unit)
 	for _, unit := range units {
-		a := app.App{Name: unit.AppName}
+		if _, ok := appUnits[unit.AppName]; !ok {
+			names = append(names, unit.AppName)
+		}
+		appUnits[unit.AppName] = append(appUnits[unit.AppName], unit)
+	}
+	prune := len(units) > 0
+	if prune {
+		// apps whose units all vanished are not in the list of units.
+		var vanished []app.App
+		query := bson.M{"name": bson.M{"$nin": names}, "units.0": bson.M{"$exists": true}}
+		if err := db.Session.Apps().Find(query).Select(bson.M{"name": 1}).All(&vanished); err != nil {
+			log.Printf("collector: failed to list the apps without reported units: %s", err)
+		}
+		for _, a := range vanished {
+			names = append(names, a.Name)
+		}
+	}
+	for _, name := range names {
+		a := app.App{Name: name}
 		err := a.Get()
 		if err != nil {
-			log.Printf("collector: app %s not found. Skipping.\n", unit.AppName)
+			log.Printf("collector: app %s not found. Skipping.\n", name)
+			continue
+		}
+		if err = a.Reconcile(appUnits[name], prune, now); err != nil {
+			log.Printf("collector: failed to update the app %s: %s", name, err)
+			continue
+		}
+		heal(&a, now)
+	}
+}
+
+// heal replaces the stuck units of the app, if its healing policy is enabled.
+// Units are only replaced while the app has started units, so an outage of
+// the whole app, or of the provisioner, doesn't replace all of them at once.
+// Units of stopped apps and of apps in maintenance mode are never replaced.
+func heal(a *app.App, now time.Time) {
+	if !a.Healing.Enabled || a.Stopped || !a.Available() {
+		return
+	}
+	units := a.StuckUnits(now)
+	if len(units) == 0 {
+		return
+	}
+	if a.Maintenance.Enabled {
+		log.Printf("collector: not healing the app %s: it's in maintenance mode", a.Name)
+		return
+	}
+	lock, err := app.AcquireLock(a.Name, "collector", "heal", 0)
+	if err != nil {
+		log.Printf("collector: not healing the app %s: %s", a.Name, err)
+		return
+	}
+	defer lock.Release()
+	if err = a.Get(); err != nil {
+		log.Printf("collector: failed to reload the app %s: %s", a.Name, err)
+		return
+	}
+	if a.Maintenance.Enabled {
+		log.Printf("collector: not healing the app %s: it's in maintenance mode", a.Name)
+		return
+	} else if !a.Healing.Enabled || a.Stopped {
+		return
+	}
+	for _, u := range a.StuckUnits(now) {
+		if err := a.Heal(u); err != nil {
+			log.Printf("collector: failed to replace the unit %s of the app %s: %s", u.Name, a.Name, err)
+		}
+	}
+}
+
+// sampleMetrics samples the resources used by the started units of all apps
+// (see app.App.CollectMetrics), and then scales the apps according to their
+// autoscale policies.
+func sampleMetrics(now time.Time) {
+	var apps []app.App
+	query := bson.M{"units.state": provision.StatusStarted.String()}
+	if err := db.Session.Apps().Find(query).All(&apps); err != nil {
+		log.Printf("collector: failed to list the apps with started units: %s", err)
+		return
+	}
+	for i := range apps {
+		if _, err := apps[i].CollectMetrics(now); err != nil {
+			log.Printf("collector: failed to collect the metrics of the app %s: %s", apps[i].Name, err)
 			continue
 		}
-		u := app.Unit{}
-		u.Name = unit.Name
-		u.Type = unit.Type
-		u.Machine = unit.Machine
-		u.Ip = unit.Ip
-		u.State = string(unit.Status)
-		a.State = string(unit.Status)
-		a.AddUnit(&u)
-		db.Session.Apps().Update(bson.M{"name": a.Name}, a)
+		autoscale(&apps[i], now)
+	}
+}
+
+// autoscale evaluates the autoscale policy of the app and applies its
+// decision. Apps in maintenance mode are skipped. Decisions are recorded in
+// the audit trail, as made by the collector.
+//
+// The policy is evaluated again once the lock of the app is taken, against
+// the app reloaded from the database, as it may have changed in the meantime.
+func autoscale(a *app.App, now time.Time) {
+	if a.Maintenance.Enabled {
+		log.Printf("collector: not autoscaling the app %s: it's in maintenance mode", a.Name)
+		return
+	}
+	d, err := a.EvaluateAutoscale(now)
+	if err != nil {
+		log.Printf("collector: failed to evaluate the autoscale policy of the app %s: %s", a.Name, err)
+		return
+	} else if d == nil {
+		return
+	}
+	lock, err := app.AcquireLock(a.Name, "collector", "autoscale", 0)
+	if err != nil {
+		log.Printf("collector: not autoscaling the app %s: %s", a.Name, err)
+		return
+	}
+	defer lock.Release()
+	if err = a.Get(); err != nil {
+		log.Printf("collector: failed to reload the app %s: %s", a.Name, err)
+		return
+	}
+	if d, err = a.EvaluateAutoscale(now); err != nil {
+		log.Printf("collector: failed to evaluate the autoscale policy of the app %s: %s", a.Name, err)
+		return
+	} else if d == nil {
+		return
+	}
+	entry := audit.Entry{
+		User:   auditUser,
+		Action: "autoscale",
+		App:    a.Name,
+		Params: map[string]string{
+			"process": d.Process,
+			"from":    strconv.Itoa(d.From),
+			"to":      strconv.Itoa(d.To),
+			"reason":  d.Reason,
+		},
+		Timestamp: now,
+	}
+	status := http.StatusOK
+	err = a.Scale(d, now)
+	if err != nil {
+		log.Printf("collector: failed to autoscale the app %s: %s", a.Name, err)
+		status = http.StatusInternalServerError
+	}
+	if err := entry.Record(status, err); err != nil {
+		log.Printf("collector: failed to record the autoscale of the app %s in the audit trail: %s", a.Name, err)
 	}
 }
@@ -5,11 +5,16 @@
 package main
 
 import (
+	"github.com/globocom/config"
 	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/audit"
 	"github.com/globocom/tsuru/db"
 	"github.com/globocom/tsuru/provision"
+	ttesting "github.com/globocom/tsuru/testing"
 	"labix.org/v2/mgo/bson"
 	. "launchpad.net/gocheck"
+	"net/http"
+	"time"
 )
 
 func getOutput() []provision.Unit {
@@ -156,3 +161,336 @@ func (s *S) TestUpdateWithMultipleApps(c *C) {
 		c.Assert(a.Units[0].Ip, Equals, appDict["ip"])
 	}
 }
+
+func (s *S) TestUpdateComputesTheStateFromAllUnits(c *C) {
+	a := getApp(c)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.UnitHistory().RemoveAll(bson.M{"app": a.Name})
+	out := getOutput()
+	out = append(out, provision.Unit{
+		Name:    "i-00000zz9",
+		AppName: "umaappqq",
+		Type:    "python",
+		Machine: 2,
+		Status:  provision.StatusPending,
+	})
+	update(out)
+	err := a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.State, Equals, app.StatePartiallyStarted)
+	out[1].Status = provision.StatusError
+	update(out)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.State, Equals, app.StateDegraded)
+}
+
+func (s *S) TestUpdateRecordsTheUnitHistory(c *C) {
+	a := getApp(c)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.UnitHistory().RemoveAll(bson.M{"app": a.Name})
+	out := getOutput()
+	out[0].Status = provision.StatusPending
+	update(out)
+	err := a.Get()
+	c.Assert(err, IsNil)
+	since := a.Units[0].StateSince
+	c.Assert(since.IsZero(), Equals, false)
+	update(out)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units[0].StateSince.Equal(since), Equals, true)
+	out[0].Status = provision.StatusStarted
+	update(out)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units[0].StateSince.Before(since), Equals, false)
+	var changes []app.UnitStatusChange
+	err = db.Session.UnitHistory().Find(bson.M{"app": a.Name}).Sort("date").All(&changes)
+	c.Assert(err, IsNil)
+	c.Assert(changes, HasLen, 2)
+	c.Assert(changes[0].From, Equals, "")
+	c.Assert(changes[0].To, Equals, "pending")
+	c.Assert(changes[1].From, Equals, "pending")
+	c.Assert(changes[1].To, Equals, "started")
+}
+
+func (s *S) TestUpdateRemovesUnitsThatAreNoLongerReported(c *C) {
+	old := time.Now().Add(-time.Hour)
+	a := app.App{
+		Name: "umaappqq",
+		Units: []app.Unit{
+			{Name: "i-00000zz8", State: "started", StateSince: old},
+			{Name: "i-00000zz7", State: "started", StateSince: old},
+			{Name: "i-00000zz6", State: "pending", StateSince: time.Now()},
+		},
+	}
+	err := db.Session.Apps().Insert(&a)
+	c.Assert(err, IsNil)
+	other := app.App{Name: "vanished", Units: []app.Unit{{Name: "vanished/0", State: "started", StateSince: old}}}
+	err = db.Session.Apps().Insert(&other)
+	c.Assert(err, IsNil)
+	defer db.Session.UnitHistory().RemoveAll(bson.M{"app": bson.M{"$in": []string{a.Name, other.Name}}})
+	update(getOutput())
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 2)
+	c.Assert(a.Units[0].Name, Equals, "i-00000zz8")
+	c.Assert(a.Units[1].Name, Equals, "i-00000zz6")
+	err = other.Get()
+	c.Assert(err, IsNil)
+	c.Assert(other.Units, HasLen, 0)
+	var l app.Applog
+	err = db.Session.Logs().Find(bson.M{"appname": a.Name}).One(&l)
+	c.Assert(err, IsNil)
+	c.Assert(l.Message, Equals, "Removing the unit i-00000zz7: it's no longer reported by the provisioner.")
+	n, err := db.Session.UnitHistory().Find(bson.M{"app": a.Name, "unit": "i-00000zz7", "to": ""}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+}
+
+func (s *S) TestUpdateDoesNotRemoveUnitsWithoutStatus(c *C) {
+	a := app.App{
+		Name:  "umaappqq",
+		Units: []app.Unit{{Name: "i-00000zz8", State: "started", StateSince: time.Now().Add(-time.Hour)}},
+	}
+	err := db.Session.Apps().Insert(&a)
+	c.Assert(err, IsNil)
+	update(nil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 1)
+}
+
+func (s *S) TestUpdateFlagsStuckUnits(c *C) {
+	since := time.Now().Add(-time.Hour)
+	a := app.App{
+		Name: "umaappqq",
+		Units: []app.Unit{
+			{Name: "i-00000zz8", State: "started", StateSince: since},
+			{Name: "i-00000zz9", State: "error", StateSince: since},
+		},
+	}
+	err := db.Session.Apps().Insert(&a)
+	c.Assert(err, IsNil)
+	defer db.Session.UnitHistory().RemoveAll(bson.M{"app": a.Name})
+	out := getOutput()
+	out = append(out, provision.Unit{Name: "i-00000zz9", AppName: "umaappqq", Machine: 2, Status: provision.StatusError})
+	update(out)
+	update(out)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.State, Equals, app.StateDegraded)
+	c.Assert(a.Units[0].Stuck, Equals, false)
+	c.Assert(a.Units[1].Stuck, Equals, true)
+	c.Assert(a.Units, HasLen, 2)
+	query := bson.M{"appname": a.Name, "message": bson.RegEx{Pattern: "^The unit i-00000zz9 is stuck"}}
+	n, err := db.Session.Logs().Find(query).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+}
+
+func (s *S) TestUpdateReplacesStuckUnitsWhenHealingIsEnabled(c *C) {
+	server := ttesting.FakeQueueServer{}
+	server.Start("127.0.0.1:0")
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err == nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	a := app.App{Name: "umaappqq", Healing: app.HealingPolicy{Enabled: true, After: time.Minute}}
+	err = db.Session.Apps().Insert(&a)
+	c.Assert(err, IsNil)
+	defer db.Session.UnitHistory().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = a.AddUnits(2, "")
+	c.Assert(err, IsNil)
+	since := time.Now().Add(-time.Hour)
+	a.Units[0].State, a.Units[0].StateSince = "started", since
+	a.Units[1].State, a.Units[1].StateSince = "error", since
+	err = db.Session.Apps().Update(bson.M{"name": a.Name}, &a)
+	c.Assert(err, IsNil)
+	out := []provision.Unit{
+		{Name: a.Units[0].Name, AppName: a.Name, Status: provision.StatusStarted},
+		{Name: a.Units[1].Name, AppName: a.Name, Status: provision.StatusError},
+	}
+	update(out)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 2)
+	c.Assert(a.Units[0].Name, Equals, "umaappqq/0")
+	c.Assert(a.Units[1].Name, Equals, "umaappqq/2")
+	c.Assert(s.provisioner.GetUnits(&a), HasLen, 2)
+}
+
+func (s *S) TestUpdateDoesNotReplaceStuckUnitsOfAppsInMaintenance(c *C) {
+	since := time.Now().Add(-time.Hour)
+	a := app.App{
+		Name:        "umaappqq",
+		Healing:     app.HealingPolicy{Enabled: true, After: time.Minute},
+		Maintenance: app.Maintenance{Enabled: true, User: "admin@tsuru.io", Since: since},
+		Units: []app.Unit{
+			{Name: "i-00000zz8", State: "started", StateSince: since},
+			{Name: "i-00000zz9", State: "error", StateSince: since},
+		},
+	}
+	err := db.Session.Apps().Insert(&a)
+	c.Assert(err, IsNil)
+	defer db.Session.UnitHistory().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	out := getOutput()
+	out = append(out, provision.Unit{Name: "i-00000zz9", AppName: "umaappqq", Machine: 2, Status: provision.StatusError})
+	update(out)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 2)
+	c.Assert(a.Units[1].Name, Equals, "i-00000zz9")
+	c.Assert(s.provisioner.GetUnits(&a), HasLen, 0)
+	query := bson.M{"appname": a.Name, "message": bson.RegEx{Pattern: "^Replacing the unit"}}
+	n, err := db.Session.Logs().Find(query).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *S) TestUpdateKeepsStoppedAppsDown(c *C) {
+	since := time.Now().Add(-time.Hour)
+	a := app.App{
+		Name:    "umaappqq",
+		State:   "down",
+		Stopped: true,
+		Healing: app.HealingPolicy{Enabled: true, After: time.Minute},
+		Units: []app.Unit{
+			{Name: "i-00000zz8", State: "down", StateSince: since},
+			{Name: "i-00000zz9", State: "down", StateSince: since},
+		},
+	}
+	err := db.Session.Apps().Insert(&a)
+	c.Assert(err, IsNil)
+	defer db.Session.UnitHistory().RemoveAll(bson.M{"app": a.Name})
+	out := getOutput()
+	out = append(out, provision.Unit{Name: "i-00000zz9", AppName: "umaappqq", Machine: 2, Status: provision.StatusDown})
+	update(out)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.State, Equals, "down")
+	c.Assert(a.Stopped, Equals, true)
+	c.Assert(a.Units, HasLen, 2)
+	c.Assert(a.Units[1].Name, Equals, "i-00000zz9")
+	c.Assert(a.Units[1].Stuck, Equals, false)
+}
+
+func (s *S) TestSampleMetrics(c *C) {
+	s.provisioner.PrepareOutput([]byte("sleep: command not found"))
+	started := app.App{
+		Name:  "measured",
+		Units: []app.Unit{{Name: "measured/0", State: string(provision.StatusStarted)}},
+	}
+	stopped := app.App{
+		Name:  "stopped",
+		Units: []app.Unit{{Name: "stopped/0", State: string(provision.StatusDown)}},
+	}
+	err := db.Session.Apps().Insert(started, stopped)
+	c.Assert(err, IsNil)
+	sampleMetrics(time.Now())
+	c.Assert(s.provisioner.GetCmds("", &started), HasLen, 1)
+	c.Assert(s.provisioner.GetCmds("", &stopped), HasLen, 0)
+}
+
+func (s *S) TestAutoscale(c *C) {
+	server := ttesting.FakeQueueServer{}
+	server.Start("127.0.0.1:0")
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err == nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	a := app.App{
+		Name:      "elastic",
+		Framework: "python",
+		Autoscale: app.AutoscalePolicy{Enabled: true, MinUnits: 1, MaxUnits: 2, Metric: app.MetricCPU, Threshold: 50},
+	}
+	err = db.Session.Apps().Insert(&a)
+	c.Assert(err, IsNil)
+	defer db.Session.Metrics().RemoveAll(bson.M{"app": a.Name})
+	defer db.Session.Audit().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = a.AddUnits(1, "")
+	c.Assert(err, IsNil)
+	a.Units[0].State = "started"
+	err = db.Session.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": bson.M{"units": a.Units}})
+	c.Assert(err, IsNil)
+	now := time.Now()
+	err = db.Session.Metrics().Insert(app.UnitMetrics{App: a.Name, Unit: a.Units[0].Name, Date: now, CPU: 75})
+	c.Assert(err, IsNil)
+	autoscale(&a, now)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 2)
+	c.Assert(s.provisioner.GetUnits(&a), HasLen, 2)
+	var entry audit.Entry
+	err = db.Session.Audit().Find(bson.M{"app": a.Name}).One(&entry)
+	c.Assert(err, IsNil)
+	c.Assert(entry.User, Equals, auditUser)
+	c.Assert(entry.Action, Equals, "autoscale")
+	c.Assert(entry.Status, Equals, http.StatusOK)
+	c.Assert(entry.Params, DeepEquals, map[string]string{
+		"process": "web",
+		"from":    "1",
+		"to":      "2",
+		"reason":  "average cpu of 75.0% is above the threshold of 50.0%",
+	})
+	n, err := db.Session.Logs().Find(bson.M{"appname": a.Name, "message": bson.RegEx{Pattern: "^Autoscaling the web units from 1 to 2"}}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	autoscale(&a, now.Add(time.Minute))
+	c.Assert(a.Units, HasLen, 2)
+	n, err = db.Session.Audit().Find(bson.M{"app": a.Name}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+}
+
+func (s *S) TestAutoscaleSkipsAppsInMaintenance(c *C) {
+	a := app.App{
+		Name:        "elastic",
+		Framework:   "python",
+		Autoscale:   app.AutoscalePolicy{Enabled: true, MinUnits: 2, MaxUnits: 3, Metric: app.MetricCPU, Threshold: 50},
+		Maintenance: app.Maintenance{Enabled: true, User: "admin@tsuru.io", Since: time.Now()},
+		Units:       []app.Unit{{Name: "elastic/0", State: "started"}},
+	}
+	err := db.Session.Apps().Insert(&a)
+	c.Assert(err, IsNil)
+	defer db.Session.Audit().RemoveAll(bson.M{"app": a.Name})
+	autoscale(&a, time.Now())
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 1)
+	n, err := db.Session.Audit().Find(bson.M{"app": a.Name}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *S) TestAutoscaleReloadsTheAppAfterTakingTheLock(c *C) {
+	a := app.App{
+		Name:      "elastic",
+		Framework: "python",
+		Autoscale: app.AutoscalePolicy{Enabled: true, MinUnits: 2, MaxUnits: 3, Metric: app.MetricCPU, Threshold: 50},
+		Units:     []app.Unit{{Name: "elastic/0", State: "started"}},
+	}
+	err := db.Session.Apps().Insert(&a)
+	c.Assert(err, IsNil)
+	defer db.Session.Audit().RemoveAll(bson.M{"app": a.Name})
+	stale := a
+	err = db.Session.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": bson.M{"autoscale.enabled": false}})
+	c.Assert(err, IsNil)
+	autoscale(&stale, time.Now())
+	c.Assert(stale.Autoscale.Enabled, Equals, false)
+	c.Assert(stale.Units, HasLen, 1)
+	n, err := db.Session.Audit().Find(bson.M{"app": a.Name}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
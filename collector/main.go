@@ -24,11 +24,30 @@ func jujuCollect(ticker <-chan time.Time) {
 		units, err := app.Provisioner.CollectStatus()
 		if err != nil {
 			log.Printf("Failed to collect status within the provisioner: %s.", err)
+			continue
 		}
 		update(units)
 	}
 }
 
+// scheduleCrons dispatches, at each tick, the cron jobs of apps that are due.
+// The jobs are run by the message handler.
+func scheduleCrons(ticker <-chan time.Time) {
+	for now := range ticker {
+		if _, err := app.DispatchCrons(now); err != nil {
+			log.Printf("Failed to dispatch cron jobs: %s.", err)
+		}
+	}
+}
+
+// collectMetrics samples, at each tick, the resources used by the units of
+// apps.
+func collectMetrics(ticker <-chan time.Time) {
+	for now := range ticker {
+		sampleMetrics(now)
+	}
+}
+
 func fatal(err error) {
 	fmt.Fprintln(os.Stderr, err)
 	log.Fatal(err)
@@ -86,6 +105,8 @@ func main() {
 		}
 		fmt.Printf("Queue server listening at %s.\n", handler.server.Addr())
 		defer handler.stop()
+		go scheduleCrons(time.Tick(time.Minute))
+		go collectMetrics(time.Tick(time.Minute))
 		ticker := time.Tick(time.Minute)
 		fmt.Println("tsuru collector agent started...")
 		jujuCollect(ticker)
@@ -57,7 +57,7 @@ func (h *MessageHandler) ensureAppIsStarted(msg queue.Message) (app.App, error)
 		return a, fmt.Errorf("Error handling %q: app %q does not exist.", msg.Action, a.Name)
 	}
 	units := h.getUnits(&a, msg.Args[1:])
-	if a.State != "started" || !units.Started() {
+	if !a.Available() || !units.Started() {
 		format := "Error handling %q for the app %q:"
 		switch a.State {
 		case "error":
@@ -104,6 +104,19 @@ func (h *MessageHandler) handle(msg queue.Message) {
 		if err != nil {
 			log.Printf("Error handling %q. App failed to start:\n%s.", msg.Action, err)
 		}
+	case app.RunCron:
+		if len(msg.Args) < 2 {
+			log.Printf("Error handling %q: this action requires 2 arguments.", msg.Action)
+			return
+		}
+		a := app.App{Name: msg.Args[0]}
+		if err := a.Get(); err != nil {
+			log.Printf("Error handling %q: app %q does not exist.", msg.Action, a.Name)
+			return
+		}
+		if err := a.RunCron(msg.Args[1]); err != nil {
+			log.Printf("Error handling %q for the app %q: %s", msg.Action, a.Name, err)
+		}
 	default:
 		log.Printf("Error handling %q: invalid action.", msg.Action)
 	}
@@ -6,6 +6,7 @@ package main
 
 import (
 	"bytes"
+	"github.com/globocom/config"
 	"github.com/globocom/tsuru/api/bind"
 	"github.com/globocom/tsuru/app"
 	"github.com/globocom/tsuru/db"
@@ -53,8 +54,8 @@ func (s *S) TestHandleMessages(c *C) {
 	cmds := s.provisioner.GetCmds("", &a)
 	c.Assert(cmds, HasLen, 1)
 	output := strings.Replace(cmds[0].Cmd, "\n", " ", -1)
-	outputRegexp := `^cat > /home/application/apprc <<END # generated by tsuru.*`
-	outputRegexp += `export http_proxy="http://myproxy.com:3128/" END $`
+	outputRegexp := `^cat > /home/application/apprc <<'END' # generated by tsuru.*`
+	outputRegexp += `export http_proxy='http://myproxy.com:3128/' END $`
 	c.Assert(output, Matches, outputRegexp)
 }
 
@@ -102,11 +103,36 @@ func (s *S) TestHandleMessageWithSpecificUnit(c *C) {
 	cmds := s.provisioner.GetCmds("", &a)
 	c.Assert(cmds, HasLen, 1)
 	output := strings.Replace(cmds[0].Cmd, "\n", " ", -1)
-	outputRegexp := `^cat > /home/application/apprc <<END # generated by tsuru.*`
-	outputRegexp += `export http_proxy="http://myproxy.com:3128/" END $`
+	outputRegexp := `^cat > /home/application/apprc <<'END' # generated by tsuru.*`
+	outputRegexp += `export http_proxy='http://myproxy.com:3128/' END $`
 	c.Assert(output, Matches, outputRegexp)
 }
 
+func (s *S) TestHandleMessageWithSpecificUnitInAPartiallyStartedApp(c *C) {
+	s.provisioner.PrepareOutput([]byte("exported"))
+	handler := MessageHandler{}
+	err := handler.start()
+	c.Assert(err, IsNil)
+	defer handler.stop()
+	a := app.App{
+		Name: "nemesis",
+		Units: []app.Unit{
+			{Name: "nemesis/0", State: "started", Machine: 19},
+			{Name: "nemesis/1", State: "pending", Machine: 20},
+		},
+		State: app.StatePartiallyStarted,
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	messages, _, err := queue.Dial(handler.server.Addr())
+	c.Assert(err, IsNil)
+	messages <- queue.Message{Action: app.RegenerateApprc, Args: []string{a.Name, "nemesis/0"}}
+	time.Sleep(1e9)
+	cmds := s.provisioner.GetCmds("", &a)
+	c.Assert(cmds, HasLen, 1)
+}
+
 func (s *S) TestHandleMessageErrors(c *C) {
 	var data = []struct {
 		action      string
@@ -255,6 +281,78 @@ func (s *S) TestHandleRestartAppMessage(c *C) {
 	c.Assert(cmds, HasLen, 1)
 }
 
+func (s *S) TestHandleRunCronMessage(c *C) {
+	s.provisioner.PrepareOutput([]byte("sessions cleared"))
+	handler := MessageHandler{}
+	err := handler.start()
+	c.Assert(err, IsNil)
+	defer handler.stop()
+	a := app.App{
+		Name: "nemesis",
+		Units: []app.Unit{
+			{Name: "nemesis/0", State: "pending", Machine: 19},
+			{Name: "nemesis/1", State: "started", Machine: 20},
+		},
+		State: string(provision.StatusStarted),
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	cron, err := a.AddCron("@daily", "python manage.py clearsessions")
+	c.Assert(err, IsNil)
+	defer db.Session.Crons().RemoveId(cron.Id)
+	messages, _, err := queue.Dial(handler.server.Addr())
+	c.Assert(err, IsNil)
+	messages <- queue.Message{Action: app.RunCron, Args: []string{a.Name, cron.Id}}
+	time.Sleep(1e9)
+	cmds := s.provisioner.GetCmds("", &a)
+	c.Assert(cmds, HasLen, 1)
+	c.Assert(cmds[0].Cmd, Matches, ".*python manage.py clearsessions$")
+	var l app.Applog
+	err = db.Session.Logs().Find(bson.M{"appname": a.Name, "source": "cron"}).One(&l)
+	c.Assert(err, IsNil)
+	c.Assert(l.Message, Equals, "sessions cleared")
+}
+
+func (s *S) TestScheduleCrons(c *C) {
+	s.provisioner.PrepareOutput([]byte("sessions cleared"))
+	handler := MessageHandler{}
+	err := handler.start()
+	c.Assert(err, IsNil)
+	defer handler.stop()
+	config.Set("queue-server", handler.server.Addr())
+	defer config.Set("queue-server", "127.0.0.1:0")
+	a := app.App{
+		Name:  "nemesis",
+		Units: []app.Unit{{Name: "nemesis/0", State: "started", Machine: 19}},
+		State: string(provision.StatusStarted),
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	now := time.Now()
+	cron := app.Cron{
+		Id:       "clearsessions",
+		App:      a.Name,
+		Schedule: "* * * * *",
+		Command:  "python manage.py clearsessions",
+		NextRun:  now.Add(-time.Minute),
+	}
+	err = db.Session.Crons().Insert(&cron)
+	c.Assert(err, IsNil)
+	defer db.Session.Crons().RemoveId(cron.Id)
+	ch := make(chan time.Time)
+	go scheduleCrons(ch)
+	ch <- now
+	close(ch)
+	time.Sleep(1e9)
+	cmds := s.provisioner.GetCmds("", &a)
+	c.Assert(cmds, HasLen, 1)
+	err = db.Session.Crons().FindId(cron.Id).One(&cron)
+	c.Assert(err, IsNil)
+	c.Assert(cron.NextRun.After(now), Equals, true)
+}
+
 func (s *S) TestUnitListStarted(c *C) {
 	var tests = []struct {
 		input    []app.Unit
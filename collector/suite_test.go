@@ -44,5 +44,6 @@ func (s *S) TearDownSuite(c *C) {
 func (s *S) TearDownTest(c *C) {
 	_, err := db.Session.Apps().RemoveAll(nil)
 	c.Assert(err, IsNil)
+	ttesting.ClearLogs(c)
 	s.provisioner.Reset()
 }
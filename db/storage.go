@@ -16,11 +16,16 @@ import (
 // Session stores the current connection with the database.
 var Session *Storage
 
+// LogsSize is the maximum size, in bytes, of the logs collection. When the
+// collection reaches this size, the oldest log entries are discarded.
+const LogsSize = 100 * 1024 * 1024
+
 // Storage holds the connection with the database.
 type Storage struct {
 	collections map[string]*mgo.Collection
 	session     *mgo.Session
 	dbname      string
+	logsOnce    sync.Once
 	sync.RWMutex
 }
 
@@ -104,3 +109,94 @@ func (s *Storage) Users() *mgo.Collection {
 func (s *Storage) Teams() *mgo.Collection {
 	return s.getCollection("teams")
 }
+
+// Deploys returns the deploys collection from MongoDB.
+func (s *Storage) Deploys() *mgo.Collection {
+	appIndex := mgo.Index{Key: []string{"app", "-timestamp"}}
+	c := s.getCollection("deploys")
+	c.EnsureIndex(appIndex)
+	return c
+}
+
+// EnvVersions returns the env_versions collection from MongoDB.
+func (s *Storage) EnvVersions() *mgo.Collection {
+	versionIndex := mgo.Index{Key: []string{"app", "-version"}, Unique: true}
+	c := s.getCollection("env_versions")
+	c.EnsureIndex(versionIndex)
+	return c
+}
+
+// Audit returns the audit collection from MongoDB.
+func (s *Storage) Audit() *mgo.Collection {
+	timestampIndex := mgo.Index{Key: []string{"-timestamp"}}
+	c := s.getCollection("audit")
+	c.EnsureIndex(timestampIndex)
+	return c
+}
+
+// Webhooks returns the webhooks collection from MongoDB.
+func (s *Storage) Webhooks() *mgo.Collection {
+	appIndex := mgo.Index{Key: []string{"app"}}
+	teamIndex := mgo.Index{Key: []string{"team"}}
+	c := s.getCollection("webhooks")
+	c.EnsureIndex(appIndex)
+	c.EnsureIndex(teamIndex)
+	return c
+}
+
+// WebhookDeliveries returns the webhook_deliveries collection from MongoDB.
+func (s *Storage) WebhookDeliveries() *mgo.Collection {
+	hookIndex := mgo.Index{Key: []string{"hook", "-timestamp"}}
+	c := s.getCollection("webhook_deliveries")
+	c.EnsureIndex(hookIndex)
+	return c
+}
+
+// AppLocks returns the app_locks collection from MongoDB.
+func (s *Storage) AppLocks() *mgo.Collection {
+	return s.getCollection("app_locks")
+}
+
+// Crons returns the crons collection from MongoDB.
+func (s *Storage) Crons() *mgo.Collection {
+	appIndex := mgo.Index{Key: []string{"app"}}
+	nextRunIndex := mgo.Index{Key: []string{"nextrun"}}
+	c := s.getCollection("crons")
+	c.EnsureIndex(appIndex)
+	c.EnsureIndex(nextRunIndex)
+	return c
+}
+
+// UnitHistory returns the collection with the status history of units from
+// MongoDB.
+func (s *Storage) UnitHistory() *mgo.Collection {
+	index := mgo.Index{Key: []string{"app", "unit", "-date"}}
+	c := s.getCollection("unit_history")
+	c.EnsureIndex(index)
+	return c
+}
+
+// Metrics returns the collection with the resource usage samples of units from
+// MongoDB.
+func (s *Storage) Metrics() *mgo.Collection {
+	index := mgo.Index{Key: []string{"app", "date"}}
+	c := s.getCollection("metrics")
+	c.EnsureIndex(index)
+	return c
+}
+
+// Logs returns the logs collection from MongoDB.
+//
+// The logs collection is capped (see LogsSize), and it's created in the first
+// call to this method. Entries in a capped collection can not be removed, they
+// are discarded as new entries are added.
+func (s *Storage) Logs() *mgo.Collection {
+	c := s.getCollection("logs")
+	s.logsOnce.Do(func() {
+		// fails if the collection already exists, which is ok.
+		c.Create(&mgo.CollectionInfo{Capped: true, MaxBytes: LogsSize})
+	})
+	appDateIndex := mgo.Index{Key: []string{"appname", "date", "_id"}}
+	c.EnsureIndex(appDateIndex)
+	return c
+}
@@ -6,6 +6,7 @@ package db
 
 import (
 	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
 	. "launchpad.net/gocheck"
 	"reflect"
 	"testing"
@@ -123,3 +124,83 @@ func (s *S) TestMethodTeamsShouldReturnTeamsCollection(c *C) {
 	teamsc := s.storage.getCollection("teams")
 	c.Assert(teams, DeepEquals, teamsc)
 }
+
+func (s *S) TestMethodDeploysShouldReturnDeploysCollection(c *C) {
+	deploys := s.storage.Deploys()
+	deploysc := s.storage.getCollection("deploys")
+	c.Assert(deploys, DeepEquals, deploysc)
+}
+
+func (s *S) TestMethodEnvVersionsShouldReturnEnvVersionsCollection(c *C) {
+	versions := s.storage.EnvVersions()
+	versionsc := s.storage.getCollection("env_versions")
+	c.Assert(versions, DeepEquals, versionsc)
+}
+
+func (s *S) TestMethodAuditShouldReturnAuditCollection(c *C) {
+	audit := s.storage.Audit()
+	auditc := s.storage.getCollection("audit")
+	c.Assert(audit, DeepEquals, auditc)
+}
+
+func (s *S) TestMethodWebhooksShouldReturnWebhooksCollection(c *C) {
+	webhooks := s.storage.Webhooks()
+	webhooksc := s.storage.getCollection("webhooks")
+	c.Assert(webhooks, DeepEquals, webhooksc)
+}
+
+func (s *S) TestMethodWebhookDeliveriesShouldReturnWebhookDeliveriesCollection(c *C) {
+	deliveries := s.storage.WebhookDeliveries()
+	deliveriesc := s.storage.getCollection("webhook_deliveries")
+	c.Assert(deliveries, DeepEquals, deliveriesc)
+}
+
+func (s *S) TestMethodAppLocksShouldReturnAppLocksCollection(c *C) {
+	locks := s.storage.AppLocks()
+	locksc := s.storage.getCollection("app_locks")
+	c.Assert(locks, DeepEquals, locksc)
+}
+
+func (s *S) TestMethodCronsShouldReturnCronsCollection(c *C) {
+	crons := s.storage.Crons()
+	cronsc := s.storage.getCollection("crons")
+	c.Assert(crons, DeepEquals, cronsc)
+}
+
+func (s *S) TestMethodUnitHistoryShouldReturnUnitHistoryCollection(c *C) {
+	history := s.storage.UnitHistory()
+	historyc := s.storage.getCollection("unit_history")
+	c.Assert(history, DeepEquals, historyc)
+}
+
+func (s *S) TestMethodMetricsShouldReturnMetricsCollection(c *C) {
+	metrics := s.storage.Metrics()
+	metricsc := s.storage.getCollection("metrics")
+	c.Assert(metrics, DeepEquals, metricsc)
+}
+
+func (s *S) TestMethodLogsShouldReturnLogsCollection(c *C) {
+	logs := s.storage.Logs()
+	logsc := s.storage.getCollection("logs")
+	c.Assert(logs, DeepEquals, logsc)
+}
+
+func (s *S) TestLogsCollectionHasIndexForAppAndDate(c *C) {
+	indexes, err := s.storage.Logs().Indexes()
+	c.Assert(err, IsNil)
+	var found bool
+	for _, index := range indexes {
+		if reflect.DeepEqual(index.Key, []string{"appname", "date", "_id"}) {
+			found = true
+		}
+	}
+	c.Assert(found, Equals, true)
+}
+
+func (s *S) TestLogsCollectionIsCapped(c *C) {
+	logs := s.storage.Logs()
+	var stats map[string]interface{}
+	err := logs.Database.Run(bson.M{"collStats": "logs"}, &stats)
+	c.Assert(err, IsNil)
+	c.Assert(stats["capped"], Equals, true)
+}
@@ -10,10 +10,11 @@ import (
 )
 
 type FakeUnit struct {
-	name    string
-	machine int
-	status  provision.Status
-	actions []string
+	name        string
+	machine     int
+	status      provision.Status
+	processType string
+	actions     []string
 }
 
 func (u *FakeUnit) GetName() string {
@@ -31,6 +32,10 @@ func (u *FakeUnit) GetStatus() provision.Status {
 	return u.status
 }
 
+func (u *FakeUnit) GetProcessType() string {
+	return u.processType
+}
+
 type FakeApp struct {
 	name      string
 	framework string
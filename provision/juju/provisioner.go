@@ -91,7 +91,7 @@ func (p *JujuProvisioner) Destroy(app provision.App) error {
 	return nil
 }
 
-func (p *JujuProvisioner) AddUnits(app provision.App, n uint) ([]provision.Unit, error) {
+func (p *JujuProvisioner) AddUnits(app provision.App, n uint, processType string) ([]provision.Unit, error) {
 	if n < 1 {
 		return nil, errors.New("Cannot add zero units.")
 	}
@@ -114,7 +114,7 @@ func (p *JujuProvisioner) AddUnits(app provision.App, n uint) ([]provision.Unit,
 	for err == nil {
 		matches := unitRe.FindStringSubmatch(line)
 		if len(matches) > 1 {
-			units = append(units, provision.Unit{Name: matches[1]})
+			units = append(units, provision.Unit{Name: matches[1], ProcessType: processType})
 		}
 		line, err = reader.ReadString('\n')
 	}
@@ -175,6 +175,42 @@ func (p *JujuProvisioner) RemoveUnits(app provision.App, n uint) error {
 	return p.removeUnits(app, units[:n]...)
 }
 
+// processEnv returns a shell statement that exports the process type of the
+// unit in the TSURU_PROCESS_TYPE environment variable. It should prefix the
+// commands run in the unit.
+func processEnv(unit provision.AppUnit) string {
+	processType := unit.GetProcessType()
+	if processType == "" {
+		processType = provision.DefaultProcessType
+	}
+	return "export TSURU_PROCESS_TYPE=" + processType + "; "
+}
+
+// runHook runs the given tsuru hook in all units of the app, regardless of
+// their status.
+func (p *JujuProvisioner) runHook(app provision.App, hook string) error {
+	var buf bytes.Buffer
+	cmd := "/var/lib/tsuru/hooks/" + hook
+	for _, unit := range app.ProvisionUnits() {
+		buf.Reset()
+		err := runCmd(true, &buf, &buf, "ssh", "-o", "StrictHostKeyChecking no", "-q", strconv.Itoa(unit.GetMachine()), processEnv(unit)+cmd)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to run %s hook in unit %s: %s", hook, unit.GetName(), buf.String())
+			app.Log(msg, "tsuru")
+			return &provision.Error{Reason: buf.String(), Err: err}
+		}
+	}
+	return nil
+}
+
+func (p *JujuProvisioner) Stop(app provision.App) error {
+	return p.runHook(app, "stop")
+}
+
+func (p *JujuProvisioner) Start(app provision.App) error {
+	return p.runHook(app, "start")
+}
+
 func (p *JujuProvisioner) ExecuteCommand(stdout, stderr io.Writer, app provision.App, cmd string, args ...string) error {
 	arguments := []string{"ssh", "-o", "StrictHostKeyChecking no", "-q"}
 	units := app.ProvisionUnits()
@@ -193,7 +229,7 @@ func (p *JujuProvisioner) ExecuteCommand(stdout, stderr io.Writer, app provision
 		}
 		var cmdargs []string
 		cmdargs = append(cmdargs, arguments...)
-		cmdargs = append(cmdargs, strconv.Itoa(unit.GetMachine()), cmd)
+		cmdargs = append(cmdargs, strconv.Itoa(unit.GetMachine()), processEnv(unit)+cmd)
 		cmdargs = append(cmdargs, args...)
 		err := runCmd(true, stdout, stderr, cmdargs...)
 		fmt.Fprintln(stdout)
@@ -98,12 +98,13 @@ func (s *S) TestAddUnits(c *C) {
 	defer commandmocker.Remove(tmpdir)
 	app := NewFakeApp("resist", "rush", 0)
 	p := JujuProvisioner{}
-	units, err := p.AddUnits(app, 4)
+	units, err := p.AddUnits(app, 4, "worker")
 	c.Assert(err, IsNil)
 	c.Assert(units, HasLen, 4)
 	names := make([]string, len(units))
 	for i, unit := range units {
 		names[i] = unit.Name
+		c.Check(unit.ProcessType, Equals, "worker")
 	}
 	expected := []string{"resist/3", "resist/4", "resist/5", "resist/6"}
 	c.Assert(names, DeepEquals, expected)
@@ -117,7 +118,7 @@ func (s *S) TestAddUnits(c *C) {
 
 func (s *S) TestAddZeroUnits(c *C) {
 	p := JujuProvisioner{}
-	units, err := p.AddUnits(nil, 0)
+	units, err := p.AddUnits(nil, 0, "web")
 	c.Assert(units, IsNil)
 	c.Assert(err, NotNil)
 	c.Assert(err.Error(), Equals, "Cannot add zero units.")
@@ -129,7 +130,7 @@ func (s *S) TestAddUnitsFailure(c *C) {
 	defer commandmocker.Remove(tmpdir)
 	app := NewFakeApp("headlong", "rush", 1)
 	p := JujuProvisioner{}
-	units, err := p.AddUnits(app, 1)
+	units, err := p.AddUnits(app, 1, "web")
 	c.Assert(units, IsNil)
 	c.Assert(err, NotNil)
 	e, ok := err.(*provision.Error)
@@ -245,6 +246,53 @@ func (s *S) TestRemoveUnitsFailure(c *C) {
 	c.Assert(e.Err.Error(), Equals, "exit status 66")
 }
 
+func (s *S) TestStop(c *C) {
+	tmpdir, err := commandmocker.Add("juju", "$*")
+	c.Assert(err, IsNil)
+	defer commandmocker.Remove(tmpdir)
+	app := NewFakeApp("hemispheres", "rush", 2)
+	p := JujuProvisioner{}
+	err = p.Stop(app)
+	c.Assert(err, IsNil)
+	c.Assert(commandmocker.Ran(tmpdir), Equals, true)
+	expected := []string{
+		"ssh", "-o", "StrictHostKeyChecking no", "-q", "1", "export TSURU_PROCESS_TYPE=web; /var/lib/tsuru/hooks/stop",
+		"ssh", "-o", "StrictHostKeyChecking no", "-q", "2", "export TSURU_PROCESS_TYPE=web; /var/lib/tsuru/hooks/stop",
+	}
+	c.Assert(commandmocker.Parameters(tmpdir), DeepEquals, expected)
+}
+
+func (s *S) TestStopFailure(c *C) {
+	tmpdir, err := commandmocker.Error("juju", "juju failed", 2)
+	c.Assert(err, IsNil)
+	defer commandmocker.Remove(tmpdir)
+	app := NewFakeApp("hemispheres", "rush", 2)
+	p := JujuProvisioner{}
+	err = p.Stop(app)
+	c.Assert(err, NotNil)
+	e, ok := err.(*provision.Error)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Reason, Equals, "juju failed")
+	c.Assert(e.Err.Error(), Equals, "exit status 2")
+	c.Assert(app.logs, HasLen, 1)
+}
+
+func (s *S) TestStart(c *C) {
+	tmpdir, err := commandmocker.Add("juju", "$*")
+	c.Assert(err, IsNil)
+	defer commandmocker.Remove(tmpdir)
+	app := NewFakeApp("hemispheres", "rush", 2)
+	p := JujuProvisioner{}
+	err = p.Start(app)
+	c.Assert(err, IsNil)
+	c.Assert(commandmocker.Ran(tmpdir), Equals, true)
+	expected := []string{
+		"ssh", "-o", "StrictHostKeyChecking no", "-q", "1", "export TSURU_PROCESS_TYPE=web; /var/lib/tsuru/hooks/start",
+		"ssh", "-o", "StrictHostKeyChecking no", "-q", "2", "export TSURU_PROCESS_TYPE=web; /var/lib/tsuru/hooks/start",
+	}
+	c.Assert(commandmocker.Parameters(tmpdir), DeepEquals, expected)
+}
+
 func (s *S) TestExecuteCommand(c *C) {
 	var buf bytes.Buffer
 	tmpdir, err := commandmocker.Add("juju", "$*")
@@ -256,18 +304,32 @@ func (s *S) TestExecuteCommand(c *C) {
 	c.Assert(err, IsNil)
 	bufOutput := `Output from unit "almah/0":
 
-ssh -o StrictHostKeyChecking no -q 1 ls -lh
+ssh -o StrictHostKeyChecking no -q 1 export TSURU_PROCESS_TYPE=web; ls -lh
 
 Output from unit "almah/1":
 
-ssh -o StrictHostKeyChecking no -q 2 ls -lh
+ssh -o StrictHostKeyChecking no -q 2 export TSURU_PROCESS_TYPE=web; ls -lh
 `
-	cmdOutput := "ssh -o StrictHostKeyChecking no -q 1 ls -lhssh -o StrictHostKeyChecking no -q 2 ls -lh"
+	cmdOutput := "ssh -o StrictHostKeyChecking no -q 1 export TSURU_PROCESS_TYPE=web; ls -lhssh -o StrictHostKeyChecking no -q 2 export TSURU_PROCESS_TYPE=web; ls -lh"
 	c.Assert(commandmocker.Ran(tmpdir), Equals, true)
 	c.Assert(commandmocker.Output(tmpdir), Equals, cmdOutput)
 	c.Assert(buf.String(), Equals, bufOutput)
 }
 
+func (s *S) TestExecuteCommandWithProcessType(c *C) {
+	var buf bytes.Buffer
+	tmpdir, err := commandmocker.Add("juju", "$*")
+	c.Assert(err, IsNil)
+	defer commandmocker.Remove(tmpdir)
+	app := NewFakeApp("almah", "static", 1)
+	app.units[0].(*FakeUnit).processType = "worker"
+	p := JujuProvisioner{}
+	err = p.ExecuteCommand(&buf, &buf, app, "ls", "-lh")
+	c.Assert(err, IsNil)
+	output := "ssh -o StrictHostKeyChecking no -q 1 export TSURU_PROCESS_TYPE=worker; ls -lh"
+	c.Assert(commandmocker.Output(tmpdir), Equals, output)
+}
+
 func (s *S) TestExecuteCommandFailure(c *C) {
 	var buf bytes.Buffer
 	tmpdir, err := commandmocker.Error("juju", "failed", 2)
@@ -290,7 +352,7 @@ func (s *S) TestExecuteCommandOneUnit(c *C) {
 	p := JujuProvisioner{}
 	err = p.ExecuteCommand(&buf, &buf, app, "ls", "-lh")
 	c.Assert(err, IsNil)
-	output := "ssh -o StrictHostKeyChecking no -q 1 ls -lh"
+	output := "ssh -o StrictHostKeyChecking no -q 1 export TSURU_PROCESS_TYPE=web; ls -lh"
 	c.Assert(commandmocker.Ran(tmpdir), Equals, true)
 	c.Assert(commandmocker.Output(tmpdir), Equals, output)
 	c.Assert(buf.String(), Equals, output+"\n")
@@ -306,11 +368,11 @@ func (s *S) TestExecuteCommandUnitDown(c *C) {
 	p := JujuProvisioner{}
 	err = p.ExecuteCommand(&buf, &buf, app, "ls", "-lha")
 	c.Assert(err, IsNil)
-	cmdOutput := "ssh -o StrictHostKeyChecking no -q 1 ls -lha"
-	cmdOutput += "ssh -o StrictHostKeyChecking no -q 3 ls -lha"
+	cmdOutput := "ssh -o StrictHostKeyChecking no -q 1 export TSURU_PROCESS_TYPE=web; ls -lha"
+	cmdOutput += "ssh -o StrictHostKeyChecking no -q 3 export TSURU_PROCESS_TYPE=web; ls -lha"
 	bufOutput := `Output from unit "almah/0":
 
-ssh -o StrictHostKeyChecking no -q 1 ls -lha
+ssh -o StrictHostKeyChecking no -q 1 export TSURU_PROCESS_TYPE=web; ls -lha
 
 Output from unit "almah/1":
 
@@ -318,7 +380,7 @@ Unit state is "down", it must be "started" for running commands.
 
 Output from unit "almah/2":
 
-ssh -o StrictHostKeyChecking no -q 3 ls -lha
+ssh -o StrictHostKeyChecking no -q 3 export TSURU_PROCESS_TYPE=web; ls -lha
 `
 	c.Assert(commandmocker.Ran(tmpdir), Equals, true)
 	c.Assert(commandmocker.Output(tmpdir), Equals, cmdOutput)
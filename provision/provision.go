@@ -26,15 +26,22 @@ const (
 	StatusCreating   = Status("creating")
 )
 
+// DefaultProcessType is the process type of units that don't declare one.
+const DefaultProcessType = "web"
+
 // Unit represents a provision unit. Can be a machine, container or anything
 // IP-addressable.
+//
+// ProcessType is the process type, as declared in the Procfile of the app,
+// that runs in the unit.
 type Unit struct {
-	Name    string
-	AppName string
-	Type    string
-	Machine int
-	Ip      string
-	Status  Status
+	Name        string
+	AppName     string
+	Type        string
+	Machine     int
+	Ip          string
+	Status      Status
+	ProcessType string
 }
 
 // AppUnit represents a unit in an app.
@@ -47,6 +54,9 @@ type AppUnit interface {
 
 	// Returns the status of the unit.
 	GetStatus() Status
+
+	// Returns the process type that runs in the unit.
+	GetProcessType() string
 }
 
 // App represents a tsuru app.
@@ -82,10 +92,11 @@ type Provisioner interface {
 	Destroy(App) error
 
 	// AddUnits adds units to an app. The first parameter is the app, the
-	// second is the number of units to add.
+	// second is the number of units to add and the third is the process type
+	// that will run in the new units.
 	//
 	// It returns a slice containing all added units
-	AddUnits(App, uint) ([]Unit, error)
+	AddUnits(App, uint, string) ([]Unit, error)
 
 	// RemoveUnit removes a unit from the app. It receives the app and the name
 	// of the unit to be removed.
@@ -95,7 +106,17 @@ type Provisioner interface {
 	// the app, the second is the number of units to remove.
 	RemoveUnits(App, uint) error
 
-	// ExecuteCommand runs a command in all units of the app.
+	// Stop is called when tsuru is taking the app offline. It should stop
+	// the application server in all units, without destroying them.
+	Stop(App) error
+
+	// Start is called when tsuru is bringing a stopped app back online.
+	Start(App) error
+
+	// ExecuteCommand runs a command in all units of the app. The process
+	// type of each unit must be available to the command in the
+	// TSURU_PROCESS_TYPE environment variable, so hooks can start the right
+	// process.
 	ExecuteCommand(stdout, stderr io.Writer, app App, cmd string, args ...string) error
 
 	// CollectStatus returns information about all provisioned units. It's used
@@ -103,6 +124,32 @@ type Provisioner interface {
 	CollectStatus() ([]Unit, error)
 }
 
+// Metrics is a sample of the resources used by a unit.
+//
+// CPU is the percentage of CPU time used, considering all cores, Memory is the
+// amount of memory in use and Disk is the space used in the root filesystem,
+// both in bytes. NetworkIn and NetworkOut are the bytes received and sent per
+// second.
+type Metrics struct {
+	Unit       string
+	CPU        float64
+	Memory     uint64
+	Disk       uint64
+	NetworkIn  uint64
+	NetworkOut uint64
+}
+
+// MetricsProvisioner is implemented by provisioners that are able to sample
+// the resources used by the units of apps.
+//
+// This interface is optional: when the provisioner does not implement it, the
+// tsuru collector samples units using ExecuteCommand.
+type MetricsProvisioner interface {
+	// CollectMetrics returns a sample of the resources used by each started
+	// unit of the app.
+	CollectMetrics(App) ([]Metrics, error)
+}
+
 var provisioners = make(map[string]Provisioner)
 
 // Register registers a new provisioner in the Provisioner registry.
@@ -10,8 +10,35 @@ import (
 	"github.com/globocom/config"
 	"github.com/globocom/tsuru/log"
 	"io"
+	"regexp"
+	"strings"
 )
 
+var (
+	commitRegexp = regexp.MustCompile(`\b[0-9a-f]{40}\b`)
+	refRegexp    = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+)
+
+// ValidateRef checks that the given ref is a valid git branch, tag or commit
+// name, following the rules of git check-ref-format. Refs are used in
+// commands run in the units, so only a safe subset of characters is accepted.
+func ValidateRef(ref string) error {
+	invalid := !refRegexp.MatchString(ref) || strings.HasPrefix(ref, "-") ||
+		strings.HasPrefix(ref, "/") || strings.HasSuffix(ref, "/") ||
+		strings.HasPrefix(ref, ".") || strings.HasSuffix(ref, ".") ||
+		strings.HasSuffix(ref, ".lock") || strings.Contains(ref, "..") ||
+		strings.Contains(ref, "//") || strings.Contains(ref, "/.")
+	if invalid {
+		return fmt.Errorf("Invalid ref %q.", ref)
+	}
+	return nil
+}
+
+// shellQuote quotes the value to be used as a single word in a shell command.
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
 // Unit interface represents a unit of execution.
 //
 // It must provide two methods:
@@ -30,9 +57,19 @@ type Unit interface {
 // Given a machine id (from juju), it runs a git clone into this machine,
 // cloning from the bare repository that is being served by git-daemon in the
 // tsuru server.
-func clone(u Unit) ([]byte, error) {
+//
+// The clone has the whole history, so the unit can later check out older
+// commits, in deploys and rollbacks. If ref is not empty, it checks out the
+// given ref, which may be a branch, a tag or a commit (see ValidateRef).
+func clone(u Unit, ref string) ([]byte, error) {
 	var buf bytes.Buffer
-	cmd := fmt.Sprintf("git clone %s /home/application/current --depth 1", GetReadOnlyUrl(u.GetName()))
+	cmd := fmt.Sprintf("git clone %s /home/application/current", GetReadOnlyUrl(u.GetName()))
+	if ref != "" {
+		if err := ValidateRef(ref); err != nil {
+			return nil, err
+		}
+		cmd = fmt.Sprintf("git clone %s /home/application/current && cd /home/application/current && git checkout -q %s", GetReadOnlyUrl(u.GetName()), shellQuote(ref))
+	}
 	err := u.Command(&buf, &buf, cmd)
 	b := buf.Bytes()
 	log.Printf(`"git clone" output: %s`, b)
@@ -41,10 +78,14 @@ func clone(u Unit) ([]byte, error) {
 
 // Pull runs a git pull to update the code in a unit.
 //
-// It works like Clone, pulling from the app bare repository.
-func pull(u Unit) ([]byte, error) {
+// It works like Clone, pulling from the app bare repository. If ref is not
+// empty, it checks out the given ref instead of pulling master (see Checkout).
+func pull(u Unit, ref string) ([]byte, error) {
+	if ref != "" {
+		return Checkout(u, ref)
+	}
 	var buf bytes.Buffer
-	cmd := fmt.Sprintf("cd /home/application/current && git pull origin master")
+	cmd := fmt.Sprintf("cd /home/application/current && git checkout -q master && git pull origin master")
 	err := u.Command(&buf, &buf, cmd)
 	b := buf.Bytes()
 	log.Printf(`"git pull" output: %s`, b)
@@ -54,15 +95,55 @@ func pull(u Unit) ([]byte, error) {
 // CloneOrPull runs a git clone or a git pull in a unit of the app.
 //
 // First it tries to clone, and if the clone fail (meaning that the repository
-// is already cloned), it pulls changes from the bare repository.
-func CloneOrPull(u Unit) ([]byte, error) {
-	b, err := clone(u)
+// is already cloned), it pulls changes from the bare repository. The ref may
+// be a branch, a tag or a commit; an empty ref means master.
+func CloneOrPull(u Unit, ref string) ([]byte, error) {
+	if ref != "" {
+		if err := ValidateRef(ref); err != nil {
+			return nil, err
+		}
+	}
+	b, err := clone(u, ref)
 	if err != nil {
-		b, err = pull(u)
+		b, err = pull(u, ref)
+	}
+	return b, err
+}
+
+// Checkout checks out the given ref in a unit. The ref may be a branch, a tag
+// or a commit.
+//
+// It fetches the app bare repository before checking out, so the ref does not
+// need to be present in the unit. Branches are checked out from the remote, so
+// the unit always gets their latest commit. Units cloned shallowly by previous
+// versions of tsuru fetch the whole history first.
+func Checkout(u Unit, ref string) ([]byte, error) {
+	if err := ValidateRef(ref); err != nil {
+		return nil, err
 	}
+	var buf bytes.Buffer
+	cmd := fmt.Sprintf("cd /home/application/current && ([ ! -f .git/shallow ] || git fetch -q --unshallow origin) && git fetch origin && git fetch --tags origin && (git checkout -q %s 2>/dev/null || git checkout -q %s)", shellQuote("origin/"+ref), shellQuote(ref))
+	err := u.Command(&buf, &buf, cmd)
+	b := buf.Bytes()
+	log.Printf(`"git checkout" output: %s`, b)
 	return b, err
 }
 
+// GetCommit returns the SHA of the commit checked out in a unit.
+func GetCommit(u Unit) (string, error) {
+	var buf bytes.Buffer
+	cmd := "cd /home/application/current && git rev-parse HEAD"
+	err := u.Command(&buf, &buf, cmd)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get the commit: %s (%s).", err, buf.String())
+	}
+	commit := commitRegexp.FindString(buf.String())
+	if commit == "" {
+		return "", fmt.Errorf("Failed to get the commit: invalid output (%s).", buf.String())
+	}
+	return commit, nil
+}
+
 // getGitServer returns the git server defined in the tsuru.conf file.
 //
 // If git:host configuration is not defined, this function panics.
@@ -15,6 +15,7 @@ import (
 
 type FakeUnit struct {
 	name     string
+	output   string
 	commands []string
 }
 
@@ -33,6 +34,9 @@ func (u *FakeUnit) GetName() string {
 
 func (u *FakeUnit) Command(stdout, stderr io.Writer, cmd ...string) error {
 	u.commands = append(u.commands, cmd[0])
+	if stdout != nil {
+		stdout.Write([]byte(u.output))
+	}
 	return nil
 }
 
@@ -50,40 +54,146 @@ func (u *FailingCloneUnit) Command(stdout, stderr io.Writer, cmd ...string) erro
 
 func (s *S) TestCloneRepository(c *C) {
 	u := FakeUnit{name: "my-unit"}
-	_, err := clone(&u)
+	_, err := clone(&u, "")
 	c.Assert(err, IsNil)
-	expectedCommand := fmt.Sprintf("git clone %s /home/application/current --depth 1", GetReadOnlyUrl(u.GetName()))
+	expectedCommand := fmt.Sprintf("git clone %s /home/application/current", GetReadOnlyUrl(u.GetName()))
+	c.Assert(u.RanCommand(expectedCommand), Equals, true)
+}
+
+func (s *S) TestCloneRepositoryWithRef(c *C) {
+	u := FakeUnit{name: "my-unit"}
+	_, err := clone(&u, "v1.0")
+	c.Assert(err, IsNil)
+	expectedCommand := fmt.Sprintf("git clone %s /home/application/current && cd /home/application/current && git checkout -q 'v1.0'", GetReadOnlyUrl(u.GetName()))
 	c.Assert(u.RanCommand(expectedCommand), Equals, true)
 }
 
 func (s *S) TestPullRepository(c *C) {
 	u := FakeUnit{name: "your-unit"}
-	_, err := pull(&u)
+	_, err := pull(&u, "")
 	c.Assert(err, IsNil)
-	expectedCommand := fmt.Sprintf("cd /home/application/current && git pull origin master")
+	expectedCommand := fmt.Sprintf("cd /home/application/current && git checkout -q master && git pull origin master")
+	c.Assert(u.RanCommand(expectedCommand), Equals, true)
+}
+
+func (s *S) TestPullRepositoryWithRef(c *C) {
+	u := FakeUnit{name: "your-unit"}
+	_, err := pull(&u, "hotfix")
+	c.Assert(err, IsNil)
+	expectedCommand := "cd /home/application/current && ([ ! -f .git/shallow ] || git fetch -q --unshallow origin) && git fetch origin && git fetch --tags origin && (git checkout -q 'origin/hotfix' 2>/dev/null || git checkout -q 'hotfix')"
 	c.Assert(u.RanCommand(expectedCommand), Equals, true)
 }
 
 func (s *S) TestCloneOrPullRepositoryRunsClone(c *C) {
 	u := FakeUnit{name: "my-unit"}
-	_, err := CloneOrPull(&u)
+	_, err := CloneOrPull(&u, "")
 	c.Assert(err, IsNil)
-	clone := fmt.Sprintf("git clone %s /home/application/current --depth 1", GetReadOnlyUrl(u.GetName()))
-	pull := fmt.Sprintf("cd /home/application/current && git pull origin master")
+	clone := fmt.Sprintf("git clone %s /home/application/current", GetReadOnlyUrl(u.GetName()))
+	pull := fmt.Sprintf("cd /home/application/current && git checkout -q master && git pull origin master")
 	c.Assert(u.RanCommand(clone), Equals, true)
 	c.Assert(u.RanCommand(pull), Equals, false)
 }
 
 func (s *S) TestCloneOrPullRepositoryRunsPullIfCloneFail(c *C) {
 	u := FailingCloneUnit{FakeUnit{name: "my-unit"}}
-	_, err := CloneOrPull(&u)
+	_, err := CloneOrPull(&u, "")
 	c.Assert(err, IsNil)
-	clone := fmt.Sprintf("git clone %s /home/application/current --depth 1", GetReadOnlyUrl(u.GetName()))
-	pull := fmt.Sprintf("cd /home/application/current && git pull origin master")
+	clone := fmt.Sprintf("git clone %s /home/application/current", GetReadOnlyUrl(u.GetName()))
+	pull := fmt.Sprintf("cd /home/application/current && git checkout -q master && git pull origin master")
 	c.Assert(u.RanCommand(clone), Equals, true)
 	c.Assert(u.RanCommand(pull), Equals, true)
 }
 
+func (s *S) TestCloneOrPullRepositoryWithRefChecksOutTheRefIfCloneFail(c *C) {
+	u := FailingCloneUnit{FakeUnit{name: "my-unit"}}
+	_, err := CloneOrPull(&u, "v1.0")
+	c.Assert(err, IsNil)
+	checkout := "cd /home/application/current && ([ ! -f .git/shallow ] || git fetch -q --unshallow origin) && git fetch origin && git fetch --tags origin && (git checkout -q 'origin/v1.0' 2>/dev/null || git checkout -q 'v1.0')"
+	c.Assert(u.RanCommand(checkout), Equals, true)
+}
+
+type FailingUnit struct {
+	FakeUnit
+}
+
+func (u *FailingUnit) Command(stdout, stderr io.Writer, cmd ...string) error {
+	u.commands = append(u.commands, cmd[0])
+	stderr.Write([]byte("fatal: Not a git repository"))
+	return errors.New("exit status 128")
+}
+
+func (s *S) TestCheckout(c *C) {
+	commit := "6c7a8e93c1ab2c7b3b5d8bc4e3c2f3a8b3d1a2e4"
+	u := FakeUnit{name: "my-unit"}
+	_, err := Checkout(&u, commit)
+	c.Assert(err, IsNil)
+	expectedCommand := "cd /home/application/current && ([ ! -f .git/shallow ] || git fetch -q --unshallow origin) && git fetch origin && git fetch --tags origin && (git checkout -q 'origin/" + commit + "' 2>/dev/null || git checkout -q '" + commit + "')"
+	c.Assert(u.RanCommand(expectedCommand), Equals, true)
+}
+
+func (s *S) TestCheckoutInvalidRef(c *C) {
+	u := FakeUnit{name: "my-unit"}
+	_, err := Checkout(&u, "master; rm -rf /")
+	c.Assert(err, NotNil)
+	c.Assert(u.commands, HasLen, 0)
+}
+
+func (s *S) TestCloneOrPullInvalidRef(c *C) {
+	u := FakeUnit{name: "my-unit"}
+	_, err := CloneOrPull(&u, "$(reboot)")
+	c.Assert(err, NotNil)
+	c.Assert(u.commands, HasLen, 0)
+}
+
+func (s *S) TestValidateRef(c *C) {
+	valid := []string{"master", "v1.0", "feature/login", "release-2.1_rc", "6c7a8e93c1ab2c7b3b5d8bc4e3c2f3a8b3d1a2e4"}
+	for _, ref := range valid {
+		c.Check(ValidateRef(ref), IsNil, Commentf("%q", ref))
+	}
+	invalid := []string{"", "-f", "--upload-pack=touch /tmp/x", "a;b", "a b", "$(id)", "`id`", "a'b",
+		"a..b", "/master", "master/", "a//b", ".hidden", "a/.b", "master.lock", "master.", "a@{1}", "a~1", "a^", "a:b"}
+	for _, ref := range invalid {
+		c.Check(ValidateRef(ref), NotNil, Commentf("%q", ref))
+	}
+}
+
+func (s *S) TestGetCommit(c *C) {
+	u := FakeUnit{name: "my-unit", output: "6c7a8e93c1ab2c7b3b5d8bc4e3c2f3a8b3d1a2e4\n"}
+	commit, err := GetCommit(&u)
+	c.Assert(err, IsNil)
+	c.Assert(commit, Equals, "6c7a8e93c1ab2c7b3b5d8bc4e3c2f3a8b3d1a2e4")
+	c.Assert(u.RanCommand("cd /home/application/current && git rev-parse HEAD"), Equals, true)
+}
+
+func (s *S) TestGetCommitWithMultipleUnitsOutput(c *C) {
+	output := `Output from unit "my-unit/0":
+
+6c7a8e93c1ab2c7b3b5d8bc4e3c2f3a8b3d1a2e4
+
+Output from unit "my-unit/1":
+
+6c7a8e93c1ab2c7b3b5d8bc4e3c2f3a8b3d1a2e4
+`
+	u := FakeUnit{name: "my-unit", output: output}
+	commit, err := GetCommit(&u)
+	c.Assert(err, IsNil)
+	c.Assert(commit, Equals, "6c7a8e93c1ab2c7b3b5d8bc4e3c2f3a8b3d1a2e4")
+}
+
+func (s *S) TestGetCommitInvalidOutput(c *C) {
+	u := FakeUnit{name: "my-unit", output: "HEAD"}
+	_, err := GetCommit(&u)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Failed to get the commit: invalid output (HEAD).")
+}
+
+func (s *S) TestGetCommitFailure(c *C) {
+	u := FailingUnit{FakeUnit{name: "my-unit"}}
+	_, err := GetCommit(&u)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Failed to get the commit: exit status 128 (fatal: Not a git repository).")
+}
+
 func (s *S) TestGetRepositoryUrl(c *C) {
 	url := GetUrl("foobar")
 	expected := "git@gandalf.plataformas.glb.com:foobar.git"
@@ -0,0 +1,22 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing
+
+import (
+	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo"
+	. "launchpad.net/gocheck"
+)
+
+// ClearLogs drops the logs collection and creates it again, capped. Entries in
+// a capped collection can not be removed, so suites that log should call it
+// between tests instead.
+func ClearLogs(c *C) {
+	logs := db.Session.Logs()
+	err := logs.DropCollection()
+	c.Assert(err, IsNil)
+	err = logs.Create(&mgo.CollectionInfo{Capped: true, MaxBytes: db.LogsSize})
+	c.Assert(err, IsNil)
+}
@@ -20,10 +20,11 @@ func init() {
 
 // Fake implementation for provision.Unit.
 type FakeUnit struct {
-	name    string
-	machine int
-	status  provision.Status
-	actions []string
+	name        string
+	machine     int
+	status      provision.Status
+	processType string
+	actions     []string
 }
 
 func (u *FakeUnit) GetName() string {
@@ -40,6 +41,10 @@ func (u *FakeUnit) GetStatus() provision.Status {
 	return u.status
 }
 
+func (u *FakeUnit) GetProcessType() string {
+	return u.processType
+}
+
 // Fake implementation for provision.App.
 type FakeApp struct {
 	name      string
@@ -97,6 +102,7 @@ type failure struct {
 type FakeProvisioner struct {
 	apps     []provision.App
 	units    map[string][]provision.Unit
+	statuses map[string][]provision.Status
 	cmds     []Cmd
 	outputs  chan []byte
 	failures chan failure
@@ -109,6 +115,7 @@ func NewFakeProvisioner() *FakeProvisioner {
 	p.outputs = make(chan []byte, 8)
 	p.failures = make(chan failure, 8)
 	p.units = make(map[string][]provision.Unit)
+	p.statuses = make(map[string][]provision.Status)
 	return &p
 }
 
@@ -161,9 +168,19 @@ func (p *FakeProvisioner) PrepareFailure(method string, err error) {
 	p.failures <- failure{method, err}
 }
 
+// PrepareStatus makes the next calls to CollectStatus report the given
+// statuses for the unit, one status per call. After that, the unit is reported
+// with its own status again.
+func (p *FakeProvisioner) PrepareStatus(unit string, statuses ...provision.Status) {
+	p.unitMut.Lock()
+	p.statuses[unit] = append(p.statuses[unit], statuses...)
+	p.unitMut.Unlock()
+}
+
 func (p *FakeProvisioner) Reset() {
 	p.unitMut.Lock()
 	p.units = make(map[string][]provision.Unit)
+	p.statuses = make(map[string][]provision.Status)
 	p.unitMut.Unlock()
 
 	p.cmdMut.Lock()
@@ -211,7 +228,7 @@ func (p *FakeProvisioner) Destroy(app provision.App) error {
 	return nil
 }
 
-func (p *FakeProvisioner) AddUnits(app provision.App, n uint) ([]provision.Unit, error) {
+func (p *FakeProvisioner) AddUnits(app provision.App, n uint, processType string) ([]provision.Unit, error) {
 	if err := p.getError("AddUnits"); err != nil {
 		return nil, err
 	}
@@ -229,12 +246,13 @@ func (p *FakeProvisioner) AddUnits(app provision.App, n uint) ([]provision.Unit,
 	length := uint(len(p.units[name]))
 	for i := uint(0); i < n; i++ {
 		unit := provision.Unit{
-			Name:    fmt.Sprintf("%s/%d", name, length+i),
-			AppName: name,
-			Type:    framework,
-			Status:  provision.StatusStarted,
-			Ip:      fmt.Sprintf("10.10.10.%d", length+i),
-			Machine: int(length + i),
+			Name:        fmt.Sprintf("%s/%d", name, length+i),
+			AppName:     name,
+			Type:        framework,
+			Status:      provision.StatusStarted,
+			Ip:          fmt.Sprintf("10.10.10.%d", length+i),
+			Machine:     int(length + i),
+			ProcessType: processType,
 		}
 		p.units[name] = append(p.units[name], unit)
 	}
@@ -284,6 +302,33 @@ func (p *FakeProvisioner) RemoveUnits(app provision.App, n uint) error {
 	return nil
 }
 
+func (p *FakeProvisioner) setStatus(app provision.App, status provision.Status) error {
+	if index := p.FindApp(app); index < 0 {
+		return errors.New("App is not provisioned.")
+	}
+	name := app.GetName()
+	p.unitMut.Lock()
+	defer p.unitMut.Unlock()
+	for i := range p.units[name] {
+		p.units[name][i].Status = status
+	}
+	return nil
+}
+
+func (p *FakeProvisioner) Stop(app provision.App) error {
+	if err := p.getError("Stop"); err != nil {
+		return err
+	}
+	return p.setStatus(app, provision.StatusDown)
+}
+
+func (p *FakeProvisioner) Start(app provision.App) error {
+	if err := p.getError("Start"); err != nil {
+		return err
+	}
+	return p.setStatus(app, provision.StatusStarted)
+}
+
 func (p *FakeProvisioner) ExecuteCommand(stdout, stderr io.Writer, app provision.App, cmd string, args ...string) error {
 	var (
 		output []byte
@@ -331,8 +376,20 @@ func (p *FakeProvisioner) CollectStatus() ([]provision.Unit, error) {
 	if err := p.getError("CollectStatus"); err != nil {
 		return nil, err
 	}
-	units := make([]provision.Unit, len(p.apps))
+	units := make([]provision.Unit, 0, len(p.apps))
+	p.unitMut.Lock()
+	defer p.unitMut.Unlock()
 	for i, app := range p.apps {
+		if appUnits := p.units[app.GetName()]; len(appUnits) > 0 {
+			for _, unit := range appUnits {
+				if statuses := p.statuses[unit.Name]; len(statuses) > 0 {
+					unit.Status = statuses[0]
+					p.statuses[unit.Name] = statuses[1:]
+				}
+				units = append(units, unit)
+			}
+			continue
+		}
 		unit := provision.Unit{
 			Name:    app.GetName() + "/0",
 			AppName: app.GetName(),
@@ -341,7 +398,7 @@ func (p *FakeProvisioner) CollectStatus() ([]provision.Unit, error) {
 			Ip:      "10.10.10." + strconv.Itoa(i+1),
 			Machine: i + 1,
 		}
-		units[i] = unit
+		units = append(units, unit)
 	}
 	return units, nil
 }
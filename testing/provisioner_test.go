@@ -48,8 +48,8 @@ func (s *S) TestGetCmds(c *C) {
 
 func (s *S) TestGetUnits(c *C) {
 	list := []provision.Unit{
-		{"chain-lighting/0", "chain-lighting", "django", 1, "10.10.10.10", provision.StatusStarted},
-		{"chain-lighting/1", "chain-lighting", "django", 2, "10.10.10.15", provision.StatusStarted},
+		{"chain-lighting/0", "chain-lighting", "django", 1, "10.10.10.10", provision.StatusStarted, "web"},
+		{"chain-lighting/1", "chain-lighting", "django", 2, "10.10.10.15", provision.StatusStarted, "web"},
 	}
 	app := NewFakeApp("chain-lighting", "rush", 1)
 	p := NewFakeProvisioner()
@@ -133,15 +133,17 @@ func (s *S) TestAddUnits(c *C) {
 	app := NewFakeApp("mystic-rhythms", "rush", 0)
 	p := NewFakeProvisioner()
 	p.Provision(app)
-	units, err := p.AddUnits(app, 2)
+	units, err := p.AddUnits(app, 2, "worker")
 	c.Assert(err, IsNil)
 	c.Assert(p.units["mystic-rhythms"], HasLen, 2)
 	c.Assert(units, HasLen, 2)
+	c.Assert(units[0].ProcessType, Equals, "worker")
+	c.Assert(units[1].ProcessType, Equals, "worker")
 }
 
 func (s *S) TestAddZeroUnits(c *C) {
 	p := NewFakeProvisioner()
-	units, err := p.AddUnits(nil, 0)
+	units, err := p.AddUnits(nil, 0, "web")
 	c.Assert(units, IsNil)
 	c.Assert(err, NotNil)
 	c.Assert(err.Error(), Equals, "Cannot add 0 units.")
@@ -150,7 +152,7 @@ func (s *S) TestAddZeroUnits(c *C) {
 func (s *S) TestAddUnitsUnprovisionedApp(c *C) {
 	app := NewFakeApp("mystic-rhythms", "rush", 0)
 	p := NewFakeProvisioner()
-	units, err := p.AddUnits(app, 1)
+	units, err := p.AddUnits(app, 1, "web")
 	c.Assert(units, IsNil)
 	c.Assert(err, NotNil)
 	c.Assert(err.Error(), Equals, "App is not provisioned.")
@@ -159,7 +161,7 @@ func (s *S) TestAddUnitsUnprovisionedApp(c *C) {
 func (s *S) TestAddUnitsFailure(c *C) {
 	p := NewFakeProvisioner()
 	p.PrepareFailure("AddUnits", errors.New("Cannot add more units."))
-	units, err := p.AddUnits(nil, 10)
+	units, err := p.AddUnits(nil, 10, "web")
 	c.Assert(units, IsNil)
 	c.Assert(err, NotNil)
 	c.Assert(err.Error(), Equals, "Cannot add more units.")
@@ -169,7 +171,7 @@ func (s *S) TestRemoveUnit(c *C) {
 	app := NewFakeApp("hemispheres", "rush", 0)
 	p := NewFakeProvisioner()
 	p.Provision(app)
-	_, err := p.AddUnits(app, 2)
+	_, err := p.AddUnits(app, 2, "web")
 	c.Assert(err, IsNil)
 	err = p.RemoveUnit(app, "hemispheres/1")
 	c.Assert(err, IsNil)
@@ -189,7 +191,7 @@ func (s *S) TestRemoveUnknownUnit(c *C) {
 	app := NewFakeApp("hemispheres", "rush", 0)
 	p := NewFakeProvisioner()
 	p.Provision(app)
-	_, err := p.AddUnits(app, 2)
+	_, err := p.AddUnits(app, 2, "web")
 	c.Assert(err, IsNil)
 	err = p.RemoveUnit(app, "hemispheres/2")
 	c.Assert(err, NotNil)
@@ -208,7 +210,7 @@ func (s *S) TestRemoveUnits(c *C) {
 	app := NewFakeApp("trees", "rush", 0)
 	p := NewFakeProvisioner()
 	p.Provision(app)
-	_, err := p.AddUnits(app, 9)
+	_, err := p.AddUnits(app, 9, "web")
 	c.Assert(err, IsNil)
 	err = p.RemoveUnits(app, 3)
 	c.Assert(err, IsNil)
@@ -228,7 +230,7 @@ func (s *S) TestRemoveTooManyUnits(c *C) {
 	app := NewFakeApp("strangiato", "rush", 0)
 	p := NewFakeProvisioner()
 	p.Provision(app)
-	_, err := p.AddUnits(app, 9)
+	_, err := p.AddUnits(app, 9, "web")
 	c.Assert(err, IsNil)
 	numbers := []uint{9, 10, 30}
 	for _, n := range numbers {
@@ -246,6 +248,58 @@ func (s *S) TestRemoveUnitsFailure(c *C) {
 	c.Assert(err.Error(), Equals, "This program has performed an illegal operation.")
 }
 
+func (s *S) TestStop(c *C) {
+	app := NewFakeApp("kid-a", "radiohead", 0)
+	p := NewFakeProvisioner()
+	p.Provision(app)
+	_, err := p.AddUnits(app, 2, "web")
+	c.Assert(err, IsNil)
+	err = p.Stop(app)
+	c.Assert(err, IsNil)
+	for _, unit := range p.units["kid-a"] {
+		c.Assert(unit.Status, Equals, provision.StatusDown)
+	}
+}
+
+func (s *S) TestStopUnprovisionedApp(c *C) {
+	app := NewFakeApp("kid-a", "radiohead", 0)
+	p := NewFakeProvisioner()
+	err := p.Stop(app)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "App is not provisioned.")
+}
+
+func (s *S) TestStopFailure(c *C) {
+	p := NewFakeProvisioner()
+	p.PrepareFailure("Stop", errors.New("Failed to stop."))
+	err := p.Stop(nil)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Failed to stop.")
+}
+
+func (s *S) TestStart(c *C) {
+	app := NewFakeApp("kid-a", "radiohead", 0)
+	p := NewFakeProvisioner()
+	p.Provision(app)
+	_, err := p.AddUnits(app, 2, "web")
+	c.Assert(err, IsNil)
+	err = p.Stop(app)
+	c.Assert(err, IsNil)
+	err = p.Start(app)
+	c.Assert(err, IsNil)
+	for _, unit := range p.units["kid-a"] {
+		c.Assert(unit.Status, Equals, provision.StatusStarted)
+	}
+}
+
+func (s *S) TestStartFailure(c *C) {
+	p := NewFakeProvisioner()
+	p.PrepareFailure("Start", errors.New("Failed to start."))
+	err := p.Start(nil)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Failed to start.")
+}
+
 func (s *S) TestExecuteCommand(c *C) {
 	var buf bytes.Buffer
 	output := []byte("myoutput!")
@@ -297,16 +351,33 @@ func (s *S) TestCollectStatus(c *C) {
 		NewFakeApp("grand-designs", "rush", 1),
 	}
 	expected := []provision.Unit{
-		{"red-lenses/0", "red-lenses", "rush", 1, "10.10.10.1", "started"},
-		{"between-the-wheels/0", "between-the-wheels", "rush", 2, "10.10.10.2", "started"},
-		{"the-big-money/0", "the-big-money", "rush", 3, "10.10.10.3", "started"},
-		{"grand-designs/0", "grand-designs", "rush", 4, "10.10.10.4", "started"},
+		{"red-lenses/0", "red-lenses", "rush", 1, "10.10.10.1", "started", ""},
+		{"between-the-wheels/0", "between-the-wheels", "rush", 2, "10.10.10.2", "started", ""},
+		{"the-big-money/0", "the-big-money", "rush", 3, "10.10.10.3", "started", ""},
+		{"grand-designs/0", "grand-designs", "rush", 4, "10.10.10.4", "started", ""},
 	}
 	units, err := p.CollectStatus()
 	c.Assert(err, IsNil)
 	c.Assert(units, DeepEquals, expected)
 }
 
+func (s *S) TestCollectStatusReturnsAddedUnits(c *C) {
+	app := NewFakeApp("red-lenses", "rush", 1)
+	p := NewFakeProvisioner()
+	p.Provision(app)
+	_, err := p.AddUnits(app, 2, "web")
+	c.Assert(err, IsNil)
+	err = p.Stop(app)
+	c.Assert(err, IsNil)
+	units, err := p.CollectStatus()
+	c.Assert(err, IsNil)
+	c.Assert(units, HasLen, 2)
+	c.Assert(units[0].Name, Equals, "red-lenses/0")
+	c.Assert(units[0].Status, Equals, provision.StatusDown)
+	c.Assert(units[1].Name, Equals, "red-lenses/1")
+	c.Assert(units[1].Status, Equals, provision.StatusDown)
+}
+
 func (s *S) TestCollectStatusPreparedFailure(c *C) {
 	p := NewFakeProvisioner()
 	p.PrepareFailure("CollectStatus", errors.New("Failed to collect status."))
@@ -322,3 +393,20 @@ func (s *S) TestCollectStatusNoApps(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(units, HasLen, 0)
 }
+
+func (s *S) TestCollectStatusPreparedStatus(c *C) {
+	app := NewFakeApp("red-lenses", "rush", 1)
+	p := NewFakeProvisioner()
+	p.Provision(app)
+	_, err := p.AddUnits(app, 2, "web")
+	c.Assert(err, IsNil)
+	p.PrepareStatus("red-lenses/1", provision.StatusDown, provision.StatusPending)
+	expected := []provision.Status{provision.StatusDown, provision.StatusPending, provision.StatusStarted}
+	for _, status := range expected {
+		units, err := p.CollectStatus()
+		c.Assert(err, IsNil)
+		c.Assert(units, HasLen, 2)
+		c.Assert(units[0].Status, Equals, provision.StatusStarted)
+		c.Assert(units[1].Status, Equals, status)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"github.com/globocom/tsuru/db"
+	. "launchpad.net/gocheck"
+	"testing"
+	"time"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type S struct {
+	interval time.Duration
+}
+
+var _ = Suite(&S{})
+
+func (s *S) SetUpSuite(c *C) {
+	var err error
+	db.Session, err = db.Open("127.0.0.1:27017", "tsuru_webhook_test")
+	c.Assert(err, IsNil)
+	s.interval = retryInterval
+	retryInterval = 10 * time.Millisecond
+}
+
+func (s *S) TearDownSuite(c *C) {
+	defer db.Session.Close()
+	db.Session.Webhooks().Database.DropDatabase()
+	retryInterval = s.interval
+}
+
+func (s *S) TearDownTest(c *C) {
+	_, err := db.Session.Webhooks().RemoveAll(nil)
+	c.Assert(err, IsNil)
+	_, err = db.Session.WebhookDeliveries().RemoveAll(nil)
+	c.Assert(err, IsNil)
+}
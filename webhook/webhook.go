@@ -0,0 +1,261 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package webhook notifies external services, like CI servers and chat bots,
+// about events in the lifecycle of apps.
+//
+// Webhooks are registered for an app or for a team. When an event finishes,
+// tsuru POSTs a JSON payload describing it to the URL of every webhook of the
+// app, and of the teams that have access to the app, that is interested in the
+// event. The payload is signed with the secret of the webhook, using
+// HMAC-SHA256, and the signature is sent in the X-Tsuru-Signature header, in
+// the format "sha256=<hex digest>".
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/log"
+	"io"
+	"labix.org/v2/mgo/bson"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Events of the lifecycle of apps.
+const (
+	Deploy   = "deploy"
+	Restart  = "restart"
+	AddUnits = "add-units"
+	Bind     = "bind"
+	Unbind   = "unbind"
+	Destroy  = "destroy"
+)
+
+// Events lists all events webhooks can be notified about.
+var Events = []string{Deploy, Restart, AddUnits, Bind, Unbind, Destroy}
+
+const (
+	SignatureHeader = "X-Tsuru-Signature"
+	EventHeader     = "X-Tsuru-Event"
+	DeliveryHeader  = "X-Tsuru-Delivery"
+)
+
+var (
+	// maxAttempts is how many times a payload is sent to a webhook before
+	// the delivery is considered failed.
+	maxAttempts = 3
+
+	// retryInterval is the interval before the first retry of a delivery.
+	// It grows linearly with the number of attempts.
+	retryInterval = 10 * time.Second
+
+	// requestTimeout is how long a webhook has to answer a delivery, from
+	// connecting to it to reading its response.
+	requestTimeout = 10 * time.Second
+)
+
+// client is the HTTP client used to deliver payloads. Connections are not
+// reused, so the deadline set when dialing bounds the whole request.
+var client = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.DialTimeout(network, addr, 5*time.Second)
+			if err != nil {
+				return nil, err
+			}
+			conn.SetDeadline(time.Now().Add(requestTimeout))
+			return conn, nil
+		},
+		DisableKeepAlives: true,
+	},
+}
+
+// Hook is a webhook, registered either for an app or for a team. Hooks
+// without events are notified about all events.
+type Hook struct {
+	Id     string `bson:"_id"`
+	App    string `bson:",omitempty" json:",omitempty"`
+	Team   string `bson:",omitempty" json:",omitempty"`
+	URL    string
+	Events []string `bson:",omitempty" json:",omitempty"`
+	Secret string   `bson:",omitempty" json:",omitempty"`
+}
+
+// Create validates the hook and stores it, generating its id and secret.
+func (h *Hook) Create() error {
+	if (h.App == "") == (h.Team == "") {
+		return &errors.Http{Code: http.StatusBadRequest, Message: "A webhook must be registered either for an app or for a team."}
+	}
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &errors.Http{Code: http.StatusBadRequest, Message: fmt.Sprintf("Invalid webhook URL: %q.", h.URL)}
+	}
+	for _, e := range h.Events {
+		if !isEvent(e) {
+			return &errors.Http{Code: http.StatusBadRequest, Message: fmt.Sprintf("Invalid event: %q.", e)}
+		}
+	}
+	if h.Secret, err = newSecret(); err != nil {
+		return err
+	}
+	h.Id = bson.NewObjectId().Hex()
+	return db.Session.Webhooks().Insert(h)
+}
+
+// Wants returns whether the hook is interested in the given event.
+func (h *Hook) Wants(event string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func isEvent(name string) bool {
+	for _, e := range Events {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
+
+// Sign returns the signature of the payload, as sent in the SignatureHeader.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return fmt.Sprintf("sha256=%x", mac.Sum(nil))
+}
+
+// Event is the payload POSTed to webhooks.
+type Event struct {
+	Event     string            `json:"event"`
+	App       string            `json:"app"`
+	Success   bool              `json:"success"`
+	Error     string            `json:"error,omitempty"`
+	Data      map[string]string `json:"data,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// Delivery records the delivery of an event to a webhook.
+type Delivery struct {
+	Id        string `bson:"_id"`
+	Hook      string
+	Event     string
+	App       string
+	URL       string
+	Payload   string
+	Attempts  int
+	Status    int
+	Success   bool
+	Error     string `bson:",omitempty" json:",omitempty"`
+	Timestamp time.Time
+}
+
+// Notify notifies the webhooks of the app, and of the given teams, about an
+// event that finished with the given error (nil in case of success). If teams
+// is nil, the teams of the app are loaded from the database.
+//
+// The webhooks are found before Notify returns, but the payloads are delivered
+// in background, being retried up to maxAttempts times.
+func Notify(event, app string, teams []string, err error, data map[string]string) {
+	if teams == nil {
+		var a struct{ Teams []string }
+		db.Session.Apps().Find(bson.M{"name": app}).Select(bson.M{"teams": 1}).One(&a)
+		teams = a.Teams
+	}
+	var hooks []Hook
+	query := bson.M{"$or": []bson.M{{"app": app}, {"team": bson.M{"$in": teams}}}}
+	if ferr := db.Session.Webhooks().Find(query).All(&hooks); ferr != nil {
+		log.Printf("Failed to find the webhooks of the app %q: %s", app, ferr)
+		return
+	}
+	e := Event{Event: event, App: app, Success: err == nil, Data: data, Timestamp: time.Now()}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	payload, merr := json.Marshal(e)
+	if merr != nil {
+		log.Printf("Failed to encode the %q event of the app %q: %s", event, app, merr)
+		return
+	}
+	for _, h := range hooks {
+		if h.Wants(event) {
+			go deliver(h, &e, payload)
+		}
+	}
+}
+
+// deliver sends the payload to the hook, retrying in case of failures, and
+// records the delivery, updating it after each attempt.
+func deliver(h Hook, e *Event, payload []byte) *Delivery {
+	d := Delivery{
+		Id:        bson.NewObjectId().Hex(),
+		Hook:      h.Id,
+		Event:     e.Event,
+		App:       e.App,
+		URL:       h.URL,
+		Payload:   string(payload),
+		Timestamp: time.Now(),
+	}
+	if err := db.Session.WebhookDeliveries().Insert(&d); err != nil {
+		log.Printf("Failed to record the delivery to the webhook %s: %s", h.Id, err)
+	}
+	for d.Attempts < maxAttempts && !d.Success {
+		if d.Attempts > 0 {
+			time.Sleep(time.Duration(d.Attempts) * retryInterval)
+		}
+		d.Attempts++
+		d.Status, d.Error = post(&h, &d, payload)
+		d.Success = d.Error == ""
+		if err := db.Session.WebhookDeliveries().UpdateId(d.Id, &d); err != nil {
+			log.Printf("Failed to record the delivery to the webhook %s: %s", h.Id, err)
+		}
+	}
+	return &d
+}
+
+// post sends the payload to the hook, returning the status code of the
+// response and an error message in case of failure.
+func post(h *Hook, d *Delivery, payload []byte) (int, string) {
+	request, err := http.NewRequest("POST", h.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventHeader, d.Event)
+	request.Header.Set(DeliveryHeader, d.Id)
+	request.Header.Set(SignatureHeader, Sign(h.Secret, payload))
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err.Error()
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Sprintf("unexpected status code: %d", response.StatusCode)
+	}
+	return response.StatusCode, ""
+}
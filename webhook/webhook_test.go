@@ -0,0 +1,212 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// recordingServer starts a server that records the requests it receives,
+// responding with the given status codes, in order. After the last status
+// code, it keeps responding with 200.
+func recordingServer(statuses ...int) (*httptest.Server, chan request) {
+	requests := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- request{header: r.Header, body: body}
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+	return server, requests
+}
+
+func (s *S) TestSign(c *C) {
+	signature := Sign("secret", []byte(`{"event":"deploy"}`))
+	c.Assert(signature, Equals, "sha256=bc5c76d171fd3787c5e5bc6c5187996a0b228caac6d71718c90ec8770fa01b5c")
+}
+
+func (s *S) TestHookCreate(c *C) {
+	h := Hook{App: "myapp", URL: "http://ci.tsuru.io/hook", Events: []string{Deploy}}
+	err := h.Create()
+	c.Assert(err, IsNil)
+	c.Assert(h.Id, Not(Equals), "")
+	c.Assert(h.Secret, HasLen, 40)
+	var stored Hook
+	err = db.Session.Webhooks().FindId(h.Id).One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored, DeepEquals, h)
+}
+
+func (s *S) TestHookCreateValidation(c *C) {
+	var tests = []struct {
+		hook    Hook
+		message string
+	}{
+		{Hook{URL: "http://ci.tsuru.io"}, "A webhook must be registered either for an app or for a team."},
+		{Hook{App: "myapp", Team: "myteam", URL: "http://ci.tsuru.io"}, "A webhook must be registered either for an app or for a team."},
+		{Hook{App: "myapp", URL: "ftp://ci.tsuru.io"}, `Invalid webhook URL: "ftp://ci.tsuru.io".`},
+		{Hook{App: "myapp", URL: "ci.tsuru.io"}, `Invalid webhook URL: "ci.tsuru.io".`},
+		{Hook{App: "myapp", URL: "http://ci.tsuru.io", Events: []string{"push"}}, `Invalid event: "push".`},
+	}
+	for _, t := range tests {
+		err := t.hook.Create()
+		c.Assert(err, NotNil)
+		e, ok := err.(*errors.Http)
+		c.Assert(ok, Equals, true)
+		c.Check(e.Code, Equals, http.StatusBadRequest)
+		c.Check(e.Message, Equals, t.message)
+	}
+	n, err := db.Session.Webhooks().Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *S) TestHookWants(c *C) {
+	h := Hook{Events: []string{Deploy, Restart}}
+	c.Assert(h.Wants(Deploy), Equals, true)
+	c.Assert(h.Wants(Bind), Equals, false)
+	h = Hook{}
+	c.Assert(h.Wants(Bind), Equals, true)
+}
+
+func (s *S) TestDeliver(c *C) {
+	server, requests := recordingServer()
+	defer server.Close()
+	h := Hook{Id: "hook1", App: "myapp", URL: server.URL, Secret: "secret"}
+	e := Event{Event: Deploy, App: "myapp", Success: true}
+	payload := []byte(`{"event":"deploy"}`)
+	d := deliver(h, &e, payload)
+	c.Assert(d.Success, Equals, true)
+	c.Assert(d.Attempts, Equals, 1)
+	c.Assert(d.Status, Equals, http.StatusOK)
+	r := <-requests
+	c.Assert(string(r.body), Equals, `{"event":"deploy"}`)
+	c.Assert(r.header.Get("Content-Type"), Equals, "application/json")
+	c.Assert(r.header.Get(EventHeader), Equals, Deploy)
+	c.Assert(r.header.Get(DeliveryHeader), Equals, d.Id)
+	c.Assert(r.header.Get(SignatureHeader), Equals, Sign("secret", payload))
+	var stored Delivery
+	err := db.Session.WebhookDeliveries().FindId(d.Id).One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.Hook, Equals, "hook1")
+	c.Assert(stored.Payload, Equals, `{"event":"deploy"}`)
+	c.Assert(stored.Success, Equals, true)
+}
+
+func (s *S) TestDeliverRetries(c *C) {
+	server, requests := recordingServer(http.StatusInternalServerError, http.StatusBadGateway)
+	defer server.Close()
+	h := Hook{Id: "hook1", App: "myapp", URL: server.URL, Secret: "secret"}
+	d := deliver(h, &Event{Event: Restart, App: "myapp"}, []byte("{}"))
+	c.Assert(d.Success, Equals, true)
+	c.Assert(d.Attempts, Equals, 3)
+	c.Assert(d.Error, Equals, "")
+	c.Assert(requests, HasLen, 3)
+}
+
+func (s *S) TestDeliverGivesUpAfterMaxAttempts(c *C) {
+	server, _ := recordingServer(500, 500, 500, 500)
+	defer server.Close()
+	h := Hook{Id: "hook1", App: "myapp", URL: server.URL, Secret: "secret"}
+	d := deliver(h, &Event{Event: Restart, App: "myapp"}, []byte("{}"))
+	c.Assert(d.Success, Equals, false)
+	c.Assert(d.Attempts, Equals, maxAttempts)
+	c.Assert(d.Status, Equals, http.StatusInternalServerError)
+	c.Assert(d.Error, Equals, "unexpected status code: 500")
+	var stored Delivery
+	err := db.Session.WebhookDeliveries().FindId(d.Id).One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.Attempts, Equals, maxAttempts)
+	c.Assert(stored.Success, Equals, false)
+}
+
+func (s *S) TestPostTimesOut(c *C) {
+	old := requestTimeout
+	requestTimeout = 100 * time.Millisecond
+	defer func() { requestTimeout = old }()
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	h := Hook{Id: "hook1", App: "myapp", URL: server.URL, Secret: "secret"}
+	status, msg := post(&h, &Delivery{Id: "delivery1", Event: Deploy}, []byte("{}"))
+	c.Assert(status, Equals, 0)
+	c.Assert(msg, Not(Equals), "")
+}
+
+func (s *S) TestNotify(c *C) {
+	server, requests := recordingServer()
+	defer server.Close()
+	hooks := []Hook{
+		{Id: "app", App: "myapp", URL: server.URL + "/app", Events: []string{Deploy}},
+		{Id: "team", Team: "myteam", URL: server.URL + "/team"},
+		{Id: "restart", App: "myapp", URL: server.URL + "/restart", Events: []string{Restart}},
+		{Id: "other", App: "otherapp", URL: server.URL + "/other"},
+	}
+	for _, h := range hooks {
+		err := db.Session.Webhooks().Insert(h)
+		c.Assert(err, IsNil)
+	}
+	Notify(Deploy, "myapp", []string{"myteam"}, stderrors.New("healthcheck failed"), map[string]string{"ref": "master"})
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-requests:
+			var e Event
+			err := json.Unmarshal(r.body, &e)
+			c.Assert(err, IsNil)
+			c.Assert(e.Event, Equals, Deploy)
+			c.Assert(e.App, Equals, "myapp")
+			c.Assert(e.Success, Equals, false)
+			c.Assert(e.Error, Equals, "healthcheck failed")
+			c.Assert(e.Data, DeepEquals, map[string]string{"ref": "master"})
+		case <-time.After(2 * time.Second):
+			c.Fatal("timed out waiting for the webhooks to be notified")
+		}
+	}
+	select {
+	case <-requests:
+		c.Fatal("unexpected notification")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (s *S) TestNotifyLoadsTheTeamsOfTheApp(c *C) {
+	err := db.Session.Apps().Insert(bson.M{"name": "myapp", "teams": []string{"myteam"}})
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": "myapp"})
+	server, requests := recordingServer()
+	defer server.Close()
+	err = db.Session.Webhooks().Insert(Hook{Id: "team", Team: "myteam", URL: server.URL})
+	c.Assert(err, IsNil)
+	Notify(Bind, "myapp", nil, nil, map[string]string{"instance": "mysql"})
+	select {
+	case r := <-requests:
+		var e Event
+		err := json.Unmarshal(r.body, &e)
+		c.Assert(err, IsNil)
+		c.Assert(e.Event, Equals, Bind)
+		c.Assert(e.Success, Equals, true)
+	case <-time.After(2 * time.Second):
+		c.Fatal("timed out waiting for the webhook to be notified")
+	}
+}